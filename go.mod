@@ -4,6 +4,7 @@ go 1.12
 
 require (
 	github.com/bmatcuk/doublestar v1.1.1
+	go.etcd.io/bbolt v1.3.5
 	gopkg.in/yaml.v2 v2.2.2 // indirect
 	sigs.k8s.io/yaml v1.1.0
 )
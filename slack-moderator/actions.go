@@ -0,0 +1,265 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// moderationAction is a deactivation, recorded so that it can be appealed and undone.
+type moderationAction struct {
+	ID        uint64    `json:"id"`
+	Time      time.Time `json:"time"`
+	Moderator string    `json:"moderator"`
+	// ApprovedBy is the second moderator who approved the action, if it needed approval.
+	ApprovedBy string `json:"approved_by,omitempty"`
+	TargetUser string `json:"target_user"`
+	// TargetEmail is the target user's email address, if we could see it, so that appeals from
+	// outside Slack can be matched up with the action.
+	TargetEmail string `json:"target_email,omitempty"`
+	// ReactivatedBy is the moderator who undid the action, if anyone has.
+	ReactivatedBy string    `json:"reactivated_by,omitempty"`
+	Reactivated   time.Time `json:"reactivated,omitempty"`
+	// Appeals are the IDs of the cases filed for appeals against the action.
+	Appeals []uint64 `json:"appeals,omitempty"`
+	// MessageChannel and MessageTS identify the action's message in the moderation channel.
+	MessageChannel string `json:"message_channel,omitempty"`
+	MessageTS      string `json:"message_ts,omitempty"`
+}
+
+var actionsBucket = []byte("actions")
+
+// createAction stores a as a new action, filling in its ID.
+func (s *caseStore) createAction(a *moderationAction) error {
+	return s.actions.create(a, func(id uint64) { a.ID = id })
+}
+
+func (s *caseStore) getAction(id uint64) (moderationAction, error) {
+	var a moderationAction
+	err := s.actions.get(id, &a)
+	return a, err
+}
+
+// updateAction applies f to the action with the given ID and stores the result, unless f fails. It
+// returns the updated action.
+func (s *caseStore) updateAction(id uint64, f func(a *moderationAction) error) (moderationAction, error) {
+	var a moderationAction
+	err := s.actions.update(id, &a, func(*bolt.Tx) error { return f(&a) })
+	return a, err
+}
+
+// latestAction returns the most recent action that hasn't been undone against the user with the
+// given ID or, if user is empty, the given email address. It returns false if there isn't one.
+func (s *caseStore) latestAction(user, email string) (moderationAction, bool, error) {
+	var result moderationAction
+	found := false
+	err := s.actions.scan(true, func(id uint64, v []byte) (bool, error) {
+		var a moderationAction
+		if err := s.actions.parse(id, v, &a); err != nil {
+			return false, err
+		}
+		if !a.Reactivated.IsZero() {
+			return true, nil
+		}
+		if (user != "" && a.TargetUser == user) || (user == "" && email != "" && strings.EqualFold(a.TargetEmail, email)) {
+			result, found = a, true
+			return false, nil
+		}
+		return true, nil
+	})
+	return result, found, err
+}
+
+// describe returns a one-line summary of a, for showing to moderators.
+func (a moderationAction) describe() string {
+	text := fmt.Sprintf("<@%s> deactivated <@%s> on %s UTC", a.Moderator, a.TargetUser, a.Time.UTC().Format(removalTimeLayout))
+	if a.ApprovedBy != "" {
+		text += fmt.Sprintf(", approved by <@%s>", a.ApprovedBy)
+	}
+	return text
+}
+
+// message returns the moderation channel message for a, with a button to undo it if it hasn't
+// been.
+func (a moderationAction) message() map[string]interface{} {
+	status := fmt.Sprintf("Action %d", a.ID)
+	if a.Reactivated.IsZero() {
+		status += " is in effect."
+	} else {
+		status += fmt.Sprintf(" was undone by <@%s>, who reactivated <@%s> on %s UTC.", a.ReactivatedBy, a.TargetUser, a.Reactivated.UTC().Format(removalTimeLayout))
+	}
+	if len(a.Appeals) > 0 {
+		var cases []string
+		for _, id := range a.Appeals {
+			cases = append(cases, strconv.FormatUint(id, 10))
+		}
+		status += fmt.Sprintf(" Appealed in case %s.", strings.Join(cases, ", "))
+	}
+	attachment := map[string]interface{}{
+		"text":        status,
+		"fallback":    status,
+		"callback_id": "moderation_action",
+		"mrkdwn_in":   []string{"text"},
+	}
+	if a.Reactivated.IsZero() {
+		attachment["actions"] = []map[string]interface{}{
+			{
+				"name":    "reactivate",
+				"text":    "Reactivate",
+				"type":    "button",
+				"value":   strconv.FormatUint(a.ID, 10),
+				"confirm": map[string]string{"title": "Reactivate user?", "text": fmt.Sprintf("<@%s> will be able to sign in again.", a.TargetUser), "ok_text": "Reactivate", "dismiss_text": "Cancel"},
+			},
+		}
+	}
+	return map[string]interface{}{
+		"text":        a.describe() + ".",
+		"attachments": []map[string]interface{}{attachment},
+	}
+}
+
+// recordDeactivation records that the moderator deactivated the target user, with the approval of
+// approvedBy if it isn't empty, and posts the decision in the moderation channel. It does nothing
+// unless cases are enabled.
+func (h *handler) recordDeactivation(moderator, approvedBy, targetUser string) error {
+	if h.cases == nil {
+		return nil
+	}
+	a := &moderationAction{Time: time.Now(), Moderator: moderator, ApprovedBy: approvedBy, TargetUser: targetUser}
+	if user, err := h.getUserInfo(targetUser); err == nil {
+		a.TargetEmail = user.Profile.Email
+	}
+	if err := h.cases.createAction(a); err != nil {
+		return fmt.Errorf("couldn't record action: %v", err)
+	}
+	if h.modChannel == "" {
+		return nil
+	}
+	message := a.message()
+	message["channel"] = h.modChannel
+	ret := struct {
+		Channel string `json:"channel"`
+		TS      string `json:"ts"`
+	}{}
+	if err := h.client.CallMethod("chat.postMessage", message, &ret); err != nil {
+		return fmt.Errorf("couldn't post action %d: %v", a.ID, err)
+	}
+	_, err := h.cases.updateAction(a.ID, func(a *moderationAction) error {
+		a.MessageChannel, a.MessageTS = ret.Channel, ret.TS
+		return nil
+	})
+	return err
+}
+
+// updateActionMessage updates the moderation channel message for a to match its current state.
+func (h *handler) updateActionMessage(a moderationAction) {
+	if a.MessageTS == "" {
+		return
+	}
+	message := a.message()
+	message["channel"] = a.MessageChannel
+	message["ts"] = a.MessageTS
+	if err := h.client.CallMethod("chat.update", message, nil); err != nil {
+		log.Printf("Failed to update message for action %d: %v\n", a.ID, err)
+	}
+}
+
+// reactivateUser reactivates the target user on behalf of the moderator, marks the action that
+// deactivated them as undone, and logs the reactivation next to it in the moderation channel.
+func (h *handler) reactivateUser(moderator, targetUser string) error {
+	if err := h.setUserActive(targetUser, true); err != nil {
+		return err
+	}
+	log.Printf("User %s reactivated %s.\n", moderator, targetUser)
+	text := fmt.Sprintf("<@%s> reactivated <@%s>.", moderator, targetUser)
+	var original moderationAction
+	if h.cases != nil {
+		a, found, err := h.cases.latestAction(targetUser, "")
+		if err != nil {
+			log.Printf("Failed to look up why %s was deactivated: %v\n", targetUser, err)
+		} else if found {
+			original, err = h.cases.updateAction(a.ID, func(a *moderationAction) error {
+				a.ReactivatedBy = moderator
+				a.Reactivated = time.Now()
+				return nil
+			})
+			if err != nil {
+				log.Printf("Failed to record that action %d was undone: %v\n", a.ID, err)
+			}
+			text += fmt.Sprintf(" This undoes action %d: %s.", a.ID, a.describe())
+			h.updateActionMessage(original)
+		} else {
+			text += " There is no record of why they were deactivated."
+		}
+	}
+
+	message := map[string]interface{}{"text": text}
+	api := h.client.Config.WebhookURL
+	if h.modChannel != "" {
+		api = "chat.postMessage"
+		message["channel"] = h.modChannel
+		if original.MessageTS != "" {
+			// Reply to the original decision, so the two are read together, but make sure
+			// everyone sees it.
+			message["channel"] = original.MessageChannel
+			message["thread_ts"] = original.MessageTS
+			message["reply_broadcast"] = true
+		}
+	}
+	if err := h.client.CallMethod(api, message, nil); err != nil {
+		log.Printf("Failed to log reactivation of %s: %v\n", targetUser, err)
+	}
+	return nil
+}
+
+// handleActionButton handles the reactivate button on moderation action messages.
+func (h *handler) handleActionButton(interaction slackInteraction, rw http.ResponseWriter) {
+	if permissions, err := h.permissions(interaction.User.ID); err != nil || !hasPermission(permissions, permissionDeactivate) {
+		respondEphemeral(rw, "Only moderators who can deactivate users can reactivate them.")
+		return
+	}
+	if len(interaction.Actions) != 1 || interaction.Actions[0].Name != "reactivate" {
+		logError(rw, "Expected one reactivate action, but got %v.", interaction.Actions)
+		return
+	}
+	id, err := strconv.ParseUint(interaction.Actions[0].Value, 10, 64)
+	if err != nil {
+		logError(rw, "Failed to parse action ID %q: %v.", interaction.Actions[0].Value, err)
+		return
+	}
+	a, err := h.cases.getAction(id)
+	if err != nil {
+		respondEphemeral(rw, fmt.Sprintf("Couldn't find action %d: %v.", id, err))
+		return
+	}
+	if !a.Reactivated.IsZero() {
+		respondEphemeral(rw, fmt.Sprintf("Action %d has already been undone.", id))
+		return
+	}
+	if err := h.reactivateUser(interaction.User.ID, a.TargetUser); err != nil {
+		respondEphemeral(rw, fmt.Sprintf("Failed to reactivate <@%s>: %v", a.TargetUser, err))
+		return
+	}
+	respondEphemeral(rw, fmt.Sprintf("Reactivated <@%s>.", a.TargetUser))
+}
@@ -0,0 +1,192 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/slack-infra/slack"
+)
+
+// maxAppealSize is the most we'll read of an appeal, including any attachments an email service
+// sends along with it.
+const maxAppealSize = 1 << 20
+
+// appealHandler accepts appeals from deactivated users, who can no longer use Slack, and files
+// them as cases. Appeals are POSTed as forms, either by a web form or by a service that forwards
+// email, and must carry the configured token.
+type appealHandler struct {
+	h     *handler
+	token string
+}
+
+// appeal is what we understand of an incoming appeal.
+type appeal struct {
+	user    string
+	email   string
+	subject string
+	text    string
+	action  uint64
+}
+
+// firstValue returns the first non-empty value of any of the given form fields. Email forwarding
+// services don't agree on what to call things, so we accept several names.
+func firstValue(r *http.Request, names ...string) string {
+	for _, n := range names {
+		if v := strings.TrimSpace(r.FormValue(n)); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func parseAppeal(r *http.Request) (appeal, error) {
+	a := appeal{
+		user:    firstValue(r, "user"),
+		subject: firstValue(r, "subject"),
+		text:    firstValue(r, "text", "stripped-text", "body-plain"),
+	}
+	if email := firstValue(r, "email", "sender", "from"); email != "" {
+		address, err := mail.ParseAddress(email)
+		if err != nil {
+			return a, fmt.Errorf("couldn't parse email address %q: %v", email, err)
+		}
+		a.email = address.Address
+	}
+	if action := firstValue(r, "action"); action != "" {
+		var err error
+		a.action, err = strconv.ParseUint(action, 10, 64)
+		if err != nil {
+			return a, fmt.Errorf("%q is not an action number", action)
+		}
+	}
+	if a.user != "" {
+		user, ok := parseUserRef(a.user)
+		if !ok {
+			return a, fmt.Errorf("%q is not a user ID", a.user)
+		}
+		a.user = user
+	}
+	if a.user == "" && a.email == "" {
+		return a, fmt.Errorf("appeals need a user ID or an email address")
+	}
+	if a.text == "" {
+		return a, fmt.Errorf("appeals need some text")
+	}
+	return a, nil
+}
+
+func (ah appealHandler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(rw, "Appeals must be POSTed.", http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(rw, r.Body, maxAppealSize)
+	if err := r.ParseMultipartForm(maxAppealSize); err != nil && err != http.ErrNotMultipart {
+		http.Error(rw, fmt.Sprintf("Couldn't parse appeal: %v", err), http.StatusBadRequest)
+		return
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		token = r.FormValue("token")
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(ah.token)) != 1 {
+		http.Error(rw, "Invalid token.", http.StatusForbidden)
+		return
+	}
+	a, err := parseAppeal(r)
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("Couldn't accept appeal: %v.", err), http.StatusBadRequest)
+		return
+	}
+	id, err := ah.h.fileAppeal(a)
+	if err != nil {
+		logError(rw, "Failed to file appeal: %v", err)
+		return
+	}
+	log.Printf("Filed appeal from %s%s as case %d.\n", a.user, a.email, id)
+	_, _ = fmt.Fprintf(rw, "Thank you. Your appeal has been received, and the moderators will look into it.\n")
+}
+
+// fileAppeal files a as a case, linked to the action it appeals against if we can find it, and
+// returns the case's ID.
+func (h *handler) fileAppeal(a appeal) (uint64, error) {
+	var action moderationAction
+	found := false
+	if a.action != 0 {
+		var err error
+		action, err = h.cases.getAction(a.action)
+		// Only trust the action number if it's about the person appealing.
+		found = err == nil && ((a.user != "" && action.TargetUser == a.user) || (a.user == "" && strings.EqualFold(action.TargetEmail, a.email)))
+	}
+	if !found {
+		var err error
+		action, found, err = h.cases.latestAction(a.user, a.email)
+		if err != nil {
+			return 0, fmt.Errorf("couldn't look up actions: %v", err)
+		}
+	}
+
+	c := &reportCase{Appeal: true, Sender: a.user, AppellantEmail: slack.EscapeMessage(a.email)}
+	if found {
+		c.AppealOf = action.ID
+		c.Sender = action.TargetUser
+	}
+	c.Summary = fmt.Sprintf("%s *appealed*", c.appellant())
+	decision := "We couldn't find the action being appealed."
+	if found {
+		c.Summary += fmt.Sprintf(" against action %d", action.ID)
+		decision = fmt.Sprintf("Original decision: %s. Reactivate them with the button on that decision, or `/mod reactivate <@%s>`.", action.describe(), action.TargetUser)
+	}
+	c.Summary += "."
+	c.Attachments = []map[string]interface{}{
+		{
+			"title":    slack.EscapeMessage(a.subject),
+			"text":     slack.EscapeMessage(a.text),
+			"fallback": slack.EscapeMessage(a.text),
+		},
+		{
+			"text":      decision,
+			"fallback":  decision,
+			"mrkdwn_in": []string{"text"},
+		},
+	}
+	if a.email != "" {
+		c.Attachments[0]["footer"] = "From " + c.AppellantEmail
+	}
+	if err := h.fileCase(c); err != nil {
+		return 0, err
+	}
+	if found {
+		updated, err := h.cases.updateAction(action.ID, func(a *moderationAction) error {
+			a.Appeals = append(a.Appeals, c.ID)
+			return nil
+		})
+		if err != nil {
+			log.Printf("Failed to link case %d to action %d: %v\n", c.ID, action.ID, err)
+		} else {
+			h.updateActionMessage(updated)
+		}
+	}
+	return c.ID, nil
+}
@@ -0,0 +1,183 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/slack-infra/slack"
+	"sigs.k8s.io/slack-infra/slack/slacktest"
+)
+
+func TestReactivationIsLoggedWithDecision(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	store := newCaseStore(db)
+	s := slacktest.NewServer()
+	defer s.Close()
+	modChannel := s.AddChannel(slack.Conversation{Name: "moderation"})
+	s.AddUser(slack.User{ID: "UMOD", IsAdmin: true})
+	s.AddUser(slack.User{ID: "USPAMMER"})
+	h := &handler{client: s.Client(), scim: s.SCIMClient(), cache: newLookupCache(time.Minute), cases: store, modChannel: modChannel}
+
+	if err := h.setUserActive("USPAMMER", false); err != nil {
+		t.Fatalf("Failed to deactivate user: %v", err)
+	}
+	if err := h.recordDeactivation("UMOD", "", "USPAMMER"); err != nil {
+		t.Fatalf("Failed to record deactivation: %v", err)
+	}
+	decision := s.Messages(modChannel)
+	if len(decision) != 1 || !strings.Contains(decision[0].Text, "<@UMOD> deactivated <@USPAMMER>") {
+		t.Fatalf("Expected the decision to be posted in the moderation channel, but got %#v", decision)
+	}
+
+	interaction := slackInteraction{Type: "interactive_message", CallbackID: "moderation_action"}
+	interaction.User.ID = "UMOD"
+	interaction.Actions = append(interaction.Actions, struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}{Name: "reactivate", Value: "1"})
+	rw := httptest.NewRecorder()
+	h.handleActionButton(interaction, rw)
+	if body := rw.Body.String(); !strings.Contains(body, "Reactivated") {
+		t.Errorf("Expected the user to be reactivated, but got %s", body)
+	}
+	if u, _ := s.User("USPAMMER"); u.Deleted {
+		t.Errorf("Expected USPAMMER to be active again")
+	}
+	a, err := store.getAction(1)
+	if err != nil || a.ReactivatedBy != "UMOD" || a.Reactivated.IsZero() {
+		t.Errorf("Expected the action to be undone by UMOD, but got %#v (%v)", a, err)
+	}
+
+	var log []map[string]string
+	for _, r := range s.RequestsFor("chat.postMessage") {
+		log = append(log, map[string]string{"thread_ts": r.Args["thread_ts"], "reply_broadcast": r.Args["reply_broadcast"]})
+		if len(log) == 2 && !strings.Contains(r.Args["text"], "This undoes action 1: <@UMOD> deactivated <@USPAMMER>") {
+			t.Errorf("Expected the reactivation to mention the original decision, but got %q", r.Args["text"])
+		}
+	}
+	expected := []map[string]string{{"thread_ts": "", "reply_broadcast": ""}, {"thread_ts": decision[0].TS, "reply_broadcast": "true"}}
+	if !reflect.DeepEqual(log, expected) {
+		t.Errorf("Expected the reactivation to be posted in the decision's thread, but got %v", log)
+	}
+	if updates := s.RequestsFor("chat.update"); len(updates) != 1 || !strings.Contains(updates[0].Args["attachments"], "Action 1 was undone by") {
+		t.Errorf("Expected the decision to be updated, but got %#v", updates)
+	}
+
+	rw = httptest.NewRecorder()
+	h.handleActionButton(interaction, rw)
+	if body := rw.Body.String(); !strings.Contains(body, "already been undone") {
+		t.Errorf("Expected a second reactivation to be refused, but got %s", body)
+	}
+}
+
+func TestAppeals(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	store := newCaseStore(db)
+	s := slacktest.NewServer()
+	defer s.Close()
+	modChannel := s.AddChannel(slack.Conversation{Name: "moderation"})
+	spammer := slack.User{ID: "USPAMMER"}
+	spammer.Profile.Email = "spammer@example.com"
+	s.AddUser(spammer)
+	h := &handler{client: s.Client(), cache: newLookupCache(time.Minute), cases: store, modChannel: modChannel}
+	if err := h.recordDeactivation("UMOD", "", "USPAMMER"); err != nil {
+		t.Fatalf("Failed to record deactivation: %v", err)
+	}
+	server := httptest.NewServer(appealHandler{h: h, token: "secret"})
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		form     url.Values
+		status   int
+		appealOf uint64
+	}{
+		{
+			name:   "wrong token",
+			form:   url.Values{"token": {"guess"}, "user": {"USPAMMER"}, "text": {"let me back in"}},
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "no text",
+			form:   url.Values{"token": {"secret"}, "user": {"USPAMMER"}},
+			status: http.StatusBadRequest,
+		},
+		{
+			name:     "by user ID",
+			form:     url.Values{"token": {"secret"}, "user": {"USPAMMER"}, "text": {"let me back in"}},
+			status:   http.StatusOK,
+			appealOf: 1,
+		},
+		{
+			name:     "by email",
+			form:     url.Values{"token": {"secret"}, "from": {"Spammer <Spammer@example.com>"}, "subject": {"Appeal"}, "body-plain": {"<!channel> let me back in"}},
+			status:   http.StatusOK,
+			appealOf: 1,
+		},
+		{
+			name:   "unknown email",
+			form:   url.Values{"token": {"secret"}, "email": {"someone@example.com"}, "text": {"what did I do?"}},
+			status: http.StatusOK,
+		},
+	}
+	var filed []uint64
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := http.PostForm(server.URL, tc.form)
+			if err != nil {
+				t.Fatalf("Failed to post appeal: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tc.status {
+				t.Fatalf("Expected status %d, but got %d", tc.status, resp.StatusCode)
+			}
+			if tc.status != http.StatusOK {
+				return
+			}
+			cases, err := store.openCases()
+			if err != nil || len(cases) == 0 {
+				t.Fatalf("Expected a case to be filed, but got %v (%v)", cases, err)
+			}
+			c := cases[len(cases)-1]
+			if !c.Appeal || c.AppealOf != tc.appealOf {
+				t.Errorf("Expected an appeal against action %d, but got %#v", tc.appealOf, c)
+			}
+			if tc.appealOf != 0 {
+				filed = append(filed, c.ID)
+			}
+		})
+	}
+
+	a, err := store.getAction(1)
+	if err != nil || !reflect.DeepEqual(a.Appeals, filed) {
+		t.Errorf("Expected action 1 to be linked to cases %v, but got %v (%v)", filed, a.Appeals, err)
+	}
+	for _, r := range s.RequestsFor("chat.postMessage") {
+		if strings.Contains(r.Args["attachments"], "<!channel>") {
+			t.Errorf("Expected appeals to be escaped, but got %s", r.Args["attachments"])
+		}
+	}
+}
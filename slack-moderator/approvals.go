@@ -0,0 +1,410 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// defaultApprovalTimeout is how long approval requests wait for a second moderator, unless
+// configured otherwise.
+const defaultApprovalTimeout = time.Hour
+
+// approvalConfig is the part of the config file that says which actions need a second moderator's
+// approval.
+type approvalConfig struct {
+	// Deactivate is whether deactivating users needs approval.
+	Deactivate bool `json:"deactivate"`
+	// RemovalOver is the longest content removal window that doesn't need approval, as a Go
+	// duration. If it is not set, removals never need approval.
+	RemovalOver string `json:"removalOver"`
+	// Timeout is how long requests wait for approval before they expire, as a Go duration.
+	Timeout string `json:"timeout"`
+}
+
+// approvalPolicy says which actions need a second moderator's approval.
+type approvalPolicy struct {
+	deactivate bool
+	// removalOver is the longest removal window that doesn't need approval. If it is zero, no
+	// removal does.
+	removalOver time.Duration
+}
+
+func (c approvalConfig) parse() (approvalPolicy, time.Duration, error) {
+	p := approvalPolicy{deactivate: c.Deactivate}
+	timeout := defaultApprovalTimeout
+	var err error
+	if c.RemovalOver != "" {
+		p.removalOver, err = time.ParseDuration(c.RemovalOver)
+		if err != nil {
+			return p, 0, fmt.Errorf("couldn't parse removalOver %q: %v", c.RemovalOver, err)
+		}
+		if p.removalOver <= 0 {
+			return p, 0, fmt.Errorf("removalOver must be positive, but is %s", p.removalOver)
+		}
+	}
+	if c.Timeout != "" {
+		timeout, err = time.ParseDuration(c.Timeout)
+		if err != nil {
+			return p, 0, fmt.Errorf("couldn't parse timeout %q: %v", c.Timeout, err)
+		}
+	}
+	return p, timeout, nil
+}
+
+// enabled returns whether anything needs approval.
+func (p approvalPolicy) enabled() bool {
+	return p.deactivate || p.removalOver > 0
+}
+
+// removalNeedsApproval returns whether removing content in the given scope needs approval.
+func (p approvalPolicy) removalNeedsApproval(scope removalScope, now time.Time) bool {
+	return p.removalOver > 0 && scope.length(now) > p.removalOver
+}
+
+type approvalKind string
+
+const (
+	approvalDeactivate approvalKind = "deactivate"
+	approvalRemoval    approvalKind = "removal"
+)
+
+var approvalsBucket = []byte("approvals")
+
+// approvalRequest is a high-impact action waiting for a second moderator.
+type approvalRequest struct {
+	ID         uint64       `json:"id"`
+	Kind       approvalKind `json:"kind"`
+	Requester  string       `json:"requester"`
+	TargetUser string       `json:"target_user"`
+	// Scope is what to remove once approved, if this is a removal.
+	Scope   removalScope `json:"scope"`
+	Expires time.Time    `json:"expires"`
+	// Channel and TS identify the request's message in the moderation channel.
+	Channel string `json:"channel,omitempty"`
+	TS      string `json:"ts,omitempty"`
+}
+
+// permission returns the permission a moderator needs to approve r.
+func (r approvalRequest) permission() permission {
+	if r.Kind == approvalDeactivate {
+		return permissionDeactivate
+	}
+	return permissionRemoveContent
+}
+
+// describe returns what r wants to do, for showing to moderators.
+func (r approvalRequest) describe() string {
+	if r.Kind == approvalDeactivate {
+		return fmt.Sprintf("deactivate <@%s>", r.TargetUser)
+	}
+	return fmt.Sprintf("remove content from <@%s>, %s", r.TargetUser, r.Scope.describe())
+}
+
+// message returns the moderation channel message for r. If outcome is empty, it has buttons to
+// approve or reject the request; otherwise, it says how the request turned out.
+func (r approvalRequest) message(outcome string) map[string]interface{} {
+	text := fmt.Sprintf("<@%s> wants to %s.", r.Requester, r.describe())
+	attachment := map[string]interface{}{
+		"text":        outcome,
+		"fallback":    outcome,
+		"callback_id": "approval_request",
+		"mrkdwn_in":   []string{"text"},
+	}
+	if outcome == "" {
+		id := strconv.FormatUint(r.ID, 10)
+		status := fmt.Sprintf("This needs another moderator's approval by %s UTC.", r.Expires.UTC().Format(removalTimeLayout))
+		attachment["text"] = status
+		attachment["fallback"] = status
+		attachment["actions"] = []map[string]interface{}{
+			{"name": "approve", "text": "Approve", "type": "button", "style": "primary", "value": id},
+			{"name": "reject", "text": "Reject", "type": "button", "style": "danger", "value": id},
+		}
+	}
+	return map[string]interface{}{
+		"text":        text,
+		"attachments": []map[string]interface{}{attachment},
+	}
+}
+
+// pendingApprovals holds approval requests until they are approved, rejected, or expire.
+type pendingApprovals struct {
+	ttl time.Duration
+	now func() time.Time
+	// stored keeps requests in a database, if there is one, so that they outlast restarts.
+	stored *bucket
+
+	mu       sync.Mutex
+	lastID   uint64
+	requests map[uint64]approvalRequest
+}
+
+// newPendingApprovals returns an empty pendingApprovals, which keeps requests in db as well as in
+// memory if db isn't nil.
+func newPendingApprovals(ttl time.Duration, db *bolt.DB) *pendingApprovals {
+	p := &pendingApprovals{ttl: ttl, now: time.Now, requests: map[uint64]approvalRequest{}}
+	if db != nil {
+		p.stored = &bucket{db: db, name: approvalsBucket, kind: "approval request"}
+	}
+	return p
+}
+
+// restore picks up the requests kept in the database, and returns them.
+func (p *pendingApprovals) restore() ([]approvalRequest, error) {
+	if p.stored == nil {
+		return nil, nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var result []approvalRequest
+	err := p.stored.scan(false, func(id uint64, v []byte) (bool, error) {
+		var r approvalRequest
+		if err := p.stored.parse(id, v, &r); err != nil {
+			return false, err
+		}
+		p.requests[id] = r
+		result = append(result, r)
+		return true, nil
+	})
+	return result, err
+}
+
+// add holds r until it is taken or dropped, and returns it as held, with its ID filled in.
+func (p *pendingApprovals) add(r approvalRequest) (approvalRequest, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r.Expires = p.now().Add(p.ttl)
+	if p.stored != nil {
+		if err := p.stored.create(&r, func(id uint64) { r.ID = id }); err != nil {
+			return r, fmt.Errorf("couldn't store request: %v", err)
+		}
+	} else {
+		p.lastID++
+		r.ID = p.lastID
+	}
+	p.requests[r.ID] = r
+	return r, nil
+}
+
+// setMessage records where the request with the given ID was posted.
+func (p *pendingApprovals) setMessage(id uint64, channel, ts string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r, ok := p.requests[id]
+	if !ok {
+		return nil
+	}
+	r.Channel, r.TS = channel, ts
+	p.requests[id] = r
+	if p.stored == nil {
+		return nil
+	}
+	var stored approvalRequest
+	return p.stored.update(id, &stored, func(*bolt.Tx) error {
+		stored.Channel, stored.TS = channel, ts
+		return nil
+	})
+}
+
+// take returns the request with the given ID and forgets about it, as long as it hasn't expired,
+// the user has the given permissions, and, if they are approving it, they aren't the one who asked.
+// Requests that can't be taken stay pending for someone else.
+func (p *pendingApprovals) take(id uint64, user string, permissions []permission, approve bool) (approvalRequest, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r, ok := p.requests[id]
+	if !ok || p.now().After(r.Expires) {
+		return approvalRequest{}, errors.New("it has expired, or was already approved or rejected")
+	}
+	if !hasPermission(permissions, r.permission()) {
+		return approvalRequest{}, fmt.Errorf("you aren't allowed to %s yourself", r.describe())
+	}
+	if approve && r.Requester == user {
+		return approvalRequest{}, errors.New("you can't approve your own request")
+	}
+	if err := p.forget(id); err != nil {
+		return approvalRequest{}, err
+	}
+	return r, nil
+}
+
+// drop forgets about the request with the given ID, and returns it if it was still waiting.
+func (p *pendingApprovals) drop(id uint64) (approvalRequest, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r, ok := p.requests[id]
+	if err := p.forget(id); err != nil {
+		log.Printf("Failed to forget approval request %d: %v\n", id, err)
+	}
+	return r, ok
+}
+
+// forget removes the request with the given ID. The caller must hold p.mu.
+func (p *pendingApprovals) forget(id uint64) error {
+	if p.stored != nil {
+		if err := p.stored.delete(id); err != nil {
+			return fmt.Errorf("couldn't remove stored request: %v", err)
+		}
+	}
+	delete(p.requests, id)
+	return nil
+}
+
+// requestApproval posts r in the moderation channel for another moderator to approve, and
+// arranges for it to expire.
+func (h *handler) requestApproval(r approvalRequest) error {
+	if h.modChannel == "" {
+		return errors.New("there is no moderation channel to ask in")
+	}
+	r, err := h.approvals.add(r)
+	if err != nil {
+		return err
+	}
+	message := r.message("")
+	message["channel"] = h.modChannel
+	ret := struct {
+		Channel string `json:"channel"`
+		TS      string `json:"ts"`
+	}{}
+	if err := h.client.CallMethod("chat.postMessage", message, &ret); err != nil {
+		h.approvals.drop(r.ID)
+		return fmt.Errorf("couldn't post approval request: %v", err)
+	}
+	if err := h.approvals.setMessage(r.ID, ret.Channel, ret.TS); err != nil {
+		log.Printf("Failed to record where approval request %d was posted: %v\n", r.ID, err)
+	}
+	log.Printf("User %s asked for approval to %s.\n", r.Requester, r.describe())
+	h.expireApproval(r)
+	return nil
+}
+
+// expireApproval arranges for r to expire when its time runs out, or straight away if it already
+// has.
+func (h *handler) expireApproval(r approvalRequest) {
+	time.AfterFunc(time.Until(r.Expires), func() {
+		if r, ok := h.approvals.drop(r.ID); ok {
+			log.Printf("Request from %s to %s expired.\n", r.Requester, r.describe())
+			h.updateApprovalMessage(r, "Expired without approval. Nothing was done.")
+		}
+	})
+}
+
+// restoreApprovals picks up the requests that were waiting when slack-moderator last stopped.
+// Those whose time ran out in the meantime expire straight away.
+func (h *handler) restoreApprovals() error {
+	requests, err := h.approvals.restore()
+	if err != nil {
+		return fmt.Errorf("couldn't restore approval requests: %v", err)
+	}
+	for _, r := range requests {
+		log.Printf("Restored request %d from %s to %s.\n", r.ID, r.Requester, r.describe())
+		h.expireApproval(r)
+	}
+	return nil
+}
+
+// updateApprovalMessage replaces the buttons on r's message with its outcome.
+func (h *handler) updateApprovalMessage(r approvalRequest, outcome string) {
+	if r.TS == "" {
+		return
+	}
+	message := r.message(outcome)
+	message["channel"] = r.Channel
+	message["ts"] = r.TS
+	if err := h.client.CallMethod("chat.update", message, nil); err != nil {
+		log.Printf("Failed to update approval request: %v\n", err)
+	}
+}
+
+// handleApprovalAction handles the buttons on approval requests.
+func (h *handler) handleApprovalAction(interaction slackInteraction, rw http.ResponseWriter) {
+	if len(interaction.Actions) != 1 {
+		logError(rw, "Expected one action, but got %d.", len(interaction.Actions))
+		return
+	}
+	action := interaction.Actions[0]
+	if action.Name != "approve" && action.Name != "reject" {
+		logError(rw, "Unknown approval action %q.", action.Name)
+		return
+	}
+	id, err := strconv.ParseUint(action.Value, 10, 64)
+	if err != nil {
+		logError(rw, "Failed to parse request ID %q: %v.", action.Value, err)
+		return
+	}
+	user := interaction.User.ID
+	permissions, err := h.permissions(user)
+	if err != nil || len(permissions) == 0 {
+		respondEphemeral(rw, "Only moderators can approve or reject requests.")
+		return
+	}
+	r, err := h.approvals.take(id, user, permissions, action.Name == "approve")
+	if err != nil {
+		respondEphemeral(rw, fmt.Sprintf("Couldn't %s the request, because %v.", action.Name, err))
+		return
+	}
+
+	if action.Name == "reject" {
+		log.Printf("User %s (%s) rejected the request from %s to %s.\n", user, interaction.User.Name, r.Requester, r.describe())
+		h.updateApprovalMessage(r, fmt.Sprintf("Rejected by <@%s>. Nothing was done.", user))
+		respondEphemeral(rw, "Rejected.")
+		return
+	}
+	log.Printf("User %s (%s) approved the request from %s to %s.\n", user, interaction.User.Name, r.Requester, r.describe())
+	outcome := h.carryOut(r, user)
+	h.updateApprovalMessage(r, fmt.Sprintf("Approved by <@%s>. %s", user, outcome))
+	respondEphemeral(rw, outcome)
+}
+
+// carryOut does what r asked for, now that approver has approved it, and says how that went.
+func (h *handler) carryOut(r approvalRequest, approver string) string {
+	// The requester may have lost the permission while the request was waiting.
+	if permissions, err := h.permissions(r.Requester); err != nil || !hasPermission(permissions, r.permission()) {
+		return fmt.Sprintf("Nothing was done, because <@%s> is no longer allowed to %s.", r.Requester, r.describe())
+	}
+	switch r.Kind {
+	case approvalDeactivate:
+		for _, moderator := range []string{r.Requester, approver} {
+			if err := h.checkCanDeactivate(moderator, r.TargetUser); err != nil {
+				return fmt.Sprintf("Not deactivating <@%s>, because %v.", r.TargetUser, err)
+			}
+		}
+		if err := h.setUserActive(r.TargetUser, false); err != nil {
+			return fmt.Sprintf("Failed to deactivate <@%s>: %v", r.TargetUser, err)
+		}
+		if err := h.recordDeactivation(r.Requester, approver, r.TargetUser); err != nil {
+			log.Printf("Failed to record deactivation of %s: %v\n", r.TargetUser, err)
+		}
+		return fmt.Sprintf("Deactivated <@%s>.", r.TargetUser)
+	case approvalRemoval:
+		j, err := h.startJob(pendingRemoval{moderator: r.Requester, targetUser: r.TargetUser, scope: r.Scope}, approver)
+		if err != nil {
+			return fmt.Sprintf("Failed to start removal: %v", err)
+		}
+		go h.runJob(j.ID)
+		return fmt.Sprintf("Started removal job %d.", j.ID)
+	}
+	return fmt.Sprintf("Unknown request kind %q.", r.Kind)
+}
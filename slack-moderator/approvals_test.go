@@ -0,0 +1,285 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/slack-infra/slack"
+	"sigs.k8s.io/slack-infra/slack/slacktest"
+)
+
+func pressApprovalButton(h *handler, user, action, id string) string {
+	interaction := slackInteraction{Type: "interactive_message", CallbackID: "approval_request"}
+	interaction.User.ID = user
+	interaction.Actions = append(interaction.Actions, struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}{Name: action, Value: id})
+	rw := httptest.NewRecorder()
+	h.handleApprovalAction(interaction, rw)
+	return rw.Body.String()
+}
+
+// onlyApproval returns the ID of the only pending approval request.
+func onlyApproval(t *testing.T, p *pendingApprovals) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.requests) != 1 {
+		t.Fatalf("Expected one pending approval, but got %d", len(p.requests))
+	}
+	for id := range p.requests {
+		return strconv.FormatUint(id, 10)
+	}
+	return ""
+}
+
+func TestDeactivationNeedsApproval(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	store := newCaseStore(db)
+	s := slacktest.NewServer()
+	defer s.Close()
+	responseServer, responses := newResponseServer()
+	defer responseServer.Close()
+	modChannel := s.AddChannel(slack.Conversation{Name: "moderation"})
+	s.AddUser(slack.User{ID: "UMOD", IsAdmin: true})
+	s.AddUser(slack.User{ID: "UOTHERMOD", IsAdmin: true})
+	s.AddUser(slack.User{ID: "UNOBODY"})
+	s.AddUser(slack.User{ID: "USPAMMER"})
+	h := &handler{client: s.Client(), scim: s.SCIMClient(), cache: newLookupCache(time.Minute), cases: store, modChannel: modChannel, approval: approvalPolicy{deactivate: true}, approvals: newPendingApprovals(time.Minute, nil)}
+
+	interaction := slackInteraction{Type: "dialog_submission", CallbackID: "moderate_user", ResponseURL: responseServer.URL, State: "USPAMMER"}
+	interaction.User.ID = "UMOD"
+	interaction.Submission = map[string]string{"deactivate": "yes"}
+	h.handleModerateSubmission(interaction)
+	<-responses // "Please wait..."
+	if text := (<-responses)["text"].(string); !strings.Contains(text, "needs another moderator's approval") {
+		t.Errorf("Expected the response to say approval is needed, but got %q", text)
+	}
+	if u, _ := s.User("USPAMMER"); u.Deleted {
+		t.Fatalf("Expected USPAMMER not to be deactivated before approval")
+	}
+	request := s.Messages(modChannel)
+	if len(request) != 1 || request[0].Text != "<@UMOD> wants to deactivate <@USPAMMER>." {
+		t.Fatalf("Expected an approval request in the moderation channel, but got %#v", request)
+	}
+	id := onlyApproval(t, h.approvals)
+
+	tests := []struct {
+		name     string
+		user     string
+		action   string
+		expected string
+	}{
+		{name: "non-moderators can't approve", user: "UNOBODY", action: "approve", expected: "Only moderators"},
+		{name: "moderators can't approve their own requests", user: "UMOD", action: "approve", expected: "can't approve your own request"},
+		{name: "another moderator can approve", user: "UOTHERMOD", action: "approve", expected: "Deactivated"},
+		{name: "requests can only be approved once", user: "UOTHERMOD", action: "approve", expected: "already approved or rejected"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if body := pressApprovalButton(h, tc.user, tc.action, id); !strings.Contains(body, tc.expected) {
+				t.Errorf("Expected a response containing %q, but got %s", tc.expected, body)
+			}
+		})
+	}
+
+	if u, _ := s.User("USPAMMER"); !u.Deleted {
+		t.Errorf("Expected USPAMMER to be deactivated once approved")
+	}
+	a, err := store.getAction(1)
+	if err != nil || a.Moderator != "UMOD" || a.ApprovedBy != "UOTHERMOD" {
+		t.Errorf("Expected the action to be recorded as by UMOD and approved by UOTHERMOD, but got %#v (%v)", a, err)
+	}
+	if updates := s.RequestsFor("chat.update"); len(updates) != 1 || !strings.Contains(updates[0].Args["attachments"], "Approved by") {
+		t.Errorf("Expected the request to be updated with its approval, but got %#v", updates)
+	}
+}
+
+func TestRemovalOverThresholdNeedsApproval(t *testing.T) {
+	s := slacktest.NewServer()
+	defer s.Close()
+	responseServer, _ := newResponseServer()
+	defer responseServer.Close()
+	jobs := newMemoryJobStore()
+	modChannel := s.AddChannel(slack.Conversation{Name: "moderation"})
+	general := s.AddChannel(slack.Conversation{Name: "general"})
+	s.AddUser(slack.User{ID: "UMOD", IsAdmin: true})
+	s.AddUser(slack.User{ID: "UOTHERMOD", IsAdmin: true})
+	s.AddMessage(general, slack.Message{User: "USPAMMER", Text: "buy things", TS: strconv.FormatInt(time.Now().Unix(), 10) + ".000100"})
+	h := &handler{client: s.Client(), cache: newLookupCache(time.Minute), pending: newPendingRemovals(time.Minute), jobs: jobs, modChannel: modChannel, approval: approvalPolicy{removalOver: time.Hour}, approvals: newPendingApprovals(time.Minute, nil)}
+
+	id, err := h.pending.add(pendingRemoval{moderator: "UMOD", targetUser: "USPAMMER", scope: removalScope{Since: time.Now().Add(-2 * time.Hour)}})
+	if err != nil {
+		t.Fatalf("Failed to add pending removal: %v", err)
+	}
+	if body := pressRemovalButton(h, "UMOD", "confirm", id, responseServer.URL).Body.String(); !strings.Contains(body, "needs another moderator's approval") {
+		t.Errorf("Expected the removal to need approval, but got %s", body)
+	}
+	if _, err := jobs.get(1); err == nil {
+		t.Errorf("Expected no job to be started before approval")
+	}
+
+	if body := pressApprovalButton(h, "UOTHERMOD", "reject", onlyApproval(t, h.approvals)); !strings.Contains(body, "Rejected") {
+		t.Errorf("Expected the request to be rejected, but got %s", body)
+	}
+	if _, err := jobs.get(1); err == nil {
+		t.Errorf("Expected no job to be started after rejection")
+	}
+	if messages := s.Messages(general); len(messages) != 1 {
+		t.Errorf("Expected the message to be kept, but got %#v", messages)
+	}
+	if updates := s.RequestsFor("chat.update"); len(updates) != 1 || !strings.Contains(updates[0].Args["attachments"], "Rejected by") {
+		t.Errorf("Expected the request to be updated with its rejection, but got %#v", updates)
+	}
+}
+
+func TestRemovalNeedsApproval(t *testing.T) {
+	now := time.Date(2019, 10, 7, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		policy   approvalPolicy
+		scope    removalScope
+		expected bool
+	}{
+		{name: "no threshold", policy: approvalPolicy{deactivate: true}, scope: removalScope{Since: now.Add(-24 * time.Hour)}},
+		{name: "under the threshold", policy: approvalPolicy{removalOver: time.Hour}, scope: removalScope{Since: now.Add(-time.Hour)}},
+		{name: "over the threshold", policy: approvalPolicy{removalOver: time.Hour}, scope: removalScope{Since: now.Add(-2 * time.Hour)}, expected: true},
+		{name: "ended window over the threshold", policy: approvalPolicy{removalOver: time.Hour}, scope: removalScope{Since: now.Add(-4 * time.Hour), Until: now.Add(-time.Hour)}, expected: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := tc.policy.removalNeedsApproval(tc.scope, now); actual != tc.expected {
+				t.Errorf("Expected %t, but got %t", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestApprovalsExpire(t *testing.T) {
+	s := slacktest.NewServer()
+	defer s.Close()
+	modChannel := s.AddChannel(slack.Conversation{Name: "moderation"})
+	s.AddUser(slack.User{ID: "UOTHERMOD", IsAdmin: true})
+	h := &handler{client: s.Client(), scim: s.SCIMClient(), cache: newLookupCache(time.Minute), modChannel: modChannel, approval: approvalPolicy{deactivate: true}, approvals: newPendingApprovals(10*time.Millisecond, nil)}
+
+	if err := h.requestApproval(approvalRequest{Kind: approvalDeactivate, Requester: "UMOD", TargetUser: "USPAMMER"}); err != nil {
+		t.Fatalf("Failed to request approval: %v", err)
+	}
+	id := onlyApproval(t, h.approvals)
+	deadline := time.Now().Add(10 * time.Second)
+	for len(s.RequestsFor("chat.update")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the request to expire")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if update := s.RequestsFor("chat.update")[0]; !strings.Contains(update.Args["attachments"], "Expired without approval") {
+		t.Errorf("Expected the request to be marked as expired, but got %#v", update)
+	}
+	if body := pressApprovalButton(h, "UOTHERMOD", "approve", id); !strings.Contains(body, "expired") {
+		t.Errorf("Expected an expired request not to be approvable, but got %s", body)
+	}
+}
+
+func TestApprovalNeedsRequesterToKeepPermission(t *testing.T) {
+	s := slacktest.NewServer()
+	defer s.Close()
+	modChannel := s.AddChannel(slack.Conversation{Name: "moderation"})
+	s.AddUser(slack.User{ID: "UMOD"})
+	s.AddUser(slack.User{ID: "UOTHERMOD", IsAdmin: true})
+	s.AddUser(slack.User{ID: "USPAMMER"})
+	moderators := moderatorConfig{Users: []string{"UMOD"}, Permissions: []permission{permissionDeactivate}}
+	h := &handler{client: s.Client(), scim: s.SCIMClient(), cache: newLookupCache(time.Minute), moderators: moderators, modChannel: modChannel, approval: approvalPolicy{deactivate: true}, approvals: newPendingApprovals(time.Minute, nil)}
+
+	if err := h.requestApproval(approvalRequest{Kind: approvalDeactivate, Requester: "UMOD", TargetUser: "USPAMMER"}); err != nil {
+		t.Fatalf("Failed to request approval: %v", err)
+	}
+	h.moderators.Users = nil
+	if body := pressApprovalButton(h, "UOTHERMOD", "approve", onlyApproval(t, h.approvals)); !strings.Contains(body, "is no longer allowed to deactivate") {
+		t.Errorf("Expected the approval to be refused, but got %s", body)
+	}
+	if u, _ := s.User("USPAMMER"); u.Deleted {
+		t.Errorf("Expected USPAMMER not to be deactivated")
+	}
+}
+
+func TestApprovalsOutlastRestarts(t *testing.T) {
+	tests := []struct {
+		name string
+		// age is how long before the restart the request was made.
+		age         time.Duration
+		deactivated bool
+		outcome     string
+	}{
+		{name: "waiting requests can still be approved", age: time.Minute, deactivated: true, outcome: "Approved by"},
+		{name: "requests whose time ran out expire", age: 2 * time.Hour, outcome: "Expired without approval"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			db, cleanup := newTestDB(t)
+			defer cleanup()
+			s := slacktest.NewServer()
+			defer s.Close()
+			modChannel := s.AddChannel(slack.Conversation{Name: "moderation"})
+			s.AddUser(slack.User{ID: "UMOD", IsAdmin: true})
+			s.AddUser(slack.User{ID: "UOTHERMOD", IsAdmin: true})
+			s.AddUser(slack.User{ID: "USPAMMER"})
+
+			// Make the request without arranging for it to expire, as if slack-moderator stopped
+			// straight afterwards.
+			before := newPendingApprovals(time.Hour, db)
+			before.now = func() time.Time { return time.Now().Add(-tc.age) }
+			r, err := before.add(approvalRequest{Kind: approvalDeactivate, Requester: "UMOD", TargetUser: "USPAMMER"})
+			if err != nil {
+				t.Fatalf("Failed to add request: %v", err)
+			}
+			if err := before.setMessage(r.ID, modChannel, "1.000100"); err != nil {
+				t.Fatalf("Failed to record the request's message: %v", err)
+			}
+
+			h := &handler{client: s.Client(), scim: s.SCIMClient(), cache: newLookupCache(time.Minute), modChannel: modChannel, approval: approvalPolicy{deactivate: true}, approvals: newPendingApprovals(time.Hour, db)}
+			if err := h.restoreApprovals(); err != nil {
+				t.Fatalf("Failed to restore requests: %v", err)
+			}
+			if tc.deactivated {
+				pressApprovalButton(h, "UOTHERMOD", "approve", onlyApproval(t, h.approvals))
+			}
+			deadline := time.Now().Add(10 * time.Second)
+			for len(s.RequestsFor("chat.update")) == 0 {
+				if time.Now().After(deadline) {
+					t.Fatalf("Expected the request's message to be updated")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if update := s.RequestsFor("chat.update")[0]; update.Args["ts"] != "1.000100" || !strings.Contains(update.Args["attachments"], tc.outcome) {
+				t.Errorf("Expected the request's message to say %q, but got %#v", tc.outcome, update)
+			}
+			if u, _ := s.User("USPAMMER"); u.Deleted != tc.deactivated {
+				t.Errorf("Expected USPAMMER to be deactivated: %t, but got %t", tc.deactivated, u.Deleted)
+			}
+			if requests, err := newPendingApprovals(time.Hour, db).restore(); err != nil || len(requests) != 0 {
+				t.Errorf("Expected the request to be forgotten, but got %#v (%v)", requests, err)
+			}
+		})
+	}
+}
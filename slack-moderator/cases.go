@@ -0,0 +1,442 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+type caseStatus string
+
+const (
+	caseOpen      caseStatus = "open"
+	caseClaimed   caseStatus = "claimed"
+	caseResolved  caseStatus = "resolved"
+	caseDismissed caseStatus = "dismissed"
+)
+
+// isOpen returns whether a case with this status still needs attention.
+func (s caseStatus) isOpen() bool {
+	return s == caseOpen || s == caseClaimed
+}
+
+// reportCase is a report that moderators need to deal with.
+type reportCase struct {
+	ID      uint64     `json:"id"`
+	Status  caseStatus `json:"status"`
+	Created time.Time  `json:"created"`
+	// Reporter is the ID of the user who made the report. If they did so anonymously, it is only
+	// kept so that they can be told the outcome, and is never shown to moderators.
+	Reporter  string `json:"reporter,omitempty"`
+	Anonymous bool   `json:"anonymous,omitempty"`
+	// FollowUp is whether the reporter asked to be told the outcome of the case.
+	FollowUp bool `json:"follow_up,omitempty"`
+	// Sender is the ID of the user whose message was reported or, for appeals, who is appealing,
+	// if we know it.
+	Sender   string     `json:"sender"`
+	Channel  string     `json:"channel"`
+	Assignee string     `json:"assignee,omitempty"`
+	Notes    []caseNote `json:"notes,omitempty"`
+	// Appeal is whether the case is an appeal against a deactivation rather than a report. AppealOf
+	// is the ID of the action being appealed, if we could tell which it was, and AppellantEmail is
+	// the address the appeal came from, if any.
+	Appeal         bool   `json:"appeal,omitempty"`
+	AppealOf       uint64 `json:"appeal_of,omitempty"`
+	AppellantEmail string `json:"appellant_email,omitempty"`
+
+	// Summary and Attachments are the report as shown in the moderation channel.
+	Summary     string                   `json:"summary"`
+	Attachments []map[string]interface{} `json:"attachments"`
+	// MessageChannel and MessageTS identify the report's message in the moderation channel.
+	MessageChannel string `json:"message_channel,omitempty"`
+	MessageTS      string `json:"message_ts,omitempty"`
+}
+
+type caseNote struct {
+	Author string    `json:"author"`
+	Time   time.Time `json:"time"`
+	Text   string    `json:"text"`
+}
+
+var casesBucket = []byte("cases")
+
+// caseStore keeps cases, and the moderation actions they can be appeals against, in a BoltDB
+// database.
+type caseStore struct {
+	cases   bucket
+	actions bucket
+}
+
+func newCaseStore(db *bolt.DB) *caseStore {
+	return &caseStore{
+		cases:   bucket{db: db, name: casesBucket, kind: "case"},
+		actions: bucket{db: db, name: actionsBucket, kind: "action"},
+	}
+}
+
+// create stores c as a new open case, filling in its ID.
+func (s *caseStore) create(c *reportCase) error {
+	c.Status = caseOpen
+	return s.cases.create(c, func(id uint64) { c.ID = id })
+}
+
+// delete forgets the case with the given ID.
+func (s *caseStore) delete(id uint64) error {
+	return s.cases.delete(id)
+}
+
+func (s *caseStore) get(id uint64) (reportCase, error) {
+	var c reportCase
+	err := s.cases.get(id, &c)
+	return c, err
+}
+
+// update applies f to the case with the given ID and stores the result, unless f fails. It returns
+// the updated case.
+func (s *caseStore) update(id uint64, f func(c *reportCase) error) (reportCase, error) {
+	var c reportCase
+	err := s.cases.update(id, &c, func(*bolt.Tx) error { return f(&c) })
+	return c, err
+}
+
+// openCases returns every case that is open or claimed, oldest first.
+func (s *caseStore) openCases() ([]reportCase, error) {
+	var result []reportCase
+	err := s.cases.scan(false, func(id uint64, v []byte) (bool, error) {
+		var c reportCase
+		if err := s.cases.parse(id, v, &c); err != nil {
+			return false, err
+		}
+		if c.Status.isOpen() {
+			result = append(result, c)
+		}
+		return true, nil
+	})
+	return result, err
+}
+
+// appellant returns who filed an appeal, for showing to moderators.
+func (c reportCase) appellant() string {
+	if c.Sender != "" {
+		return fmt.Sprintf("<@%s>", c.Sender)
+	}
+	return c.AppellantEmail
+}
+
+// transition moves c to the status that the given button action leads to.
+func (c *reportCase) transition(action, user string) error {
+	if !c.Status.isOpen() {
+		return fmt.Errorf("case %d is already %s", c.ID, c.Status)
+	}
+	switch action {
+	case "claim":
+		c.Status = caseClaimed
+		c.Assignee = user
+	case "resolve":
+		c.Status = caseResolved
+	case "dismiss":
+		c.Status = caseDismissed
+	default:
+		return fmt.Errorf("unknown case action %q", action)
+	}
+	if c.Assignee == "" {
+		c.Assignee = user
+	}
+	return nil
+}
+
+// message returns the moderation channel message for c, with buttons to act on it if it's open.
+func (c reportCase) message() map[string]interface{} {
+	status := fmt.Sprintf("Case %d is *%s*", c.ID, c.Status)
+	if c.Assignee != "" {
+		status += fmt.Sprintf(" (<@%s>)", c.Assignee)
+	}
+	if c.FollowUp {
+		status += ". The reporter will be told the outcome."
+	}
+	statusAttachment := map[string]interface{}{
+		"text":        status,
+		"fallback":    status,
+		"callback_id": "report_case",
+		"mrkdwn_in":   []string{"text"},
+	}
+	if len(c.Notes) > 0 {
+		var fields []map[string]interface{}
+		for _, n := range c.Notes {
+			fields = append(fields, map[string]interface{}{"title": fmt.Sprintf("Note from <@%s>", n.Author), "value": n.Text})
+		}
+		statusAttachment["fields"] = fields
+	}
+	if c.Status.isOpen() {
+		value := fmt.Sprintf("%d", c.ID)
+		var actions []map[string]interface{}
+		if c.Status == caseOpen {
+			actions = append(actions, map[string]interface{}{"name": "claim", "text": "Claim", "type": "button", "value": value})
+		}
+		actions = append(actions,
+			map[string]interface{}{"name": "resolve", "text": "Resolve", "type": "button", "style": "primary", "value": value},
+			map[string]interface{}{"name": "dismiss", "text": "Dismiss", "type": "button", "value": value},
+		)
+		statusAttachment["actions"] = actions
+	}
+	return map[string]interface{}{
+		"text":        c.Summary,
+		"attachments": append(append([]map[string]interface{}{}, c.Attachments...), statusAttachment),
+	}
+}
+
+// fileCase stores c as a new case and posts it in the moderation channel. If it can't be posted,
+// the case is deleted again, so that no moderator could ever see it, and it fails.
+func (h *handler) fileCase(c *reportCase) error {
+	c.Created = time.Now()
+	if err := h.cases.create(c); err != nil {
+		return fmt.Errorf("couldn't create case: %v", err)
+	}
+	message := c.message()
+	message["channel"] = h.modChannel
+	ret := struct {
+		Channel string `json:"channel"`
+		TS      string `json:"ts"`
+	}{}
+	if err := h.client.CallMethod("chat.postMessage", message, &ret); err != nil {
+		if deleteErr := h.cases.delete(c.ID); deleteErr != nil {
+			log.Printf("Failed to delete unposted case %d: %v\n", c.ID, deleteErr)
+		}
+		return fmt.Errorf("couldn't post case %d: %v", c.ID, err)
+	}
+	_, err := h.cases.update(c.ID, func(c *reportCase) error {
+		c.MessageChannel, c.MessageTS = ret.Channel, ret.TS
+		return nil
+	})
+	return err
+}
+
+// updateCaseMessage updates the moderation channel message for c to match its current state.
+func (h *handler) updateCaseMessage(c reportCase) error {
+	if c.MessageTS == "" {
+		return fmt.Errorf("case %d has no message to update", c.ID)
+	}
+	message := c.message()
+	message["channel"] = c.MessageChannel
+	message["ts"] = c.MessageTS
+	if err := h.client.CallMethod("chat.update", message, nil); err != nil {
+		return fmt.Errorf("couldn't update message for case %d: %v", c.ID, err)
+	}
+	return nil
+}
+
+// handleCaseAction handles the buttons on case messages.
+func (h *handler) handleCaseAction(interaction slackInteraction, rw http.ResponseWriter) {
+	if permissions, err := h.permissions(interaction.User.ID); err != nil || len(permissions) == 0 {
+		respondEphemeral(rw, "Only moderators can manage reports.")
+		return
+	}
+	if len(interaction.Actions) != 1 {
+		logError(rw, "Expected one action, but got %d.", len(interaction.Actions))
+		return
+	}
+	action := interaction.Actions[0]
+	id, err := strconv.ParseUint(action.Value, 10, 64)
+	if err != nil {
+		logError(rw, "Failed to parse case ID %q: %v.", action.Value, err)
+		return
+	}
+	c, err := h.cases.update(id, func(c *reportCase) error {
+		return c.transition(action.Name, interaction.User.ID)
+	})
+	if err != nil {
+		respondEphemeral(rw, fmt.Sprintf("Couldn't %s case %d: %v.", action.Name, id, err))
+		return
+	}
+	log.Printf("User %s (%s) set case %d to %s.\n", interaction.User.ID, interaction.User.Name, c.ID, c.Status)
+	if c.FollowUp && !c.Status.isOpen() {
+		if err := h.sendFollowUp(c); err != nil {
+			log.Printf("Failed to tell the reporter the outcome of case %d: %v\n", c.ID, err)
+		}
+	}
+	if err := h.updateCaseMessage(c); err != nil {
+		logError(rw, "%v", err)
+	}
+}
+
+// followUpConfig holds the messages sent to reporters who asked to be told the outcome of their
+// reports.
+type followUpConfig struct {
+	// ActionTaken is sent when a case is resolved.
+	ActionTaken string `json:"actionTaken"`
+	// NoAction is sent when a case is dismissed.
+	NoAction string `json:"noAction"`
+}
+
+var defaultFollowUp = followUpConfig{
+	ActionTaken: "Thank you for your recent report. The moderators have looked into it and taken action.",
+	NoAction:    "Thank you for your recent report. The moderators have looked into it, and decided that no action was needed.",
+}
+
+// sendFollowUp tells the reporter of c how it turned out.
+func (h *handler) sendFollowUp(c reportCase) error {
+	if c.Reporter == "" {
+		return fmt.Errorf("case %d has no reporter", c.ID)
+	}
+	text := h.followUp.ActionTaken
+	if c.Status == caseDismissed {
+		text = h.followUp.NoAction
+	}
+	channel, err := h.openIM(c.Reporter)
+	if err != nil {
+		return err
+	}
+	message := map[string]interface{}{"channel": channel, "text": text}
+	if err := h.client.CallMethod("chat.postMessage", message, nil); err != nil {
+		return fmt.Errorf("couldn't send message: %v", err)
+	}
+	return nil
+}
+
+// openIM returns the ID of the direct message channel between the bot and the given user.
+func (h *handler) openIM(user string) (string, error) {
+	response := struct {
+		Channel struct {
+			ID string `json:"id"`
+		} `json:"channel"`
+	}{}
+	if err := h.client.CallMethod("im.open", map[string]string{"user": user}, &response); err != nil {
+		return "", fmt.Errorf("couldn't open IM channel: %v", err)
+	}
+	return response.Channel.ID, nil
+}
+
+// handleCasesCommand handles the /cases slash command, which lists open cases or adds notes to
+// them.
+func (h *handler) handleCasesCommand(f url.Values, rw http.ResponseWriter) {
+	user := f.Get("user_id")
+	if permissions, err := h.permissions(user); err != nil || len(permissions) == 0 {
+		respondEphemeral(rw, "Only moderators can see reports.")
+		return
+	}
+	args := strings.Fields(f.Get("text"))
+	if len(args) == 0 || args[0] == "list" {
+		cases, err := h.cases.openCases()
+		if err != nil {
+			logError(rw, "Failed to list cases: %v", err)
+			return
+		}
+		respondEphemeral(rw, describeCases(cases))
+		return
+	}
+	if args[0] == "note" && len(args) >= 3 {
+		id, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			respondEphemeral(rw, fmt.Sprintf("%q is not a case number.", args[1]))
+			return
+		}
+		text := strings.Join(args[2:], " ")
+		c, err := h.cases.update(id, func(c *reportCase) error {
+			c.Notes = append(c.Notes, caseNote{Author: user, Time: time.Now(), Text: text})
+			return nil
+		})
+		if err != nil {
+			respondEphemeral(rw, fmt.Sprintf("Couldn't add a note to case %d: %v.", id, err))
+			return
+		}
+		if err := h.updateCaseMessage(c); err != nil {
+			log.Printf("%v\n", err)
+		}
+		respondEphemeral(rw, fmt.Sprintf("Added a note to case %d.", id))
+		return
+	}
+	if args[0] == "export" && len(args) == 2 && h.evidence != nil {
+		id, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			respondEphemeral(rw, fmt.Sprintf("%q is not a case number.", args[1]))
+			return
+		}
+		path, bundles, err := h.exportCase(id)
+		if err != nil {
+			respondEphemeral(rw, fmt.Sprintf("Couldn't export case %d: %v.", id, err))
+			return
+		}
+		log.Printf("User %s exported case %d to %s.\n", user, id, path)
+		respondEphemeral(rw, fmt.Sprintf("Exported case %d, with %s, to `%s`.", id, countOf(bundles, "evidence bundle"), path))
+		return
+	}
+	usage := fmt.Sprintf("Usage: `%[1]s [list]` or `%[1]s note <case> <text>`", f.Get("command"))
+	if h.evidence != nil {
+		usage = fmt.Sprintf("Usage: `%[1]s [list]`, `%[1]s note <case> <text>`, or `%[1]s export <case>`", f.Get("command"))
+	}
+	respondEphemeral(rw, usage)
+}
+
+// openCasesAbout returns the IDs of the open cases about the user with the given ID: reports
+// about their messages, and their appeals.
+func (h *handler) openCasesAbout(user string) []uint64 {
+	if h.cases == nil {
+		return nil
+	}
+	cases, err := h.cases.openCases()
+	if err != nil {
+		log.Printf("Failed to look up cases about %s: %v\n", user, err)
+		return nil
+	}
+	var result []uint64
+	for _, c := range cases {
+		if c.Sender == user {
+			result = append(result, c.ID)
+		}
+	}
+	return result
+}
+
+// describeCases returns a summary of the given cases suitable for posting in Slack.
+func describeCases(cases []reportCase) string {
+	if len(cases) == 0 {
+		return "There are no open reports."
+	}
+	lines := []string{fmt.Sprintf("There are %d open reports:", len(cases))}
+	for _, c := range cases {
+		line := fmt.Sprintf("• Case %d (%s), reported %s, about a message from <@%s>", c.ID, c.Status, c.Created.UTC().Format("2006-01-02 15:04 MST"), c.Sender)
+		if c.Appeal {
+			line = fmt.Sprintf("• Case %d (%s), filed %s, an appeal from %s", c.ID, c.Status, c.Created.UTC().Format("2006-01-02 15:04 MST"), c.appellant())
+			if c.AppealOf != 0 {
+				line += fmt.Sprintf(" against action %d", c.AppealOf)
+			}
+		}
+		if c.Assignee != "" {
+			line += fmt.Sprintf(", claimed by <@%s>", c.Assignee)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func respondEphemeral(rw http.ResponseWriter, text string) {
+	rw.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(rw).Encode(map[string]interface{}{
+		"text":             text,
+		"response_type":    "ephemeral",
+		"replace_original": false,
+	})
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/slack-infra/slack"
+	"sigs.k8s.io/slack-infra/slack/slacktest"
+)
+
+func TestCaseLifecycle(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	store := newCaseStore(db)
+
+	first := &reportCase{Sender: "U11111111", Summary: "first"}
+	second := &reportCase{Sender: "U22222222", Summary: "second"}
+	for _, c := range []*reportCase{first, second} {
+		if err := store.create(c); err != nil {
+			t.Fatalf("Failed to create case: %v", err)
+		}
+	}
+	if first.ID != 1 || second.ID != 2 {
+		t.Errorf("Expected cases 1 and 2, but got %d and %d", first.ID, second.ID)
+	}
+
+	c, err := store.update(first.ID, func(c *reportCase) error { return c.transition("claim", "UMOD") })
+	if err != nil {
+		t.Fatalf("Failed to claim case: %v", err)
+	}
+	if c.Status != caseClaimed || c.Assignee != "UMOD" {
+		t.Errorf("Expected case to be claimed by UMOD, but got %s by %q", c.Status, c.Assignee)
+	}
+	if _, err := store.update(second.ID, func(c *reportCase) error { return c.transition("dismiss", "UMOD") }); err != nil {
+		t.Fatalf("Failed to dismiss case: %v", err)
+	}
+	if _, err := store.update(second.ID, func(c *reportCase) error { return c.transition("resolve", "UMOD") }); err == nil {
+		t.Errorf("Expected resolving a dismissed case to fail")
+	}
+	if got, err := store.get(second.ID); err != nil || got.Status != caseDismissed {
+		t.Errorf("Expected the failed update not to change the case, but got %v (%v)", got.Status, err)
+	}
+
+	open, err := store.openCases()
+	if err != nil {
+		t.Fatalf("Failed to list open cases: %v", err)
+	}
+	if len(open) != 1 || open[0].ID != first.ID {
+		t.Errorf("Expected only case %d to be open, but got %#v", first.ID, open)
+	}
+}
+
+func TestCaseButtons(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	store := newCaseStore(db)
+	s := slacktest.NewServer()
+	defer s.Close()
+	modChannel := s.AddChannel(slack.Conversation{Name: "moderation"})
+	s.AddUser(slack.User{ID: "UMOD", IsAdmin: true})
+	s.AddUser(slack.User{ID: "UNOBODY"})
+	h := &handler{client: s.Client(), cache: newLookupCache(time.Minute), cases: store, modChannel: modChannel}
+
+	c := &reportCase{Sender: "U11111111", Summary: "Someone *reported a message*"}
+	if err := h.fileCase(c); err != nil {
+		t.Fatalf("Failed to file case: %v", err)
+	}
+	if messages := s.Messages(modChannel); len(messages) != 1 || messages[0].Text != c.Summary {
+		t.Fatalf("Expected the case to be posted in the moderation channel, but got %#v", messages)
+	}
+
+	press := func(user, action string) {
+		interaction := slackInteraction{Type: "interactive_message", CallbackID: "report_case"}
+		interaction.User.ID = user
+		interaction.Actions = append(interaction.Actions, struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		}{Name: action, Value: "1"})
+		h.handleCaseAction(interaction, httptest.NewRecorder())
+	}
+
+	press("UNOBODY", "dismiss")
+	if got, _ := store.get(c.ID); got.Status != caseOpen {
+		t.Errorf("Expected non-moderators not to be able to dismiss cases, but case is %s", got.Status)
+	}
+	press("UMOD", "resolve")
+	if got, _ := store.get(c.ID); got.Status != caseResolved || got.Assignee != "UMOD" {
+		t.Errorf("Expected case to be resolved by UMOD, but it is %s by %q", got.Status, got.Assignee)
+	}
+	updates := s.RequestsFor("chat.update")
+	if len(updates) != 1 || updates[0].Args["channel"] != modChannel || !strings.Contains(updates[0].Args["attachments"], "Case 1 is *resolved*") {
+		t.Errorf("Expected the case message to be updated in place, but got %#v", updates)
+	}
+}
+
+func TestFileCaseThatCantBePosted(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	store := newCaseStore(db)
+	s := slacktest.NewServer()
+	defer s.Close()
+	modChannel := s.AddChannel(slack.Conversation{Name: "moderation"})
+	h := &handler{client: s.Client(), cache: newLookupCache(time.Minute), cases: store, modChannel: modChannel}
+
+	s.FailNext("chat.postMessage", "channel_not_found")
+	if err := h.fileCase(&reportCase{Sender: "U11111111", Summary: "Someone *reported a message*"}); err == nil {
+		t.Fatalf("Expected filing a case that can't be posted to fail")
+	}
+	if open, err := store.openCases(); err != nil || len(open) != 0 {
+		t.Errorf("Expected the case to be deleted, but got %#v (%v)", open, err)
+	}
+}
+
+func TestCasesCommand(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	store := newCaseStore(db)
+	s := slacktest.NewServer()
+	defer s.Close()
+	s.AddUser(slack.User{ID: "UMOD", IsAdmin: true})
+	h := &handler{client: s.Client(), cache: newLookupCache(time.Minute), cases: store}
+	if err := store.create(&reportCase{Sender: "U11111111"}); err != nil {
+		t.Fatalf("Failed to create case: %v", err)
+	}
+
+	rw := httptest.NewRecorder()
+	h.handleCasesCommand(url.Values{"command": {"/cases"}, "user_id": {"UMOD"}}, rw)
+	if body := rw.Body.String(); !strings.Contains(body, "Case 1 (open)") {
+		t.Errorf("Expected case 1 to be listed, but got %s", body)
+	}
+
+	h.handleCasesCommand(url.Values{"command": {"/cases"}, "user_id": {"UMOD"}, "text": {"note 1 spoke to them"}}, httptest.NewRecorder())
+	if got, _ := store.get(1); len(got.Notes) != 1 || got.Notes[0].Text != "spoke to them" || got.Notes[0].Author != "UMOD" {
+		t.Errorf("Expected a note from UMOD, but got %#v", got.Notes)
+	}
+}
+
+func TestFollowUp(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	store := newCaseStore(db)
+	s := slacktest.NewServer()
+	defer s.Close()
+	modChannel := s.AddChannel(slack.Conversation{Name: "moderation"})
+	s.AddUser(slack.User{ID: "UMOD", IsAdmin: true})
+	s.AddUser(slack.User{ID: "UREPORTER"})
+	h := &handler{client: s.Client(), cache: newLookupCache(time.Minute), cases: store, modChannel: modChannel, followUp: defaultFollowUp}
+
+	c := &reportCase{Sender: "U11111111", Reporter: "UREPORTER", Anonymous: true, FollowUp: true, Summary: "An anonymous user *reported a message*"}
+	if err := h.fileCase(c); err != nil {
+		t.Fatalf("Failed to file case: %v", err)
+	}
+	interaction := slackInteraction{Type: "interactive_message", CallbackID: "report_case"}
+	interaction.User.ID = "UMOD"
+	interaction.Actions = append(interaction.Actions, struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}{Name: "dismiss", Value: "1"})
+	h.handleCaseAction(interaction, httptest.NewRecorder())
+
+	var dms []slacktest.Request
+	for _, r := range s.RequestsFor("chat.postMessage") {
+		if r.Args["channel"] != modChannel {
+			dms = append(dms, r)
+		}
+		if strings.Contains(r.Args["attachments"], "UREPORTER") {
+			t.Errorf("Expected the anonymous reporter not to be revealed in the moderation channel, but got %#v", r.Args)
+		}
+	}
+	if len(dms) != 1 || dms[0].Args["text"] != defaultFollowUp.NoAction {
+		t.Errorf("Expected the reporter to be told no action was taken, but got %#v", dms)
+	}
+	for _, r := range s.RequestsFor("chat.update") {
+		if strings.Contains(r.Args["attachments"], "UREPORTER") {
+			t.Errorf("Expected the anonymous reporter not to be revealed in the moderation channel, but got %#v", r.Args)
+		}
+	}
+}
@@ -17,8 +17,10 @@ limitations under the License.
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
+	"net/url"
 	"strconv"
 	"strings"
 	"sync"
@@ -27,41 +29,40 @@ import (
 	"sigs.k8s.io/slack-infra/slack"
 )
 
-func (h *handler) removeUserContent(interaction slackInteraction, duration time.Duration, targetUser string) (removedFiles, remainingFiles, removedMessages, remainingMessages int, err error) {
-	start := time.Now().Add(-duration)
-
+// findUserContent returns the target user's files and messages covered by scope.
+func (h *handler) findUserContent(targetUser string, scope removalScope) (files []foundFile, messages []messageID, err error) {
 	wg := sync.WaitGroup{}
 	wg.Add(2)
+	var fileErr, messageErr error
 	go func() {
 		defer wg.Done()
-		var err error
-		removedFiles, remainingFiles, err = h.removeFilesFromUser(targetUser, start)
-		if err != nil {
-			log.Printf("Couldn't remove files: %v", err)
-		}
+		files, fileErr = h.findFilesFromUser(targetUser, scope)
 	}()
 	go func() {
 		defer wg.Done()
-		var err error
-		removedMessages, remainingMessages, err = h.removeMessagesFromUser(targetUser, start)
-		if err != nil {
-			log.Printf("Couldn't remove messages: %v", err)
-		}
+		messages, messageErr = h.findMessagesFromUser(targetUser, scope)
 	}()
 	wg.Wait()
-
-	err = nil
-	return
+	if fileErr != nil {
+		return nil, nil, fileErr
+	}
+	if messageErr != nil {
+		return nil, nil, messageErr
+	}
+	return files, messages, nil
 }
 
-func (h *handler) removeFilesFromUser(targetUser string, since time.Time) (removed, remaining int, err error) {
+func (h *handler) findFilesFromUser(targetUser string, scope removalScope) ([]foundFile, error) {
+	if !scope.Files {
+		return nil, nil
+	}
 	page := 1
-	var files []string
+	var files []foundFile
 	for {
-		f, hasMore, err := h.searchForFiles(targetUser, since, page)
+		f, hasMore, err := h.searchForFiles(targetUser, scope, page)
 		if err != nil {
 			if len(files) == 0 {
-				return 0, 0, err
+				return nil, err
 			}
 			log.Printf("Failed to fetch more files (already got %d): %v\n", len(files), err)
 			break
@@ -72,16 +73,21 @@ func (h *handler) removeFilesFromUser(targetUser string, since time.Time) (remov
 		}
 		page += 1
 	}
-	log.Printf("Got %d files to remove...\n", len(files))
-	for _, v := range files {
-		if err := h.removeFile(v); err != nil {
-			log.Printf("Failed to remove file %s: %v\n", v, err)
-			remaining += 1
-		} else {
-			removed += 1
+	return files, nil
+}
+
+// removeFoundFile removes a file found by search. If evidence is non-nil, the file is added to it
+// first, and left alone if that fails.
+func (h *handler) removeFoundFile(f foundFile, evidence *evidenceBundle) error {
+	if evidence != nil {
+		if err := h.preserveFile(evidence, f); err != nil {
+			return fmt.Errorf("couldn't preserve file %s, so not removing it: %v", f.id, err)
 		}
 	}
-	return removed, remaining, nil
+	if err := h.removeFile(f.id); err != nil {
+		return fmt.Errorf("couldn't remove file %s: %v", f.id, err)
+	}
+	return nil
 }
 
 func (h *handler) removeFile(id string) error {
@@ -92,18 +98,38 @@ func (h *handler) removeFile(id string) error {
 		if err == nil {
 			return nil
 		}
-		if timeout, ok := err.(slack.ErrRateLimit); ok {
-			log.Printf("Slack is rate limiting us, trying again in %s...\n", timeout.Wait)
-			time.Sleep(timeout.Wait)
-			continue
+		switch e := err.(type) {
+		case slack.ErrRateLimit:
+			log.Printf("Slack is rate limiting us, trying again in %s...\n", e.Wait)
+			time.Sleep(e.Wait)
+		case slack.ErrSlack:
+			if e.Type == "file_not_found" || e.Type == "file_deleted" {
+				log.Printf("File to delete not found, probably already deleted.\n")
+				return nil
+			}
+			return err
+		default:
+			return err
 		}
-		return err
 	}
 }
 
-func (h *handler) searchForFiles(targetUser string, since time.Time, page int) ([]string, bool, error) {
+type foundFile struct {
+	id          string
+	downloadURL string
+	channels    []string
+	// match is the search result the file was found in.
+	match json.RawMessage
+}
+
+// key identifies the file among everything a removal job might remove.
+func (f foundFile) key() string {
+	return "file/" + f.id
+}
+
+func (h *handler) searchForFiles(targetUser string, scope removalScope, page int) ([]foundFile, bool, error) {
 	args := map[string]string{
-		"query":    fmt.Sprintf("from:<@%s> after:%s", targetUser, dateBefore(since)),
+		"query":    searchQuery(targetUser, scope),
 		"count":    "100",
 		"sort":     "timestamp",
 		"sort_dir": "desc",
@@ -113,11 +139,7 @@ func (h *handler) searchForFiles(targetUser string, since time.Time, page int) (
 
 	result := struct {
 		Files struct {
-			Matches []struct {
-				ID      string `json:"id"`
-				Created int64  `json:"created"`
-				User    string `json:"user"`
-			} `json:"matches"`
+			Matches    []json.RawMessage `json:"matches"`
 			Pagination struct {
 				PageCount int `json:"page_count"`
 			} `json:"pagination"`
@@ -128,17 +150,31 @@ func (h *handler) searchForFiles(targetUser string, since time.Time, page int) (
 		return nil, false, fmt.Errorf("failed to find files: %v", err)
 	}
 
-	files := make([]string, 0, len(result.Files.Matches))
-	for _, v := range result.Files.Matches {
+	files := make([]foundFile, 0, len(result.Files.Matches))
+	for _, match := range result.Files.Matches {
+		v := struct {
+			ID                 string   `json:"id"`
+			Created            int64    `json:"created"`
+			User               string   `json:"user"`
+			URLPrivateDownload string   `json:"url_private_download"`
+			Channels           []string `json:"channels"`
+		}{}
+		if err := json.Unmarshal(match, &v); err != nil {
+			log.Printf("Failed to parse file: %v\n", err)
+			continue
+		}
 		if v.User != targetUser {
 			log.Printf("Got unexpected file %s from user %s instead of target user %s", v.ID, v.User, targetUser)
 			continue
 		}
-		if time.Unix(v.Created, 0).Before(since) {
-			log.Printf("Got unexpected file %s created at %s, which is before %s", v.ID, time.Unix(v.Created, 0), since)
+		if time.Unix(v.Created, 0).Before(scope.Since) {
+			log.Printf("Got unexpected file %s created at %s, which is before %s", v.ID, time.Unix(v.Created, 0), scope.Since)
 			break
 		}
-		files = append(files, v.ID)
+		if !scope.includesTime(time.Unix(v.Created, 0)) || !scope.includesFile(v.Channels) {
+			continue
+		}
+		files = append(files, foundFile{id: v.ID, downloadURL: v.URLPrivateDownload, channels: v.Channels, match: match})
 	}
 	return files, result.Files.Pagination.PageCount > page, nil
 }
@@ -146,16 +182,24 @@ func (h *handler) searchForFiles(targetUser string, since time.Time, page int) (
 type messageID struct {
 	ts      string
 	channel string
+	text    string
+	// match is the search result the message was found in.
+	match json.RawMessage
 }
 
-func (h *handler) removeMessagesFromUser(targetUser string, since time.Time) (removed, remaining int, err error) {
+// key identifies the message among everything a removal job might remove.
+func (m messageID) key() string {
+	return "message/" + m.channel + "/" + m.ts
+}
+
+func (h *handler) findMessagesFromUser(targetUser string, scope removalScope) ([]messageID, error) {
 	page := 1
 	var messages []messageID
 	for {
-		m, hasMore, err := h.searchForMessages(targetUser, since, page)
+		m, hasMore, err := h.searchForMessages(targetUser, scope, page)
 		if err != nil {
 			if len(messages) == 0 {
-				return 0, 0, err
+				return nil, err
 			}
 			log.Printf("Failed to fetch more messages (already got %d): %v\n", len(messages), err)
 			break
@@ -166,16 +210,21 @@ func (h *handler) removeMessagesFromUser(targetUser string, since time.Time) (re
 		}
 		page += 1
 	}
-	log.Printf("Got %d messages to remove...\n", len(messages))
-	for _, v := range messages {
-		if err := h.removeMessage(v); err != nil {
-			log.Printf("Failed to remove message %s: %v\n", v, err)
-			remaining += 1
-		} else {
-			removed += 1
+	return messages, nil
+}
+
+// removeFoundMessage removes a message found by search. If evidence is non-nil, the message is
+// added to it first, and left alone if that fails.
+func (h *handler) removeFoundMessage(m messageID, evidence *evidenceBundle) error {
+	if evidence != nil {
+		if err := evidence.addMessage(m.match); err != nil {
+			return fmt.Errorf("couldn't preserve message %s/%s, so not removing it: %v", m.channel, m.ts, err)
 		}
 	}
-	return removed, remaining, nil
+	if err := h.removeMessage(m); err != nil {
+		return fmt.Errorf("couldn't remove message %s/%s: %v", m.channel, m.ts, err)
+	}
+	return nil
 }
 
 func (h *handler) removeMessage(message messageID) error {
@@ -185,7 +234,7 @@ func (h *handler) removeMessage(message messageID) error {
 		"as_user": true,
 	}
 
-	log.Printf("Removing message %s\n", message)
+	log.Printf("Removing message %s/%s\n", message.channel, message.ts)
 
 	for {
 		err := h.client.CallMethod("chat.delete", req, nil)
@@ -214,9 +263,37 @@ func dateBefore(when time.Time) string {
 	return when.Add(-2 * 24 * time.Hour).Format("2006-01-02")
 }
 
-func (h *handler) searchForMessages(targetUser string, since time.Time, page int) ([]messageID, bool, error) {
+// Likewise, slack search can only search for messages *before* a specific date.
+func dateAfter(when time.Time) string {
+	return when.Add(2 * 24 * time.Hour).Format("2006-01-02")
+}
+
+// searchQuery returns a search query for content from the target user that covers scope. Search
+// is fuzzy about time, so results must still be checked against scope.
+func searchQuery(targetUser string, scope removalScope) string {
+	query := fmt.Sprintf("from:<@%s> after:%s", targetUser, dateBefore(scope.Since))
+	if !scope.Until.IsZero() {
+		query += " before:" + dateAfter(scope.Until)
+	}
+	return query
+}
+
+// threadTS returns the timestamp of the thread a search result is in, if any. Search results
+// don't usually say, but their permalinks do.
+func threadTS(threadTS, permalink string) string {
+	if threadTS != "" || permalink == "" {
+		return threadTS
+	}
+	u, err := url.Parse(permalink)
+	if err != nil {
+		return ""
+	}
+	return u.Query().Get("thread_ts")
+}
+
+func (h *handler) searchForMessages(targetUser string, scope removalScope, page int) ([]messageID, bool, error) {
 	args := map[string]string{
-		"query":    fmt.Sprintf("from:<@%s> after:%s", targetUser, dateBefore(since)),
+		"query":    searchQuery(targetUser, scope),
 		"count":    "100",
 		"sort":     "timestamp",
 		"sort_dir": "desc",
@@ -226,13 +303,7 @@ func (h *handler) searchForMessages(targetUser string, since time.Time, page int
 
 	result := struct {
 		Messages struct {
-			Matches []struct {
-				Channel struct {
-					ID string `json:"id"`
-				} `json:"channel"`
-				TS   string `json:"ts"`
-				User string `json:"user"`
-			} `json:"matches"`
+			Matches    []json.RawMessage `json:"matches"`
 			Pagination struct {
 				PageCount int `json:"page_count"`
 			} `json:"pagination"`
@@ -244,7 +315,21 @@ func (h *handler) searchForMessages(targetUser string, since time.Time, page int
 	}
 
 	messages := make([]messageID, 0, len(result.Messages.Matches))
-	for _, v := range result.Messages.Matches {
+	for _, match := range result.Messages.Matches {
+		v := struct {
+			Channel struct {
+				ID string `json:"id"`
+			} `json:"channel"`
+			TS        string `json:"ts"`
+			ThreadTS  string `json:"thread_ts"`
+			Permalink string `json:"permalink"`
+			User      string `json:"user"`
+			Text      string `json:"text"`
+		}{}
+		if err := json.Unmarshal(match, &v); err != nil {
+			log.Printf("Failed to parse message: %v\n", err)
+			continue
+		}
 		if v.User != targetUser {
 			log.Printf("Unexpected message %s/%s from user %s, not target user %s\n", v.Channel, v.TS, v.User, targetUser)
 			continue
@@ -255,13 +340,18 @@ func (h *handler) searchForMessages(targetUser string, since time.Time, page int
 			log.Printf("Failed to parse timestamp %s: %v\n", ts, err)
 			continue
 		}
-		if time.Unix(t, 0).Before(since) {
-			log.Printf("Got message %s/%s posted %s, which is before %s, assuming we're done.", v.Channel, v.TS, time.Unix(t, 0), since)
+		if time.Unix(t, 0).Before(scope.Since) {
+			log.Printf("Got message %s/%s posted %s, which is before %s, assuming we're done.", v.Channel, v.TS, time.Unix(t, 0), scope.Since)
 			break
 		}
+		if !scope.includesTime(time.Unix(t, 0)) || !scope.includesMessage(v.Channel.ID, v.TS, threadTS(v.ThreadTS, v.Permalink)) {
+			continue
+		}
 		messages = append(messages, messageID{
 			ts:      v.TS,
 			channel: v.Channel.ID,
+			text:    v.Text,
+			match:   match,
 		})
 	}
 	return messages, result.Messages.Pagination.PageCount > page, nil
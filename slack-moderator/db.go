@@ -0,0 +1,148 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// openDB opens the BoltDB database at path, creating it and every bucket slack-moderator keeps
+// records in if they don't exist.
+func openDB(path string) (*bolt.DB, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open database %s: %v", path, err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{casesBucket, actionsBucket, jobsBucket, jobsDoneBucket, approvalsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("couldn't initialise database %s: %v", path, err)
+	}
+	return db, nil
+}
+
+// idKey returns the database key for a case, action or job ID. Keys sort in ID order.
+func idKey(id uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, id)
+	return k
+}
+
+// bucket keeps JSON records in one bucket of a BoltDB database, keyed by IDs it allocates in
+// sequence.
+type bucket struct {
+	db   *bolt.DB
+	name []byte
+	// kind is what the records are, for error messages.
+	kind string
+}
+
+func (b bucket) put(bb *bolt.Bucket, id uint64, v interface{}) error {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("couldn't serialise %s %d: %v", b.kind, id, err)
+	}
+	return bb.Put(idKey(id), content)
+}
+
+// create allocates an ID, passes it to setID so that it can be filled in, and stores v under it.
+func (b bucket) create(v interface{}, setID func(id uint64)) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bb := tx.Bucket(b.name)
+		id, err := bb.NextSequence()
+		if err != nil {
+			return fmt.Errorf("couldn't allocate %s ID: %v", b.kind, err)
+		}
+		setID(id)
+		return b.put(bb, id, v)
+	})
+}
+
+// get reads the record with the given ID into v.
+func (b bucket) get(id uint64, v interface{}) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		return b.read(tx.Bucket(b.name), id, v)
+	})
+}
+
+func (b bucket) read(bb *bolt.Bucket, id uint64, v interface{}) error {
+	content := bb.Get(idKey(id))
+	if content == nil {
+		return fmt.Errorf("no %s %d", b.kind, id)
+	}
+	return b.parse(id, content, v)
+}
+
+// update reads the record with the given ID into v, applies f, and stores v, unless f fails. f
+// runs in the same transaction, which it can use to keep anything else that goes with the record.
+func (b bucket) update(id uint64, v interface{}, f func(tx *bolt.Tx) error) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bb := tx.Bucket(b.name)
+		if err := b.read(bb, id, v); err != nil {
+			return err
+		}
+		if err := f(tx); err != nil {
+			return err
+		}
+		return b.put(bb, id, v)
+	})
+}
+
+// delete removes the record with the given ID, if there is one.
+func (b bucket) delete(id uint64) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(b.name).Delete(idKey(id))
+	})
+}
+
+// scan calls f with the ID and contents of each record, oldest first, or newest first if reverse
+// is set, until f returns false or fails.
+func (b bucket) scan(reverse bool, f func(id uint64, content []byte) (bool, error)) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(b.name).Cursor()
+		first, next := c.First, c.Next
+		if reverse {
+			first, next = c.Last, c.Prev
+		}
+		for k, v := first(); k != nil; k, v = next() {
+			more, err := f(binary.BigEndian.Uint64(k), v)
+			if err != nil || !more {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// parse parses the contents of a record found by scan into v.
+func (b bucket) parse(id uint64, content []byte, v interface{}) error {
+	if err := json.Unmarshal(content, v); err != nil {
+		return fmt.Errorf("couldn't parse %s %d: %v", b.kind, id, err)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// newTempDir creates a temporary directory, and returns it along with a function that removes it.
+func newTempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "slack-moderator")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// newTestDB opens a database in a temporary directory, and returns it along with a function that
+// closes and removes it.
+func newTestDB(t *testing.T) (*bolt.DB, func()) {
+	dir, cleanup := newTempDir(t)
+	db, err := openDB(filepath.Join(dir, "moderator.db"))
+	if err != nil {
+		cleanup()
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	return db, func() {
+		db.Close()
+		cleanup()
+	}
+}
+
+func TestBucket(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	b := bucket{db: db, name: jobsBucket, kind: "job"}
+	type record struct {
+		ID   uint64 `json:"id"`
+		Name string `json:"name"`
+	}
+	for _, name := range []string{"first", "second", "third"} {
+		r := &record{Name: name}
+		if err := b.create(r, func(id uint64) { r.ID = id }); err != nil {
+			t.Fatalf("Failed to create %s record: %v", name, err)
+		}
+	}
+	var r record
+	if err := b.update(2, &r, func(*bolt.Tx) error {
+		r.Name = "updated"
+		return nil
+	}); err != nil {
+		t.Fatalf("Failed to update record: %v", err)
+	}
+	if err := b.get(4, &r); err == nil || err.Error() != "no job 4" {
+		t.Errorf("Expected missing records to be reported, but got %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		reverse  bool
+		limit    int
+		expected []string
+	}{
+		{name: "oldest first", limit: 3, expected: []string{"first", "updated", "third"}},
+		{name: "newest first", reverse: true, limit: 3, expected: []string{"third", "updated", "first"}},
+		{name: "stopping early", reverse: true, limit: 1, expected: []string{"third"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var names []string
+			err := b.scan(tc.reverse, func(id uint64, v []byte) (bool, error) {
+				var r record
+				if err := b.parse(id, v, &r); err != nil {
+					return false, err
+				}
+				if r.ID != id {
+					t.Errorf("Expected record %d to have ID %d, but it has %d", id, id, r.ID)
+				}
+				names = append(names, r.Name)
+				return len(names) < tc.limit, nil
+			})
+			if err != nil {
+				t.Fatalf("Failed to scan records: %v", err)
+			}
+			if !reflect.DeepEqual(names, tc.expected) {
+				t.Errorf("Expected %v, but got %v", tc.expected, names)
+			}
+		})
+	}
+}
@@ -0,0 +1,506 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// evidenceTimeLayout is how times are written in the names of bundles and exports.
+const evidenceTimeLayout = "20060102T150405Z"
+
+const (
+	evidenceManifestName = "manifest.json"
+	// Messages and files are appended to these as JSON lines as they are added to a bundle, so
+	// that adding one doesn't mean rewriting everything added before it.
+	evidenceMessagesName = "messages.jsonl"
+	evidenceFilesName    = "files.jsonl"
+)
+
+// evidenceStore keeps copies of content on local disk before it is removed from Slack, so that
+// moderators still have it for appeals or escalation. Each moderation action gets a bundle: a
+// directory named after the bundle ID, holding a manifest, the messages and files added to it, and
+// the contents of those files.
+type evidenceStore struct {
+	dir string
+	// retention is how long to keep bundles for. If it is zero, they are kept forever.
+	retention time.Duration
+	now       func() time.Time
+}
+
+type evidenceManifest struct {
+	ID         string    `json:"id"`
+	Created    time.Time `json:"created"`
+	Moderator  string    `json:"moderator"`
+	TargetUser string    `json:"targetUser"`
+	// Job is the removal job that removed the content.
+	Job uint64 `json:"job,omitempty"`
+	// Cases are the open cases about the target user when the bundle was started, which it is
+	// exported with.
+	Cases []uint64 `json:"cases,omitempty"`
+	// Closed is when the job finished, after which nothing more is added to the bundle. Bundles
+	// are only pruned once closed, since their jobs might otherwise resume and add to them.
+	Closed time.Time `json:"closed,omitempty"`
+	// Messages and Files are kept apart from the manifest, in evidenceMessagesName and
+	// evidenceFilesName.
+	Messages []evidenceMessage `json:"-"`
+	Files    []evidenceFile    `json:"-"`
+}
+
+type evidenceMessage struct {
+	Channel     string          `json:"channel"`
+	TS          string          `json:"ts"`
+	ThreadTS    string          `json:"threadTS,omitempty"`
+	Text        string          `json:"text"`
+	Attachments json.RawMessage `json:"attachments,omitempty"`
+	// Match is the search result the message was found in, exactly as Slack returned it.
+	Match json.RawMessage `json:"match"`
+}
+
+type evidenceFile struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Path is where the file's contents are kept, relative to the bundle. It is empty if we had no
+	// way to download them.
+	Path string `json:"path,omitempty"`
+	// Metadata is the search result the file was found in, exactly as Slack returned it.
+	Metadata json.RawMessage `json:"metadata"`
+}
+
+type evidenceBundle struct {
+	dir string
+
+	mu       sync.Mutex
+	manifest evidenceManifest
+	seen     map[string]bool
+}
+
+func newEvidenceStore(dir string, retention time.Duration) (*evidenceStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("couldn't create evidence directory: %v", err)
+	}
+	return &evidenceStore{dir: dir, retention: retention, now: time.Now}, nil
+}
+
+// newBundle starts a bundle for content about to be removed, as described by m, filling in its ID
+// and when it was created.
+func (s *evidenceStore) newBundle(m evidenceManifest) (*evidenceBundle, error) {
+	if err := s.prune(); err != nil {
+		log.Printf("Failed to prune old evidence: %v\n", err)
+	}
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, fmt.Errorf("couldn't generate bundle ID: %v", err)
+	}
+	m.Created = s.now().UTC()
+	m.ID = m.Created.Format(evidenceTimeLayout) + "-" + hex.EncodeToString(suffix)
+	b := &evidenceBundle{dir: filepath.Join(s.dir, m.ID), manifest: m, seen: map[string]bool{}}
+	if err := os.MkdirAll(filepath.Join(b.dir, "files"), 0700); err != nil {
+		return nil, fmt.Errorf("couldn't create bundle directory: %v", err)
+	}
+	if err := b.writeManifest(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// openBundle reopens an existing bundle, so that more can be added to it.
+func (s *evidenceStore) openBundle(id string) (*evidenceBundle, error) {
+	if id == "" || id != filepath.Base(id) || id == "." || id == ".." {
+		return nil, fmt.Errorf("invalid bundle ID %q", id)
+	}
+	b := &evidenceBundle{dir: filepath.Join(s.dir, id), seen: map[string]bool{}}
+	m, err := readEvidence(b.dir, true)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read bundle %s: %v", id, err)
+	}
+	b.manifest = m
+	for _, v := range m.Messages {
+		b.seen["message/"+v.Channel+"/"+v.TS] = true
+	}
+	for _, v := range m.Files {
+		b.seen["file/"+v.ID] = true
+	}
+	return b, nil
+}
+
+// closeBundle marks the bundle with the given ID as closed, now that its job has finished.
+func (s *evidenceStore) closeBundle(id string) error {
+	b, err := s.openBundle(id)
+	if err != nil {
+		return err
+	}
+	b.manifest.Closed = s.now().UTC()
+	return b.writeManifest()
+}
+
+// prune removes closed bundles and case exports older than the retention period.
+func (s *evidenceStore) prune() error {
+	if s.retention == 0 {
+		return nil
+	}
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("couldn't list evidence: %v", err)
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			// Exports hold copies of the same content, so they are kept for just as long.
+			if isExport, _ := filepath.Match(caseExportPattern, e.Name()); !isExport || s.now().Sub(e.ModTime()) <= s.retention {
+				continue
+			}
+			log.Printf("Removing case export %s, written %s\n", e.Name(), e.ModTime())
+			if err := os.Remove(filepath.Join(s.dir, e.Name())); err != nil {
+				return fmt.Errorf("couldn't remove case export %s: %v", e.Name(), err)
+			}
+			continue
+		}
+		m, err := readEvidenceManifest(filepath.Join(s.dir, e.Name()))
+		if err != nil {
+			log.Printf("Skipping evidence bundle %s: %v\n", e.Name(), err)
+			continue
+		}
+		if m.Closed.IsZero() || s.now().Sub(m.Created) <= s.retention {
+			continue
+		}
+		log.Printf("Removing evidence bundle %s, created %s\n", m.ID, m.Created)
+		if err := os.RemoveAll(filepath.Join(s.dir, e.Name())); err != nil {
+			return fmt.Errorf("couldn't remove bundle %s: %v", m.ID, err)
+		}
+	}
+	return nil
+}
+
+// bundlesForCase returns the IDs of the bundles started while the case with the given ID was open.
+func (s *evidenceStore) bundlesForCase(id uint64) ([]string, error) {
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list evidence: %v", err)
+	}
+	var result []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		m, err := readEvidenceManifest(filepath.Join(s.dir, e.Name()))
+		if err != nil {
+			log.Printf("Skipping evidence bundle %s: %v\n", e.Name(), err)
+			continue
+		}
+		for _, c := range m.Cases {
+			if c == id {
+				result = append(result, m.ID)
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+// export writes the bundles with the given IDs to w as a gzipped tarball, along with the given
+// extra files, keyed by name.
+func (s *evidenceStore) export(w io.Writer, extra map[string][]byte, ids ...string) error {
+	for _, id := range ids {
+		if id == "" || id != filepath.Base(id) || id == "." || id == ".." {
+			return fmt.Errorf("invalid bundle ID %q", id)
+		}
+		if _, err := readEvidenceManifest(filepath.Join(s.dir, id)); err != nil {
+			return fmt.Errorf("couldn't find bundle %s: %v", id, err)
+		}
+	}
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	var names []string
+	for name := range extra {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		header := &tar.Header{Name: name, Mode: 0600, Size: int64(len(extra[name])), ModTime: s.now()}
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("couldn't export %s: %v", name, err)
+		}
+		if _, err := tw.Write(extra[name]); err != nil {
+			return fmt.Errorf("couldn't export %s: %v", name, err)
+		}
+	}
+	for _, id := range ids {
+		if err := s.exportBundle(tw, id); err != nil {
+			return fmt.Errorf("couldn't export bundle %s: %v", id, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// exportBundle adds the bundle with the given ID to tw.
+func (s *evidenceStore) exportBundle(tw *tar.Writer, id string) error {
+	return filepath.Walk(filepath.Join(s.dir, id), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+}
+
+func readEvidenceManifest(dir string) (evidenceManifest, error) {
+	m := evidenceManifest{}
+	content, err := ioutil.ReadFile(filepath.Join(dir, evidenceManifestName))
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(content, &m); err != nil {
+		return m, fmt.Errorf("couldn't parse manifest: %v", err)
+	}
+	return m, nil
+}
+
+// readEvidence reads the manifest of the bundle in dir along with the messages and files added to
+// it. If repair is set, anything cut short while it was being added is removed, so that more can
+// be added after it.
+func readEvidence(dir string, repair bool) (evidenceManifest, error) {
+	m, err := readEvidenceManifest(dir)
+	if err != nil {
+		return m, err
+	}
+	err = readEvidenceLines(filepath.Join(dir, evidenceMessagesName), repair, func(line []byte) error {
+		var v evidenceMessage
+		if err := json.Unmarshal(line, &v); err != nil {
+			return err
+		}
+		m.Messages = append(m.Messages, v)
+		return nil
+	})
+	if err != nil {
+		return m, err
+	}
+	err = readEvidenceLines(filepath.Join(dir, evidenceFilesName), repair, func(line []byte) error {
+		var v evidenceFile
+		if err := json.Unmarshal(line, &v); err != nil {
+			return err
+		}
+		m.Files = append(m.Files, v)
+		return nil
+	})
+	return m, err
+}
+
+// readEvidenceLines passes each line of the JSON lines file at path to f. A last line without a
+// newline was cut short while it was being written, so it is skipped, and removed if repair is
+// set. A missing file has no lines.
+func readEvidenceLines(path string, repair bool, f func(line []byte) error) error {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	complete := bytes.LastIndexByte(content, '\n') + 1
+	if repair && complete < len(content) {
+		if err := os.Truncate(path, int64(complete)); err != nil {
+			return fmt.Errorf("couldn't remove incomplete line from %s: %v", filepath.Base(path), err)
+		}
+	}
+	for i, line := range bytes.Split(content[:complete], []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		if err := f(line); err != nil {
+			return fmt.Errorf("couldn't parse line %d of %s: %v", i+1, filepath.Base(path), err)
+		}
+	}
+	return nil
+}
+
+// writeManifest replaces the manifest on disk, when the bundle is created or closed.
+func (b *evidenceBundle) writeManifest() error {
+	content, err := json.MarshalIndent(b.manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("couldn't marshal manifest: %v", err)
+	}
+	tmp := filepath.Join(b.dir, evidenceManifestName+".tmp")
+	if err := ioutil.WriteFile(tmp, content, 0600); err != nil {
+		return fmt.Errorf("couldn't write manifest: %v", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(b.dir, evidenceManifestName)); err != nil {
+		return fmt.Errorf("couldn't write manifest: %v", err)
+	}
+	return nil
+}
+
+// appendEvidence adds v as a line at the end of the named JSON lines file in the bundle. The
+// caller must hold b.mu.
+func (b *evidenceBundle) appendEvidence(name string, v interface{}) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("couldn't marshal evidence: %v", err)
+	}
+	f, err := os.OpenFile(filepath.Join(b.dir, name), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("couldn't open %s: %v", name, err)
+	}
+	_, err = f.Write(append(line, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't write to %s: %v", name, err)
+	}
+	return nil
+}
+
+// addMessage records a message found by search.messages.
+func (b *evidenceBundle) addMessage(match json.RawMessage) error {
+	v := struct {
+		Channel struct {
+			ID string `json:"id"`
+		} `json:"channel"`
+		TS          string          `json:"ts"`
+		ThreadTS    string          `json:"thread_ts"`
+		Text        string          `json:"text"`
+		Attachments json.RawMessage `json:"attachments"`
+		Permalink   string          `json:"permalink"`
+	}{}
+	if err := json.Unmarshal(match, &v); err != nil {
+		return fmt.Errorf("couldn't parse message: %v", err)
+	}
+	v.ThreadTS = threadTS(v.ThreadTS, v.Permalink)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	key := "message/" + v.Channel.ID + "/" + v.TS
+	if b.seen[key] {
+		return nil
+	}
+	m := evidenceMessage{
+		Channel:     v.Channel.ID,
+		TS:          v.TS,
+		ThreadTS:    v.ThreadTS,
+		Text:        v.Text,
+		Attachments: v.Attachments,
+		Match:       match,
+	}
+	if err := b.appendEvidence(evidenceMessagesName, m); err != nil {
+		return err
+	}
+	b.manifest.Messages = append(b.manifest.Messages, m)
+	b.seen[key] = true
+	return nil
+}
+
+// addFile records a file found by search.files, along with its contents if they're non-nil.
+func (b *evidenceBundle) addFile(metadata json.RawMessage, contents io.Reader) error {
+	v := struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	}{}
+	if err := json.Unmarshal(metadata, &v); err != nil {
+		return fmt.Errorf("couldn't parse file: %v", err)
+	}
+	if v.ID == "" || v.ID != filepath.Base(v.ID) {
+		return fmt.Errorf("invalid file ID %q", v.ID)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	key := "file/" + v.ID
+	if b.seen[key] {
+		return nil
+	}
+	f := evidenceFile{ID: v.ID, Name: v.Name, Metadata: metadata}
+	if contents != nil {
+		f.Path = "files/" + v.ID
+		out, err := os.OpenFile(filepath.Join(b.dir, f.Path), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return fmt.Errorf("couldn't create file: %v", err)
+		}
+		_, err = io.Copy(out, contents)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("couldn't save file contents: %v", err)
+		}
+	}
+	if err := b.appendEvidence(evidenceFilesName, f); err != nil {
+		return err
+	}
+	b.manifest.Files = append(b.manifest.Files, f)
+	b.seen[key] = true
+	return nil
+}
+
+// preserveFile downloads a file found by search.files and adds it to the bundle.
+func (h *handler) preserveFile(b *evidenceBundle, f foundFile) error {
+	if f.downloadURL == "" {
+		log.Printf("File %s has no download URL, only keeping its metadata.\n", f.id)
+		return b.addFile(f.match, nil)
+	}
+	req, err := http.NewRequest(http.MethodGet, f.downloadURL, nil)
+	if err != nil {
+		return fmt.Errorf("couldn't create download request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+h.client.Config.AccessToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("couldn't download file: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("couldn't download file: %s", resp.Status)
+	}
+	return b.addFile(f.match, resp.Body)
+}
@@ -0,0 +1,326 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/slack-infra/slack"
+	"sigs.k8s.io/slack-infra/slack/slacktest"
+)
+
+func newTestEvidenceStore(t *testing.T) (*evidenceStore, func()) {
+	dir, cleanup := newTempDir(t)
+	s, err := newEvidenceStore(dir, 0)
+	if err != nil {
+		cleanup()
+		t.Fatalf("Failed to open evidence store: %v", err)
+	}
+	return s, cleanup
+}
+
+func TestContentIsPreservedBeforeRemoval(t *testing.T) {
+	store, cleanup := newTestEvidenceStore(t)
+	defer cleanup()
+	files := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer xoxb-slacktest" {
+			http.Error(rw, "not authorized", http.StatusForbidden)
+			return
+		}
+		if r.URL.Path != "/F1" {
+			http.NotFound(rw, r)
+			return
+		}
+		_, _ = rw.Write([]byte("spam, spam, spam"))
+	}))
+	defer files.Close()
+
+	s := slacktest.NewServer()
+	defer s.Close()
+	channel := s.AddChannel(slack.Conversation{Name: "general"})
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	s.AddMessage(channel, slack.Message{User: "USPAMMER", Text: "buy things", TS: now + ".000100"})
+	s.AddMessage(channel, slack.Message{User: "USPAMMER", Text: "buy more things", TS: now + ".000200", ThreadTS: now + ".000100"})
+	s.AddMessage(channel, slack.Message{User: "UINNOCENT", Text: "no thanks", TS: now + ".000300"})
+	s.AddFile(slacktest.File{ID: "F1", User: "USPAMMER", Created: time.Now().Unix(), Name: "spam.txt", URLPrivateDownload: files.URL + "/F1"})
+	s.AddFile(slacktest.File{ID: "F2", User: "USPAMMER", Created: time.Now().Unix(), Name: "gone.txt", URLPrivateDownload: files.URL + "/F2"})
+	jobs := newMemoryJobStore()
+	h := &handler{client: s.Client(), evidence: store, jobs: jobs}
+	defer func(d time.Duration) { recheckDelay = d }(recheckDelay)
+	recheckDelay = 0
+
+	j := &removalJob{Moderator: "UMOD", TargetUser: "USPAMMER", Scope: removalScope{Since: time.Now().Add(-time.Hour), Replies: true, Files: true}}
+	if err := jobs.create(j); err != nil {
+		t.Fatalf("Failed to create job: %v", err)
+	}
+	h.runJob(j.ID)
+	got, err := jobs.get(j.ID)
+	if err != nil {
+		t.Fatalf("Failed to get job: %v", err)
+	}
+	if got.RemovedFiles != 1 || got.RemovedMessages != 2 || !reflect.DeepEqual(got.Failed, []string{"file/F2"}) {
+		t.Errorf("Expected 1 file and 2 messages removed and F2 to fail, but got %d, %d and %v", got.RemovedFiles, got.RemovedMessages, got.Failed)
+	}
+	if got.Status != jobFailed {
+		t.Errorf("Expected the job to fail because F2 couldn't be removed, but it is %s", got.Status)
+	}
+	if remaining := s.Files(); len(remaining) != 1 || remaining[0].ID != "F2" {
+		t.Errorf("Expected the file that couldn't be preserved to be left alone, but got %#v", remaining)
+	}
+
+	bundle := filepath.Join(store.dir, got.EvidenceBundle)
+	m, err := readEvidence(bundle, false)
+	if err != nil {
+		t.Fatalf("Failed to read bundle: %v", err)
+	}
+	if m.Moderator != "UMOD" || m.TargetUser != "USPAMMER" {
+		t.Errorf("Expected the bundle to record UMOD moderating USPAMMER, but got %q and %q", m.Moderator, m.TargetUser)
+	}
+	sort.Slice(m.Messages, func(i, j int) bool { return m.Messages[i].TS < m.Messages[j].TS })
+	var messages [][]string
+	for _, v := range m.Messages {
+		messages = append(messages, []string{v.Channel, v.TS, v.ThreadTS, v.Text})
+	}
+	expected := [][]string{{channel, now + ".000100", "", "buy things"}, {channel, now + ".000200", now + ".000100", "buy more things"}}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("Expected preserved messages %v, but got %v", expected, messages)
+	}
+	if len(m.Files) != 1 || m.Files[0].ID != "F1" || m.Files[0].Name != "spam.txt" {
+		t.Fatalf("Expected F1 to be preserved, but got %#v", m.Files)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(bundle, m.Files[0].Path))
+	if err != nil || string(content) != "spam, spam, spam" {
+		t.Errorf("Expected the file's contents to be preserved, but got %q (%v)", content, err)
+	}
+}
+
+func TestEvidenceRetention(t *testing.T) {
+	store, cleanup := newTestEvidenceStore(t)
+	defer cleanup()
+	now := time.Date(2019, 10, 7, 9, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+	store.retention = 24 * time.Hour
+
+	bundle := func(closed bool) *evidenceBundle {
+		b, err := store.newBundle(evidenceManifest{Moderator: "UMOD", TargetUser: "USPAMMER"})
+		if err != nil {
+			t.Fatalf("Failed to create bundle: %v", err)
+		}
+		if closed {
+			if err := store.closeBundle(b.manifest.ID); err != nil {
+				t.Fatalf("Failed to close bundle: %v", err)
+			}
+		}
+		return b
+	}
+	export := func(name string) string {
+		path := filepath.Join(store.dir, name)
+		if err := ioutil.WriteFile(path, []byte("evidence"), 0600); err != nil {
+			t.Fatalf("Failed to write export: %v", err)
+		}
+		if err := os.Chtimes(path, now, now); err != nil {
+			t.Fatalf("Failed to set export time: %v", err)
+		}
+		return path
+	}
+	oldClosed, oldOpen := bundle(true), bundle(false)
+	oldExport := export("case-1-20191007T090000Z.tar.gz")
+	oldOther := export("notes.txt")
+	now = now.Add(36 * time.Hour)
+	recentExport := export("case-1-20191008T210000Z.tar.gz")
+	recent := bundle(true)
+
+	tests := []struct {
+		name string
+		path string
+		kept bool
+	}{
+		{name: "old closed bundles are removed", path: oldClosed.dir},
+		{name: "old bundles whose jobs might resume are kept", path: oldOpen.dir, kept: true},
+		{name: "recent bundles are kept", path: recent.dir, kept: true},
+		{name: "old case exports are removed", path: oldExport},
+		{name: "recent case exports are kept", path: recentExport, kept: true},
+		{name: "other files are kept", path: oldOther, kept: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := os.Stat(tc.path); (err == nil) != tc.kept {
+				t.Errorf("Expected %s to be kept: %t, but got %v", tc.path, tc.kept, err)
+			}
+		})
+	}
+}
+
+func TestReopenedBundleDropsIncompleteLines(t *testing.T) {
+	store, cleanup := newTestEvidenceStore(t)
+	defer cleanup()
+	bundle, err := store.newBundle(evidenceManifest{Moderator: "UMOD", TargetUser: "USPAMMER"})
+	if err != nil {
+		t.Fatalf("Failed to create bundle: %v", err)
+	}
+	if err := bundle.addMessage([]byte(`{"channel": {"id": "C1"}, "ts": "1.000100", "text": "buy things"}`)); err != nil {
+		t.Fatalf("Failed to add message: %v", err)
+	}
+	// Pretend slack-moderator stopped while adding another message.
+	f, err := os.OpenFile(filepath.Join(bundle.dir, evidenceMessagesName), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatalf("Failed to open messages: %v", err)
+	}
+	if _, err := f.WriteString(`{"channel": "C1", "ts": "1.00`); err != nil {
+		t.Fatalf("Failed to write messages: %v", err)
+	}
+	f.Close()
+
+	reopened, err := store.openBundle(bundle.manifest.ID)
+	if err != nil {
+		t.Fatalf("Failed to reopen bundle: %v", err)
+	}
+	if err := reopened.addMessage([]byte(`{"channel": {"id": "C1"}, "ts": "1.000200", "text": "buy more things"}`)); err != nil {
+		t.Fatalf("Failed to add message: %v", err)
+	}
+	m, err := readEvidence(bundle.dir, false)
+	if err != nil {
+		t.Fatalf("Failed to read bundle: %v", err)
+	}
+	var texts []string
+	for _, v := range m.Messages {
+		texts = append(texts, v.Text)
+	}
+	if expected := []string{"buy things", "buy more things"}; !reflect.DeepEqual(texts, expected) {
+		t.Errorf("Expected messages %v, but got %v", expected, texts)
+	}
+}
+
+func TestExportEvidence(t *testing.T) {
+	store, cleanup := newTestEvidenceStore(t)
+	defer cleanup()
+	bundle, err := store.newBundle(evidenceManifest{Moderator: "UMOD", TargetUser: "USPAMMER"})
+	if err != nil {
+		t.Fatalf("Failed to create bundle: %v", err)
+	}
+	if err := bundle.addFile([]byte(`{"id": "F1", "name": "spam.txt"}`), bytes.NewBufferString("spam")); err != nil {
+		t.Fatalf("Failed to add file: %v", err)
+	}
+
+	if err := store.export(ioutil.Discard, nil, "../"+bundle.manifest.ID); err == nil {
+		t.Errorf("Expected exporting a path outside the store to fail")
+	}
+	buf := &bytes.Buffer{}
+	if err := store.export(buf, nil, bundle.manifest.ID); err != nil {
+		t.Fatalf("Failed to export bundle: %v", err)
+	}
+	var names []string
+	for name := range readExport(t, buf) {
+		names = append(names, name)
+	}
+	id := bundle.manifest.ID
+	sort.Strings(names)
+	if expected := []string{id, id + "/files", id + "/files.jsonl", id + "/files/F1", id + "/manifest.json"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected the export to contain %v, but got %v", expected, names)
+	}
+}
+
+// readExport returns the contents of each file and directory in an exported tarball, keyed by
+// name.
+func readExport(t *testing.T, r io.Reader) map[string]string {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		t.Fatalf("Failed to read export: %v", err)
+	}
+	tr := tar.NewReader(gz)
+	result := map[string]string{}
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return result
+		}
+		if err != nil {
+			t.Fatalf("Failed to read export: %v", err)
+		}
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("Failed to read %s from export: %v", header.Name, err)
+		}
+		result[header.Name] = string(content)
+	}
+}
+
+func TestExportCase(t *testing.T) {
+	store, cleanup := newTestEvidenceStore(t)
+	defer cleanup()
+	db, cleanupDB := newTestDB(t)
+	defer cleanupDB()
+	h := &handler{cases: newCaseStore(db), evidence: store}
+
+	reported := &reportCase{Sender: "USPAMMER", Reporter: "UREPORTER", Anonymous: true, FollowUp: true, Summary: "spam"}
+	unrelated := &reportCase{Sender: "USOMEONE", Summary: "something else"}
+	for _, c := range []*reportCase{reported, unrelated} {
+		if err := h.cases.create(c); err != nil {
+			t.Fatalf("Failed to create case: %v", err)
+		}
+	}
+	if cases := h.openCasesAbout("USPAMMER"); !reflect.DeepEqual(cases, []uint64{reported.ID}) {
+		t.Fatalf("Expected case %d to be about USPAMMER, but got %v", reported.ID, cases)
+	}
+	linked, err := store.newBundle(evidenceManifest{Moderator: "UMOD", TargetUser: "USPAMMER", Cases: h.openCasesAbout("USPAMMER")})
+	if err != nil {
+		t.Fatalf("Failed to create bundle: %v", err)
+	}
+	if _, err := store.newBundle(evidenceManifest{Moderator: "UMOD", TargetUser: "USOMEONE", Cases: h.openCasesAbout("USOMEONE")}); err != nil {
+		t.Fatalf("Failed to create bundle: %v", err)
+	}
+
+	path, bundles, err := h.exportCase(reported.ID)
+	if err != nil {
+		t.Fatalf("Failed to export case: %v", err)
+	}
+	if bundles != 1 {
+		t.Errorf("Expected 1 bundle to be exported, but got %d", bundles)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open export: %v", err)
+	}
+	defer f.Close()
+	contents := readExport(t, f)
+	var names []string
+	for name := range contents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	id := linked.manifest.ID
+	if expected := []string{id, id + "/files", id + "/manifest.json", "case.json"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected the export to contain %v, but got %v", expected, names)
+	}
+	if c := contents["case.json"]; !strings.Contains(c, `"summary": "spam"`) || strings.Contains(c, "UREPORTER") {
+		t.Errorf("Expected the case without its anonymous reporter, but got %s", c)
+	}
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// runExportEvidence implements `slack-moderator export-evidence`, which writes an evidence bundle
+// out as a gzipped tarball that can be handed on for appeals or escalation.
+func runExportEvidence(args []string) {
+	fs := flag.NewFlagSet("export-evidence", flag.ExitOnError)
+	configPath := fs.String("config-path", "config.json", "Path to a file containing the slack config")
+	output := fs.String("output", "", "Path to write the bundle to")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s export-evidence [flags] <bundle-id>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if *output == "" || fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	extra, err := loadExtraConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load moderator config from %s: %v", *configPath, err)
+	}
+	if extra.EvidenceDir == "" {
+		log.Fatalf("No evidenceDir is configured in %s", *configPath)
+	}
+	store := &evidenceStore{dir: extra.EvidenceDir, now: time.Now}
+	f, err := os.Create(*output)
+	if err != nil {
+		log.Fatalf("Failed to create %s: %v", *output, err)
+	}
+	if err := store.export(f, nil, fs.Arg(0)); err != nil {
+		f.Close()
+		os.Remove(*output)
+		log.Fatalf("%v", err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatalf("Failed to write %s: %v", *output, err)
+	}
+	log.Printf("Wrote evidence bundle %s to %s.\n", fs.Arg(0), *output)
+}
+
+// caseExportPattern matches the names of the files exportCase writes.
+const caseExportPattern = "case-*.tar.gz"
+
+// exportCase writes the case with the given ID out as a gzipped tarball in the evidence directory,
+// along with the evidence bundles started while it was open and, for appeals, the action being
+// appealed. It returns the path to the tarball and how many bundles it holds.
+func (h *handler) exportCase(id uint64) (string, int, error) {
+	c, err := h.cases.get(id)
+	if err != nil {
+		return "", 0, err
+	}
+	// Exports are handed on, so they must not give away anonymous reporters.
+	if c.Anonymous {
+		c.Reporter = ""
+	}
+	extra := map[string][]byte{}
+	if extra["case.json"], err = json.MarshalIndent(c, "", "  "); err != nil {
+		return "", 0, fmt.Errorf("couldn't marshal case: %v", err)
+	}
+	if c.AppealOf != 0 {
+		a, err := h.cases.getAction(c.AppealOf)
+		if err != nil {
+			return "", 0, fmt.Errorf("couldn't get the action being appealed: %v", err)
+		}
+		if extra["action.json"], err = json.MarshalIndent(a, "", "  "); err != nil {
+			return "", 0, fmt.Errorf("couldn't marshal action: %v", err)
+		}
+	}
+	bundles, err := h.evidence.bundlesForCase(id)
+	if err != nil {
+		return "", 0, err
+	}
+
+	path := filepath.Join(h.evidence.dir, fmt.Sprintf("case-%d-%s.tar.gz", id, h.evidence.now().UTC().Format(evidenceTimeLayout)))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", 0, fmt.Errorf("couldn't create export: %v", err)
+	}
+	err = h.evidence.export(f, extra, bundles...)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", 0, err
+	}
+	return path, len(bundles), nil
+}
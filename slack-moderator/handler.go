@@ -23,12 +23,37 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"time"
+
 	"sigs.k8s.io/slack-infra/slack"
 )
 
 type handler struct {
-	client     *slack.Client
-	adminToken string
+	client *slack.Client
+	// scim deactivates and reactivates users. If it is nil, users can't be deactivated.
+	scim       *slack.SCIMClient
+	moderators moderatorConfig
+	cache      *lookupCache
+	// cases stores reports, which are posted in modChannel. If it is nil, reports are just posted
+	// to the webhook.
+	cases      *caseStore
+	modChannel string
+	followUp   followUpConfig
+	// appealToken is the token that appeals must carry. If it is empty, appeals aren't accepted.
+	appealToken string
+	// evidence keeps removed content. If it is nil, content is removed without keeping a copy.
+	evidence *evidenceStore
+	// pending holds content removals that moderators have previewed but not yet confirmed.
+	pending *pendingRemovals
+	// jobs stores confirmed removals as they run.
+	jobs jobStore
+	// approval says which actions need a second moderator's approval, which is requested in
+	// modChannel and held in approvals until someone gives it.
+	approval  approvalPolicy
+	approvals *pendingApprovals
+	// adminMaxRemoval is the longest window workspace admins and owners may remove content from, if
+	// it is longer than maxRemovalDuration.
+	adminMaxRemoval time.Duration
 }
 
 // ServeHTTP handles Slack webhook requests.
@@ -47,6 +72,14 @@ func (h *handler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 		logError(rw, "Failed to parse incoming content: %v", err)
 		return
 	}
+	if f.Get("command") == "/mod" {
+		h.handleModCommand(f, rw)
+		return
+	}
+	if f.Get("command") == "/cases" && h.cases != nil {
+		h.handleCasesCommand(f, rw)
+		return
+	}
 	content := f.Get("payload")
 	if content == "" {
 		logError(rw, "Payload was blank.")
@@ -58,11 +91,25 @@ func (h *handler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if interaction.Type == "message_action" && interaction.CallbackID == "report_message" {
-		if isMod, err := h.userHasModerationPowers(interaction.User.ID); err == nil && isMod {
-			h.handleModerateMessage(interaction, rw)
+		permissions, err := h.permissions(interaction.User.ID)
+		if err != nil {
+			log.Printf("Failed to look up moderation powers: %v\n", err)
+		}
+		if len(permissions) > 0 {
+			h.handleModerateMessage(interaction, rw, permissions)
 		} else {
 			h.handleReportMessage(interaction, rw)
 		}
+	} else if interaction.Type == "interactive_message" && interaction.CallbackID == "report_case" && h.cases != nil {
+		h.handleCaseAction(interaction, rw)
+	} else if interaction.Type == "interactive_message" && interaction.CallbackID == "moderation_action" && h.cases != nil {
+		h.handleActionButton(interaction, rw)
+	} else if interaction.Type == "interactive_message" && interaction.CallbackID == "confirm_removal" {
+		h.handleRemovalAction(interaction, rw)
+	} else if interaction.Type == "interactive_message" && interaction.CallbackID == "removal_job" {
+		h.handleJobAction(interaction, rw)
+	} else if interaction.Type == "interactive_message" && interaction.CallbackID == "approval_request" && h.approvals != nil {
+		h.handleApprovalAction(interaction, rw)
 	} else if interaction.Type == "dialog_submission" {
 		switch interaction.CallbackID {
 		case "send_report":
@@ -106,6 +153,10 @@ type slackInteraction struct {
 	}
 	Submission map[string]string `json:"submission"`
 	State      string            `json:"state"`
+	Actions    []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	} `json:"actions"`
 }
 
 // shortenString returns the first N slice of a string.
@@ -0,0 +1,621 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// removalPasses is how many times a job searches for content and removes it. Search can lag
+// behind, so later passes catch anything earlier ones missed.
+const removalPasses = 2
+
+// maxListedJobs is how many jobs `/mod jobs` shows.
+const maxListedJobs = 20
+
+// jobProgressInterval is how often a running job updates its status message.
+var jobProgressInterval = 5 * time.Second
+
+type jobStatus string
+
+const (
+	jobRunning jobStatus = "running"
+	jobDone    jobStatus = "done"
+	jobFailed  jobStatus = "failed"
+)
+
+// removalJob is a confirmed content removal. Jobs are stored as they progress, so that they can
+// be resumed after a restart and retried after a failure.
+type removalJob struct {
+	ID        uint64    `json:"id"`
+	Status    jobStatus `json:"status"`
+	Created   time.Time `json:"created"`
+	Moderator string    `json:"moderator"`
+	// ApprovedBy is the second moderator who approved the job, if it needed approval.
+	ApprovedBy string       `json:"approved_by,omitempty"`
+	TargetUser string       `json:"target_user"`
+	Scope      removalScope `json:"scope"`
+	// Pass is the pass the job is on, counting from 1. It is greater than removalPasses once every
+	// pass is complete.
+	Pass int `json:"pass"`
+	// Failed holds the keys of everything that couldn't be removed the last time the job tried.
+	Failed          []string `json:"failed,omitempty"`
+	RemovedMessages int      `json:"removed_messages"`
+	RemovedFiles    int      `json:"removed_files"`
+	Error           string   `json:"error,omitempty"`
+	EvidenceBundle  string   `json:"evidence_bundle,omitempty"`
+	// StatusChannel and StatusTS identify the message showing the job's progress.
+	StatusChannel string `json:"status_channel,omitempty"`
+	StatusTS      string `json:"status_ts,omitempty"`
+}
+
+var (
+	jobsBucket = []byte("jobs")
+	// jobsDoneBucket holds a bucket for each job, keyed by job ID, holding the keys of everything
+	// the job has removed. They are kept apart from jobs, which would otherwise grow with every
+	// item removed and be rewritten in full each time.
+	jobsDoneBucket = []byte("jobs_done")
+)
+
+// jobStore keeps removal jobs, along with the keys of everything they have removed so that they
+// can skip them when resumed.
+type jobStore interface {
+	// create stores j as a new running job, filling in its ID.
+	create(j *removalJob) error
+	get(id uint64) (removalJob, error)
+	// update applies f to the job with the given ID and stores the result, unless f fails. It
+	// returns the updated job.
+	update(id uint64, f func(j *removalJob) error) (removalJob, error)
+	// list returns every job with one of the given statuses, oldest first.
+	list(statuses ...jobStatus) ([]removalJob, error)
+	// checkpoint records the outcome of the job with the given ID trying to remove the item with
+	// the given key, and returns the updated job.
+	checkpoint(id uint64, key string, isFile bool, err error) (removalJob, error)
+	// done returns the keys of everything the job with the given ID has removed.
+	done(id uint64) (map[string]bool, error)
+}
+
+// boltJobStore keeps removal jobs in a BoltDB database.
+type boltJobStore struct {
+	jobs bucket
+}
+
+func newBoltJobStore(db *bolt.DB) *boltJobStore {
+	return &boltJobStore{jobs: bucket{db: db, name: jobsBucket, kind: "job"}}
+}
+
+func (s *boltJobStore) create(j *removalJob) error {
+	j.Status = jobRunning
+	j.Pass = 1
+	return s.jobs.create(j, func(id uint64) { j.ID = id })
+}
+
+func (s *boltJobStore) get(id uint64) (removalJob, error) {
+	var j removalJob
+	err := s.jobs.get(id, &j)
+	return j, err
+}
+
+func (s *boltJobStore) update(id uint64, f func(j *removalJob) error) (removalJob, error) {
+	var j removalJob
+	err := s.jobs.update(id, &j, func(*bolt.Tx) error { return f(&j) })
+	return j, err
+}
+
+func (s *boltJobStore) list(statuses ...jobStatus) ([]removalJob, error) {
+	var result []removalJob
+	err := s.jobs.scan(false, func(id uint64, v []byte) (bool, error) {
+		var j removalJob
+		if err := s.jobs.parse(id, v, &j); err != nil {
+			return false, err
+		}
+		if j.hasStatus(statuses) {
+			result = append(result, j)
+		}
+		return true, nil
+	})
+	return result, err
+}
+
+func (s *boltJobStore) checkpoint(id uint64, key string, isFile bool, err error) (removalJob, error) {
+	var j removalJob
+	uerr := s.jobs.update(id, &j, func(tx *bolt.Tx) error {
+		if !j.checkpoint(key, isFile, err) {
+			return nil
+		}
+		done, err := tx.Bucket(jobsDoneBucket).CreateBucketIfNotExists(idKey(id))
+		if err != nil {
+			return fmt.Errorf("couldn't record progress of job %d: %v", id, err)
+		}
+		return done.Put([]byte(key), []byte{})
+	})
+	return j, uerr
+}
+
+func (s *boltJobStore) done(id uint64) (map[string]bool, error) {
+	result := map[string]bool{}
+	err := s.jobs.db.View(func(tx *bolt.Tx) error {
+		done := tx.Bucket(jobsDoneBucket).Bucket(idKey(id))
+		if done == nil {
+			return nil
+		}
+		return done.ForEach(func(k, _ []byte) error {
+			result[string(k)] = true
+			return nil
+		})
+	})
+	return result, err
+}
+
+// memoryJobStore keeps removal jobs in memory, for when there is no database to keep them in.
+type memoryJobStore struct {
+	mu sync.Mutex
+	// jobs holds every job, in ID order, starting from 1.
+	jobs     []removalJob
+	doneKeys map[uint64]map[string]bool
+}
+
+func newMemoryJobStore() *memoryJobStore {
+	return &memoryJobStore{doneKeys: map[uint64]map[string]bool{}}
+}
+
+func (s *memoryJobStore) create(j *removalJob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j.ID = uint64(len(s.jobs) + 1)
+	j.Status = jobRunning
+	j.Pass = 1
+	s.jobs = append(s.jobs, *j)
+	return nil
+}
+
+func (s *memoryJobStore) get(id uint64) (removalJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.getLocked(id)
+}
+
+func (s *memoryJobStore) getLocked(id uint64) (removalJob, error) {
+	if id == 0 || id > uint64(len(s.jobs)) {
+		return removalJob{}, fmt.Errorf("no job %d", id)
+	}
+	// Copy Failed, so that callers can't change the stored job through it.
+	j := s.jobs[id-1]
+	j.Failed = append([]string(nil), j.Failed...)
+	return j, nil
+}
+
+func (s *memoryJobStore) update(id uint64, f func(j *removalJob) error) (removalJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, err := s.getLocked(id)
+	if err != nil {
+		return j, err
+	}
+	if err := f(&j); err != nil {
+		return j, err
+	}
+	s.jobs[id-1] = j
+	return s.getLocked(id)
+}
+
+func (s *memoryJobStore) list(statuses ...jobStatus) ([]removalJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var result []removalJob
+	for _, j := range s.jobs {
+		if j.hasStatus(statuses) {
+			j, _ := s.getLocked(j.ID)
+			result = append(result, j)
+		}
+	}
+	return result, nil
+}
+
+func (s *memoryJobStore) checkpoint(id uint64, key string, isFile bool, err error) (removalJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, uerr := s.getLocked(id)
+	if uerr != nil {
+		return j, uerr
+	}
+	if j.checkpoint(key, isFile, err) {
+		if s.doneKeys[id] == nil {
+			s.doneKeys[id] = map[string]bool{}
+		}
+		s.doneKeys[id][key] = true
+	}
+	s.jobs[id-1] = j
+	return s.getLocked(id)
+}
+
+func (s *memoryJobStore) done(id uint64) (map[string]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := map[string]bool{}
+	for k := range s.doneKeys[id] {
+		result[k] = true
+	}
+	return result, nil
+}
+
+// hasStatus returns whether j has one of the given statuses.
+func (j removalJob) hasStatus(statuses []jobStatus) bool {
+	for _, s := range statuses {
+		if j.Status == s {
+			return true
+		}
+	}
+	return false
+}
+
+// checkpoint records the outcome of trying to remove the item with the given key, and returns
+// whether it was removed. The job's store must record the keys of removed items.
+func (j *removalJob) checkpoint(key string, isFile bool, err error) bool {
+	var failed []string
+	for _, k := range j.Failed {
+		if k != key {
+			failed = append(failed, k)
+		}
+	}
+	j.Failed = failed
+	if err != nil {
+		j.Failed = append(j.Failed, key)
+		return false
+	}
+	if isFile {
+		j.RemovedFiles++
+	} else {
+		j.RemovedMessages++
+	}
+	return true
+}
+
+// message returns the status message for j, with a button to retry it if it failed.
+func (j removalJob) message() map[string]interface{} {
+	text := fmt.Sprintf("Removal job %d: content from <@%s>, %s, requested by <@%s>", j.ID, j.TargetUser, j.Scope.describe(), j.Moderator)
+	if j.ApprovedBy != "" {
+		text += fmt.Sprintf(" and approved by <@%s>", j.ApprovedBy)
+	}
+	text += "."
+	return map[string]interface{}{
+		"text":        text,
+		"attachments": []map[string]interface{}{j.statusAttachment()},
+	}
+}
+
+func (j removalJob) statusAttachment() map[string]interface{} {
+	status := fmt.Sprintf("Job %d is *%s*", j.ID, j.Status)
+	if j.Status == jobRunning && j.Pass <= removalPasses {
+		status += fmt.Sprintf(" (pass %d of %d)", j.Pass, removalPasses)
+	}
+	status += fmt.Sprintf(". Removed %s and %s", countOf(j.RemovedMessages, "message"), countOf(j.RemovedFiles, "file"))
+	if len(j.Failed) > 0 {
+		status += fmt.Sprintf("; couldn't remove %s", countOf(len(j.Failed), "item"))
+	}
+	status += "."
+	if j.Error != "" {
+		status += "\nError: " + j.Error
+	}
+	if j.EvidenceBundle != "" {
+		status += fmt.Sprintf("\nRemoved content is preserved in evidence bundle `%s`.", j.EvidenceBundle)
+	}
+	attachment := map[string]interface{}{
+		"text":        status,
+		"fallback":    status,
+		"callback_id": "removal_job",
+		"mrkdwn_in":   []string{"text"},
+	}
+	if j.Status == jobFailed {
+		attachment["actions"] = []map[string]interface{}{
+			{"name": "retry", "text": "Retry", "type": "button", "value": fmt.Sprintf("%d", j.ID)},
+		}
+	}
+	return attachment
+}
+
+// startJob stores a job for a confirmed removal, approved by approvedBy if it isn't empty, and posts
+// its status message. The caller should then run it.
+func (h *handler) startJob(r pendingRemoval, approvedBy string) (removalJob, error) {
+	j := &removalJob{Created: time.Now(), Moderator: r.moderator, ApprovedBy: approvedBy, TargetUser: r.targetUser, Scope: r.scope}
+	if err := h.jobs.create(j); err != nil {
+		return removalJob{}, fmt.Errorf("couldn't create job: %v", err)
+	}
+	channel := h.modChannel
+	if channel == "" {
+		var err error
+		channel, err = h.openIM(j.Moderator)
+		if err != nil {
+			log.Printf("Failed to open IM channel for job %d's status: %v\n", j.ID, err)
+			return *j, nil
+		}
+	}
+	message := j.message()
+	message["channel"] = channel
+	ret := struct {
+		Channel string `json:"channel"`
+		TS      string `json:"ts"`
+	}{}
+	if err := h.client.CallMethod("chat.postMessage", message, &ret); err != nil {
+		log.Printf("Failed to post status for job %d: %v\n", j.ID, err)
+		return *j, nil
+	}
+	return h.jobs.update(j.ID, func(j *removalJob) error {
+		j.StatusChannel, j.StatusTS = ret.Channel, ret.TS
+		return nil
+	})
+}
+
+// updateJobMessage updates the status message for j to match its current state.
+func (h *handler) updateJobMessage(j removalJob) {
+	if j.StatusTS == "" {
+		return
+	}
+	message := j.message()
+	message["channel"] = j.StatusChannel
+	message["ts"] = j.StatusTS
+	if err := h.client.CallMethod("chat.update", message, nil); err != nil {
+		log.Printf("Failed to update status for job %d: %v\n", j.ID, err)
+	}
+}
+
+// runJob runs the job with the given ID until it finishes or fails, picking up wherever it left
+// off.
+func (h *handler) runJob(id uint64) {
+	j, err := h.doJob(id)
+	if err == nil && len(j.Failed) > 0 {
+		err = fmt.Errorf("couldn't remove %s", countOf(len(j.Failed), "item"))
+	}
+	j, uerr := h.jobs.update(id, func(j *removalJob) error {
+		j.Status = jobDone
+		if err != nil {
+			j.Status = jobFailed
+			j.Error = err.Error()
+		}
+		return nil
+	})
+	if uerr != nil {
+		log.Printf("Failed to finish job %d: %v\n", id, uerr)
+		return
+	}
+	log.Printf("Removal job %d is %s.\n", j.ID, j.Status)
+	if j.Status == jobDone && j.EvidenceBundle != "" {
+		if err := h.evidence.closeBundle(j.EvidenceBundle); err != nil {
+			log.Printf("Failed to close evidence bundle %s: %v\n", j.EvidenceBundle, err)
+		}
+	}
+	h.updateJobMessage(j)
+	summary := fmt.Sprintf("Removal job %d for <@%s>, requested by <@%s>, is %s. Removed %s and %s.", j.ID, j.TargetUser, j.Moderator, j.Status, countOf(j.RemovedMessages, "message"), countOf(j.RemovedFiles, "file"))
+	if j.Error != "" {
+		summary += " " + j.Error + "."
+	}
+	if j.EvidenceBundle != "" {
+		summary += fmt.Sprintf(" Removed content is preserved in evidence bundle `%s`.", j.EvidenceBundle)
+	}
+	if err := h.client.CallMethod(h.client.Config.WebhookURL, map[string]string{"text": summary}, nil); err != nil {
+		log.Printf("Failed to send summary: %v.\n", err)
+	}
+}
+
+func (h *handler) doJob(id uint64) (removalJob, error) {
+	j, err := h.jobs.get(id)
+	if err != nil {
+		return j, err
+	}
+	var evidence *evidenceBundle
+	if h.evidence != nil {
+		if j.EvidenceBundle == "" {
+			evidence, err = h.evidence.newBundle(evidenceManifest{Moderator: j.Moderator, TargetUser: j.TargetUser, Job: j.ID, Cases: h.openCasesAbout(j.TargetUser)})
+			if err != nil {
+				return j, fmt.Errorf("couldn't preserve evidence, and therefore did not remove any content: %v", err)
+			}
+			j, err = h.jobs.update(id, func(j *removalJob) error {
+				j.EvidenceBundle = evidence.manifest.ID
+				return nil
+			})
+			if err != nil {
+				return j, err
+			}
+		} else {
+			evidence, err = h.evidence.openBundle(j.EvidenceBundle)
+			if err != nil {
+				return j, fmt.Errorf("couldn't reopen evidence bundle: %v", err)
+			}
+		}
+	}
+	done, err := h.jobs.done(id)
+	if err != nil {
+		return j, fmt.Errorf("couldn't find out what has already been removed: %v", err)
+	}
+
+	lastUpdate := time.Now()
+	// remove removes the item with the given key, unless it already has been, and records how
+	// that went.
+	remove := func(key string, isFile bool, f func() error) error {
+		if done[key] {
+			return nil
+		}
+		removeErr := f()
+		if removeErr != nil {
+			log.Printf("Job %d: %v\n", id, removeErr)
+		}
+		var err error
+		j, err = h.jobs.checkpoint(id, key, isFile, removeErr)
+		if err != nil {
+			return fmt.Errorf("couldn't save progress: %v", err)
+		}
+		done[key] = true
+		if time.Since(lastUpdate) >= jobProgressInterval {
+			h.updateJobMessage(j)
+			lastUpdate = time.Now()
+		}
+		return nil
+	}
+
+	for j.Pass <= removalPasses {
+		if j.Pass > 1 {
+			// Give search a chance to catch up before looking again.
+			time.Sleep(recheckDelay)
+		}
+		files, messages, err := h.findUserContent(j.TargetUser, j.Scope)
+		if err != nil {
+			return j, fmt.Errorf("couldn't search for content: %v", err)
+		}
+		// Anything that failed last time is worth trying again.
+		for _, k := range j.Failed {
+			delete(done, k)
+		}
+		log.Printf("Job %d pass %d: found %d messages and %d files.\n", id, j.Pass, len(messages), len(files))
+		for _, m := range messages {
+			m := m
+			if err := remove(m.key(), false, func() error { return h.removeFoundMessage(m, evidence) }); err != nil {
+				return j, err
+			}
+		}
+		for _, f := range files {
+			f := f
+			if err := remove(f.key(), true, func() error { return h.removeFoundFile(f, evidence) }); err != nil {
+				return j, err
+			}
+		}
+		j, err = h.jobs.update(id, func(j *removalJob) error {
+			j.Pass++
+			return nil
+		})
+		if err != nil {
+			return j, fmt.Errorf("couldn't save progress: %v", err)
+		}
+		h.updateJobMessage(j)
+		lastUpdate = time.Now()
+	}
+	return j, nil
+}
+
+// resumeJobs restarts every job that was running when slack-moderator last stopped.
+func (h *handler) resumeJobs() error {
+	jobs, err := h.jobs.list(jobRunning)
+	if err != nil {
+		return fmt.Errorf("couldn't list running jobs: %v", err)
+	}
+	for _, j := range jobs {
+		log.Printf("Resuming removal job %d.\n", j.ID)
+		go h.runJob(j.ID)
+	}
+	return nil
+}
+
+// retryJob marks a failed job as running again. The caller should then run it.
+func (h *handler) retryJob(id uint64) (removalJob, error) {
+	return h.jobs.update(id, func(j *removalJob) error {
+		if j.Status != jobFailed {
+			return fmt.Errorf("job %d is %s, not failed", j.ID, j.Status)
+		}
+		j.Status = jobRunning
+		j.Error = ""
+		// If every pass is complete, one more will retry whatever failed.
+		if j.Pass > removalPasses {
+			j.Pass = removalPasses
+		}
+		return nil
+	})
+}
+
+// handleJobAction handles the retry button on job status messages.
+func (h *handler) handleJobAction(interaction slackInteraction, rw http.ResponseWriter) {
+	if permissions, err := h.permissions(interaction.User.ID); err != nil || !hasPermission(permissions, permissionRemoveContent) {
+		respondEphemeral(rw, "You aren't allowed to remove content.")
+		return
+	}
+	if len(interaction.Actions) != 1 || interaction.Actions[0].Name != "retry" {
+		logError(rw, "Expected one retry action, but got %v.", interaction.Actions)
+		return
+	}
+	id, err := strconv.ParseUint(interaction.Actions[0].Value, 10, 64)
+	if err != nil {
+		logError(rw, "Failed to parse job ID %q: %v.", interaction.Actions[0].Value, err)
+		return
+	}
+	j, err := h.retryJob(id)
+	if err != nil {
+		respondEphemeral(rw, fmt.Sprintf("Couldn't retry job %d: %v.", id, err))
+		return
+	}
+	log.Printf("User %s (%s) retried job %d.\n", interaction.User.ID, interaction.User.Name, id)
+	h.updateJobMessage(j)
+	go h.runJob(id)
+	respondEphemeral(rw, fmt.Sprintf("Retrying job %d.", id))
+}
+
+// handleModCommand handles the /mod slash command. `/mod jobs` lists running and failed jobs, and
+// `/mod reactivate <user>` reactivates a deactivated user.
+func (h *handler) handleModCommand(f url.Values, rw http.ResponseWriter) {
+	args := strings.Fields(f.Get("text"))
+	switch {
+	case len(args) == 1 && args[0] == "jobs":
+		h.listJobs(f, rw)
+	case len(args) == 2 && args[0] == "reactivate":
+		h.handleReactivate(f, args[1], rw)
+	default:
+		respondEphemeral(rw, fmt.Sprintf("Usage: `%[1]s jobs` or `%[1]s reactivate @user`", f.Get("command")))
+	}
+}
+
+// listJobs responds with the running and failed jobs.
+func (h *handler) listJobs(f url.Values, rw http.ResponseWriter) {
+	if permissions, err := h.permissions(f.Get("user_id")); err != nil || !hasPermission(permissions, permissionRemoveContent) {
+		respondEphemeral(rw, "Only moderators who can remove content can see removal jobs.")
+		return
+	}
+	jobs, err := h.jobs.list(jobRunning, jobFailed)
+	if err != nil {
+		logError(rw, "Failed to list jobs: %v", err)
+		return
+	}
+	if len(jobs) == 0 {
+		respondEphemeral(rw, "There are no running or failed removal jobs.")
+		return
+	}
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].ID > jobs[k].ID })
+	text := "Running and failed removal jobs:"
+	if len(jobs) > maxListedJobs {
+		text = fmt.Sprintf("The latest %d of %d running and failed removal jobs:", maxListedJobs, len(jobs))
+		jobs = jobs[:maxListedJobs]
+	}
+	var attachments []map[string]interface{}
+	for _, j := range jobs {
+		a := j.statusAttachment()
+		a["pretext"] = fmt.Sprintf("Content from <@%s>, %s, requested by <@%s>:", j.TargetUser, j.Scope.describe(), j.Moderator)
+		attachments = append(attachments, a)
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(rw).Encode(map[string]interface{}{
+		"text":          text,
+		"response_type": "ephemeral",
+		"attachments":   attachments,
+	})
+}
@@ -0,0 +1,230 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/slack-infra/slack"
+	"sigs.k8s.io/slack-infra/slack/slacktest"
+)
+
+func TestJobCheckpoint(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	tests := []struct {
+		name  string
+		store jobStore
+	}{
+		{name: "database", store: newBoltJobStore(db)},
+		{name: "memory", store: newMemoryJobStore()},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			j := &removalJob{}
+			if err := tc.store.create(j); err != nil {
+				t.Fatalf("Failed to create job: %v", err)
+			}
+			checkpoints := []struct {
+				key    string
+				isFile bool
+				err    error
+			}{
+				{key: "message/C1/1.000100"},
+				{key: "file/F1", isFile: true, err: errors.New("cant_delete_file")},
+				{key: "file/F2", isFile: true},
+				{key: "file/F1", isFile: true},
+			}
+			for _, c := range checkpoints {
+				if _, err := tc.store.checkpoint(j.ID, c.key, c.isFile, c.err); err != nil {
+					t.Fatalf("Failed to checkpoint %s: %v", c.key, err)
+				}
+			}
+			got, err := tc.store.get(j.ID)
+			if err != nil {
+				t.Fatalf("Failed to get job: %v", err)
+			}
+			if len(got.Failed) != 0 {
+				t.Errorf("Expected nothing to have failed after F1 was retried, but got %v", got.Failed)
+			}
+			if got.RemovedMessages != 1 || got.RemovedFiles != 2 {
+				t.Errorf("Expected 1 message and 2 files removed, but got %d and %d", got.RemovedMessages, got.RemovedFiles)
+			}
+			done, err := tc.store.done(j.ID)
+			if err != nil {
+				t.Fatalf("Failed to get removed items: %v", err)
+			}
+			if expected := map[string]bool{"message/C1/1.000100": true, "file/F1": true, "file/F2": true}; !reflect.DeepEqual(done, expected) {
+				t.Errorf("Expected %v to be done, but got %v", expected, done)
+			}
+		})
+	}
+}
+
+func TestJobResumesFromCheckpoint(t *testing.T) {
+	defer func(d time.Duration) { recheckDelay = d }(recheckDelay)
+	recheckDelay = 0
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	jobs := newBoltJobStore(db)
+	s := slacktest.NewServer()
+	defer s.Close()
+	general := s.AddChannel(slack.Conversation{Name: "general"})
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	s.AddMessage(general, slack.Message{User: "USPAMMER", Text: "buy things", TS: now + ".000100"})
+	s.AddMessage(general, slack.Message{User: "USPAMMER", Text: "buy more things", TS: now + ".000200"})
+	h := &handler{client: s.Client(), jobs: jobs}
+
+	// Pretend the job was interrupted after it removed the first message, but before Slack caught
+	// up.
+	j := &removalJob{Moderator: "UMOD", TargetUser: "USPAMMER", Scope: removalScope{Since: time.Now().Add(-time.Hour), Replies: true}}
+	if err := jobs.create(j); err != nil {
+		t.Fatalf("Failed to create job: %v", err)
+	}
+	if _, err := jobs.checkpoint(j.ID, "message/"+general+"/"+now+".000100", false, nil); err != nil {
+		t.Fatalf("Failed to checkpoint job: %v", err)
+	}
+	h.runJob(j.ID)
+
+	got, err := jobs.get(j.ID)
+	if err != nil {
+		t.Fatalf("Failed to get job: %v", err)
+	}
+	if got.Status != jobDone || got.RemovedMessages != 2 {
+		t.Errorf("Expected the job to be done with 2 messages removed, but it is %s with %d", got.Status, got.RemovedMessages)
+	}
+	if deletes := s.RequestsFor("chat.delete"); len(deletes) != 1 || deletes[0].Args["ts"] != now+".000200" {
+		t.Errorf("Expected only the second message to be deleted, but got %#v", deletes)
+	}
+	if messages := s.Messages(general); len(messages) != 1 || messages[0].TS != now+".000100" {
+		t.Errorf("Expected the checkpointed message to be skipped, but got %#v", messages)
+	}
+}
+
+func TestJobRetry(t *testing.T) {
+	defer func(d time.Duration) { recheckDelay = d }(recheckDelay)
+	recheckDelay = 0
+	defer func(d time.Duration) { jobProgressInterval = d }(jobProgressInterval)
+	jobProgressInterval = 0
+	jobs := newMemoryJobStore()
+	s := slacktest.NewServer()
+	defer s.Close()
+	s.AddUser(slack.User{ID: "UMOD", IsAdmin: true})
+	modChannel := s.AddChannel(slack.Conversation{Name: "moderation"})
+	general := s.AddChannel(slack.Conversation{Name: "general"})
+	s.AddMessage(general, slack.Message{User: "USPAMMER", Text: "buy things", TS: strconv.FormatInt(time.Now().Unix(), 10) + ".000100"})
+	h := &handler{client: s.Client(), cache: newLookupCache(time.Minute), jobs: jobs, modChannel: modChannel}
+
+	j, err := h.startJob(pendingRemoval{moderator: "UMOD", targetUser: "USPAMMER", scope: removalScope{Since: time.Now().Add(-time.Hour), Replies: true}}, "")
+	if err != nil {
+		t.Fatalf("Failed to start job: %v", err)
+	}
+	if messages := s.Messages(modChannel); len(messages) != 1 || !strings.Contains(messages[0].Text, "Removal job 1") {
+		t.Fatalf("Expected the job's status to be posted in the moderation channel, but got %#v", messages)
+	}
+	s.FailNext("search.messages", "internal_error")
+	h.runJob(j.ID)
+	if got, _ := jobs.get(j.ID); got.Status != jobFailed || !strings.Contains(got.Error, "couldn't search for content") {
+		t.Fatalf("Expected the job to fail to search, but it is %s: %s", got.Status, got.Error)
+	}
+	updates := s.RequestsFor("chat.update")
+	if len(updates) == 0 || !strings.Contains(updates[len(updates)-1].Args["attachments"], "Job 1 is *failed*") || !strings.Contains(updates[len(updates)-1].Args["attachments"], "Retry") {
+		t.Errorf("Expected the status message to offer a retry, but got %#v", updates)
+	}
+
+	rw := httptest.NewRecorder()
+	h.handleModCommand(url.Values{"command": {"/mod"}, "user_id": {"UMOD"}, "text": {"jobs"}}, rw)
+	if body := rw.Body.String(); !strings.Contains(body, "Job 1 is *failed*") {
+		t.Errorf("Expected the failed job to be listed, but got %s", body)
+	}
+
+	if _, err := h.retryJob(j.ID); err != nil {
+		t.Fatalf("Failed to retry job: %v", err)
+	}
+	h.runJob(j.ID)
+	if got, _ := jobs.get(j.ID); got.Status != jobDone || got.RemovedMessages != 1 {
+		t.Errorf("Expected the retried job to be done with 1 message removed, but it is %s with %d", got.Status, got.RemovedMessages)
+	}
+	if _, err := h.retryJob(j.ID); err == nil {
+		t.Errorf("Expected a finished job not to be retryable")
+	}
+	if messages := s.Messages(general); len(messages) != 0 {
+		t.Errorf("Expected the message to be removed, but got %#v", messages)
+	}
+}
+
+func TestRetriedJobKeepsItsWindow(t *testing.T) {
+	defer func(d time.Duration) { recheckDelay = d }(recheckDelay)
+	recheckDelay = 0
+	s := slacktest.NewServer()
+	defer s.Close()
+	responseServer, _ := newResponseServer()
+	defer responseServer.Close()
+	jobs := newMemoryJobStore()
+	s.AddUser(slack.User{ID: "UMOD", IsAdmin: true})
+	modChannel := s.AddChannel(slack.Conversation{Name: "moderation"})
+	general := s.AddChannel(slack.Conversation{Name: "general"})
+	old := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10) + ".000100"
+	s.AddMessage(general, slack.Message{User: "USPAMMER", Text: "buy things", TS: old})
+	h := &handler{client: s.Client(), cache: newLookupCache(time.Minute), pending: newPendingRemovals(time.Minute), jobs: jobs, modChannel: modChannel}
+
+	id, err := h.pending.add(pendingRemoval{moderator: "UMOD", targetUser: "USPAMMER", scope: removalScope{Since: time.Now().Add(-time.Hour), Replies: true}})
+	if err != nil {
+		t.Fatalf("Failed to add pending removal: %v", err)
+	}
+	s.FailNext("search.messages", "internal_error")
+	pressRemovalButton(h, "UMOD", "confirm", id, responseServer.URL)
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		j, err := jobs.get(1)
+		if err != nil {
+			t.Fatalf("Failed to get job: %v", err)
+		}
+		if j.Status == jobFailed {
+			if j.Scope.Until.IsZero() {
+				t.Errorf("Expected the confirmed job's window to have an end")
+			}
+			break
+		}
+		if j.Status != jobRunning || time.Now().After(deadline) {
+			t.Fatalf("Expected the job to fail, but it is %s: %s", j.Status, j.Error)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Whatever the spammer posts after the removal was confirmed wasn't in the preview, so a
+	// retry mustn't remove it.
+	newer := strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10) + ".000100"
+	s.AddMessage(general, slack.Message{User: "USPAMMER", Text: "buy even more things", TS: newer})
+	if _, err := h.retryJob(1); err != nil {
+		t.Fatalf("Failed to retry job: %v", err)
+	}
+	h.runJob(1)
+	if got, _ := jobs.get(1); got.Status != jobDone || got.RemovedMessages != 1 {
+		t.Errorf("Expected the retried job to be done with 1 message removed, but it is %s with %d", got.Status, got.RemovedMessages)
+	}
+	if messages := s.Messages(general); len(messages) != 1 || messages[0].TS != newer {
+		t.Errorf("Expected only the newer message to survive, but got %#v", messages)
+	}
+}
@@ -24,6 +24,9 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
 
 	"sigs.k8s.io/slack-infra/slack"
 )
@@ -46,6 +49,9 @@ func handleHealthz(w http.ResponseWriter, r *http.Request) {
 func runServer(h *handler) error {
 	http.HandleFunc("/healthz", handleHealthz)
 	http.Handle(os.Getenv("PATH_PREFIX")+"/webhook", h)
+	if h.appealToken != "" {
+		http.Handle(os.Getenv("PATH_PREFIX")+"/appeal", appealHandler{h: h, token: h.appealToken})
+	}
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -57,32 +63,139 @@ func runServer(h *handler) error {
 	return http.ListenAndServe(fmt.Sprintf(":%s", port), nil)
 }
 
-func loadAdminToken(path string) (string, error) {
-	extraConf := struct {
-		AdminToken string `json:"adminToken"`
-	}{}
+// extraConfig is the part of the config file specific to slack-moderator.
+type extraConfig struct {
+	// AdminToken is a token with the admin scope, used to deactivate and reactivate users through
+	// SCIM. If it is not set, users can't be deactivated.
+	AdminToken string          `json:"adminToken"`
+	Moderators moderatorConfig `json:"moderators"`
+	// CacheTTL is how long to remember users and usergroup members for, as a Go duration.
+	CacheTTL string `json:"cacheTTL"`
+	// CasesDB is the path to a database in which to keep reports as cases, along with everything
+	// else that should survive a restart. If it is set, reports are posted in ModChannel instead of
+	// to the webhook.
+	CasesDB    string         `json:"casesDB"`
+	ModChannel string         `json:"modChannel"`
+	FollowUp   followUpConfig `json:"followUp"`
+	// AppealToken is the token that appeals must carry. If it is set, appeals are accepted at
+	// /appeal and filed as cases, so CasesDB must be set too.
+	AppealToken string `json:"appealToken"`
+	// EvidenceDir is a directory in which to keep a copy of content before removing it. If it is
+	// not set, content is removed without keeping a copy.
+	EvidenceDir string `json:"evidenceDir"`
+	// EvidenceRetention is how long to keep evidence for, as a Go duration. If it is not set,
+	// evidence is kept forever.
+	EvidenceRetention string `json:"evidenceRetention"`
+	// AdminMaxRemoval is the longest window workspace admins and owners may remove content from, as
+	// a Go duration. Everyone else is limited to maxRemovalDuration.
+	AdminMaxRemoval string `json:"adminMaxRemoval"`
+	// Approval says which actions need a second moderator's approval, which is requested in
+	// ModChannel.
+	Approval approvalConfig `json:"approval"`
+}
+
+func loadExtraConfig(path string) (extraConfig, error) {
+	extraConf := extraConfig{CacheTTL: "5m", FollowUp: defaultFollowUp}
 	content, err := ioutil.ReadFile(path)
 	if err != nil {
-		return "", fmt.Errorf("couldn't open file: %v", err)
+		return extraConf, fmt.Errorf("couldn't open file: %v", err)
 	}
 	if err := json.Unmarshal(content, &extraConf); err != nil {
-		return "", fmt.Errorf("couldn't parse config: %v", err)
+		return extraConf, fmt.Errorf("couldn't parse config: %v", err)
+	}
+	if err := extraConf.Moderators.validate(); err != nil {
+		return extraConf, err
+	}
+	if extraConf.CasesDB != "" && extraConf.ModChannel == "" {
+		return extraConf, fmt.Errorf("modChannel must be set to keep cases")
 	}
-	return extraConf.AdminToken, nil
+	if (extraConf.Approval.Deactivate || extraConf.Approval.RemovalOver != "") && extraConf.ModChannel == "" {
+		return extraConf, fmt.Errorf("modChannel must be set to ask for approval")
+	}
+	return extraConf, nil
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "export-evidence" {
+		runExportEvidence(os.Args[2:])
+		return
+	}
 	o := parseFlags()
 	c, err := slack.LoadConfig(o.configPath)
 	if err != nil {
 		log.Fatalf("Failed to load config from %s: %v", o.configPath, err)
 	}
-	adminToken, err := loadAdminToken(o.configPath)
+	extra, err := loadExtraConfig(o.configPath)
 	if err != nil {
-		log.Fatalf("Failed to load admin token from %s: %v", o.configPath, err)
+		log.Fatalf("Failed to load moderator config from %s: %v", o.configPath, err)
+	}
+	ttl, err := time.ParseDuration(extra.CacheTTL)
+	if err != nil {
+		log.Fatalf("Failed to parse cacheTTL %q: %v", extra.CacheTTL, err)
 	}
 	s := slack.New(c)
 
-	h := &handler{client: s, adminToken: adminToken}
+	h := &handler{client: s, moderators: extra.Moderators, cache: newLookupCache(ttl), pending: newPendingRemovals(pendingRemovalTTL), modChannel: extra.ModChannel, followUp: extra.FollowUp}
+	if extra.AdminToken != "" {
+		h.scim = slack.NewSCIM(slack.SCIMConfig{Token: extra.AdminToken})
+	}
+	var db *bolt.DB
+	if extra.CasesDB != "" {
+		db, err = openDB(extra.CasesDB)
+		if err != nil {
+			log.Fatalf("Failed to open case database: %v", err)
+		}
+		h.cases = newCaseStore(db)
+	}
+	if extra.AppealToken != "" {
+		if h.cases == nil {
+			log.Fatalf("appealToken is set, but casesDB isn't, so there is nowhere to file appeals")
+		}
+		h.appealToken = extra.AppealToken
+	}
+	policy, approvalTimeout, err := extra.Approval.parse()
+	if err != nil {
+		log.Fatalf("Failed to parse approval config: %v", err)
+	}
+	if policy.enabled() {
+		h.approval = policy
+		h.approvals = newPendingApprovals(approvalTimeout, db)
+	}
+	if extra.AdminMaxRemoval != "" {
+		h.adminMaxRemoval, err = time.ParseDuration(extra.AdminMaxRemoval)
+		if err != nil {
+			log.Fatalf("Failed to parse adminMaxRemoval %q: %v", extra.AdminMaxRemoval, err)
+		}
+	}
+	if extra.EvidenceDir != "" {
+		var retention time.Duration
+		if extra.EvidenceRetention != "" {
+			retention, err = time.ParseDuration(extra.EvidenceRetention)
+			if err != nil {
+				log.Fatalf("Failed to parse evidenceRetention %q: %v", extra.EvidenceRetention, err)
+			}
+		}
+		h.evidence, err = newEvidenceStore(extra.EvidenceDir, retention)
+		if err != nil {
+			log.Fatalf("Failed to open evidence store: %v", err)
+		}
+		if err := h.evidence.prune(); err != nil {
+			log.Printf("Failed to prune old evidence: %v\n", err)
+		}
+	}
+	if db != nil {
+		h.jobs = newBoltJobStore(db)
+	} else {
+		h.jobs = newMemoryJobStore()
+		log.Printf("No casesDB is configured, so removal jobs will not survive restarts.\n")
+	}
+	if err := h.resumeJobs(); err != nil {
+		log.Printf("Failed to resume removal jobs: %v\n", err)
+	}
+	if h.approvals != nil {
+		if err := h.restoreApprovals(); err != nil {
+			log.Printf("%v\n", err)
+		}
+	}
 	log.Fatal(runServer(h))
 }
@@ -28,7 +28,7 @@ import (
 
 const maxRemovalDuration = 48 * time.Hour
 
-func (h *handler) handleModerateMessage(interaction slackInteraction, rw http.ResponseWriter) {
+func (h *handler) handleModerateMessage(interaction slackInteraction, rw http.ResponseWriter, permissions []permission) {
 	targetUser, err := h.getDisplayName(interaction.Message.User)
 	if err != nil {
 		targetUser = "<error>"
@@ -48,41 +48,70 @@ func (h *handler) handleModerateMessage(interaction slackInteraction, rw http.Re
 		},
 		Value: "no",
 	}
-	removeContentElement := slack.SelectElement{
-		Name:  "remove_content",
-		Label: fmt.Sprintf("How much content would you like to remove?"),
-		Options: []slack.SelectOption{
-			{
-				Label: "None",
-				Value: "none",
-			},
-			{
-				Label: "10 minutes",
-				Value: "10m",
-			},
-			{
-				Label: "1 hour",
-				Value: "1h",
-			},
-			{
-				Label: "6 hours",
-				Value: "6h",
-			},
-			{
-				Label: "12 hours",
-				Value: "12h",
-			},
-			{
-				Label: "24 hours",
-				Value: "24h",
-			},
-			{
-				Label: "48 hours",
-				Value: "48h",
-			},
-			// If you change these, you may need to change maxRemovalDuration accordingly.
+	limit, err := h.removalLimit(interaction.User.ID)
+	if err != nil {
+		log.Printf("Failed to look up removal limit, so using the default: %v\n", err)
+		limit = maxRemovalDuration
+	}
+	removalOptions := []slack.SelectOption{{Label: "None", Value: "none"}}
+	for _, d := range removalDurations {
+		if d.duration <= limit {
+			removalOptions = append(removalOptions, slack.SelectOption{Label: d.label, Value: d.duration.String()})
+		}
+	}
+	removalOptions = append(removalOptions, slack.SelectOption{Label: "Custom window (below)", Value: "custom"})
+	removeContentElements := []interface{}{
+		slack.SelectElement{
+			Name:    "remove_content",
+			Label:   "How much content would you like to remove?",
+			Options: removalOptions,
+			Value:   "10m0s",
+		},
+		slack.TextElement{
+			Name:        "remove_from",
+			Label:       "Custom window start (UTC)",
+			Placeholder: removalTimeLayout,
+			Optional:    true,
+			Hint:        fmt.Sprintf("Only used for a custom window. The window may be at most %s long.", limit),
+		},
+		slack.TextElement{
+			Name:        "remove_until",
+			Label:       "Custom window end (UTC)",
+			Placeholder: removalTimeLayout,
+			Optional:    true,
+			Hint:        "Only used for a custom window. Leave empty to remove content up to now.",
+		},
+		slack.TextElement{
+			Name:     "remove_channels",
+			Label:    "Only in these channels",
+			Optional: true,
+			Hint:     "Channel names or IDs, separated by commas. Leave empty to remove content from everywhere.",
+		},
+		slack.TextArea{
+			Name:     "remove_threads",
+			Label:    "Only in these threads",
+			Optional: true,
+			Hint:     "Links to messages in the threads, one per line. Leave empty to remove content from everywhere.",
+		},
+		slack.SelectElement{
+			Name:    "remove_replies",
+			Label:   "Remove thread replies?",
+			Options: []slack.SelectOption{{Label: "Yes", Value: "yes"}, {Label: "No", Value: "no"}},
+			Value:   "yes",
+		},
+		slack.SelectElement{
+			Name:    "remove_files",
+			Label:   "Remove files?",
+			Options: []slack.SelectOption{{Label: "Yes", Value: "yes"}, {Label: "No", Value: "no"}},
+			Value:   "yes",
 		},
-		Value: "10m",
+	}
+	var elements []interface{}
+	if hasPermission(permissions, permissionDeactivate) && h.scim != nil {
+		elements = append(elements, deactivateElement)
+	}
+	if hasPermission(permissions, permissionRemoveContent) {
+		elements = append(elements, removeContentElements...)
 	}
 	dialog := slack.DialogWrapper{
 		TriggerID: interaction.TriggerID,
@@ -90,7 +119,7 @@ func (h *handler) handleModerateMessage(interaction slackInteraction, rw http.Re
 			CallbackID:     "moderate_user",
 			NotifyOnCancel: false,
 			Title:          "Moderate User",
-			Elements:       []interface{}{deactivateElement, removeContentElement},
+			Elements:       elements,
 			State:          interaction.Message.User,
 		},
 	}
@@ -101,8 +130,8 @@ func (h *handler) handleModerateMessage(interaction slackInteraction, rw http.Re
 }
 
 func (h *handler) handleModerateSubmission(interaction slackInteraction) {
-	isMod, err := h.userHasModerationPowers(interaction.User.ID)
-	if err != nil || !isMod {
+	permissions, err := h.permissions(interaction.User.ID)
+	if err != nil || len(permissions) == 0 {
 		log.Printf("User %s (%s) does not seem to be a mod: %v\n", interaction.User.ID, interaction.User.Name, err)
 		return
 	}
@@ -128,45 +157,57 @@ func (h *handler) handleModerateSubmission(interaction slackInteraction) {
 	}
 
 	if interaction.Submission["deactivate"] == "yes" {
-		if err := h.deactivateUser(interaction, targetUser); err != nil {
+		if !hasPermission(permissions, permissionDeactivate) {
+			messages = append(messages, fmt.Sprintf("Not deactivating user %s (%s), because you aren't allowed to deactivate users", targetUser, targetDisplayName))
+		} else if err := h.checkCanDeactivate(interaction.User.ID, targetUser); err != nil {
+			messages = append(messages, fmt.Sprintf("Not deactivating user %s (%s), because %v", targetUser, targetDisplayName, err))
+		} else if h.approval.deactivate {
+			if err := h.requestApproval(approvalRequest{Kind: approvalDeactivate, Requester: interaction.User.ID, TargetUser: targetUser}); err != nil {
+				messages = append(messages, fmt.Sprintf("Not deactivating user %s (%s), because it needs another moderator's approval, and we couldn't ask for it: %v", targetUser, targetDisplayName, err))
+			} else {
+				messages = append(messages, fmt.Sprintf("Deactivating user %s (%s) needs another moderator's approval, which has been requested in <#%s>", targetUser, targetDisplayName, h.modChannel))
+			}
+		} else if err := h.setUserActive(targetUser, false); err != nil {
 			messages = append(messages, fmt.Sprintf("Failed to deactivate user %s (%s): %v", targetUser, targetDisplayName, err))
 		} else {
 			messages = append(messages, fmt.Sprintf("Successfully deactivated user %s (%s)", targetUser, targetDisplayName))
+			if err := h.recordDeactivation(interaction.User.ID, "", targetUser); err != nil {
+				log.Printf("Failed to record deactivation of %s: %v\n", targetUser, err)
+			}
 		}
 	}
+	var preview []map[string]interface{}
 	if remove, ok := interaction.Submission["remove_content"]; ok && remove != "none" {
-		duration, err := time.ParseDuration(remove)
-		if err != nil {
-			messages = append(messages, fmt.Sprintf("Failed to parse removal duration, and therefore could not remove any content: %v", err))
+		if !hasPermission(permissions, permissionRemoveContent) {
+			messages = append(messages, "Not removing any content, because you aren't allowed to remove content")
 			goto respond
 		}
-		if duration > maxRemovalDuration {
-			messages = append(messages, fmt.Sprintf("unacceptably long content removal duration: %s", duration))
+		limit, err := h.removalLimit(interaction.User.ID)
+		if err != nil {
+			messages = append(messages, fmt.Sprintf("Failed to look up how much content you may remove, and therefore could not remove any: %v", err))
 			goto respond
 		}
-		removedFiles, remainingFiles, removedMessages, remainingMessages, err := h.removeUserContent(interaction, duration, targetUser)
-
+		scope, err := h.parseRemovalScope(interaction.Submission, time.Now(), limit)
 		if err != nil {
-			messages = append(messages, fmt.Sprintf("Failed to remove any content: %v", err))
+			messages = append(messages, fmt.Sprintf("Not removing any content, because %v", err))
 			goto respond
 		}
-		// Delete things again in case search was behind before.
-		time.Sleep(10 * time.Second)
-		fs2, fe2, ms2, me2, err := h.removeUserContent(interaction, duration, targetUser)
-		removedFiles += fs2
-		remainingFiles += fe2
-		removedMessages += ms2
-		remainingMessages += me2
-
+		files, found, err := h.findUserContent(targetUser, scope)
 		if err != nil {
-			messages = append(messages, fmt.Sprintf("Deleted things once, but the cleanup check failed, so very recent messages may remain: %v", err))
+			messages = append(messages, fmt.Sprintf("Failed to search for content, and therefore could not remove any: %v", err))
+			goto respond
 		}
-
-		if remainingFiles == 0 && remainingMessages == 0 {
-			messages = append(messages, fmt.Sprintf("Successfully removed %d messages and %d files", removedMessages, removedFiles))
-		} else {
-			messages = append(messages, fmt.Sprintf("Couldn't remove all content. Removed %d messages and %d files, but there are %d messages and %d files left.", removedMessages, removedFiles, remainingMessages, remainingFiles))
+		if len(files) == 0 && len(found) == 0 {
+			messages = append(messages, fmt.Sprintf("Found no content to remove %s.", scope.describe()))
+			goto respond
 		}
+		id, err := h.pending.add(pendingRemoval{moderator: interaction.User.ID, targetUser: targetUser, scope: scope})
+		if err != nil {
+			messages = append(messages, fmt.Sprintf("Failed to prepare content removal, and therefore could not remove any: %v", err))
+			goto respond
+		}
+		messages = append(messages, fmt.Sprintf("Waiting for confirmation to remove %s and %s.", countOf(len(found), "message"), countOf(len(files), "file")))
+		preview = removalPreview(id, targetUser, scope, files, found)
 	}
 
 respond:
@@ -180,6 +221,9 @@ respond:
 		"response_type":    "ephemeral",
 		"replace_original": true,
 	}
+	if preview != nil {
+		response["attachments"] = preview
+	}
 
 	if h.client.CallMethod(interaction.ResponseURL, response, nil) != nil {
 		log.Printf("Failed to send response: %v.\n", err)
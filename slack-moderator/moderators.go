@@ -0,0 +1,215 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/slack-infra/slack"
+)
+
+// permission is something a moderator can be allowed to do.
+type permission string
+
+const (
+	permissionRemoveContent permission = "remove_content"
+	permissionDeactivate    permission = "deactivate"
+)
+
+var allPermissions = []permission{permissionRemoveContent, permissionDeactivate}
+
+// defaultModeratorPermissions are what configured moderators can do unless the config says
+// otherwise. Deactivation has to be granted explicitly.
+var defaultModeratorPermissions = []permission{permissionRemoveContent}
+
+// moderatorConfig describes who, other than workspace admins and owners, can moderate.
+type moderatorConfig struct {
+	// Usergroup is the handle of a usergroup whose members are moderators.
+	Usergroup string `json:"usergroup"`
+	// Users are the IDs of moderators.
+	Users []string `json:"users"`
+	// Permissions are what moderators can do. If empty, they can only remove content.
+	Permissions []permission `json:"permissions"`
+}
+
+func (c moderatorConfig) validate() error {
+	for _, p := range c.Permissions {
+		if !hasPermission(allPermissions, p) {
+			return fmt.Errorf("unknown moderator permission %q", p)
+		}
+	}
+	return nil
+}
+
+func hasPermission(permissions []permission, p permission) bool {
+	for _, v := range permissions {
+		if v == p {
+			return true
+		}
+	}
+	return false
+}
+
+// permissions returns what the user with the given ID is allowed to do. Workspace admins and
+// owners can do everything, configured moderators can do what the config allows, and everyone else
+// can do nothing.
+func (h *handler) permissions(id string) ([]permission, error) {
+	user, err := h.getUserInfo(id)
+	if err != nil {
+		return nil, err
+	}
+	if isAdmin(user) {
+		return allPermissions, nil
+	}
+	isMod, err := h.isConfiguredModerator(id)
+	if err != nil || !isMod {
+		return nil, err
+	}
+	if len(h.moderators.Permissions) == 0 {
+		return defaultModeratorPermissions, nil
+	}
+	return h.moderators.Permissions, nil
+}
+
+// isAdmin returns whether user is a workspace admin or owner.
+func isAdmin(user slack.User) bool {
+	return user.IsAdmin || user.IsOwner || user.IsPrimaryOwner
+}
+
+// checkCanDeactivate returns an error unless the moderator may deactivate the target user. Only
+// workspace admins and owners may deactivate other admins and owners.
+func (h *handler) checkCanDeactivate(moderator, targetUser string) error {
+	target, err := h.getUserInfo(targetUser)
+	if err != nil {
+		return err
+	}
+	if !isAdmin(target) {
+		return nil
+	}
+	user, err := h.getUserInfo(moderator)
+	if err != nil {
+		return err
+	}
+	if !isAdmin(user) {
+		return fmt.Errorf("only workspace admins and owners can deactivate <@%s>, who is one", targetUser)
+	}
+	return nil
+}
+
+func (h *handler) isConfiguredModerator(id string) (bool, error) {
+	for _, u := range h.moderators.Users {
+		if u == id {
+			return true, nil
+		}
+	}
+	if h.moderators.Usergroup == "" {
+		return false, nil
+	}
+	members, err := h.getUsergroupMembers(h.moderators.Usergroup)
+	if err != nil {
+		return false, err
+	}
+	for _, m := range members {
+		if m == id {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (h *handler) getUsergroupMembers(handle string) ([]string, error) {
+	if members, ok := h.cache.usergroupMembers(handle); ok {
+		return members, nil
+	}
+	result := struct {
+		Usergroups []slack.Subteam `json:"usergroups"`
+	}{}
+	if err := h.client.CallOldMethod("usergroups.list", map[string]string{"include_users": "true"}, &result); err != nil {
+		return nil, fmt.Errorf("failed to list usergroups: %v", err)
+	}
+	for _, g := range result.Usergroups {
+		if g.Handle == handle {
+			h.cache.setUsergroupMembers(handle, g.Users)
+			return g.Users, nil
+		}
+	}
+	return nil, fmt.Errorf("couldn't find moderator usergroup %q", handle)
+}
+
+// lookupCache remembers users and usergroup members for a while, so that we don't have to look
+// them up on every interaction.
+type lookupCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu         sync.Mutex
+	users      map[string]cachedUser
+	usergroups map[string]cachedMembers
+}
+
+type cachedUser struct {
+	user    slack.User
+	expires time.Time
+}
+
+type cachedMembers struct {
+	members []string
+	expires time.Time
+}
+
+func newLookupCache(ttl time.Duration) *lookupCache {
+	return &lookupCache{
+		ttl:        ttl,
+		now:        time.Now,
+		users:      map[string]cachedUser{},
+		usergroups: map[string]cachedMembers{},
+	}
+}
+
+func (c *lookupCache) user(id string) (slack.User, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	u, ok := c.users[id]
+	if !ok || c.now().After(u.expires) {
+		return slack.User{}, false
+	}
+	return u.user, true
+}
+
+func (c *lookupCache) setUser(u slack.User) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.users[u.ID] = cachedUser{user: u, expires: c.now().Add(c.ttl)}
+}
+
+func (c *lookupCache) usergroupMembers(handle string) ([]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	g, ok := c.usergroups[handle]
+	if !ok || c.now().After(g.expires) {
+		return nil, false
+	}
+	return g.members, true
+}
+
+func (c *lookupCache) setUsergroupMembers(handle string, members []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.usergroups[handle] = cachedMembers{members: members, expires: c.now().Add(c.ttl)}
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/slack-infra/slack"
+	"sigs.k8s.io/slack-infra/slack/slacktest"
+)
+
+func TestPermissions(t *testing.T) {
+	s := slacktest.NewServer()
+	defer s.Close()
+	s.AddUser(slack.User{ID: "UADMIN", IsAdmin: true})
+	s.AddUser(slack.User{ID: "ULISTED"})
+	s.AddUser(slack.User{ID: "UGROUPED"})
+	s.AddUser(slack.User{ID: "UNOBODY"})
+	s.AddUsergroup(slack.Subteam{Handle: "community-moderators", Users: []string{"UGROUPED"}})
+
+	tests := []struct {
+		name       string
+		moderators moderatorConfig
+		user       string
+		expected   []permission
+	}{
+		{
+			name:     "admins can do everything",
+			user:     "UADMIN",
+			expected: allPermissions,
+		},
+		{
+			name:     "other users can do nothing by default",
+			user:     "ULISTED",
+			expected: nil,
+		},
+		{
+			name:       "listed moderators can only remove content by default",
+			moderators: moderatorConfig{Users: []string{"ULISTED"}},
+			user:       "ULISTED",
+			expected:   defaultModeratorPermissions,
+		},
+		{
+			name:       "members of the moderator usergroup are moderators",
+			moderators: moderatorConfig{Usergroup: "community-moderators"},
+			user:       "UGROUPED",
+			expected:   defaultModeratorPermissions,
+		},
+		{
+			name:       "moderators can be given other permissions",
+			moderators: moderatorConfig{Users: []string{"ULISTED"}, Usergroup: "community-moderators", Permissions: []permission{permissionDeactivate}},
+			user:       "UGROUPED",
+			expected:   []permission{permissionDeactivate},
+		},
+		{
+			name:       "admins aren't limited by moderator permissions",
+			moderators: moderatorConfig{Users: []string{"ULISTED"}, Permissions: []permission{permissionRemoveContent}},
+			user:       "UADMIN",
+			expected:   allPermissions,
+		},
+		{
+			name:       "people who aren't moderators can do nothing",
+			moderators: moderatorConfig{Users: []string{"ULISTED"}, Usergroup: "community-moderators"},
+			user:       "UNOBODY",
+			expected:   nil,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &handler{client: s.Client(), moderators: tc.moderators, cache: newLookupCache(time.Minute)}
+			permissions, err := h.permissions(tc.user)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(permissions, tc.expected) {
+				t.Errorf("Expected permissions %v, but got %v", tc.expected, permissions)
+			}
+		})
+	}
+}
+
+func TestPermissionLookupsAreCached(t *testing.T) {
+	s := slacktest.NewServer()
+	defer s.Close()
+	s.AddUser(slack.User{ID: "UGROUPED"})
+	s.AddUsergroup(slack.Subteam{Handle: "community-moderators", Users: []string{"UGROUPED"}})
+
+	now := time.Date(2019, 10, 7, 9, 0, 0, 0, time.UTC)
+	h := &handler{client: s.Client(), moderators: moderatorConfig{Usergroup: "community-moderators"}, cache: newLookupCache(time.Minute)}
+	h.cache.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if _, err := h.permissions("UGROUPED"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if n := len(s.Requests()); n != 2 {
+		t.Errorf("Expected a single users.info and usergroups.list call, but got %d calls", n)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, err := h.permissions("UGROUPED"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if n := len(s.Requests()); n != 4 {
+		t.Errorf("Expected expired lookups to be repeated, but got %d calls in total", n)
+	}
+}
+
+func TestOnlyAdminsCanDeactivateAdmins(t *testing.T) {
+	s := slacktest.NewServer()
+	defer s.Close()
+	s.AddUser(slack.User{ID: "UADMIN", IsAdmin: true})
+	s.AddUser(slack.User{ID: "UOWNER", IsOwner: true})
+	s.AddUser(slack.User{ID: "UMOD"})
+	s.AddUser(slack.User{ID: "USPAMMER"})
+	h := &handler{client: s.Client(), moderators: moderatorConfig{Users: []string{"UMOD"}, Permissions: allPermissions}, cache: newLookupCache(time.Minute)}
+
+	tests := []struct {
+		name      string
+		moderator string
+		target    string
+		expectErr bool
+	}{
+		{name: "moderators can deactivate ordinary users", moderator: "UMOD", target: "USPAMMER"},
+		{name: "moderators can't deactivate admins", moderator: "UMOD", target: "UADMIN", expectErr: true},
+		{name: "moderators can't deactivate owners", moderator: "UMOD", target: "UOWNER", expectErr: true},
+		{name: "admins can deactivate owners", moderator: "UADMIN", target: "UOWNER"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := h.checkCanDeactivate(tc.moderator, tc.target); (err != nil) != tc.expectErr {
+				t.Errorf("Expected error: %t, but got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pendingRemovalTTL is how long a moderator has to confirm a previewed removal.
+const pendingRemovalTTL = 15 * time.Minute
+
+// maxPreviewSamples is how many messages to quote in a removal preview.
+const maxPreviewSamples = 3
+
+// recheckDelay is how long a removal job waits before searching for content again, in case search
+// was behind the first time.
+var recheckDelay = 10 * time.Second
+
+// pendingRemoval is a content removal that a moderator has previewed, but not yet confirmed.
+type pendingRemoval struct {
+	moderator  string
+	targetUser string
+	scope      removalScope
+	expires    time.Time
+}
+
+// pendingRemovals holds previewed removals until they are confirmed, cancelled, or expire.
+type pendingRemovals struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu       sync.Mutex
+	removals map[string]pendingRemoval
+}
+
+func newPendingRemovals(ttl time.Duration) *pendingRemovals {
+	return &pendingRemovals{ttl: ttl, now: time.Now, removals: map[string]pendingRemoval{}}
+}
+
+// add holds r until it is taken, and returns the ID to take it with.
+func (p *pendingRemovals) add(r pendingRemoval) (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("couldn't generate removal ID: %v", err)
+	}
+	id := hex.EncodeToString(b)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	for k, v := range p.removals {
+		if now.After(v.expires) {
+			delete(p.removals, k)
+		}
+	}
+	r.expires = now.Add(p.ttl)
+	p.removals[id] = r
+	return id, nil
+}
+
+// take returns the removal with the given ID and forgets about it, as long as it was previewed by
+// the given moderator and hasn't expired.
+func (p *pendingRemovals) take(id, moderator string) (pendingRemoval, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r, ok := p.removals[id]
+	if !ok || p.now().After(r.expires) {
+		delete(p.removals, id)
+		return pendingRemoval{}, errors.New("it has expired, or was already confirmed or cancelled")
+	}
+	if r.moderator != moderator {
+		return pendingRemoval{}, errors.New("it was previewed by someone else")
+	}
+	delete(p.removals, id)
+	return r, nil
+}
+
+// removalPreview returns attachments describing what removing the given files and messages would
+// remove, with buttons to confirm or cancel removal.
+func removalPreview(id, targetUser string, scope removalScope, files []foundFile, messages []messageID) []map[string]interface{} {
+	type counts struct{ messages, files int }
+	perChannel := map[string]*counts{}
+	count := func(channel string) *counts {
+		if perChannel[channel] == nil {
+			perChannel[channel] = &counts{}
+		}
+		return perChannel[channel]
+	}
+	for _, m := range messages {
+		count(m.channel).messages++
+	}
+	for _, f := range files {
+		if len(f.channels) == 0 {
+			count("").files++
+		}
+		for _, c := range f.channels {
+			count(c).files++
+		}
+	}
+	var channels []string
+	for c := range perChannel {
+		channels = append(channels, c)
+	}
+	sort.Strings(channels)
+
+	lines := []string{fmt.Sprintf("Confirming will remove %s and %s from <@%s>, %s:", countOf(len(messages), "message"), countOf(len(files), "file"), targetUser, scope.describe())}
+	for _, c := range channels {
+		where := fmt.Sprintf("<#%s>", c)
+		if c == "" {
+			where = "Not shared in any channel"
+		}
+		lines = append(lines, fmt.Sprintf("• %s: %s, %s", where, countOf(perChannel[c].messages, "message"), countOf(perChannel[c].files, "file")))
+	}
+	if len(messages) > 0 {
+		lines = append(lines, "For example:")
+		for i, m := range messages {
+			if i == maxPreviewSamples {
+				break
+			}
+			lines = append(lines, fmt.Sprintf("> <#%s>: %s", m.channel, strings.Replace(shortenString(m.text, 150), "\n", " ", -1)))
+		}
+	}
+	text := strings.Join(lines, "\n")
+
+	return []map[string]interface{}{
+		{
+			"text":      text,
+			"fallback":  text,
+			"mrkdwn_in": []string{"text"},
+		},
+		{
+			"text":        "Content is searched for again when you confirm, so anything posted since will be removed too.",
+			"fallback":    "Confirm or cancel removal",
+			"callback_id": "confirm_removal",
+			"actions": []map[string]interface{}{
+				{"name": "confirm", "text": "Confirm", "type": "button", "style": "danger", "value": id},
+				{"name": "cancel", "text": "Cancel", "type": "button", "value": id},
+			},
+		},
+	}
+}
+
+// handleRemovalAction handles the buttons on removal previews.
+func (h *handler) handleRemovalAction(interaction slackInteraction, rw http.ResponseWriter) {
+	if permissions, err := h.permissions(interaction.User.ID); err != nil || !hasPermission(permissions, permissionRemoveContent) {
+		respondEphemeral(rw, "You aren't allowed to remove content.")
+		return
+	}
+	if len(interaction.Actions) != 1 {
+		logError(rw, "Expected one action, but got %d.", len(interaction.Actions))
+		return
+	}
+	action := interaction.Actions[0]
+	if action.Name != "confirm" && action.Name != "cancel" {
+		logError(rw, "Unknown removal action %q.", action.Name)
+		return
+	}
+	r, err := h.pending.take(action.Value, interaction.User.ID)
+	if err != nil {
+		respondEphemeral(rw, fmt.Sprintf("Couldn't %s removal, because %v.", action.Name, err))
+		return
+	}
+
+	if action.Name == "cancel" {
+		log.Printf("User %s (%s) cancelled content removal from %s.\n", interaction.User.ID, interaction.User.Name, r.targetUser)
+		replaceOriginal(rw, "Cancelled. No content was removed.")
+		return
+	}
+	log.Printf("User %s (%s) confirmed content removal from %s.\n", interaction.User.ID, interaction.User.Name, r.targetUser)
+	r.scope = r.scope.pin(time.Now())
+	if h.approval.removalNeedsApproval(r.scope, time.Now()) {
+		if err := h.requestApproval(approvalRequest{Kind: approvalRemoval, Requester: r.moderator, TargetUser: r.targetUser, Scope: r.scope}); err != nil {
+			logError(rw, "Failed to ask for approval: %v", err)
+			return
+		}
+		replaceOriginal(rw, fmt.Sprintf("Removing content from more than %s needs another moderator's approval, which has been requested in <#%s>.", h.approval.removalOver, h.modChannel))
+		return
+	}
+	j, err := h.startJob(r, "")
+	if err != nil {
+		logError(rw, "Failed to start removal: %v", err)
+		return
+	}
+	go h.runJob(j.ID)
+	where := "with `/mod jobs`"
+	if j.StatusTS != "" && h.modChannel != "" {
+		where = fmt.Sprintf("in <#%s>", h.modChannel)
+	} else if j.StatusTS != "" {
+		where = "in a direct message"
+	}
+	replaceOriginal(rw, fmt.Sprintf("Started removal job %d. You can follow its progress %s.", j.ID, where))
+}
+
+func replaceOriginal(rw http.ResponseWriter, text string) {
+	rw.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(rw).Encode(map[string]interface{}{
+		"text":             text,
+		"response_type":    "ephemeral",
+		"replace_original": true,
+	})
+}
+
+// countOf returns n and the noun, which is pluralised if necessary.
+func countOf(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("1 %s", noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/slack-infra/slack"
+	"sigs.k8s.io/slack-infra/slack/slacktest"
+)
+
+// newResponseServer returns a server to use as an interaction's response URL, and a channel of the
+// responses sent to it.
+func newResponseServer() (*httptest.Server, chan map[string]interface{}) {
+	responses := make(chan map[string]interface{}, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		response := map[string]interface{}{}
+		_ = json.NewDecoder(r.Body).Decode(&response)
+		responses <- response
+		_, _ = rw.Write([]byte(`{"ok": true}`))
+	}))
+	return server, responses
+}
+
+func pressRemovalButton(h *handler, user, action, id, responseURL string) *httptest.ResponseRecorder {
+	interaction := slackInteraction{Type: "interactive_message", CallbackID: "confirm_removal", ResponseURL: responseURL}
+	interaction.User.ID = user
+	interaction.Actions = append(interaction.Actions, struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}{Name: action, Value: id})
+	rw := httptest.NewRecorder()
+	h.handleRemovalAction(interaction, rw)
+	return rw
+}
+
+func TestRemovalPreview(t *testing.T) {
+	s := slacktest.NewServer()
+	defer s.Close()
+	responseServer, responses := newResponseServer()
+	defer responseServer.Close()
+	s.AddUser(slack.User{ID: "UMOD", IsAdmin: true})
+	s.AddUser(slack.User{ID: "USPAMMER", Name: "spammer"})
+	general := s.AddChannel(slack.Conversation{Name: "general"})
+	random := s.AddChannel(slack.Conversation{Name: "random"})
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	s.AddMessage(general, slack.Message{User: "USPAMMER", Text: "buy things", TS: now + ".000100"})
+	s.AddMessage(general, slack.Message{User: "USPAMMER", Text: "buy more things", TS: now + ".000200"})
+	s.AddMessage(random, slack.Message{User: "USPAMMER", Text: "buy things here too", TS: now + ".000300"})
+	s.AddFile(slacktest.File{ID: "F1", User: "USPAMMER", Created: time.Now().Unix(), Channels: []string{random}})
+	h := &handler{client: s.Client(), cache: newLookupCache(time.Minute), pending: newPendingRemovals(time.Minute)}
+
+	interaction := slackInteraction{Type: "dialog_submission", CallbackID: "moderate_user", ResponseURL: responseServer.URL, State: "USPAMMER"}
+	interaction.User.ID = "UMOD"
+	interaction.Submission = map[string]string{"remove_content": "1h"}
+	h.handleModerateSubmission(interaction)
+
+	if n := len(s.RequestsFor("chat.delete")) + len(s.RequestsFor("files.delete")); n != 0 {
+		t.Errorf("Expected nothing to be removed before confirmation, but got %d removals", n)
+	}
+	<-responses // "Please wait..."
+	response := <-responses
+	if text := response["text"].(string); !strings.Contains(text, "3 messages and 1 file") {
+		t.Errorf("Expected the response to mention 3 messages and 1 file, but got %q", text)
+	}
+	attachments := &strings.Builder{}
+	encoder := json.NewEncoder(attachments)
+	encoder.SetEscapeHTML(false)
+	_ = encoder.Encode(response["attachments"])
+	for _, expected := range []string{"<#" + general + ">: 2 messages, 0 files", "<#" + random + ">: 1 message, 1 file", "buy more things", `"callback_id":"confirm_removal"`} {
+		if !strings.Contains(attachments.String(), expected) {
+			t.Errorf("Expected the preview to contain %q, but got %s", expected, attachments)
+		}
+	}
+	if n := len(h.pending.removals); n != 1 {
+		t.Errorf("Expected one pending removal, but got %d", n)
+	}
+}
+
+func TestRemovalConfirmation(t *testing.T) {
+	defer func(d time.Duration) { recheckDelay = d }(recheckDelay)
+	recheckDelay = 0
+	s := slacktest.NewServer()
+	defer s.Close()
+	responseServer, _ := newResponseServer()
+	defer responseServer.Close()
+	jobs := newMemoryJobStore()
+	s.AddUser(slack.User{ID: "UMOD", IsAdmin: true})
+	s.AddUser(slack.User{ID: "UOTHERMOD", IsAdmin: true})
+	modChannel := s.AddChannel(slack.Conversation{Name: "moderation"})
+	general := s.AddChannel(slack.Conversation{Name: "general"})
+	s.AddMessage(general, slack.Message{User: "USPAMMER", Text: "buy things", TS: strconv.FormatInt(time.Now().Unix(), 10) + ".000100"})
+	h := &handler{client: s.Client(), cache: newLookupCache(time.Minute), pending: newPendingRemovals(time.Minute), jobs: jobs, modChannel: modChannel}
+	removal := pendingRemoval{moderator: "UMOD", targetUser: "USPAMMER", scope: removalScope{Since: time.Now().Add(-time.Hour), Replies: true, Files: true}}
+
+	cancelled, err := h.pending.add(removal)
+	if err != nil {
+		t.Fatalf("Failed to add pending removal: %v", err)
+	}
+	if body := pressRemovalButton(h, "UOTHERMOD", "cancel", cancelled, responseServer.URL).Body.String(); !strings.Contains(body, "previewed by someone else") {
+		t.Errorf("Expected other moderators not to be able to cancel the removal, but got %s", body)
+	}
+	if body := pressRemovalButton(h, "UMOD", "cancel", cancelled, responseServer.URL).Body.String(); !strings.Contains(body, "No content was removed") {
+		t.Errorf("Expected the removal to be cancelled, but got %s", body)
+	}
+	if body := pressRemovalButton(h, "UMOD", "confirm", cancelled, responseServer.URL).Body.String(); !strings.Contains(body, "already confirmed or cancelled") {
+		t.Errorf("Expected a cancelled removal not to be confirmable, but got %s", body)
+	}
+
+	confirmed, err := h.pending.add(removal)
+	if err != nil {
+		t.Fatalf("Failed to add pending removal: %v", err)
+	}
+	if body := pressRemovalButton(h, "UMOD", "confirm", confirmed, responseServer.URL).Body.String(); !strings.Contains(body, "Started removal job 1") {
+		t.Errorf("Expected a removal job to be started, but got %s", body)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		j, err := jobs.get(1)
+		if err != nil {
+			t.Fatalf("Failed to get job: %v", err)
+		}
+		if j.Status == jobDone {
+			break
+		}
+		if j.Status != jobRunning || time.Now().After(deadline) {
+			t.Fatalf("Expected the job to finish, but it is %s: %s", j.Status, j.Error)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if messages := s.Messages(general); len(messages) != 0 {
+		t.Errorf("Expected the message to be removed, but got %#v", messages)
+	}
+}
+
+func TestPendingRemovalsExpire(t *testing.T) {
+	now := time.Date(2019, 10, 7, 9, 0, 0, 0, time.UTC)
+	p := newPendingRemovals(time.Minute)
+	p.now = func() time.Time { return now }
+	id, err := p.add(pendingRemoval{moderator: "UMOD"})
+	if err != nil {
+		t.Fatalf("Failed to add pending removal: %v", err)
+	}
+	now = now.Add(2 * time.Minute)
+	if _, err := p.take(id, "UMOD"); err == nil {
+		t.Errorf("Expected an expired removal not to be taken")
+	}
+}
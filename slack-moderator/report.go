@@ -53,6 +53,23 @@ func (h *handler) handleReportMessage(interaction slackInteraction, rw http.Resp
 	} else {
 		elements = []interface{}{textArea, selectElement}
 	}
+	if h.cases != nil {
+		elements = append(elements, slack.SelectElement{
+			Name:  "follow_up",
+			Label: "Would you like to hear what happens?",
+			Options: []slack.SelectOption{
+				{
+					Label: "No",
+					Value: "no",
+				},
+				{
+					Label: "Yes, message me when it's been dealt with",
+					Value: "yes",
+				},
+			},
+			Value: "no",
+		})
+	}
 	state, err := json.Marshal(dialogState{
 		Sender:  interaction.Message.User,
 		TS:      interaction.Message.Timestamp,
@@ -129,26 +146,44 @@ func (h *handler) handleReportSubmission(interaction slackInteraction, rw http.R
 		log.Printf("Failed to look up sender: %v", err)
 	}
 
-	report := map[string]interface{}{
-		"text": summary,
-		"attachments": []map[string]interface{}{
-			{
-				"pretext":   "They said:",
-				"text":      message,
-				"mrkdwn_in": []string{"text"},
-				"fallback":  "They said: " + message,
-			},
-			{
-				"pretext":     fmt.Sprintf("The %s was:", messageLink),
-				"author_name": author,
-				"text":        state.Content,
-				"ts":          ts,
-				"mrkdwn_in":   []string{"text", "pretext", "author_name"},
-				"fallback":    fmt.Sprintf("The message they reported was: %s", state.Content),
-			},
+	attachments := []map[string]interface{}{
+		{
+			"pretext":   "They said:",
+			"text":      message,
+			"mrkdwn_in": []string{"text"},
+			"fallback":  "They said: " + message,
+		},
+		{
+			"pretext":     fmt.Sprintf("The %s was:", messageLink),
+			"author_name": author,
+			"text":        state.Content,
+			"ts":          ts,
+			"mrkdwn_in":   []string{"text", "pretext", "author_name"},
+			"fallback":    fmt.Sprintf("The message they reported was: %s", state.Content),
 		},
 	}
-	if err := h.client.CallMethod(h.client.Config.WebhookURL, report, nil); err != nil {
+	report := map[string]interface{}{
+		"text":        summary,
+		"attachments": attachments,
+	}
+	if h.cases != nil {
+		c := &reportCase{
+			Sender:      state.Sender,
+			Channel:     interaction.Channel.ID,
+			Anonymous:   anonymous,
+			FollowUp:    interaction.Submission["follow_up"] == "yes",
+			Summary:     summary,
+			Attachments: attachments,
+		}
+		// Only keep track of anonymous reporters if we need to tell them how it went.
+		if !anonymous || c.FollowUp {
+			c.Reporter = interaction.User.ID
+		}
+		if err := h.fileCase(c); err != nil {
+			logError(rw, "Failed to file report: %v.", err)
+			return
+		}
+	} else if err := h.client.CallMethod(h.client.Config.WebhookURL, report, nil); err != nil {
 		logError(rw, "Failed to send report: %v.", err)
 		return
 	}
@@ -0,0 +1,285 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// removalTimeLayout is how moderators write the start and end of a custom removal window, in UTC.
+const removalTimeLayout = "2006-01-02 15:04"
+
+// removalScope is what a content removal covers.
+type removalScope struct {
+	Since time.Time `json:"since"`
+	// Until is the end of the window. If it is zero, the window runs up to the present, but
+	// confirmed removals always have one; see pin.
+	Until time.Time `json:"until,omitempty"`
+	// Channels and Threads restrict removal to content in those channels and threads. If both are
+	// empty, content is removed from everywhere.
+	Channels []string    `json:"channels,omitempty"`
+	Threads  []threadRef `json:"threads,omitempty"`
+	// Replies is whether to remove thread replies.
+	Replies bool `json:"replies"`
+	// Files is whether to remove files.
+	Files bool `json:"files"`
+}
+
+// threadRef identifies a thread by its channel and the timestamp of its first message.
+type threadRef struct {
+	Channel string `json:"channel"`
+	TS      string `json:"ts"`
+}
+
+// pin returns s, ending at now if it ran up to the present. Removals are pinned when they are
+// confirmed, so that a job that is resumed, retried or approved later doesn't remove anything
+// posted after the moderator confirmed it, and its window doesn't keep growing.
+func (s removalScope) pin(now time.Time) removalScope {
+	if s.Until.IsZero() {
+		s.Until = now
+	}
+	return s
+}
+
+func (s removalScope) restricted() bool {
+	return len(s.Channels) > 0 || len(s.Threads) > 0
+}
+
+// includesTime returns whether t is in the removal window.
+func (s removalScope) includesTime(t time.Time) bool {
+	return !t.Before(s.Since) && (s.Until.IsZero() || !t.After(s.Until))
+}
+
+// includesMessage returns whether the message with the given channel, timestamp and thread
+// timestamp is covered, ignoring when it was posted.
+func (s removalScope) includesMessage(channel, ts, threadTS string) bool {
+	if threadTS != "" && threadTS != ts && !s.Replies {
+		return false
+	}
+	if !s.restricted() {
+		return true
+	}
+	for _, c := range s.Channels {
+		if c == channel {
+			return true
+		}
+	}
+	for _, t := range s.Threads {
+		if t.Channel == channel && (t.TS == ts || t.TS == threadTS) {
+			return true
+		}
+	}
+	return false
+}
+
+// includesFile returns whether a file shared in the given channels is covered, ignoring when it
+// was created. Search doesn't tell us which threads files are in, so restricting removal to threads
+// alone excludes every file.
+func (s removalScope) includesFile(channels []string) bool {
+	if !s.Files {
+		return false
+	}
+	if !s.restricted() {
+		return true
+	}
+	for _, c := range channels {
+		for _, v := range s.Channels {
+			if c == v {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// length returns how long the removal window is, if it is now.
+func (s removalScope) length(now time.Time) time.Duration {
+	end := now
+	if !s.Until.IsZero() {
+		end = s.Until
+	}
+	return end.Sub(s.Since)
+}
+
+// describe returns a human-readable summary of the scope, for showing to moderators.
+func (s removalScope) describe() string {
+	until := "now"
+	if !s.Until.IsZero() {
+		until = s.Until.UTC().Format(removalTimeLayout) + " UTC"
+	}
+	parts := []string{fmt.Sprintf("from %s UTC until %s", s.Since.UTC().Format(removalTimeLayout), until)}
+	var where []string
+	for _, c := range s.Channels {
+		where = append(where, fmt.Sprintf("<#%s>", c))
+	}
+	for _, t := range s.Threads {
+		where = append(where, fmt.Sprintf("a thread in <#%s>", t.Channel))
+	}
+	if len(where) > 0 {
+		parts = append(parts, "in "+strings.Join(where, ", "))
+	}
+	if !s.Replies {
+		parts = append(parts, "excluding thread replies")
+	}
+	if !s.Files {
+		parts = append(parts, "excluding files")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// removalDurations are the removal windows moderators can pick from, as long as they're within
+// their limit.
+var removalDurations = []struct {
+	label    string
+	duration time.Duration
+}{
+	{"10 minutes", 10 * time.Minute},
+	{"1 hour", time.Hour},
+	{"6 hours", 6 * time.Hour},
+	{"12 hours", 12 * time.Hour},
+	{"24 hours", 24 * time.Hour},
+	{"48 hours", 48 * time.Hour},
+	{"72 hours", 72 * time.Hour},
+	{"7 days", 7 * 24 * time.Hour},
+	{"30 days", 30 * 24 * time.Hour},
+}
+
+// removalLimit returns the longest window the user with the given ID may remove content from.
+// Workspace admins and owners may be allowed more than everyone else.
+func (h *handler) removalLimit(id string) (time.Duration, error) {
+	if h.adminMaxRemoval <= maxRemovalDuration {
+		return maxRemovalDuration, nil
+	}
+	user, err := h.getUserInfo(id)
+	if err != nil {
+		return 0, err
+	}
+	if user.IsAdmin || user.IsOwner || user.IsPrimaryOwner {
+		return h.adminMaxRemoval, nil
+	}
+	return maxRemovalDuration, nil
+}
+
+var channelIDPattern = regexp.MustCompile(`^[CG][A-Z0-9]{6,}$`)
+
+// parseRemovalScope parses the content removal part of a moderate_user dialog submission. The
+// window may be no longer than limit.
+func (h *handler) parseRemovalScope(submission map[string]string, now time.Time, limit time.Duration) (removalScope, error) {
+	scope := removalScope{
+		Replies: submission["remove_replies"] != "no",
+		Files:   submission["remove_files"] != "no",
+	}
+	if remove := submission["remove_content"]; remove == "custom" {
+		var err error
+		scope.Since, err = time.Parse(removalTimeLayout, strings.TrimSpace(submission["remove_from"]))
+		if err != nil {
+			return scope, fmt.Errorf("couldn't parse the start of the removal window, which should look like %q", removalTimeLayout)
+		}
+		if until := strings.TrimSpace(submission["remove_until"]); until != "" {
+			scope.Until, err = time.Parse(removalTimeLayout, until)
+			if err != nil {
+				return scope, fmt.Errorf("couldn't parse the end of the removal window, which should look like %q", removalTimeLayout)
+			}
+			if scope.Until.Before(scope.Since) {
+				return scope, fmt.Errorf("the removal window ends before it starts")
+			}
+		}
+	} else {
+		duration, err := time.ParseDuration(remove)
+		if err != nil {
+			return scope, fmt.Errorf("couldn't parse removal duration: %v", err)
+		}
+		scope.Since = now.Add(-duration)
+	}
+	if length := scope.length(now); length > limit {
+		return scope, fmt.Errorf("unacceptably long content removal window: %s (you may remove at most %s)", length, limit)
+	}
+
+	var names []string
+	for _, c := range strings.FieldsFunc(submission["remove_channels"], isListSeparator) {
+		c = strings.TrimPrefix(c, "#")
+		if channelIDPattern.MatchString(c) {
+			scope.Channels = append(scope.Channels, c)
+		} else {
+			names = append(names, c)
+		}
+	}
+	if len(names) > 0 {
+		ids, err := h.channelIDs(names)
+		if err != nil {
+			return scope, err
+		}
+		scope.Channels = append(scope.Channels, ids...)
+	}
+	for _, link := range strings.FieldsFunc(submission["remove_threads"], isListSeparator) {
+		t, err := parseThreadLink(link)
+		if err != nil {
+			return scope, err
+		}
+		scope.Threads = append(scope.Threads, t)
+	}
+	return scope, nil
+}
+
+func isListSeparator(r rune) bool {
+	return r == ',' || r == ' ' || r == '\n' || r == '\t'
+}
+
+// channelIDs returns the IDs of the public channels with the given names.
+func (h *handler) channelIDs(names []string) ([]string, error) {
+	channels, err := h.client.GetPublicChannels()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't look up channels: %v", err)
+	}
+	byName := map[string]string{}
+	for _, c := range channels {
+		byName[c.Name] = c.ID
+	}
+	var ids []string
+	for _, n := range names {
+		id, ok := byName[n]
+		if !ok {
+			return nil, fmt.Errorf("couldn't find a channel called #%s", n)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+var messageLinkPattern = regexp.MustCompile(`/archives/([A-Z0-9]+)/p(\d{10})(\d{6})$`)
+
+// parseThreadLink parses a link to a message, as produced by "Copy link", into the thread that
+// message is in.
+func parseThreadLink(link string) (threadRef, error) {
+	u, err := url.Parse(strings.Trim(link, "<>"))
+	if err != nil {
+		return threadRef{}, fmt.Errorf("couldn't parse message link %q: %v", link, err)
+	}
+	m := messageLinkPattern.FindStringSubmatch(u.Path)
+	if m == nil {
+		return threadRef{}, fmt.Errorf("%q doesn't look like a link to a message", link)
+	}
+	t := threadRef{Channel: m[1], TS: m[2] + "." + m[3]}
+	if threadTS := u.Query().Get("thread_ts"); threadTS != "" {
+		t.TS = threadTS
+	}
+	return t, nil
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/slack-infra/slack"
+	"sigs.k8s.io/slack-infra/slack/slacktest"
+)
+
+func TestParseRemovalScope(t *testing.T) {
+	s := slacktest.NewServer()
+	defer s.Close()
+	general := s.AddChannel(slack.Conversation{Name: "general"})
+	h := &handler{client: s.Client()}
+	now := time.Date(2019, 10, 7, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		submission map[string]string
+		limit      time.Duration
+		expected   removalScope
+		expectErr  bool
+	}{
+		{
+			name:       "a duration removes everything since then",
+			submission: map[string]string{"remove_content": "1h"},
+			limit:      maxRemovalDuration,
+			expected:   removalScope{Since: now.Add(-time.Hour), Replies: true, Files: true},
+		},
+		{
+			name:       "durations longer than the limit are rejected",
+			submission: map[string]string{"remove_content": "72h"},
+			limit:      maxRemovalDuration,
+			expectErr:  true,
+		},
+		{
+			name:       "a custom window has a start and end",
+			submission: map[string]string{"remove_content": "custom", "remove_from": "2019-10-01 12:00", "remove_until": "2019-10-02 12:00"},
+			limit:      maxRemovalDuration,
+			expected:   removalScope{Since: time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC), Until: time.Date(2019, 10, 2, 12, 0, 0, 0, time.UTC), Replies: true, Files: true},
+		},
+		{
+			name:       "a custom window without an end runs up to now, and is limited",
+			submission: map[string]string{"remove_content": "custom", "remove_from": "2019-10-01 12:00"},
+			limit:      maxRemovalDuration,
+			expectErr:  true,
+		},
+		{
+			name:       "a higher limit allows longer windows",
+			submission: map[string]string{"remove_content": "custom", "remove_from": "2019-10-01 12:00"},
+			limit:      7 * 24 * time.Hour,
+			expected:   removalScope{Since: time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC), Replies: true, Files: true},
+		},
+		{
+			name:       "windows that end before they start are rejected",
+			submission: map[string]string{"remove_content": "custom", "remove_from": "2019-10-02 12:00", "remove_until": "2019-10-01 12:00"},
+			limit:      maxRemovalDuration,
+			expectErr:  true,
+		},
+		{
+			name: "channels, threads, replies and files can be restricted",
+			submission: map[string]string{
+				"remove_content":  "1h",
+				"remove_channels": "#general, C12345678",
+				"remove_threads":  "https://example.slack.com/archives/C87654321/p1570438800000100\nhttps://example.slack.com/archives/C87654321/p1570438900000200?thread_ts=1570438850.000300&cid=C87654321",
+				"remove_replies":  "no",
+				"remove_files":    "no",
+			},
+			limit: maxRemovalDuration,
+			expected: removalScope{
+				Since:    now.Add(-time.Hour),
+				Channels: []string{"C12345678", general},
+				Threads:  []threadRef{{Channel: "C87654321", TS: "1570438800.000100"}, {Channel: "C87654321", TS: "1570438850.000300"}},
+			},
+		},
+		{
+			name:       "unknown channels are rejected",
+			submission: map[string]string{"remove_content": "1h", "remove_channels": "nowhere"},
+			limit:      maxRemovalDuration,
+			expectErr:  true,
+		},
+		{
+			name:       "things that aren't message links are rejected",
+			submission: map[string]string{"remove_content": "1h", "remove_threads": "https://example.com"},
+			limit:      maxRemovalDuration,
+			expectErr:  true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			scope, err := h.parseRemovalScope(tc.submission, now, tc.limit)
+			if tc.expectErr {
+				if err == nil {
+					t.Errorf("Expected an error, but got %#v", scope)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(scope, tc.expected) {
+				t.Errorf("Expected scope %#v, but got %#v", tc.expected, scope)
+			}
+		})
+	}
+}
+
+func TestRemovalLimit(t *testing.T) {
+	s := slacktest.NewServer()
+	defer s.Close()
+	s.AddUser(slack.User{ID: "UADMIN", IsAdmin: true})
+	s.AddUser(slack.User{ID: "UMOD"})
+	h := &handler{client: s.Client(), cache: newLookupCache(time.Minute), adminMaxRemoval: 7 * 24 * time.Hour}
+
+	if limit, err := h.removalLimit("UADMIN"); err != nil || limit != 7*24*time.Hour {
+		t.Errorf("Expected admins to be allowed 168h, but got %s (%v)", limit, err)
+	}
+	if limit, err := h.removalLimit("UMOD"); err != nil || limit != maxRemovalDuration {
+		t.Errorf("Expected other moderators to be allowed %s, but got %s (%v)", maxRemovalDuration, limit, err)
+	}
+}
+
+func TestScopedSearch(t *testing.T) {
+	s := slacktest.NewServer()
+	defer s.Close()
+	general := s.AddChannel(slack.Conversation{Name: "general"})
+	random := s.AddChannel(slack.Conversation{Name: "random"})
+	base := time.Now().Add(-time.Hour).Unix()
+	ts := func(offset int64) string { return strconv.FormatInt(base+offset, 10) + ".000100" }
+	s.AddMessage(general, slack.Message{User: "USPAMMER", Text: "early", TS: ts(0)})
+	s.AddMessage(general, slack.Message{User: "USPAMMER", Text: "parent", TS: ts(60)})
+	s.AddMessage(general, slack.Message{User: "USPAMMER", Text: "reply", TS: ts(120), ThreadTS: ts(60)})
+	s.AddMessage(random, slack.Message{User: "USPAMMER", Text: "elsewhere", TS: ts(180)})
+	s.AddFile(slacktest.File{ID: "F1", User: "USPAMMER", Created: base + 60, Channels: []string{general}})
+	s.AddFile(slacktest.File{ID: "F2", User: "USPAMMER", Created: base + 60, Channels: []string{random}})
+	h := &handler{client: s.Client()}
+	since := time.Unix(base-60, 0)
+
+	tests := []struct {
+		name             string
+		scope            removalScope
+		expectedMessages []string
+		expectedFiles    []string
+	}{
+		{
+			name:             "everything",
+			scope:            removalScope{Since: since, Replies: true, Files: true},
+			expectedMessages: []string{"early", "elsewhere", "parent", "reply"},
+			expectedFiles:    []string{"F1", "F2"},
+		},
+		{
+			name:             "a window",
+			scope:            removalScope{Since: time.Unix(base+30, 0), Until: time.Unix(base+150, 0), Replies: true, Files: true},
+			expectedMessages: []string{"parent", "reply"},
+			expectedFiles:    []string{"F1", "F2"},
+		},
+		{
+			name:             "a channel",
+			scope:            removalScope{Since: since, Channels: []string{random}, Replies: true, Files: true},
+			expectedMessages: []string{"elsewhere"},
+			expectedFiles:    []string{"F2"},
+		},
+		{
+			name:             "a thread",
+			scope:            removalScope{Since: since, Threads: []threadRef{{Channel: general, TS: ts(60)}}, Replies: true, Files: true},
+			expectedMessages: []string{"parent", "reply"},
+		},
+		{
+			name:             "no replies or files",
+			scope:            removalScope{Since: since},
+			expectedMessages: []string{"early", "elsewhere", "parent"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			files, messages, err := h.findUserContent("USPAMMER", tc.scope)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			var gotMessages, gotFiles []string
+			for _, m := range messages {
+				gotMessages = append(gotMessages, m.text)
+			}
+			for _, f := range files {
+				gotFiles = append(gotFiles, f.id)
+			}
+			sort.Strings(gotMessages)
+			sort.Strings(gotFiles)
+			if !reflect.DeepEqual(gotMessages, tc.expectedMessages) {
+				t.Errorf("Expected messages %v, but got %v", tc.expectedMessages, gotMessages)
+			}
+			if !reflect.DeepEqual(gotFiles, tc.expectedFiles) {
+				t.Errorf("Expected files %v, but got %v", tc.expectedFiles, gotFiles)
+			}
+		})
+	}
+}
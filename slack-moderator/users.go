@@ -17,11 +17,11 @@ limitations under the License.
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
+	"net/url"
+	"regexp"
 
 	"sigs.k8s.io/slack-infra/slack"
 )
@@ -35,6 +35,9 @@ func (h *handler) getDisplayName(id string) (string, error) {
 }
 
 func (h *handler) getUserInfo(id string) (slack.User, error) {
+	if u, ok := h.cache.user(id); ok {
+		return u, nil
+	}
 	user := struct {
 		User slack.User `json:"user"`
 	}{}
@@ -42,34 +45,49 @@ func (h *handler) getUserInfo(id string) (slack.User, error) {
 	if err := h.client.CallOldMethod("users.info", map[string]string{"user": id}, &user); err != nil {
 		return slack.User{}, fmt.Errorf("failed get user: %v", err)
 	}
+	h.cache.setUser(user.User)
 	return user.User, nil
 }
 
-func (h *handler) userHasModerationPowers(id string) (bool, error) {
-	user, err := h.getUserInfo(id)
-	if err != nil {
-		log.Printf("Failed to look up moderation powers: %v\n", err)
-		return false, err
+// setUserActive deactivates or reactivates the user with the given ID through SCIM.
+func (h *handler) setUserActive(targetUser string, active bool) error {
+	if h.scim == nil {
+		return errors.New("no adminToken is configured")
 	}
-	return user.IsAdmin || user.IsOwner || user.IsPrimaryOwner, nil
+	if _, err := h.scim.SetUserActive(targetUser, active); err != nil {
+		if e, ok := err.(slack.ErrSCIM); ok && e.NotFound() {
+			return errors.New("no such user")
+		}
+		return err
+	}
+	return nil
 }
 
-func (h *handler) deactivateUser(interaction slackInteraction, targetUser string) error {
-	result := struct {
-		Ok    bool   `json:"ok"`
-		Error string `json:"error,omitempty"`
-	}{}
+var userMentionPattern = regexp.MustCompile(`^<@([UW][A-Z0-9]+)(\|[^>]*)?>$|^([UW][A-Z0-9]{6,})$`)
 
-	resp, err := http.Post("https://slack.com/api/users.admin.setInactive", "application/x-www-form-urlencoded", bytes.NewBufferString("token="+h.adminToken+"&user="+targetUser))
-	if err != nil {
-		return fmt.Errorf("couldn't deactivate user %s: %v", targetUser, err)
+// parseUserRef returns the ID of the user mentioned in s, which may also be a bare user ID.
+func parseUserRef(s string) (string, bool) {
+	m := userMentionPattern.FindStringSubmatch(s)
+	if m == nil {
+		return "", false
+	}
+	return m[1] + m[3], true
+}
+
+// handleReactivate handles `/mod reactivate <user>`, where the user is a mention or an ID.
+func (h *handler) handleReactivate(f url.Values, user string, rw http.ResponseWriter) {
+	if permissions, err := h.permissions(f.Get("user_id")); err != nil || !hasPermission(permissions, permissionDeactivate) {
+		respondEphemeral(rw, "Only moderators who can deactivate users can reactivate them.")
+		return
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return fmt.Errorf("failed to decode json: %v", err)
+	targetUser, ok := parseUserRef(user)
+	if !ok {
+		respondEphemeral(rw, fmt.Sprintf("%q doesn't look like a user. Mention them, or give their ID.", user))
+		return
 	}
-	if !result.Ok {
-		return fmt.Errorf("couldn't update membership: %s", result.Error)
+	if err := h.reactivateUser(f.Get("user_id"), targetUser); err != nil {
+		respondEphemeral(rw, fmt.Sprintf("Failed to reactivate <@%s>: %v", targetUser, err))
+		return
 	}
-
-	return nil
+	respondEphemeral(rw, fmt.Sprintf("Reactivated <@%s>.", targetUser))
 }
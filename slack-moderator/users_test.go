@@ -0,0 +1,76 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/slack-infra/slack"
+	"sigs.k8s.io/slack-infra/slack/slacktest"
+)
+
+func TestDeactivateAndReactivate(t *testing.T) {
+	s := slacktest.NewServer()
+	defer s.Close()
+	s.AddUser(slack.User{ID: "UMOD", IsAdmin: true})
+	s.AddUser(slack.User{ID: "UNOBODY"})
+	s.AddUser(slack.User{ID: "USPAMMER"})
+	h := &handler{client: s.Client(), scim: s.SCIMClient(), cache: newLookupCache(time.Minute)}
+
+	if err := h.setUserActive("USPAMMER", false); err != nil {
+		t.Fatalf("Failed to deactivate user: %v", err)
+	}
+	if u, _ := s.User("USPAMMER"); !u.Deleted {
+		t.Errorf("Expected USPAMMER to be deactivated")
+	}
+	if err := h.setUserActive("UMISSING", false); err == nil || err.Error() != "no such user" {
+		t.Errorf("Expected deactivating an unknown user to fail, but got %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		user     string
+		text     string
+		expected string
+		active   bool
+	}{
+		{name: "non-moderators can't reactivate", user: "UNOBODY", text: "reactivate <@USPAMMER|spammer>", expected: "Only moderators"},
+		{name: "names aren't understood", user: "UMOD", text: "reactivate spammer", expected: "doesn't look like a user"},
+		{name: "mentions are understood", user: "UMOD", text: "reactivate <@USPAMMER|spammer>", expected: "Reactivated", active: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			h.handleModCommand(url.Values{"command": {"/mod"}, "user_id": {tc.user}, "text": {tc.text}}, rw)
+			if body := rw.Body.String(); !strings.Contains(body, tc.expected) {
+				t.Errorf("Expected a response containing %q, but got %s", tc.expected, body)
+			}
+			if u, _ := s.User("USPAMMER"); u.Deleted == tc.active {
+				t.Errorf("Expected USPAMMER to be active: %t, but got %t", tc.active, !u.Deleted)
+			}
+		})
+	}
+
+	h.scim = nil
+	if err := h.setUserActive("USPAMMER", false); err == nil {
+		t.Errorf("Expected deactivation to fail without an admin token")
+	}
+}
@@ -80,3 +80,146 @@ func (c *Client) GetConversations(types []ConversationType) ([]Conversation, err
 func (c *Client) GetPublicChannels() ([]Conversation, error) {
 	return c.GetConversations([]ConversationType{ConversationTypePublicChannel})
 }
+
+// GetConversationMembers returns the IDs of every member of the given conversation.
+func (c *Client) GetConversationMembers(channel string) ([]string, error) {
+	var members []string
+	cursor := ""
+	for {
+		args := map[string]string{
+			"channel": channel,
+			"limit":   "1000",
+		}
+		if cursor != "" {
+			args["cursor"] = cursor
+		}
+
+		ret := struct {
+			Members  []string `json:"members"`
+			Metadata struct {
+				NextCursor string `json:"next_cursor"`
+			} `json:"response_metadata"`
+		}{}
+
+		for {
+			if err := c.CallOldMethod("conversations.members", args, &ret); err != nil {
+				switch e := err.(type) {
+				case ErrRateLimit:
+					time.Sleep(e.Wait)
+					continue
+				default:
+					return nil, fmt.Errorf("failed to list members of %s: %v", channel, err)
+				}
+			}
+			break
+		}
+
+		members = append(members, ret.Members...)
+		if ret.Metadata.NextCursor == "" {
+			break
+		}
+		cursor = ret.Metadata.NextCursor
+	}
+	return members, nil
+}
+
+// GetBookmarks returns the bookmarks in the given channel.
+func (c *Client) GetBookmarks(channel string) ([]Bookmark, error) {
+	ret := struct {
+		Bookmarks []Bookmark `json:"bookmarks"`
+	}{}
+	for {
+		if err := c.CallOldMethod("bookmarks.list", map[string]string{"channel_id": channel}, &ret); err != nil {
+			switch e := err.(type) {
+			case ErrRateLimit:
+				time.Sleep(e.Wait)
+				continue
+			default:
+				return nil, fmt.Errorf("failed to list bookmarks in %s: %v", channel, err)
+			}
+		}
+		return ret.Bookmarks, nil
+	}
+}
+
+// GetConversationInfo returns the given conversation.
+func (c *Client) GetConversationInfo(channel string) (Conversation, error) {
+	ret := struct {
+		Channel Conversation `json:"channel"`
+	}{}
+	for {
+		if err := c.CallOldMethod("conversations.info", map[string]string{"channel": channel}, &ret); err != nil {
+			switch e := err.(type) {
+			case ErrRateLimit:
+				time.Sleep(e.Wait)
+				continue
+			default:
+				return Conversation{}, fmt.Errorf("failed to get info for %s: %v", channel, err)
+			}
+		}
+		return ret.Channel, nil
+	}
+}
+
+// GetConversationHistory calls f with each message in the given conversation, newest first,
+// until f returns false or there are no more messages. Thread replies are not included.
+func (c *Client) GetConversationHistory(channel string, f func(Message) bool) error {
+	cursor := ""
+	for {
+		args := map[string]string{
+			"channel": channel,
+			"limit":   "200",
+		}
+		if cursor != "" {
+			args["cursor"] = cursor
+		}
+
+		ret := struct {
+			Messages []Message `json:"messages"`
+			Metadata struct {
+				NextCursor string `json:"next_cursor"`
+			} `json:"response_metadata"`
+		}{}
+
+		for {
+			if err := c.CallOldMethod("conversations.history", args, &ret); err != nil {
+				switch e := err.(type) {
+				case ErrRateLimit:
+					time.Sleep(e.Wait)
+					continue
+				default:
+					return fmt.Errorf("failed to get history of %s: %v", channel, err)
+				}
+			}
+			break
+		}
+
+		for _, m := range ret.Messages {
+			if !f(m) {
+				return nil
+			}
+		}
+		if ret.Metadata.NextCursor == "" {
+			return nil
+		}
+		cursor = ret.Metadata.NextCursor
+	}
+}
+
+// AuthTestResponse describes the identity of the token a Client uses.
+type AuthTestResponse struct {
+	URL    string `json:"url"`
+	Team   string `json:"team"`
+	User   string `json:"user"`
+	TeamID string `json:"team_id"`
+	UserID string `json:"user_id"`
+}
+
+// AuthTest returns the identity of the token the Client uses.
+func (c *Client) AuthTest() (AuthTestResponse, error) {
+	var ret AuthTestResponse
+	if err := c.CallOldMethod("auth.test", map[string]string{}, &ret); err != nil {
+		return AuthTestResponse{}, fmt.Errorf("failed to test auth: %v", err)
+	}
+	return ret, nil
+}
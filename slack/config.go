@@ -27,6 +27,8 @@ type Config struct {
 	SigningSecret string `json:"signingSecret"`
 	WebhookURL    string `json:"webhook"`
 	AccessToken   string `json:"accessToken"`
+	// APIURL is the root of the Web API, which defaults to https://slack.com/api/.
+	APIURL string `json:"apiURL,omitempty"`
 }
 
 // LoadConfig loads a Config from a JSON file.
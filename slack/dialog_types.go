@@ -53,6 +53,25 @@ func (textAreaType) MarshalJSON() ([]byte, error) {
 	return json.Marshal("textarea")
 }
 
+// TextElement represents a single-line text input
+type TextElement struct {
+	Type        textElementType `json:"type"`
+	Label       string          `json:"label"`
+	Name        string          `json:"name"`
+	Placeholder string          `json:"placeholder,omitempty"`
+	MaxLength   int             `json:"max_length,omitempty"`
+	MinLength   int             `json:"min_length,omitempty"`
+	Optional    bool            `json:"optional,omitempty"`
+	Hint        string          `json:"hint,omitempty"`
+	Subtype     string          `json:"subtype,omitempty"`
+	Value       string          `json:"value,omitempty"`
+}
+type textElementType string
+
+func (textElementType) MarshalJSON() ([]byte, error) {
+	return json.Marshal("text")
+}
+
 // SelectElement represents a SelectElement
 type SelectElement struct {
 	Label           string         `json:"label"`
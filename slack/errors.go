@@ -18,6 +18,7 @@ package slack
 
 import (
 	"fmt"
+	"net/http"
 	"time"
 )
 
@@ -37,3 +38,22 @@ type ErrSlack struct {
 func (e ErrSlack) Error() string {
 	return fmt.Sprintf("slack call failed: %s (%v)", e.Type, e.Warnings)
 }
+
+// ErrSCIM is returned when Slack rejects a SCIM request.
+type ErrSCIM struct {
+	// Method and Path are the HTTP method and resource of the request, such as "PATCH" and
+	// "Users/U0123ABCD".
+	Method     string
+	Path       string
+	StatusCode int
+	Detail     string
+}
+
+func (e ErrSCIM) Error() string {
+	return fmt.Sprintf("SCIM %s %s failed with status %d: %s", e.Method, e.Path, e.StatusCode, e.Detail)
+}
+
+// NotFound returns whether the request failed because the user or group doesn't exist.
+func (e ErrSCIM) NotFound() bool {
+	return e.StatusCode == http.StatusNotFound
+}
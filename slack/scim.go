@@ -0,0 +1,226 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package slack
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SCIMUserSchema  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SCIMGroupSchema = "urn:ietf:params:scim:schemas:core:2.0:Group"
+)
+
+// SCIMConfig is the information needed to use Slack's SCIM API, which manages users and groups on
+// paid Slack teams.
+type SCIMConfig struct {
+	// Token is a token with the admin scope, installed by an Admin or Owner.
+	Token string `json:"token"`
+	// URL is the root of the SCIM API, which defaults to https://api.slack.com/scim/v2/.
+	URL string `json:"url,omitempty"`
+}
+
+// SCIMClient has methods for interacting with Slack's SCIM API. Requests that Slack rejects fail
+// with an ErrSCIM.
+type SCIMClient struct {
+	Config SCIMConfig
+}
+
+// NewSCIM returns a new SCIMClient.
+func NewSCIM(config SCIMConfig) *SCIMClient {
+	return &SCIMClient{Config: config}
+}
+
+// SCIMValue is an entry in one of SCIM's multi-valued attributes, such as a user's emails or a
+// group's members.
+type SCIMValue struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+	// Operation is "delete" to remove the value when patching. Anything else adds it.
+	Operation string `json:"operation,omitempty"`
+}
+
+type SCIMUser struct {
+	Schemas     []string    `json:"schemas,omitempty"`
+	ID          string      `json:"id"`
+	UserName    string      `json:"userName"`
+	DisplayName string      `json:"displayName,omitempty"`
+	Active      bool        `json:"active"`
+	Emails      []SCIMValue `json:"emails,omitempty"`
+	Groups      []SCIMValue `json:"groups,omitempty"`
+}
+
+type SCIMGroup struct {
+	Schemas     []string    `json:"schemas,omitempty"`
+	ID          string      `json:"id"`
+	DisplayName string      `json:"displayName"`
+	Members     []SCIMValue `json:"members,omitempty"`
+}
+
+// GetUser returns the user with the given ID.
+func (c *SCIMClient) GetUser(id string) (SCIMUser, error) {
+	var user SCIMUser
+	err := c.call(http.MethodGet, "Users/"+url.PathEscape(id), nil, &user)
+	return user, err
+}
+
+// SetUserActive activates or deactivates the user with the given ID, and returns the updated user.
+// Deactivated users are signed out everywhere and can no longer sign in, but keep their content.
+func (c *SCIMClient) SetUserActive(id string, active bool) (SCIMUser, error) {
+	patch := map[string]interface{}{
+		"schemas": []string{SCIMUserSchema},
+		"active":  active,
+	}
+	var user SCIMUser
+	err := c.call(http.MethodPatch, "Users/"+url.PathEscape(id), patch, &user)
+	return user, err
+}
+
+// DeleteUser deactivates the user with the given ID. Slack never really deletes users, so this is
+// the same as setting them inactive, except that it also removes them from every group.
+func (c *SCIMClient) DeleteUser(id string) error {
+	return c.call(http.MethodDelete, "Users/"+url.PathEscape(id), nil, nil)
+}
+
+// GetGroup returns the group with the given ID, including its members.
+func (c *SCIMClient) GetGroup(id string) (SCIMGroup, error) {
+	var group SCIMGroup
+	err := c.call(http.MethodGet, "Groups/"+url.PathEscape(id), nil, &group)
+	return group, err
+}
+
+// AddGroupMembers adds the users with the given IDs to the group with the given ID.
+func (c *SCIMClient) AddGroupMembers(group string, users ...string) error {
+	return c.patchGroupMembers(group, users, "")
+}
+
+// RemoveGroupMembers removes the users with the given IDs from the group with the given ID.
+func (c *SCIMClient) RemoveGroupMembers(group string, users ...string) error {
+	return c.patchGroupMembers(group, users, "delete")
+}
+
+func (c *SCIMClient) patchGroupMembers(group string, users []string, operation string) error {
+	members := make([]SCIMValue, 0, len(users))
+	for _, u := range users {
+		members = append(members, SCIMValue{Value: u, Operation: operation})
+	}
+	patch := map[string]interface{}{
+		"schemas": []string{SCIMGroupSchema},
+		"members": members,
+	}
+	return c.call(http.MethodPatch, "Groups/"+url.PathEscape(group), patch, nil)
+}
+
+func (c *SCIMClient) resourceURL(path string) string {
+	base := c.Config.URL
+	if base == "" {
+		base = "https://api.slack.com/scim/v2/"
+	}
+	return strings.TrimSuffix(base, "/") + "/" + path
+}
+
+// call makes a SCIM request, waiting out any rate limits, and decodes the response into ret if it
+// is non-nil.
+func (c *SCIMClient) call(method, path string, args interface{}, ret interface{}) error {
+	var body []byte
+	if args != nil {
+		var err error
+		body, err = json.Marshal(args)
+		if err != nil {
+			return fmt.Errorf("failed to marshal SCIM request: %v", err)
+		}
+	}
+	for {
+		err := c.do(method, path, body, ret)
+		if e, ok := err.(ErrRateLimit); ok {
+			time.Sleep(e.Wait)
+			continue
+		}
+		return err
+	}
+}
+
+func (c *SCIMClient) do(method, path string, body []byte, ret interface{}) error {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, c.resourceURL(path), r)
+	if err != nil {
+		return fmt.Errorf("failed to create HTTP request: %v", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.Config.Token)
+	response, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make SCIM request: %v", err)
+	}
+	defer response.Body.Close()
+	content, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read body: %v", err)
+	}
+
+	if response.StatusCode == http.StatusTooManyRequests {
+		retryAfter, err := strconv.ParseInt(response.Header.Get("Retry-After"), 10, 64)
+		if err != nil {
+			return fmt.Errorf("slack has rate limited us for %q seconds, but we can't parse that", response.Header.Get("Retry-After"))
+		}
+		return ErrRateLimit{Wait: time.Duration(retryAfter) * time.Second}
+	}
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return parseSCIMError(method, path, response.StatusCode, content)
+	}
+	if ret != nil && len(content) > 0 {
+		if err := json.Unmarshal(content, ret); err != nil {
+			return fmt.Errorf("SCIM call succeeded, but failed to unmarshal result: %v", err)
+		}
+	}
+	return nil
+}
+
+// parseSCIMError turns a failed response into an ErrSCIM. Slack describes errors in its own
+// format, but we also understand the standard one.
+func parseSCIMError(method, path string, status int, content []byte) error {
+	result := struct {
+		Errors struct {
+			Description string `json:"description"`
+		} `json:"Errors"`
+		Detail string `json:"detail"`
+	}{}
+	e := ErrSCIM{Method: method, Path: path, StatusCode: status}
+	if err := json.Unmarshal(content, &result); err == nil {
+		e.Detail = result.Errors.Description
+		if e.Detail == "" {
+			e.Detail = result.Detail
+		}
+	}
+	return e
+}
@@ -44,14 +44,14 @@ func New(config Config) *Client {
 }
 
 // CallMethod calls most Slack API methods by name. If the API is normal but the URL is weird,
-// providing a complete https:// URL as the API name also works.
+// providing a complete URL as the API name also works.
 func (c *Client) CallMethod(api string, args interface{}, ret interface{}) error {
 	marshalled, err := json.Marshal(args)
 	if err != nil {
 		return fmt.Errorf("failed to marshal slack message: %v", err)
 	}
 	b := bytes.NewBuffer(marshalled)
-	req, err := http.NewRequest("POST", slackMethodToURL(api), b)
+	req, err := http.NewRequest("POST", c.methodURL(api), b)
 	if err != nil {
 		return fmt.Errorf("failed to create HTTP request: %v", err)
 	}
@@ -69,7 +69,7 @@ func (c *Client) CallOldMethod(api string, args map[string]string, ret interface
 	vs["token"] = []string{c.Config.AccessToken}
 	q := vs.Encode()
 	b := bytes.NewBufferString(q)
-	u := slackMethodToURL(api)
+	u := c.methodURL(api)
 	req, err := http.NewRequest("POST", u, b)
 	if err != nil {
 		return fmt.Errorf("failed to create HTTP request: %v", err)
@@ -78,11 +78,15 @@ func (c *Client) CallOldMethod(api string, args map[string]string, ret interface
 	return handleSlackRequest(req, ret)
 }
 
-func slackMethodToURL(method string) string {
-	if !strings.HasPrefix(method, "https://") {
-		return "https://slack.com/api/" + method
+func (c *Client) methodURL(method string) string {
+	if strings.HasPrefix(method, "https://") || strings.HasPrefix(method, "http://") {
+		return method
 	}
-	return method
+	base := c.Config.APIURL
+	if base == "" {
+		base = "https://slack.com/api/"
+	}
+	return strings.TrimSuffix(base, "/") + "/" + method
 }
 
 func handleSlackRequest(req *http.Request, ret interface{}) error {
@@ -0,0 +1,679 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package slacktest
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/slack-infra/slack"
+)
+
+var methods = map[string]handler{
+	"auth.test":                authTest,
+	"conversations.list":       conversationsList,
+	"conversations.info":       conversationsInfo,
+	"conversations.members":    conversationsMembers,
+	"conversations.history":    conversationsHistory,
+	"conversations.create":     conversationsCreate,
+	"conversations.rename":     conversationsRename,
+	"conversations.archive":    conversationsArchive,
+	"conversations.unarchive":  conversationsUnarchive,
+	"conversations.invite":     conversationsInvite,
+	"conversations.kick":       conversationsKick,
+	"conversations.setTopic":   conversationsSetTopic,
+	"conversations.setPurpose": conversationsSetPurpose,
+	"chat.postMessage":         chatPostMessage,
+	"chat.update":              chatUpdate,
+	"chat.delete":              chatDelete,
+	"chat.getPermalink":        chatGetPermalink,
+	"pins.add":                 pinsAdd,
+	"bookmarks.list":           bookmarksList,
+	"bookmarks.add":            bookmarksAdd,
+	"bookmarks.edit":           bookmarksEdit,
+	"bookmarks.remove":         bookmarksRemove,
+	"usergroups.list":          usergroupsList,
+	"usergroups.create":        usergroupsCreate,
+	"usergroups.update":        usergroupsUpdate,
+	"usergroups.disable":       usergroupsDisable,
+	"usergroups.enable":        usergroupsEnable,
+	"usergroups.users.update":  usergroupsUsersUpdate,
+	"search.messages":          searchMessages,
+	"search.files":             searchFiles,
+	"files.delete":             filesDelete,
+	"users.info":               usersInfo,
+	"dialog.open":              dialogOpen,
+	"im.open":                  imOpen,
+}
+
+func authTest(s *Server, args map[string]string) (map[string]interface{}, error) {
+	return map[string]interface{}{"team_id": s.TeamID, "user_id": s.BotUserID, "team": "slacktest", "user": "slacktest"}, nil
+}
+
+// page returns the range of a list of n items that the cursor and limit in args select, and the
+// cursor for the next page, if any.
+func page(args map[string]string, n int) (int, int, string) {
+	start, _ := strconv.Atoi(args["cursor"])
+	limit, err := strconv.Atoi(args["limit"])
+	if err != nil || limit <= 0 {
+		limit = 100
+	}
+	if start > n {
+		start = n
+	}
+	end := start + limit
+	if end >= n {
+		return start, n, ""
+	}
+	return start, end, strconv.Itoa(end)
+}
+
+func metadata(cursor string) map[string]interface{} {
+	return map[string]interface{}{"next_cursor": cursor}
+}
+
+func (s *Server) channel(args map[string]string) (*slack.Conversation, error) {
+	c, ok := s.channels[args["channel"]]
+	if !ok {
+		return nil, apiError("channel_not_found")
+	}
+	return c, nil
+}
+
+func conversationsList(s *Server, args map[string]string) (map[string]interface{}, error) {
+	types := strings.Split(args["types"], ",")
+	if args["types"] == "" {
+		types = []string{string(slack.ConversationTypePublicChannel)}
+	}
+	var channels []slack.Conversation
+	for _, c := range s.channels {
+		var t slack.ConversationType
+		switch {
+		case c.IsIM:
+			t = slack.ConversationTypeIM
+		case c.IsMPIM:
+			t = slack.ConversationTypeMPIM
+		case c.IsPrivate || c.IsGroup:
+			t = slack.ConversationTypePrivateChannel
+		default:
+			t = slack.ConversationTypePublicChannel
+		}
+		if contains(types, string(t)) {
+			channels = append(channels, *c)
+		}
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i].ID < channels[j].ID })
+	start, end, next := page(args, len(channels))
+	return map[string]interface{}{"channels": channels[start:end], "response_metadata": metadata(next)}, nil
+}
+
+func conversationsInfo(s *Server, args map[string]string) (map[string]interface{}, error) {
+	c, err := s.channel(args)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"channel": c}, nil
+}
+
+func conversationsMembers(s *Server, args map[string]string) (map[string]interface{}, error) {
+	if _, err := s.channel(args); err != nil {
+		return nil, err
+	}
+	members := s.members[args["channel"]]
+	start, end, next := page(args, len(members))
+	return map[string]interface{}{"members": append([]string{}, members[start:end]...), "response_metadata": metadata(next)}, nil
+}
+
+func conversationsHistory(s *Server, args map[string]string) (map[string]interface{}, error) {
+	if _, err := s.channel(args); err != nil {
+		return nil, err
+	}
+	var messages []slack.Message
+	all := s.messages[args["channel"]]
+	replies := map[string][]string{}
+	for _, m := range all {
+		if m.ThreadTS != "" && m.ThreadTS != m.TS {
+			replies[m.ThreadTS] = append(replies[m.ThreadTS], m.TS)
+		}
+	}
+	for i := len(all) - 1; i >= 0; i-- {
+		if m := all[i]; m.ThreadTS == "" || m.ThreadTS == m.TS {
+			if r := replies[m.TS]; len(r) > 0 {
+				m.ReplyCount = len(r)
+				m.LatestReply = r[len(r)-1]
+			}
+			messages = append(messages, m)
+		}
+	}
+	start, end, next := page(args, len(messages))
+	return map[string]interface{}{"messages": append([]slack.Message{}, messages[start:end]...), "response_metadata": metadata(next)}, nil
+}
+
+func conversationsCreate(s *Server, args map[string]string) (map[string]interface{}, error) {
+	name := args["name"]
+	if name == "" {
+		return nil, apiError("invalid_name_required")
+	}
+	if s.channelByName(name) != nil {
+		return nil, apiError("name_taken")
+	}
+	c := &slack.Conversation{ID: s.newID("C"), Name: name, NameNormalized: name, IsChannel: true, IsMember: true, Creator: s.BotUserID, Created: time.Now().Unix()}
+	s.channels[c.ID] = c
+	s.addMembers(c.ID, []string{s.BotUserID})
+	return map[string]interface{}{"channel": c}, nil
+}
+
+func conversationsRename(s *Server, args map[string]string) (map[string]interface{}, error) {
+	c, err := s.channel(args)
+	if err != nil {
+		return nil, err
+	}
+	if other := s.channelByName(args["name"]); other != nil && other != c {
+		return nil, apiError("name_taken")
+	}
+	if c.Name != args["name"] {
+		c.PreviousNames = append([]string{c.Name}, c.PreviousNames...)
+	}
+	c.Name = args["name"]
+	c.NameNormalized = args["name"]
+	return map[string]interface{}{"channel": c}, nil
+}
+
+func conversationsArchive(s *Server, args map[string]string) (map[string]interface{}, error) {
+	c, err := s.channel(args)
+	if err != nil {
+		return nil, err
+	}
+	if c.IsArchived {
+		return nil, apiError("already_archived")
+	}
+	c.IsArchived = true
+	return nil, nil
+}
+
+func conversationsUnarchive(s *Server, args map[string]string) (map[string]interface{}, error) {
+	c, err := s.channel(args)
+	if err != nil {
+		return nil, err
+	}
+	if !c.IsArchived {
+		return nil, apiError("not_archived")
+	}
+	c.IsArchived = false
+	return nil, nil
+}
+
+func conversationsInvite(s *Server, args map[string]string) (map[string]interface{}, error) {
+	c, err := s.channel(args)
+	if err != nil {
+		return nil, err
+	}
+	if c.IsArchived {
+		return nil, apiError("is_archived")
+	}
+	users := strings.Split(args["users"], ",")
+	if len(users) > 1000 {
+		return nil, apiError("too_many_users")
+	}
+	for _, u := range users {
+		if contains(s.members[c.ID], u) {
+			return nil, apiError("already_in_channel")
+		}
+	}
+	s.addMembers(c.ID, users)
+	return map[string]interface{}{"channel": c}, nil
+}
+
+func conversationsKick(s *Server, args map[string]string) (map[string]interface{}, error) {
+	c, err := s.channel(args)
+	if err != nil {
+		return nil, err
+	}
+	var remaining []string
+	for _, m := range s.members[c.ID] {
+		if m != args["user"] {
+			remaining = append(remaining, m)
+		}
+	}
+	if len(remaining) == len(s.members[c.ID]) {
+		return nil, apiError("not_in_channel")
+	}
+	s.members[c.ID] = remaining
+	c.NumMembers = len(remaining)
+	return nil, nil
+}
+
+func conversationsSetTopic(s *Server, args map[string]string) (map[string]interface{}, error) {
+	c, err := s.channel(args)
+	if err != nil {
+		return nil, err
+	}
+	c.Topic.Topic = args["topic"]
+	c.Topic.Creator = s.BotUserID
+	c.Topic.LastSet = time.Now().Unix()
+	return map[string]interface{}{"channel": c}, nil
+}
+
+func conversationsSetPurpose(s *Server, args map[string]string) (map[string]interface{}, error) {
+	c, err := s.channel(args)
+	if err != nil {
+		return nil, err
+	}
+	c.Purpose.Purpose = args["purpose"]
+	c.Purpose.Creator = s.BotUserID
+	c.Purpose.LastSet = time.Now().Unix()
+	return map[string]interface{}{"channel": c}, nil
+}
+
+func chatPostMessage(s *Server, args map[string]string) (map[string]interface{}, error) {
+	c, err := s.channel(args)
+	if err != nil {
+		return nil, err
+	}
+	if c.IsArchived {
+		return nil, apiError("is_archived")
+	}
+	if args["text"] == "" && args["blocks"] == "" && args["attachments"] == "" {
+		return nil, apiError("no_text")
+	}
+	m := slack.Message{Type: "message", User: s.BotUserID, Text: args["text"], TS: s.newTS(), ThreadTS: args["thread_ts"]}
+	s.messages[c.ID] = append(s.messages[c.ID], m)
+	return map[string]interface{}{"channel": c.ID, "ts": m.TS, "message": m}, nil
+}
+
+func (s *Server) messageIndex(channel, ts string) int {
+	for i, m := range s.messages[channel] {
+		if m.TS == ts {
+			return i
+		}
+	}
+	return -1
+}
+
+func chatUpdate(s *Server, args map[string]string) (map[string]interface{}, error) {
+	c, err := s.channel(args)
+	if err != nil {
+		return nil, err
+	}
+	i := s.messageIndex(c.ID, args["ts"])
+	if i < 0 {
+		return nil, apiError("message_not_found")
+	}
+	if v, ok := args["text"]; ok {
+		s.messages[c.ID][i].Text = v
+	}
+	return map[string]interface{}{"channel": c.ID, "ts": args["ts"], "text": s.messages[c.ID][i].Text}, nil
+}
+
+func chatDelete(s *Server, args map[string]string) (map[string]interface{}, error) {
+	c, err := s.channel(args)
+	if err != nil {
+		return nil, err
+	}
+	i := s.messageIndex(c.ID, args["ts"])
+	if i < 0 {
+		return nil, apiError("message_not_found")
+	}
+	s.messages[c.ID] = append(s.messages[c.ID][:i], s.messages[c.ID][i+1:]...)
+	return map[string]interface{}{"channel": c.ID, "ts": args["ts"]}, nil
+}
+
+func chatGetPermalink(s *Server, args map[string]string) (map[string]interface{}, error) {
+	c, err := s.channel(args)
+	if err != nil {
+		return nil, err
+	}
+	if s.messageIndex(c.ID, args["message_ts"]) < 0 {
+		return nil, apiError("message_not_found")
+	}
+	link := fmt.Sprintf("https://slacktest.slack.com/archives/%s/p%s", c.ID, strings.Replace(args["message_ts"], ".", "", 1))
+	return map[string]interface{}{"channel": c.ID, "permalink": link}, nil
+}
+
+func pinsAdd(s *Server, args map[string]string) (map[string]interface{}, error) {
+	c, err := s.channel(args)
+	if err != nil {
+		return nil, err
+	}
+	if s.messageIndex(c.ID, args["timestamp"]) < 0 {
+		return nil, apiError("message_not_found")
+	}
+	if contains(s.pins[c.ID], args["timestamp"]) {
+		return nil, apiError("already_pinned")
+	}
+	s.pins[c.ID] = append(s.pins[c.ID], args["timestamp"])
+	return nil, nil
+}
+
+// bookmarkChannel is channel, but for the bookmarks methods, which take channel_id instead.
+func (s *Server) bookmarkChannel(args map[string]string) (*slack.Conversation, error) {
+	return s.channel(map[string]string{"channel": args["channel_id"]})
+}
+
+func (s *Server) bookmarkIndex(channel, id string) int {
+	for i, b := range s.bookmarks[channel] {
+		if b.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func bookmarksList(s *Server, args map[string]string) (map[string]interface{}, error) {
+	c, err := s.bookmarkChannel(args)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"bookmarks": append([]slack.Bookmark{}, s.bookmarks[c.ID]...)}, nil
+}
+
+func bookmarksAdd(s *Server, args map[string]string) (map[string]interface{}, error) {
+	c, err := s.bookmarkChannel(args)
+	if err != nil {
+		return nil, err
+	}
+	if args["title"] == "" {
+		return nil, apiError("invalid_title")
+	}
+	now := time.Now().Unix()
+	b := slack.Bookmark{ID: s.newID("Bk"), ChannelID: c.ID, Title: args["title"], Link: args["link"], Emoji: args["emoji"], Type: args["type"], Created: now, Updated: now}
+	s.bookmarks[c.ID] = append(s.bookmarks[c.ID], b)
+	return map[string]interface{}{"bookmark": b}, nil
+}
+
+func bookmarksEdit(s *Server, args map[string]string) (map[string]interface{}, error) {
+	c, err := s.bookmarkChannel(args)
+	if err != nil {
+		return nil, err
+	}
+	i := s.bookmarkIndex(c.ID, args["bookmark_id"])
+	if i < 0 {
+		return nil, apiError("not_found")
+	}
+	b := &s.bookmarks[c.ID][i]
+	for k, f := range map[string]*string{"title": &b.Title, "link": &b.Link, "emoji": &b.Emoji} {
+		if v, ok := args[k]; ok {
+			*f = v
+		}
+	}
+	b.Updated = time.Now().Unix()
+	return map[string]interface{}{"bookmark": *b}, nil
+}
+
+func bookmarksRemove(s *Server, args map[string]string) (map[string]interface{}, error) {
+	c, err := s.bookmarkChannel(args)
+	if err != nil {
+		return nil, err
+	}
+	i := s.bookmarkIndex(c.ID, args["bookmark_id"])
+	if i < 0 {
+		return nil, apiError("not_found")
+	}
+	s.bookmarks[c.ID] = append(s.bookmarks[c.ID][:i], s.bookmarks[c.ID][i+1:]...)
+	return nil, nil
+}
+
+func usergroupsList(s *Server, args map[string]string) (map[string]interface{}, error) {
+	var groups []slack.Subteam
+	for _, g := range s.usergroups {
+		if g.DeleteTime != 0 && args["include_disabled"] != "true" {
+			continue
+		}
+		g2 := *g
+		if args["include_users"] != "true" {
+			g2.Users = nil
+		}
+		groups = append(groups, g2)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].ID < groups[j].ID })
+	return map[string]interface{}{"usergroups": groups}, nil
+}
+
+func (s *Server) usergroup(args map[string]string) (*slack.Subteam, error) {
+	g, ok := s.usergroups[args["usergroup"]]
+	if !ok {
+		return nil, apiError("no_such_subteam")
+	}
+	return g, nil
+}
+
+// updateUsergroup sets the fields of g given in args, checking that its name and handle stay
+// unique.
+func (s *Server) updateUsergroup(g *slack.Subteam, args map[string]string) error {
+	for _, o := range s.usergroups {
+		if o == g {
+			continue
+		}
+		if args["name"] != "" && o.Name == args["name"] {
+			return apiError("name_already_exists")
+		}
+		if args["handle"] != "" && o.Handle == args["handle"] {
+			return apiError("handle_already_exists")
+		}
+	}
+	if v, ok := args["name"]; ok {
+		g.Name = v
+	}
+	if v, ok := args["handle"]; ok {
+		g.Handle = v
+	}
+	if v, ok := args["description"]; ok {
+		g.Description = v
+	}
+	if v, ok := args["channels"]; ok {
+		g.Prefs.Channels = nil
+		if v != "" {
+			g.Prefs.Channels = strings.Split(v, ",")
+		}
+	}
+	g.UpdatedBy = s.BotUserID
+	g.UpdateTime = int(time.Now().Unix())
+	return nil
+}
+
+func usergroupsCreate(s *Server, args map[string]string) (map[string]interface{}, error) {
+	if args["name"] == "" {
+		return nil, apiError("invalid_name")
+	}
+	g := &slack.Subteam{ID: s.newID("S"), IsUsergroup: true, CreatedBy: s.BotUserID, CreateTime: int(time.Now().Unix())}
+	if err := s.updateUsergroup(g, args); err != nil {
+		return nil, err
+	}
+	s.usergroups[g.ID] = g
+	return map[string]interface{}{"usergroup": g}, nil
+}
+
+func usergroupsUpdate(s *Server, args map[string]string) (map[string]interface{}, error) {
+	g, err := s.usergroup(args)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.updateUsergroup(g, args); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"usergroup": g}, nil
+}
+
+func usergroupsDisable(s *Server, args map[string]string) (map[string]interface{}, error) {
+	g, err := s.usergroup(args)
+	if err != nil {
+		return nil, err
+	}
+	if g.DeleteTime != 0 {
+		return nil, apiError("already_disabled")
+	}
+	g.DeleteTime = int(time.Now().Unix())
+	g.DeletedBy = s.BotUserID
+	return map[string]interface{}{"usergroup": g}, nil
+}
+
+func usergroupsEnable(s *Server, args map[string]string) (map[string]interface{}, error) {
+	g, err := s.usergroup(args)
+	if err != nil {
+		return nil, err
+	}
+	if g.DeleteTime == 0 {
+		return nil, apiError("already_enabled")
+	}
+	g.DeleteTime = 0
+	g.DeletedBy = ""
+	return map[string]interface{}{"usergroup": g}, nil
+}
+
+func usergroupsUsersUpdate(s *Server, args map[string]string) (map[string]interface{}, error) {
+	g, err := s.usergroup(args)
+	if err != nil {
+		return nil, err
+	}
+	if args["users"] == "" {
+		return nil, apiError("invalid_users")
+	}
+	g.Users = strings.Split(args["users"], ",")
+	g.UserCount = len(g.Users)
+	return map[string]interface{}{"usergroup": g}, nil
+}
+
+// fromQuery matches the "from:" part of a search query, which is the only part we understand.
+var fromQuery = regexp.MustCompile(`from:<@([A-Z0-9]+)>`)
+
+// searchPage returns the range of a list of n search results that the page and count in args
+// select, and the pagination object to return with them.
+func searchPage(args map[string]string, n int) (int, int, map[string]interface{}) {
+	p, err := strconv.Atoi(args["page"])
+	if err != nil || p < 1 {
+		p = 1
+	}
+	count, err := strconv.Atoi(args["count"])
+	if err != nil || count <= 0 {
+		count = 20
+	}
+	pageCount := (n + count - 1) / count
+	start := (p - 1) * count
+	if start > n {
+		start = n
+	}
+	end := start + count
+	if end > n {
+		end = n
+	}
+	return start, end, map[string]interface{}{"page": p, "page_count": pageCount, "total_count": n}
+}
+
+func searchMessages(s *Server, args map[string]string) (map[string]interface{}, error) {
+	from := fromQuery.FindStringSubmatch(args["query"])
+	type match struct {
+		Channel struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"channel"`
+		TS       string `json:"ts"`
+		ThreadTS string `json:"thread_ts,omitempty"`
+		User     string `json:"user"`
+		Text     string `json:"text"`
+	}
+	var matches []match
+	for id, messages := range s.messages {
+		for _, m := range messages {
+			if from != nil && m.User != from[1] {
+				continue
+			}
+			var r match
+			r.Channel.ID = id
+			if c, ok := s.channels[id]; ok {
+				r.Channel.Name = c.Name
+			}
+			r.TS, r.ThreadTS, r.User, r.Text = m.TS, m.ThreadTS, m.User, m.Text
+			matches = append(matches, r)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return tsLess(matches[j].TS, matches[i].TS) })
+	start, end, pagination := searchPage(args, len(matches))
+	return map[string]interface{}{"messages": map[string]interface{}{"matches": append([]match{}, matches[start:end]...), "pagination": pagination}}, nil
+}
+
+func searchFiles(s *Server, args map[string]string) (map[string]interface{}, error) {
+	from := fromQuery.FindStringSubmatch(args["query"])
+	var matches []File
+	for _, f := range s.files {
+		if from == nil || f.User == from[1] {
+			matches = append(matches, f)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Created != matches[j].Created {
+			return matches[i].Created > matches[j].Created
+		}
+		return matches[i].ID < matches[j].ID
+	})
+	start, end, pagination := searchPage(args, len(matches))
+	return map[string]interface{}{"files": map[string]interface{}{"matches": append([]File{}, matches[start:end]...), "pagination": pagination}}, nil
+}
+
+// tsLess returns whether the message timestamp a is before b.
+func tsLess(a, b string) bool {
+	fa, _ := strconv.ParseFloat(a, 64)
+	fb, _ := strconv.ParseFloat(b, 64)
+	return fa < fb
+}
+
+func filesDelete(s *Server, args map[string]string) (map[string]interface{}, error) {
+	if _, ok := s.files[args["file"]]; !ok {
+		return nil, apiError("file_not_found")
+	}
+	delete(s.files, args["file"])
+	return nil, nil
+}
+
+func usersInfo(s *Server, args map[string]string) (map[string]interface{}, error) {
+	u, ok := s.users[args["user"]]
+	if !ok {
+		return nil, apiError("user_not_found")
+	}
+	return map[string]interface{}{"user": u}, nil
+}
+
+func dialogOpen(s *Server, args map[string]string) (map[string]interface{}, error) {
+	if args["trigger_id"] == "" {
+		return nil, apiError("missing_trigger")
+	}
+	d := slack.DialogWrapper{TriggerID: args["trigger_id"]}
+	if err := json.Unmarshal([]byte(args["dialog"]), &d.Dialog); err != nil {
+		return nil, apiError("validation_errors")
+	}
+	s.dialogs = append(s.dialogs, d)
+	return nil, nil
+}
+
+func imOpen(s *Server, args map[string]string) (map[string]interface{}, error) {
+	user := args["user"]
+	if _, ok := s.users[user]; !ok {
+		return nil, apiError("user_not_found")
+	}
+	id, ok := s.ims[user]
+	if !ok {
+		id = s.newID("D")
+		s.ims[user] = id
+		s.channels[id] = &slack.Conversation{ID: id, IsIM: true}
+		s.members[id] = []string{s.BotUserID, user}
+	}
+	return map[string]interface{}{"channel": map[string]interface{}{"id": id}}, nil
+}
@@ -0,0 +1,224 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package slacktest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/slack-infra/slack"
+)
+
+// The fake SCIM API works on the same workspace as the Web API. Its users are the workspace's
+// users, who are active unless they are deleted, and its groups are the workspace's usergroups.
+// Calls are recorded as requests whose method is the HTTP method and resource, such as
+// "PATCH Users/U0123ABCD", and FailNext and RateLimitNext work on those too. Failures injected with
+// FailNext are reported with status 400 and the error type as their detail.
+
+const scimPrefix = "/scim/v2/"
+
+// SCIMURL is the root of the fake SCIM API, suitable for slack.SCIMConfig.URL.
+func (s *Server) SCIMURL() string {
+	return s.server.URL + scimPrefix
+}
+
+// SCIMClient returns a slack.SCIMClient that talks to s.
+func (s *Server) SCIMClient() *slack.SCIMClient {
+	return slack.NewSCIM(slack.SCIMConfig{Token: "xoxp-slacktest", URL: s.SCIMURL()})
+}
+
+// User returns the user with the given ID.
+func (s *Server) User(id string) (slack.User, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[id]
+	if !ok {
+		return slack.User{}, false
+	}
+	return *u, true
+}
+
+func (s *Server) serveSCIM(w http.ResponseWriter, r *http.Request) {
+	resource := strings.TrimPrefix(r.URL.Path, scimPrefix)
+	method := r.Method + " " + resource
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	args := map[string]string{}
+	raw := map[string]json.RawMessage{}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &raw); err != nil {
+			writeSCIMError(w, http.StatusBadRequest, "couldn't parse JSON body")
+			return
+		}
+		for k, v := range raw {
+			args[k] = string(v)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, Request{Method: method, Args: args})
+
+	if faults := s.faults[method]; len(faults) > 0 {
+		f := faults[0]
+		s.faults[method] = faults[1:]
+		if f.err == "" {
+			w.Header().Set("Retry-After", strconv.Itoa(f.retryAfter))
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		writeSCIMError(w, http.StatusBadRequest, f.err)
+		return
+	}
+	if strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer")) == "" {
+		writeSCIMError(w, http.StatusUnauthorized, "invalid_authentication")
+		return
+	}
+
+	parts := strings.Split(resource, "/")
+	if len(parts) != 2 {
+		writeSCIMError(w, http.StatusNotFound, "unknown resource")
+		return
+	}
+	switch parts[0] {
+	case "Users":
+		s.serveSCIMUser(w, r.Method, parts[1], raw)
+	case "Groups":
+		s.serveSCIMGroup(w, r.Method, parts[1], raw)
+	default:
+		writeSCIMError(w, http.StatusNotFound, "unknown resource")
+	}
+}
+
+func (s *Server) serveSCIMUser(w http.ResponseWriter, method, id string, patch map[string]json.RawMessage) {
+	u, ok := s.users[id]
+	if !ok {
+		writeSCIMError(w, http.StatusNotFound, "No User found with id "+id)
+		return
+	}
+	switch method {
+	case http.MethodGet:
+	case http.MethodPatch:
+		if v, ok := patch["active"]; ok {
+			var active bool
+			if err := json.Unmarshal(v, &active); err != nil {
+				writeSCIMError(w, http.StatusBadRequest, "active must be a boolean")
+				return
+			}
+			u.Deleted = !active
+		}
+	case http.MethodDelete:
+		u.Deleted = true
+		for _, g := range s.usergroups {
+			s.removeUsergroupMember(g, id)
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		writeSCIMError(w, http.StatusMethodNotAllowed, "unsupported method")
+		return
+	}
+	writeJSON(w, s.scimUser(u))
+}
+
+func (s *Server) scimUser(u *slack.User) slack.SCIMUser {
+	result := slack.SCIMUser{
+		Schemas:     []string{slack.SCIMUserSchema},
+		ID:          u.ID,
+		UserName:    u.Name,
+		DisplayName: u.Profile.DisplayName,
+		Active:      !u.Deleted,
+	}
+	if u.Profile.Email != "" {
+		result.Emails = []slack.SCIMValue{{Value: u.Profile.Email, Primary: true}}
+	}
+	for _, g := range s.usergroups {
+		if contains(g.Users, u.ID) {
+			result.Groups = append(result.Groups, slack.SCIMValue{Value: g.ID, Display: g.Name})
+		}
+	}
+	sort.Slice(result.Groups, func(i, j int) bool { return result.Groups[i].Value < result.Groups[j].Value })
+	return result
+}
+
+func (s *Server) serveSCIMGroup(w http.ResponseWriter, method, id string, patch map[string]json.RawMessage) {
+	g, ok := s.usergroups[id]
+	if !ok {
+		writeSCIMError(w, http.StatusNotFound, "No Group found with id "+id)
+		return
+	}
+	switch method {
+	case http.MethodGet:
+	case http.MethodPatch:
+		if v, ok := patch["members"]; ok {
+			var members []slack.SCIMValue
+			if err := json.Unmarshal(v, &members); err != nil {
+				writeSCIMError(w, http.StatusBadRequest, "members must be a list")
+				return
+			}
+			for _, m := range members {
+				if _, ok := s.users[m.Value]; !ok {
+					writeSCIMError(w, http.StatusBadRequest, "No User found with id "+m.Value)
+					return
+				}
+			}
+			for _, m := range members {
+				if m.Operation == "delete" {
+					s.removeUsergroupMember(g, m.Value)
+				} else if !contains(g.Users, m.Value) {
+					g.Users = append(g.Users, m.Value)
+					g.UserCount = len(g.Users)
+				}
+			}
+		}
+	default:
+		writeSCIMError(w, http.StatusMethodNotAllowed, "unsupported method")
+		return
+	}
+	result := slack.SCIMGroup{Schemas: []string{slack.SCIMGroupSchema}, ID: g.ID, DisplayName: g.Name}
+	for _, u := range g.Users {
+		result.Members = append(result.Members, slack.SCIMValue{Value: u})
+	}
+	writeJSON(w, result)
+}
+
+func (s *Server) removeUsergroupMember(g *slack.Subteam, user string) {
+	var users []string
+	for _, u := range g.Users {
+		if u != user {
+			users = append(users, u)
+		}
+	}
+	g.Users = users
+	g.UserCount = len(users)
+}
+
+// writeSCIMError reports an error the way Slack's SCIM API does.
+func writeSCIMError(w http.ResponseWriter, status int, description string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"Errors": map[string]interface{}{"description": description, "code": status},
+	})
+}
@@ -0,0 +1,457 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package slacktest provides an in-memory fake of the parts of the Slack Web API that we use, for
+// testing code that talks to Slack.
+package slacktest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/slack-infra/slack"
+)
+
+// Server is a fake Slack workspace served over HTTP. The zero value is not usable; use NewServer.
+type Server struct {
+	// TeamID and BotUserID are reported by auth.test. BotUserID is also the author of every
+	// message posted through the API.
+	TeamID    string
+	BotUserID string
+
+	server *httptest.Server
+
+	mu         sync.Mutex
+	nextID     int
+	nextTS     int64
+	channels   map[string]*slack.Conversation
+	members    map[string][]string
+	messages   map[string][]slack.Message
+	pins       map[string][]string
+	bookmarks  map[string][]slack.Bookmark
+	usergroups map[string]*slack.Subteam
+	users      map[string]*slack.User
+	files      map[string]File
+	ims        map[string]string
+	dialogs    []slack.DialogWrapper
+	requests   []Request
+	faults     map[string][]fault
+}
+
+// File is an uploaded file, as far as search.files and files.delete are concerned.
+type File struct {
+	ID      string `json:"id"`
+	User    string `json:"user"`
+	Created int64  `json:"created"`
+	Name    string `json:"name,omitempty"`
+	// Channels are the IDs of the channels the file is shared in.
+	Channels []string `json:"channels,omitempty"`
+	// URLPrivateDownload is where the file's contents can be fetched from. The fake doesn't serve
+	// file contents itself, so tests that need them should point this at their own server.
+	URLPrivateDownload string `json:"url_private_download,omitempty"`
+}
+
+// Request is a call made to the server.
+type Request struct {
+	// Method is the name of the API method, such as "conversations.create".
+	Method string
+	// Args are the arguments to the call. Arguments sent as JSON strings are given as is; any
+	// other JSON values are given in their JSON encoding.
+	Args map[string]string
+}
+
+type fault struct {
+	err        string
+	retryAfter int
+}
+
+// NewServer starts a Server with an empty workspace. It must be closed when no longer needed.
+func NewServer() *Server {
+	s := &Server{
+		TeamID:     "T00000000",
+		BotUserID:  "U00000000",
+		nextTS:     time.Now().Unix(),
+		channels:   map[string]*slack.Conversation{},
+		members:    map[string][]string{},
+		messages:   map[string][]slack.Message{},
+		pins:       map[string][]string{},
+		bookmarks:  map[string][]slack.Bookmark{},
+		usergroups: map[string]*slack.Subteam{},
+		users:      map[string]*slack.User{},
+		files:      map[string]File{},
+		ims:        map[string]string{},
+		faults:     map[string][]fault{},
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// URL is the root of the fake Web API, suitable for slack.Config.APIURL.
+func (s *Server) URL() string {
+	return s.server.URL + "/api/"
+}
+
+// Client returns a slack.Client that talks to s.
+func (s *Server) Client() *slack.Client {
+	return slack.New(slack.Config{AccessToken: "xoxb-slacktest", APIURL: s.URL()})
+}
+
+// Close shuts down the server.
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// FailNext makes the next call to method fail with the Slack error errType, such as
+// "channel_not_found". Repeated calls queue up further failures.
+func (s *Server) FailNext(method, errType string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults[method] = append(s.faults[method], fault{err: errType})
+}
+
+// RateLimitNext makes the next call to method be rate limited, asking the caller to wait for the
+// given number of seconds. Repeated calls queue up further rate limits.
+func (s *Server) RateLimitNext(method string, retryAfter int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults[method] = append(s.faults[method], fault{retryAfter: retryAfter})
+}
+
+// Requests returns every call made to the server so far, in order, including failed ones.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// RequestsFor returns every call made to method so far, in order.
+func (s *Server) RequestsFor(method string) []Request {
+	var result []Request
+	for _, r := range s.Requests() {
+		if r.Method == method {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+func (s *Server) newID(prefix string) string {
+	s.nextID++
+	return fmt.Sprintf("%s%08d", prefix, s.nextID)
+}
+
+func (s *Server) newTS() string {
+	s.nextTS++
+	return fmt.Sprintf("%d.000100", s.nextTS)
+}
+
+// AddChannel adds c to the workspace and returns its ID, which is generated if c doesn't have
+// one. Unless c says otherwise, it is a public channel.
+func (s *Server) AddChannel(c slack.Conversation) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c.ID == "" {
+		c.ID = s.newID("C")
+	}
+	if !c.IsGroup && !c.IsIM && !c.IsMPIM && !c.IsPrivate {
+		c.IsChannel = true
+	}
+	s.channels[c.ID] = &c
+	return c.ID
+}
+
+// AddMembers adds users to the channel with the given ID.
+func (s *Server) AddMembers(channel string, users ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addMembers(channel, users)
+}
+
+func (s *Server) addMembers(channel string, users []string) {
+	for _, u := range users {
+		if !contains(s.members[channel], u) {
+			s.members[channel] = append(s.members[channel], u)
+		}
+	}
+	if c, ok := s.channels[channel]; ok {
+		c.NumMembers = len(s.members[channel])
+	}
+}
+
+// AddMessage posts m in the given channel and returns its timestamp, which is generated if m
+// doesn't have one. Messages must be added oldest first.
+func (s *Server) AddMessage(channel string, m slack.Message) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m.TS == "" {
+		m.TS = s.newTS()
+	}
+	if m.Type == "" {
+		m.Type = "message"
+	}
+	s.messages[channel] = append(s.messages[channel], m)
+	return m.TS
+}
+
+// AddUsergroup adds g to the workspace and returns its ID, which is generated if g doesn't have
+// one.
+func (s *Server) AddUsergroup(g slack.Subteam) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if g.ID == "" {
+		g.ID = s.newID("S")
+	}
+	g.IsUsergroup = true
+	g.UserCount = len(g.Users)
+	s.usergroups[g.ID] = &g
+	return g.ID
+}
+
+// AddUser adds u to the workspace.
+func (s *Server) AddUser(u slack.User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[u.ID] = &u
+}
+
+// AddFile adds f to the workspace and returns its ID, which is generated if f doesn't have one.
+func (s *Server) AddFile(f File) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f.ID == "" {
+		f.ID = s.newID("F")
+	}
+	s.files[f.ID] = f
+	return f.ID
+}
+
+// Channel returns the channel with the given ID.
+func (s *Server) Channel(id string) (slack.Conversation, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.channels[id]
+	if !ok {
+		return slack.Conversation{}, false
+	}
+	return *c, true
+}
+
+// ChannelByName returns the channel with the given name.
+func (s *Server) ChannelByName(name string) (slack.Conversation, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.channelByName(name)
+	if c == nil {
+		return slack.Conversation{}, false
+	}
+	return *c, true
+}
+
+func (s *Server) channelByName(name string) *slack.Conversation {
+	for _, c := range s.channels {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// Members returns the IDs of the members of the given channel, in the order they joined.
+func (s *Server) Members(channel string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.members[channel]...)
+}
+
+// Messages returns the messages in the given channel, oldest first.
+func (s *Server) Messages(channel string) []slack.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]slack.Message(nil), s.messages[channel]...)
+}
+
+// Pins returns the timestamps of the messages pinned in the given channel.
+func (s *Server) Pins(channel string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.pins[channel]...)
+}
+
+// Bookmarks returns the bookmarks in the given channel, in the order they were added.
+func (s *Server) Bookmarks(channel string) []slack.Bookmark {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]slack.Bookmark(nil), s.bookmarks[channel]...)
+}
+
+// Usergroup returns the usergroup with the given handle.
+func (s *Server) Usergroup(handle string) (slack.Subteam, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, g := range s.usergroups {
+		if g.Handle == handle {
+			return *g, true
+		}
+	}
+	return slack.Subteam{}, false
+}
+
+// Files returns the files that haven't been deleted, sorted by ID.
+func (s *Server) Files() []File {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var result []File
+	for _, f := range s.files {
+		result = append(result, f)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+// Dialogs returns every dialog that has been opened.
+func (s *Server) Dialogs() []slack.DialogWrapper {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]slack.DialogWrapper(nil), s.dialogs...)
+}
+
+// apiError is returned by method handlers to make the call fail with the given Slack error.
+type apiError string
+
+// handler implements a single API method. It returns the fields of the response other than "ok".
+type handler func(s *Server, args map[string]string) (map[string]interface{}, error)
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, scimPrefix) {
+		s.serveSCIM(w, r)
+		return
+	}
+	method := strings.TrimPrefix(r.URL.Path, "/api/")
+	args, token, err := parseArgs(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, Request{Method: method, Args: args})
+
+	if faults := s.faults[method]; len(faults) > 0 {
+		f := faults[0]
+		s.faults[method] = faults[1:]
+		if f.err == "" {
+			w.Header().Set("Retry-After", strconv.Itoa(f.retryAfter))
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		writeError(w, f.err)
+		return
+	}
+
+	h, ok := methods[method]
+	if !ok {
+		writeError(w, "unknown_method")
+		return
+	}
+	if token == "" {
+		writeError(w, "not_authed")
+		return
+	}
+	resp, err := h(s, args)
+	if err != nil {
+		if e, ok := err.(apiError); ok {
+			writeError(w, string(e))
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if resp == nil {
+		resp = map[string]interface{}{}
+	}
+	resp["ok"] = true
+	writeJSON(w, resp)
+}
+
+func (e apiError) Error() string {
+	return string(e)
+}
+
+// parseArgs returns the arguments to a request, whether they were sent as JSON or a form, along
+// with the token it was authenticated with.
+func parseArgs(r *http.Request) (map[string]string, string, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("couldn't read body: %v", err)
+	}
+	args := map[string]string{}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		raw := map[string]json.RawMessage{}
+		if err := json.Unmarshal(body, &raw); err != nil {
+			return nil, "", fmt.Errorf("couldn't parse JSON body: %v", err)
+		}
+		for k, v := range raw {
+			var str string
+			if err := json.Unmarshal(v, &str); err == nil {
+				args[k] = str
+			} else {
+				args[k] = string(v)
+			}
+		}
+		return args, token, nil
+	}
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, "", fmt.Errorf("couldn't parse form body: %v", err)
+	}
+	for k, v := range values {
+		if k == "token" {
+			token = v[0]
+			continue
+		}
+		args[k] = v[0]
+	}
+	return args, token, nil
+}
+
+func writeError(w http.ResponseWriter, errType string) {
+	writeJSON(w, map[string]interface{}{"ok": false, "error": errType})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,356 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package slacktest
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"sigs.k8s.io/slack-infra/slack"
+)
+
+func TestChannels(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := s.Client()
+
+	ret := struct {
+		Channel slack.Conversation `json:"channel"`
+	}{}
+	if err := c.CallMethod("conversations.create", map[string]string{"name": "sig-testing"}, &ret); err != nil {
+		t.Fatalf("Failed to create channel: %v", err)
+	}
+	id := ret.Channel.ID
+	if err := c.CallMethod("conversations.create", map[string]string{"name": "sig-testing"}, nil); !isSlackError(err, "name_taken") {
+		t.Errorf("Expected creating a duplicate channel to fail with name_taken, but got %v", err)
+	}
+	if err := c.CallMethod("conversations.rename", map[string]string{"channel": id, "name": "sig-testing-2"}, nil); err != nil {
+		t.Fatalf("Failed to rename channel: %v", err)
+	}
+	if err := c.CallMethod("conversations.invite", map[string]string{"channel": id, "users": "U11111111,U22222222"}, nil); err != nil {
+		t.Fatalf("Failed to invite users: %v", err)
+	}
+	if err := c.CallMethod("conversations.kick", map[string]string{"channel": id, "user": "U11111111"}, nil); err != nil {
+		t.Fatalf("Failed to kick user: %v", err)
+	}
+	if err := c.CallMethod("conversations.archive", map[string]string{"channel": id}, nil); err != nil {
+		t.Fatalf("Failed to archive channel: %v", err)
+	}
+
+	got, ok := s.ChannelByName("sig-testing-2")
+	if !ok {
+		t.Fatalf("Expected to find renamed channel")
+	}
+	if !got.IsArchived || !reflect.DeepEqual(got.PreviousNames, []string{"sig-testing"}) {
+		t.Errorf("Expected an archived channel previously called sig-testing, but got %#v", got)
+	}
+	if members, expected := s.Members(id), []string{s.BotUserID, "U22222222"}; !reflect.DeepEqual(members, expected) {
+		t.Errorf("Expected members %v, but got %v", expected, members)
+	}
+
+	listed, err := c.GetPublicChannels()
+	if err != nil {
+		t.Fatalf("Failed to list channels: %v", err)
+	}
+	if len(listed) != 1 || listed[0].ID != id {
+		t.Errorf("Expected to list just channel %s, but got %#v", id, listed)
+	}
+}
+
+func TestPagination(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	for i := 0; i < 250; i++ {
+		s.AddChannel(slack.Conversation{Name: fmt.Sprintf("channel-%d", i)})
+	}
+	channels, err := s.Client().GetPublicChannels()
+	if err != nil {
+		t.Fatalf("Failed to list channels: %v", err)
+	}
+	if len(channels) != 250 {
+		t.Errorf("Expected 250 channels, but got %d", len(channels))
+	}
+	if n := len(s.RequestsFor("conversations.list")); n != 3 {
+		t.Errorf("Expected channels to be listed in 3 pages, but got %d", n)
+	}
+}
+
+func TestMessages(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := s.Client()
+	id := s.AddChannel(slack.Conversation{Name: "general"})
+	old := s.AddMessage(id, slack.Message{User: "U11111111", Text: "hello"})
+
+	ret := struct {
+		TS string `json:"ts"`
+	}{}
+	if err := c.CallMethod("chat.postMessage", map[string]interface{}{"channel": id, "text": "hi", "as_user": true}, &ret); err != nil {
+		t.Fatalf("Failed to post message: %v", err)
+	}
+	if err := c.CallMethod("pins.add", map[string]string{"channel": id, "timestamp": ret.TS}, nil); err != nil {
+		t.Fatalf("Failed to pin message: %v", err)
+	}
+	if pins := s.Pins(id); !reflect.DeepEqual(pins, []string{ret.TS}) {
+		t.Errorf("Expected %s to be pinned, but pins are %v", ret.TS, pins)
+	}
+
+	var history []string
+	if err := c.GetConversationHistory(id, func(m slack.Message) bool {
+		history = append(history, m.Text)
+		return true
+	}); err != nil {
+		t.Fatalf("Failed to get history: %v", err)
+	}
+	if expected := []string{"hi", "hello"}; !reflect.DeepEqual(history, expected) {
+		t.Errorf("Expected history %v, but got %v", expected, history)
+	}
+
+	if err := c.CallMethod("chat.delete", map[string]string{"channel": id, "ts": old}, nil); err != nil {
+		t.Fatalf("Failed to delete message: %v", err)
+	}
+	if err := c.CallMethod("chat.delete", map[string]string{"channel": id, "ts": old}, nil); !isSlackError(err, "message_not_found") {
+		t.Errorf("Expected deleting a deleted message to fail with message_not_found, but got %v", err)
+	}
+	if messages := s.Messages(id); len(messages) != 1 || messages[0].Text != "hi" {
+		t.Errorf("Expected only the posted message to remain, but got %#v", messages)
+	}
+
+	requests := s.RequestsFor("chat.postMessage")
+	if len(requests) != 1 || requests[0].Args["as_user"] != "true" || requests[0].Args["text"] != "hi" {
+		t.Errorf("Expected a recorded chat.postMessage request, but got %#v", requests)
+	}
+}
+
+func TestUpdateMessage(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := s.Client()
+	id := s.AddChannel(slack.Conversation{Name: "general"})
+	ts := s.AddMessage(id, slack.Message{User: "U11111111", Text: "hi"})
+
+	if err := c.CallMethod("chat.update", map[string]string{"channel": id, "ts": ts, "text": "hi there"}, nil); err != nil {
+		t.Fatalf("Failed to update message: %v", err)
+	}
+	if messages := s.Messages(id); len(messages) != 1 || messages[0].Text != "hi there" || messages[0].TS != ts {
+		t.Errorf("Expected the message to be updated in place, but got %#v", messages)
+	}
+	if err := c.CallMethod("chat.update", map[string]string{"channel": id, "ts": "1.000000", "text": "hello"}, nil); !isSlackError(err, "message_not_found") {
+		t.Errorf("Expected updating a missing message to fail with message_not_found, but got %v", err)
+	}
+}
+
+func TestBookmarks(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := s.Client()
+	id := s.AddChannel(slack.Conversation{Name: "general"})
+
+	ret := struct {
+		Bookmark slack.Bookmark `json:"bookmark"`
+	}{}
+	if err := c.CallMethod("bookmarks.add", map[string]string{"channel_id": id, "title": "Docs", "type": "link", "link": "https://example.com"}, &ret); err != nil {
+		t.Fatalf("Failed to add bookmark: %v", err)
+	}
+	if err := c.CallMethod("bookmarks.edit", map[string]string{"channel_id": id, "bookmark_id": ret.Bookmark.ID, "title": "Documentation"}, nil); err != nil {
+		t.Fatalf("Failed to edit bookmark: %v", err)
+	}
+	bookmarks, err := c.GetBookmarks(id)
+	if err != nil {
+		t.Fatalf("Failed to list bookmarks: %v", err)
+	}
+	if len(bookmarks) != 1 || bookmarks[0].ID != ret.Bookmark.ID || bookmarks[0].Title != "Documentation" || bookmarks[0].Link != "https://example.com" {
+		t.Errorf("Expected the edited bookmark, but got %#v", bookmarks)
+	}
+
+	if err := c.CallMethod("bookmarks.remove", map[string]string{"channel_id": id, "bookmark_id": ret.Bookmark.ID}, nil); err != nil {
+		t.Fatalf("Failed to remove bookmark: %v", err)
+	}
+	if err := c.CallMethod("bookmarks.remove", map[string]string{"channel_id": id, "bookmark_id": ret.Bookmark.ID}, nil); !isSlackError(err, "not_found") {
+		t.Errorf("Expected removing a removed bookmark to fail with not_found, but got %v", err)
+	}
+	if bookmarks := s.Bookmarks(id); len(bookmarks) != 0 {
+		t.Errorf("Expected no bookmarks to remain, but got %#v", bookmarks)
+	}
+}
+
+func TestUsergroups(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := s.Client()
+
+	ret := struct {
+		Usergroup slack.Subteam `json:"usergroup"`
+	}{}
+	if err := c.CallMethod("usergroups.create", map[string]string{"name": "Pony Fans", "handle": "pony-fans", "description": "Fans of ponies"}, &ret); err != nil {
+		t.Fatalf("Failed to create usergroup: %v", err)
+	}
+	if err := c.CallMethod("usergroups.users.update", map[string]string{"usergroup": ret.Usergroup.ID, "users": "U11111111,U22222222"}, nil); err != nil {
+		t.Fatalf("Failed to update usergroup members: %v", err)
+	}
+	if err := c.CallMethod("usergroups.disable", map[string]string{"usergroup": ret.Usergroup.ID}, nil); err != nil {
+		t.Fatalf("Failed to disable usergroup: %v", err)
+	}
+
+	g, ok := s.Usergroup("pony-fans")
+	if !ok {
+		t.Fatalf("Expected to find usergroup")
+	}
+	if g.DeleteTime == 0 || !reflect.DeepEqual(g.Users, []string{"U11111111", "U22222222"}) {
+		t.Errorf("Expected a disabled usergroup with two members, but got %#v", g)
+	}
+
+	list := struct {
+		Usergroups []slack.Subteam `json:"usergroups"`
+	}{}
+	if err := c.CallOldMethod("usergroups.list", map[string]string{}, &list); err != nil {
+		t.Fatalf("Failed to list usergroups: %v", err)
+	}
+	if len(list.Usergroups) != 0 {
+		t.Errorf("Expected disabled usergroups not to be listed by default, but got %#v", list.Usergroups)
+	}
+}
+
+func TestSearchAndDeleteFiles(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := s.Client()
+	s.AddFile(File{ID: "F1", User: "U11111111", Created: 100})
+	s.AddFile(File{ID: "F2", User: "U22222222", Created: 200})
+	s.AddFile(File{ID: "F3", User: "U11111111", Created: 300})
+
+	result := struct {
+		Files struct {
+			Matches    []File `json:"matches"`
+			Pagination struct {
+				PageCount int `json:"page_count"`
+			} `json:"pagination"`
+		} `json:"files"`
+	}{}
+	if err := c.CallOldMethod("search.files", map[string]string{"query": "from:<@U11111111> after:2019-01-01", "count": "1"}, &result); err != nil {
+		t.Fatalf("Failed to search files: %v", err)
+	}
+	if len(result.Files.Matches) != 1 || result.Files.Matches[0].ID != "F3" || result.Files.Pagination.PageCount != 2 {
+		t.Errorf("Expected the first of two pages to hold F3, but got %#v", result.Files)
+	}
+
+	if err := c.CallMethod("files.delete", map[string]string{"file": "F3"}, nil); err != nil {
+		t.Fatalf("Failed to delete file: %v", err)
+	}
+	if files, expected := s.Files(), []File{{ID: "F1", User: "U11111111", Created: 100}, {ID: "F2", User: "U22222222", Created: 200}}; !reflect.DeepEqual(files, expected) {
+		t.Errorf("Expected files %v, but got %v", expected, files)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := s.Client()
+	s.AddUser(slack.User{ID: "U11111111", Name: "katharine"})
+
+	s.FailNext("users.info", "ratelimited")
+	s.RateLimitNext("users.info", 3)
+	args := map[string]string{"user": "U11111111"}
+	if err := c.CallOldMethod("users.info", args, nil); !isSlackError(err, "ratelimited") {
+		t.Errorf("Expected injected error, but got %v", err)
+	}
+	if err, ok := c.CallOldMethod("users.info", args, nil).(slack.ErrRateLimit); !ok || err.Wait.Seconds() != 3 {
+		t.Errorf("Expected to be rate limited for 3 seconds, but got %v", err)
+	}
+	ret := struct {
+		User slack.User `json:"user"`
+	}{}
+	if err := c.CallOldMethod("users.info", args, &ret); err != nil || ret.User.Name != "katharine" {
+		t.Errorf("Expected the call to succeed once the faults were used up, but got %v (%#v)", err, ret.User)
+	}
+	if n := len(s.Requests()); n != 3 {
+		t.Errorf("Expected 3 recorded requests, but got %d", n)
+	}
+
+	unauthed := slack.New(slack.Config{APIURL: s.URL()})
+	if err := unauthed.CallOldMethod("users.info", args, nil); !isSlackError(err, "not_authed") {
+		t.Errorf("Expected a call without a token to fail with not_authed, but got %v", err)
+	}
+}
+
+func TestSCIM(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c := s.SCIMClient()
+	s.AddUser(slack.User{ID: "U11111111", Name: "katharine"})
+	s.AddUser(slack.User{ID: "U22222222", Name: "spammer"})
+	group := s.AddUsergroup(slack.Subteam{Name: "Moderators", Handle: "moderators", Users: []string{"U11111111"}})
+
+	user, err := c.SetUserActive("U22222222", false)
+	if err != nil || user.Active {
+		t.Fatalf("Expected the user to be deactivated, but got %#v (%v)", user, err)
+	}
+	if u, _ := s.User("U22222222"); !u.Deleted {
+		t.Errorf("Expected the deactivated user to be deleted from the workspace")
+	}
+	if user, err := c.SetUserActive("U22222222", true); err != nil || !user.Active {
+		t.Errorf("Expected the user to be reactivated, but got %#v (%v)", user, err)
+	}
+
+	if err := c.AddGroupMembers(group, "U22222222"); err != nil {
+		t.Fatalf("Failed to add group member: %v", err)
+	}
+	if err := c.RemoveGroupMembers(group, "U11111111"); err != nil {
+		t.Fatalf("Failed to remove group member: %v", err)
+	}
+	g, err := c.GetGroup(group)
+	if expected := []slack.SCIMValue{{Value: "U22222222"}}; err != nil || !reflect.DeepEqual(g.Members, expected) {
+		t.Errorf("Expected members %v, but got %v (%v)", expected, g.Members, err)
+	}
+	if err := c.DeleteUser("U22222222"); err != nil {
+		t.Fatalf("Failed to delete user: %v", err)
+	}
+	if user, err := c.GetUser("U22222222"); err != nil || user.Active || len(user.Groups) != 0 {
+		t.Errorf("Expected a deleted user to be inactive and in no groups, but got %#v (%v)", user, err)
+	}
+
+	if _, err := c.GetUser("U99999999"); !isSCIMError(err, 404) {
+		t.Errorf("Expected getting an unknown user to fail with status 404, but got %v", err)
+	}
+	s.FailNext("PATCH Users/U11111111", "insufficient_permissions")
+	if _, err := c.SetUserActive("U11111111", false); !isSCIMError(err, 400) || err.(slack.ErrSCIM).Detail != "insufficient_permissions" {
+		t.Errorf("Expected injected error, but got %v", err)
+	}
+	s.RateLimitNext("GET Users/U11111111", 0)
+	if user, err := c.GetUser("U11111111"); err != nil || !user.Active {
+		t.Errorf("Expected the client to wait out the rate limit, but got %#v (%v)", user, err)
+	}
+	if n := len(s.RequestsFor("GET Users/U11111111")); n != 2 {
+		t.Errorf("Expected 2 requests for the rate limited user, but got %d", n)
+	}
+
+	unauthed := slack.NewSCIM(slack.SCIMConfig{URL: s.SCIMURL()})
+	if _, err := unauthed.GetUser("U11111111"); !isSCIMError(err, 401) {
+		t.Errorf("Expected a call without a token to fail with status 401, but got %v", err)
+	}
+}
+
+func isSCIMError(err error, status int) bool {
+	e, ok := err.(slack.ErrSCIM)
+	return ok && e.StatusCode == status
+}
+
+func isSlackError(err error, errType string) bool {
+	e, ok := err.(slack.ErrSlack)
+	return ok && e.Type == errType
+}
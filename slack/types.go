@@ -115,3 +115,28 @@ type Conversation struct {
 	NumMembers    int      `json:"num_members"`
 	Locale        string   `json:"locale"`
 }
+
+// Bookmark represents a slack Bookmark object.
+type Bookmark struct {
+	ID        string `json:"id"`
+	ChannelID string `json:"channel_id"`
+	Title     string `json:"title"`
+	Link      string `json:"link"`
+	Emoji     string `json:"emoji"`
+	Type      string `json:"type"`
+	Created   int64  `json:"date_created"`
+	Updated   int64  `json:"date_updated"`
+}
+
+// Message represents a slack Message object, as returned by conversations.history.
+type Message struct {
+	Type        string `json:"type"`
+	Subtype     string `json:"subtype,omitempty"`
+	User        string `json:"user,omitempty"`
+	BotID       string `json:"bot_id,omitempty"`
+	Text        string `json:"text"`
+	TS          string `json:"ts"`
+	ThreadTS    string `json:"thread_ts,omitempty"`
+	ReplyCount  int    `json:"reply_count,omitempty"`
+	LatestReply string `json:"latest_reply,omitempty"`
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"sigs.k8s.io/slack-infra/tempelis/config"
+)
+
+// runCheckApprovals implements `tempelis check-approvals`, which reports which changed config files
+// have not been approved by their owners, and exits unsuccessfully if there are any.
+func runCheckApprovals(args []string) {
+	fs := flag.NewFlagSet("check-approvals", flag.ExitOnError)
+	restrictions := fs.String("restrictions", "", "path to a configuration file containing restrictions")
+	approvers := fs.String("approvers", "", "comma-separated list of the handles of everyone who has approved the change")
+	prefix := fs.String("prefix", "", "path of the config root relative to the changed file paths; other files are ignored")
+	fs.Usage = func() {
+		log.Printf("Usage: %s check-approvals [flags] [changed files...]\n", os.Args[0])
+		log.Println("If no changed files are given, they are read from stdin, one per line.")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if *restrictions == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	p := config.NewParser()
+	if err := p.ParseFile(*restrictions, path.Dir(*restrictions)); err != nil {
+		log.Fatalf("Failed to parse restrictions file: %v\n", err)
+	}
+
+	changed := fs.Args()
+	if len(changed) == 0 {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if l := strings.TrimSpace(scanner.Text()); l != "" {
+				changed = append(changed, l)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			log.Fatalf("Failed to read changed files: %v\n", err)
+		}
+	}
+
+	var relevant []string
+	for _, f := range changed {
+		if *prefix != "" {
+			root := strings.TrimSuffix(*prefix, "/") + "/"
+			if !strings.HasPrefix(f, root) {
+				continue
+			}
+			f = strings.TrimPrefix(f, root)
+		}
+		relevant = append(relevant, f)
+	}
+
+	var handles []string
+	if *approvers != "" {
+		handles = strings.Split(*approvers, ",")
+	}
+
+	unapproved := 0
+	for _, a := range config.CheckApprovals(p.Config.Restrictions, relevant, handles) {
+		if a.Approved() {
+			fmt.Printf("%s: approved by %s\n", a.Path, strings.Join(a.ApprovedBy, ", "))
+			continue
+		}
+		unapproved++
+		if len(a.Owners) == 0 {
+			fmt.Printf("%s: needs approval from anyone\n", a.Path)
+		} else {
+			fmt.Printf("%s: needs approval from one of %s\n", a.Path, strings.Join(a.Owners, ", "))
+		}
+	}
+	if unapproved > 0 {
+		log.Fatalf("%d changed files lack approval.\n", unapproved)
+	}
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"strings"
+)
+
+// Approval describes whether a change to a single config file has been approved by its owners.
+type Approval struct {
+	Path string
+	// Owners are the owners of the restrictions that apply to Path. If there are none, anyone may
+	// approve the change.
+	Owners []string
+	// ApprovedBy are the approvers who may approve the change.
+	ApprovedBy []string
+}
+
+// Approved returns whether the change has been approved by someone permitted to approve it.
+func (a Approval) Approved() bool {
+	return len(a.ApprovedBy) > 0
+}
+
+// CheckApprovals works out, for each changed path, whether any of approvers owns it according to
+// restrictions. Paths are relative to the root of the config tree, just like the paths that
+// restrictions are matched against. Handles are compared case-insensitively, ignoring any leading
+// "@".
+func CheckApprovals(restrictions []Restrictions, changed []string, approvers []string) []Approval {
+	approvals := make([]Approval, 0, len(changed))
+	for _, path := range changed {
+		r := resolveRestrictions(restrictions, path)
+		a := Approval{Path: path, Owners: r.Owners}
+		for _, approver := range approvers {
+			if len(r.Owners) == 0 || containsHandle(r.Owners, approver) {
+				a.ApprovedBy = append(a.ApprovedBy, approver)
+			}
+		}
+		approvals = append(approvals, a)
+	}
+	return approvals
+}
+
+func containsHandle(handles []string, handle string) bool {
+	for _, h := range handles {
+		if normaliseHandle(h) == normaliseHandle(handle) {
+			return true
+		}
+	}
+	return false
+}
+
+func normaliseHandle(h string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(h), "@"))
+}
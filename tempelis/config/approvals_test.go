@@ -0,0 +1,85 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheckApprovals(t *testing.T) {
+	restrictions := []Restrictions{
+		{Path: "sig-testing/*.yaml", Owners: []string{"@Katharine", "bentheelder"}},
+		{Path: "sig-release/*.yaml"},
+		{Path: "**/*", Owners: []string{"slack-admins"}},
+	}
+
+	tests := []struct {
+		name      string
+		changed   []string
+		approvers []string
+		expected  []Approval
+	}{
+		{
+			name:      "an owner's approval counts",
+			changed:   []string{"sig-testing/channels.yaml"},
+			approvers: []string{"bentheelder"},
+			expected:  []Approval{{Path: "sig-testing/channels.yaml", Owners: []string{"@Katharine", "bentheelder"}, ApprovedBy: []string{"bentheelder"}}},
+		},
+		{
+			name:      "handles are compared ignoring case and @",
+			changed:   []string{"sig-testing/channels.yaml"},
+			approvers: []string{"@katharine"},
+			expected:  []Approval{{Path: "sig-testing/channels.yaml", Owners: []string{"@Katharine", "bentheelder"}, ApprovedBy: []string{"@katharine"}}},
+		},
+		{
+			name:      "a non-owner's approval doesn't count",
+			changed:   []string{"sig-testing/channels.yaml"},
+			approvers: []string{"spiffxp"},
+			expected:  []Approval{{Path: "sig-testing/channels.yaml", Owners: []string{"@Katharine", "bentheelder"}}},
+		},
+		{
+			name:      "the first matching restriction decides the owners",
+			changed:   []string{"sig-testing/channels.yaml", "users.yaml"},
+			approvers: []string{"slack-admins"},
+			expected: []Approval{
+				{Path: "sig-testing/channels.yaml", Owners: []string{"@Katharine", "bentheelder"}},
+				{Path: "users.yaml", Owners: []string{"slack-admins"}, ApprovedBy: []string{"slack-admins"}},
+			},
+		},
+		{
+			name:      "anyone may approve files with no owners",
+			changed:   []string{"sig-release/channels.yaml"},
+			approvers: []string{"spiffxp"},
+			expected:  []Approval{{Path: "sig-release/channels.yaml", ApprovedBy: []string{"spiffxp"}}},
+		},
+		{
+			name:     "files with no owners still need an approval",
+			changed:  []string{"sig-release/channels.yaml"},
+			expected: []Approval{{Path: "sig-release/channels.yaml"}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := CheckApprovals(restrictions, tc.changed, tc.approvers)
+			if !reflect.DeepEqual(actual, tc.expected) {
+				t.Errorf("Expected approvals %#v, got %#v", tc.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ChannelSetPlaceholder is replaced by each of a ChannelSet's values to produce channel names.
+const ChannelSetPlaceholder = "{}"
+
+// ChannelSet describes a family of channels, one for every combination of name pattern and value.
+// For example, the names ["sig-{}", "sig-{}-leads"] with the values ["testing", "release"] describe
+// four channels. The other fields apply to every channel in the set, and may also contain the
+// placeholder.
+type ChannelSet struct {
+	Names      []string `json:"names" desc:"Channel name patterns, each containing {}." required:"true"`
+	Values     []string `json:"values" desc:"The values substituted for {} in each name pattern." required:"true"`
+	Topic      string   `json:"topic,omitempty" desc:"The topic set when each channel is created. May contain {}."`
+	Purpose    string   `json:"purpose,omitempty" desc:"The purpose set when each channel is created. May contain {}."`
+	Moderators []string `json:"moderators,omitempty" desc:"Names of the moderators of every channel."`
+	Archived   bool     `json:"archived,omitempty" desc:"Whether every channel is archived."`
+	Workspaces []string `json:"workspaces,omitempty" desc:"The workspaces every channel belongs to."`
+}
+
+// expandChannelSets returns the channels described by sets, in order.
+func expandChannelSets(sets []ChannelSet) ([]Channel, error) {
+	var channels []Channel
+	for i, s := range sets {
+		if len(s.Names) == 0 {
+			return nil, fmt.Errorf("channel set %d has no names", i+1)
+		}
+		if len(s.Values) == 0 {
+			return nil, fmt.Errorf("channel set %d has no values", i+1)
+		}
+		for _, n := range s.Names {
+			if !strings.Contains(n, ChannelSetPlaceholder) {
+				return nil, fmt.Errorf("channel set name %q does not contain %q", n, ChannelSetPlaceholder)
+			}
+		}
+		for _, v := range s.Values {
+			if v == "" {
+				return nil, fmt.Errorf("channel set %d has an empty value", i+1)
+			}
+			for _, n := range s.Names {
+				channels = append(channels, Channel{
+					Name:       expandChannelSetPattern(n, v),
+					Topic:      expandChannelSetPattern(s.Topic, v),
+					Purpose:    expandChannelSetPattern(s.Purpose, v),
+					Moderators: s.Moderators,
+					Archived:   s.Archived,
+					Workspaces: s.Workspaces,
+				})
+			}
+		}
+	}
+	return channels, nil
+}
+
+func expandChannelSetPattern(pattern, value string) string {
+	return strings.Replace(pattern, ChannelSetPlaceholder, value, -1)
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandChannelSets(t *testing.T) {
+	tests := []struct {
+		name      string
+		sets      []ChannelSet
+		expected  []Channel
+		expectErr bool
+	}{
+		{
+			name: "no sets produce no channels",
+		},
+		{
+			name: "every name is expanded for every value",
+			sets: []ChannelSet{{Names: []string{"sig-{}", "sig-{}-leads"}, Values: []string{"testing", "release"}}},
+			expected: []Channel{
+				{Name: "sig-testing"},
+				{Name: "sig-testing-leads"},
+				{Name: "sig-release"},
+				{Name: "sig-release-leads"},
+			},
+		},
+		{
+			name: "defaults apply to every channel",
+			sets: []ChannelSet{{
+				Names:      []string{"sig-{}"},
+				Values:     []string{"testing", "release"},
+				Topic:      "SIG {} discussion",
+				Purpose:    "For SIG {}",
+				Moderators: []string{"Katharine"},
+				Archived:   true,
+			}},
+			expected: []Channel{
+				{Name: "sig-testing", Topic: "SIG testing discussion", Purpose: "For SIG testing", Moderators: []string{"Katharine"}, Archived: true},
+				{Name: "sig-release", Topic: "SIG release discussion", Purpose: "For SIG release", Moderators: []string{"Katharine"}, Archived: true},
+			},
+		},
+		{
+			name:      "a set with no names is an error",
+			sets:      []ChannelSet{{Values: []string{"testing"}}},
+			expectErr: true,
+		},
+		{
+			name:      "a set with no values is an error",
+			sets:      []ChannelSet{{Names: []string{"sig-{}"}}},
+			expectErr: true,
+		},
+		{
+			name:      "a name without a placeholder is an error",
+			sets:      []ChannelSet{{Names: []string{"sig-testing"}, Values: []string{"testing"}}},
+			expectErr: true,
+		},
+		{
+			name:      "an empty value is an error",
+			sets:      []ChannelSet{{Names: []string{"sig-{}"}, Values: []string{""}}},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			channels, err := expandChannelSets(tc.sets)
+			if err != nil {
+				if !tc.expectErr {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if tc.expectErr {
+				t.Fatalf("expected an error, but got result %#v", channels)
+			}
+			if !reflect.DeepEqual(channels, tc.expected) {
+				t.Fatalf("Expected channels %#v, got %#v", tc.expected, channels)
+			}
+		})
+	}
+}
+
+func TestParseChannelSets(t *testing.T) {
+	const restrictions = `
+restrictions:
+- path: sig-testing.yaml
+  channels: ["^sig-testing"]
+`
+	tests := []struct {
+		name      string
+		config    string
+		expected  []Channel
+		expectErr bool
+	}{
+		{
+			name: "generated channels are merged with listed channels",
+			config: `
+channels:
+- name: sig-testing-misc
+channel_sets:
+- names: ["sig-{}", "sig-{}-leads"]
+  values: ["testing"]
+`,
+			expected: []Channel{{Name: "sig-testing-misc"}, {Name: "sig-testing"}, {Name: "sig-testing-leads"}},
+		},
+		{
+			name: "generated channels are subject to restrictions",
+			config: `
+channel_sets:
+- names: ["sig-{}"]
+  values: ["testing", "release"]
+`,
+			expectErr: true,
+		},
+		{
+			name: "generated channels can't duplicate listed channels",
+			config: `
+channels:
+- name: sig-testing
+channel_sets:
+- names: ["sig-{}"]
+  values: ["testing"]
+`,
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p := NewParser()
+			if err := p.Parse(strings.NewReader(restrictions), "restrictions.yaml"); err != nil {
+				t.Fatalf("failed to parse restrictions: %v", err)
+			}
+			err := p.Parse(strings.NewReader(tc.config), "sig-testing.yaml")
+			if err != nil {
+				if !tc.expectErr {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if tc.expectErr {
+				t.Fatalf("expected an error, but got channels %#v", p.Config.Channels)
+			}
+			if !reflect.DeepEqual(p.Config.Channels, tc.expected) {
+				t.Fatalf("Expected channels %#v, got %#v", tc.expected, p.Config.Channels)
+			}
+			if len(p.Config.ChannelSets) != 0 {
+				t.Errorf("Expected channel sets to be expanded away, but got %#v", p.Config.ChannelSets)
+			}
+		})
+	}
+}
@@ -23,44 +23,114 @@ import (
 )
 
 type Config struct {
-	Users           map[string]string `json:"users"`
-	Channels        []Channel         `json:"channels"`
-	Usergroups      []Usergroup       `json:"usergroups"`
-	ChannelTemplate ChannelTemplate   `json:"channel_template,omitempty"`
-	Restrictions    []Restrictions    `json:"restrictions"`
+	Users    map[string]string `json:"users" desc:"Maps user names, as used elsewhere in the config, to Slack user IDs." pattern:"^[UW][A-Z0-9]{8,}$"`
+	Channels []Channel         `json:"channels" desc:"The complete list of public channels."`
+	// ChannelSets are expanded into Channels during parsing, and are always empty afterwards.
+	ChannelSets     []ChannelSet    `json:"channel_sets,omitempty" desc:"Families of channels generated from name patterns."`
+	Usergroups      []Usergroup     `json:"usergroups" desc:"The usergroups Tempelis manages."`
+	ChannelTemplate ChannelTemplate `json:"channel_template,omitempty" desc:"Topic, purpose and pinned messages for newly created channels."`
+	Workspaces      []Workspace     `json:"workspaces,omitempty" desc:"The Slack workspaces to manage. If absent, only the workspace given by --auth is managed."`
+	Restrictions    []Restrictions  `json:"restrictions" desc:"Limits on what each file in the config tree may define. The first matching entry applies."`
+	// Emoji maps emoji names to either "alias:" followed by the name of another emoji, an image, or
+	// EmojiRemoved if the emoji should be removed. In config files, image paths are relative to the file; after parsing, they are relative to
+	// the root of the config tree.
+	Emoji map[string]string `json:"emoji,omitempty" keypattern:"^[a-z0-9_+'-]+$" desc:"Maps custom emoji names to an image path relative to this file, to alias: followed by another emoji name, or to removed to remove the emoji."`
 }
 
 type Restrictions struct {
-	Path             string   `json:"path"`
-	Users            bool     `json:"users"`
-	ChannelsString   []string `json:"channels"`
-	UsergroupsString []string `json:"usergroups"`
-	Template         bool     `json:"template"`
+	Path             string   `json:"path" desc:"A glob matching the config files these restrictions apply to." required:"true"`
+	Users            bool     `json:"users" desc:"Whether matching files may define users."`
+	ChannelsString   []string `json:"channels" desc:"Regexes matching the channels that matching files may define."`
+	UsergroupsString []string `json:"usergroups" desc:"Regexes matching the usergroups that matching files may define."`
+	Template         bool     `json:"template" desc:"Whether matching files may define the channel template."`
+	EmojiString      []string `json:"emoji" desc:"Regexes matching the emoji that matching files may define."`
+	Workspaces       bool     `json:"workspaces" desc:"Whether matching files may define workspaces."`
+	Owners           []string `json:"owners,omitempty" desc:"GitHub or Slack handles of the people who may approve changes to matching files."`
+	// These default to permitting everything, unlike the fields above, so that adding them didn't
+	// restrict existing configs.
+	ArchiveChannels      *bool    `json:"archive_channels,omitempty" desc:"Whether matching files may archive or unarchive channels. Defaults to true."`
+	RenameChannels       *bool    `json:"rename_channels,omitempty" desc:"Whether matching files may rename channels. Defaults to true."`
+	MaxUsergroupSize     int      `json:"max_usergroup_size,omitempty" desc:"The most members a usergroup defined in matching files may have. Defaults to unlimited."`
+	UsergroupMembersFrom []string `json:"usergroup_members_from,omitempty" desc:"If set, usergroups defined in matching files may only contain members of these usergroups."`
 
 	Channels   []*regexp.Regexp
 	Usergroups []*regexp.Regexp
+	Emoji      []*regexp.Regexp
 }
 
 type Channel struct {
-	Name       string   `json:"name"`
-	ID         string   `json:"id,omitempty"`
-	Archived   bool     `json:"archived,omitempty"`
-	Moderators []string `json:"moderators,omitempty"`
+	Name       string   `json:"name" desc:"The channel name." pattern:"^[a-z0-9_-]{1,80}$" required:"true"`
+	ID         string   `json:"id,omitempty" desc:"The channel's Slack ID, required to rename it." pattern:"^[CG][A-Z0-9]+$"`
+	Archived   bool     `json:"archived,omitempty" desc:"Whether the channel is archived."`
+	Moderators []string `json:"moderators,omitempty" desc:"Names of the channel's moderators."`
+	// Topic and Purpose are set when the channel is created, in preference to the channel template.
+	Topic   string `json:"topic,omitempty" desc:"The topic set when the channel is created."`
+	Purpose string `json:"purpose,omitempty" desc:"The purpose set when the channel is created."`
+	// ExemptFromStaleness stops the channel from being proposed for archiving when it's quiet.
+	ExemptFromStaleness bool     `json:"exempt_from_staleness,omitempty" desc:"Never report the channel as stale."`
+	Workspaces          []string `json:"workspaces,omitempty" desc:"The workspaces the channel belongs to. Defaults to the default workspaces."`
+	// RedirectFrom are old names of the channel. Tempelis keeps an archived channel under each of
+	// them, containing a pinned message pointing to this one.
+	RedirectFrom []string `json:"redirect_from,omitempty" desc:"Old names of the channel, under which archived placeholder channels pointing to it are kept."`
+	// Bookmarks is the complete list of bookmarks the channel should have. If it is nil, the
+	// channel's bookmarks are left alone.
+	Bookmarks []Bookmark `json:"bookmarks,omitempty" desc:"The complete list of the channel's bookmarks. If absent, bookmarks are left alone."`
+
+	// ArchiveForbidden and RenameForbidden are set when the file defining the channel, DefinedIn,
+	// may not archive or unarchive it, or rename it. Whether the config would do either depends on
+	// the channel's current state, so the reconciler checks them.
+	ArchiveForbidden bool   `json:"-"`
+	RenameForbidden  bool   `json:"-"`
+	DefinedIn        string `json:"-"`
+}
+
+type Bookmark struct {
+	Title string `json:"title" desc:"The bookmark title, which must be unique within the channel." required:"true"`
+	Link  string `json:"link" desc:"The bookmarked URL." pattern:"^https?://" required:"true"`
+	Emoji string `json:"emoji,omitempty" desc:"An emoji to show next to the bookmark."`
 }
 
 type Usergroup struct {
-	Name        string   `json:"name,omitempty"`
-	LongName    string   `json:"long_name,omitempty"`
-	Members     []string `json:"members,omitempty"`
-	Channels    []string `json:"channels,omitempty"`
-	Description string   `json:"description,omitempty"`
-	External    bool     `json:"external,omitempty"`
+	Name        string   `json:"name,omitempty" desc:"The usergroup handle, without the @." pattern:"^[a-z0-9._-]+$" required:"true"`
+	LongName    string   `json:"long_name,omitempty" desc:"The usergroup's display name."`
+	Members     []string `json:"members,omitempty" desc:"Names of the usergroup's members."`
+	Channels    []string `json:"channels,omitempty" desc:"The usergroup's default channels."`
+	Description string   `json:"description,omitempty" desc:"The usergroup description."`
+	External    bool     `json:"external,omitempty" desc:"Whether the usergroup is managed by something other than Tempelis."`
+	// Manage lists the fields of an external usergroup that Tempelis manages anyway.
+	Manage   []string  `json:"manage,omitempty" desc:"For external usergroups, which of long_name, description and channels Tempelis manages anyway. Membership is always left alone." pattern:"^(long_name|description|channels)$"`
+	Rotation *Rotation `json:"rotation,omitempty" desc:"An on-call rotation that determines the usergroup's members."`
+
+	EnforceChannelMembership bool `json:"enforce_channel_membership,omitempty" desc:"Invite every member to the usergroup's channels."`
+	KickRemovedMembers       bool `json:"kick_removed_members,omitempty" desc:"Remove people from the usergroup's channels when they leave the usergroup."`
+
+	Workspaces []string `json:"workspaces,omitempty" desc:"The workspaces the usergroup is provisioned in. Defaults to the default workspaces."`
+}
+
+// These are the fields of external usergroups that can be managed.
+const (
+	ManageLongName    = "long_name"
+	ManageDescription = "description"
+	ManageChannels    = "channels"
+)
+
+// Manages returns whether Tempelis manages field of g.
+func (g Usergroup) Manages(field string) bool {
+	if !g.External {
+		return true
+	}
+	for _, f := range g.Manage {
+		if f == field {
+			return true
+		}
+	}
+	return false
 }
 
 type ChannelTemplate struct {
-	Pins    []string `json:"pins,omitempty"`
-	Topic   string   `json:"topic,omitempty"`
-	Purpose string   `json:"purpose,omitempty"`
+	Pins    []string `json:"pins,omitempty" desc:"Messages to post and pin in new channels."`
+	Topic   string   `json:"topic,omitempty" desc:"The topic of new channels."`
+	Purpose string   `json:"purpose,omitempty" desc:"The purpose of new channels."`
 }
 
 // NamesToIDs converts a list of names to a list of slack user IDs
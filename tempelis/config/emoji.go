@@ -0,0 +1,110 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"image"
+	_ "image/gif"  // register GIF decoding
+	_ "image/jpeg" // register JPEG decoding
+	_ "image/png"  // register PNG decoding
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// EmojiAliasPrefix marks an emoji definition as an alias for another emoji.
+	EmojiAliasPrefix = "alias:"
+	// EmojiRemoved is the definition of an emoji that should be removed.
+	EmojiRemoved = "removed"
+
+	// These are the limits Slack enforces on uploaded emoji.
+	maxEmojiBytes     = 128 * 1024
+	maxEmojiDimension = 128
+)
+
+var emojiNameRegexp = regexp.MustCompile(`^[a-z0-9_+'-]+$`)
+
+// IsEmojiAlias returns whether the emoji definition v is an alias, and if so what it's an alias for.
+func IsEmojiAlias(v string) (string, bool) {
+	if !strings.HasPrefix(v, EmojiAliasPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(v, EmojiAliasPrefix), true
+}
+
+// mergeEmoji merges the emoji in source into target. Image paths in source are relative to dir,
+// which is in turn relative to root; they are stored in target relative to root.
+func mergeEmoji(target map[string]string, source map[string]string, r Restrictions, root, dir string) error {
+	for name, v := range source {
+		if !emojiNameRegexp.MatchString(name) {
+			return fmt.Errorf("%q is not a valid emoji name", name)
+		}
+		if !matchesRegexList(name, r.Emoji) {
+			return fmt.Errorf("cannot define emoji %q in %q", name, r.Path)
+		}
+		if _, ok := target[name]; ok {
+			return fmt.Errorf("cannot overwrite emoji (duplicate emoji %s)", name)
+		}
+		if v == EmojiRemoved {
+			target[name] = v
+			continue
+		}
+		if alias, ok := IsEmojiAlias(v); ok {
+			if alias == "" {
+				return fmt.Errorf("emoji %s is an alias for nothing", name)
+			}
+			target[name] = v
+			continue
+		}
+		if clean := filepath.ToSlash(filepath.Clean(v)); filepath.IsAbs(v) || clean == ".." || strings.HasPrefix(clean, "../") {
+			return fmt.Errorf("emoji %s: image path %q must be inside the directory of the file defining it", name, v)
+		}
+		rel := strings.TrimPrefix(filepath.ToSlash(filepath.Join(dir, v)), "/")
+		if err := validateEmojiImage(filepath.Join(root, rel)); err != nil {
+			return fmt.Errorf("emoji %s: %v", name, err)
+		}
+		target[name] = rel
+	}
+	return nil
+}
+
+// validateEmojiImage checks that the image at path is one that Slack would accept as an emoji.
+func validateEmojiImage(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("couldn't open image: %v", err)
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("couldn't stat image: %v", err)
+	}
+	if stat.Size() > maxEmojiBytes {
+		return fmt.Errorf("%s is %d bytes, but emoji can be at most %d bytes", path, stat.Size(), maxEmojiBytes)
+	}
+	c, format, err := image.DecodeConfig(f)
+	if err != nil {
+		return fmt.Errorf("%s is not a PNG, GIF, or JPEG image: %v", path, err)
+	}
+	if c.Width > maxEmojiDimension || c.Height > maxEmojiDimension {
+		return fmt.Errorf("%s is a %dx%d %s, but emoji can be at most %dx%d", path, c.Width, c.Height, format, maxEmojiDimension, maxEmojiDimension)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func writePNG(t *testing.T, path string, size int) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Failed to create directory for %s: %v", path, err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create %s: %v", path, err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, image.NewGray(image.Rect(0, 0, size, size))); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+}
+
+func TestMergeEmoji(t *testing.T) {
+	root, err := ioutil.TempDir("", "tempelis-emoji")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+	writePNG(t, filepath.Join(root, "sig-testing", "emoji", "prow.png"), 64)
+	writePNG(t, filepath.Join(root, "sig-testing", "emoji", "huge.png"), 512)
+	writePNG(t, filepath.Join(root, "sig-testing", "..prow.png"), 64)
+	if err := ioutil.WriteFile(filepath.Join(root, "sig-testing", "emoji", "text.png"), []byte("not an image"), 0644); err != nil {
+		t.Fatalf("Failed to write text.png: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		a            map[string]string
+		b            map[string]string
+		restrictions Restrictions
+		expected     map[string]string
+		expectErr    bool
+	}{
+		{
+			name:         "images are resolved relative to the config root",
+			a:            map[string]string{},
+			b:            map[string]string{"prow": "emoji/prow.png"},
+			restrictions: defaultRestriction,
+			expected:     map[string]string{"prow": "sig-testing/emoji/prow.png"},
+		},
+		{
+			name:         "aliases are kept as-is",
+			a:            map[string]string{"prow": "sig-testing/emoji/prow.png"},
+			b:            map[string]string{"prow-ship": "alias:prow"},
+			restrictions: defaultRestriction,
+			expected:     map[string]string{"prow": "sig-testing/emoji/prow.png", "prow-ship": "alias:prow"},
+		},
+		{
+			name:         "removals are kept as-is",
+			a:            map[string]string{},
+			b:            map[string]string{"old-prow": "removed"},
+			restrictions: defaultRestriction,
+			expected:     map[string]string{"old-prow": "removed"},
+		},
+		{
+			name:         "removals not matching restrictions are an error",
+			a:            map[string]string{},
+			b:            map[string]string{"old-prow": "removed"},
+			restrictions: Restrictions{Emoji: []*regexp.Regexp{regexp.MustCompile("^sig-")}},
+			expectErr:    true,
+		},
+		{
+			name:         "duplicate emoji are an error",
+			a:            map[string]string{"prow": "sig-testing/emoji/prow.png"},
+			b:            map[string]string{"prow": "alias:ship"},
+			restrictions: defaultRestriction,
+			expectErr:    true,
+		},
+		{
+			name:         "emoji not matching restrictions are an error",
+			a:            map[string]string{},
+			b:            map[string]string{"prow": "emoji/prow.png"},
+			restrictions: Restrictions{Emoji: []*regexp.Regexp{regexp.MustCompile("^sig-")}},
+			expectErr:    true,
+		},
+		{
+			name:         "invalid emoji names are an error",
+			a:            map[string]string{},
+			b:            map[string]string{"Prow Ship": "emoji/prow.png"},
+			restrictions: defaultRestriction,
+			expectErr:    true,
+		},
+		{
+			name:         "aliases for nothing are an error",
+			a:            map[string]string{},
+			b:            map[string]string{"prow": "alias:"},
+			restrictions: defaultRestriction,
+			expectErr:    true,
+		},
+		{
+			name:         "missing images are an error",
+			a:            map[string]string{},
+			b:            map[string]string{"prow": "emoji/missing.png"},
+			restrictions: defaultRestriction,
+			expectErr:    true,
+		},
+		{
+			name:         "images that are too large are an error",
+			a:            map[string]string{},
+			b:            map[string]string{"prow": "emoji/huge.png"},
+			restrictions: defaultRestriction,
+			expectErr:    true,
+		},
+		{
+			name:         "files that are not images are an error",
+			a:            map[string]string{},
+			b:            map[string]string{"prow": "emoji/text.png"},
+			restrictions: defaultRestriction,
+			expectErr:    true,
+		},
+		{
+			name:         "names starting with dots are inside the directory",
+			a:            map[string]string{},
+			b:            map[string]string{"prow": "..prow.png"},
+			restrictions: defaultRestriction,
+			expected:     map[string]string{"prow": "sig-testing/..prow.png"},
+		},
+		{
+			name:         "images outside the defining file's directory are an error",
+			a:            map[string]string{},
+			b:            map[string]string{"prow": "../sig-testing/emoji/prow.png"},
+			restrictions: defaultRestriction,
+			expectErr:    true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := mergeEmoji(tc.a, tc.b, tc.restrictions, root, "/sig-testing")
+			if err != nil {
+				if !tc.expectErr {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if tc.expectErr {
+				t.Fatalf("expected an error, but got result %#v", tc.a)
+			}
+			if !reflect.DeepEqual(tc.a, tc.expected) {
+				t.Fatalf("Expected emoji %#v, got %#v", tc.expected, tc.a)
+			}
+		})
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -32,17 +33,26 @@ import (
 
 var (
 	emptyRegexp        = regexp.MustCompile("")
-	defaultRestriction = Restrictions{Path: "*", Users: true, Channels: []*regexp.Regexp{emptyRegexp}, Usergroups: []*regexp.Regexp{emptyRegexp}, Template: true}
+	defaultRestriction = Restrictions{Path: "*", Users: true, Channels: []*regexp.Regexp{emptyRegexp}, Usergroups: []*regexp.Regexp{emptyRegexp}, Emoji: []*regexp.Regexp{emptyRegexp}, Template: true, Workspaces: true}
 )
 
 type Parser struct {
 	Config Config
-	parsed map[string]struct{}
+	// ChannelFiles maps the names of channels listed in a file's channels (but not generated by
+	// channel sets) to the path of that file.
+	ChannelFiles map[string]string
+	parsed       map[string]struct{}
+	// memberChecks are usergroup membership restrictions, which can only be checked once every
+	// usergroup has been parsed.
+	memberChecks []memberCheck
+	// root is the directory that paths passed to Parse are relative to.
+	root string
 }
 
 func NewParser() *Parser {
 	return &Parser{
-		parsed: map[string]struct{}{},
+		ChannelFiles: map[string]string{},
+		parsed:       map[string]struct{}{},
 	}
 }
 
@@ -59,6 +69,9 @@ func (p *Parser) Parse(reader io.Reader, path string) error {
 	if p.Config.Users == nil {
 		p.Config.Users = map[string]string{}
 	}
+	if p.Config.Emoji == nil {
+		p.Config.Emoji = map[string]string{}
+	}
 
 	restrictions, err := mergeRestrictions(p.Config.Restrictions, c.Restrictions)
 	if err != nil {
@@ -72,17 +85,40 @@ func (p *Parser) Parse(reader io.Reader, path string) error {
 		return fmt.Errorf("couldn't merge users: %v", err)
 	}
 
-	channels, err := mergeChannels(p.Config.Channels, c.Channels, r)
+	generated, err := expandChannelSets(c.ChannelSets)
+	if err != nil {
+		return fmt.Errorf("couldn't expand channel sets: %v", err)
+	}
+
+	channels, err := mergeChannels(p.Config.Channels, append(c.Channels, generated...), r)
 	if err != nil {
 		return fmt.Errorf("couldn't merge channels: %v", err)
 	}
 	p.Config.Channels = channels
+	for _, ch := range c.Channels {
+		p.ChannelFiles[ch.Name] = filepath.Join(p.root, path)
+	}
 
 	usergroups, err := mergeUsergroups(p.Config.Usergroups, c.Usergroups, r)
 	if err != nil {
 		return fmt.Errorf("couldn't merge usergroups: %v", err)
 	}
 	p.Config.Usergroups = usergroups
+	if len(r.UsergroupMembersFrom) > 0 {
+		for _, g := range c.Usergroups {
+			p.memberChecks = append(p.memberChecks, memberCheck{path: path, restrictionPath: r.Path, group: g, from: r.UsergroupMembersFrom})
+		}
+	}
+
+	workspaces, err := mergeWorkspaces(p.Config.Workspaces, c.Workspaces, r)
+	if err != nil {
+		return fmt.Errorf("couldn't merge workspaces: %v", err)
+	}
+	p.Config.Workspaces = workspaces
+
+	if err := mergeEmoji(p.Config.Emoji, c.Emoji, r, p.root, filepath.Dir(path)); err != nil {
+		return fmt.Errorf("couldn't merge emoji: %v", err)
+	}
 
 	if !isTemplateEmpty(c.ChannelTemplate) {
 		if !r.Template {
@@ -97,6 +133,24 @@ func (p *Parser) Parse(reader io.Reader, path string) error {
 	return nil
 }
 
+// Validate performs the checks that need the complete config. It must be called once every file
+// has been parsed.
+func (p *Parser) Validate() error {
+	if err := p.Config.validateWorkspaces(); err != nil {
+		return err
+	}
+	groups := map[string]Usergroup{}
+	for _, g := range p.Config.Usergroups {
+		groups[g.Name] = g
+	}
+	for _, c := range p.memberChecks {
+		if err := c.check(groups); err != nil {
+			return fmt.Errorf("%s: %v", c.path, err)
+		}
+	}
+	return nil
+}
+
 func (p *Parser) ParseFile(path, basedir string) error {
 	if _, ok := p.parsed[path]; ok {
 		return nil
@@ -110,6 +164,7 @@ func (p *Parser) ParseFile(path, basedir string) error {
 	if !strings.HasPrefix(path, basedir) {
 		return fmt.Errorf("%q is not a prefix of %q", basedir, path)
 	}
+	p.root = basedir
 	if err := p.Parse(f, path[len(basedir):]); err != nil {
 		return fmt.Errorf("failed to parse %s: %v", path, err)
 	}
@@ -135,6 +190,9 @@ func ParseFile(path string) (Config, error) {
 	if err := p.ParseFile(path, path); err != nil {
 		return Config{}, err
 	}
+	if err := p.Validate(); err != nil {
+		return Config{}, err
+	}
 	return p.Config, nil
 }
 
@@ -143,6 +201,9 @@ func ParseDir(path string) (Config, error) {
 	if err := p.ParseDir(path); err != nil {
 		return Config{}, err
 	}
+	if err := p.Validate(); err != nil {
+		return Config{}, err
+	}
 	return p.Config, nil
 }
 
@@ -180,6 +241,14 @@ func mergeRestrictions(a []Restrictions, b []Restrictions) ([]Restrictions, erro
 			}
 			r.Usergroups = append(r.Usergroups, re)
 		}
+		r.Emoji = make([]*regexp.Regexp, 0, len(r.EmojiString))
+		for _, p := range r.EmojiString {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse emoji pattern %q for path %q: %v", p, r.Path, err)
+			}
+			r.Emoji = append(r.Emoji, re)
+		}
 		ret = append(ret, r)
 	}
 	return ret, nil
@@ -218,11 +287,14 @@ func mergeChannels(a []Channel, b []Channel, r Restrictions) ([]Channel, error)
 	ids := map[string]struct{}{}
 	for _, v := range a {
 		names[v.Name] = struct{}{}
+		for _, old := range v.RedirectFrom {
+			names[old] = struct{}{}
+		}
 		if v.ID != "" {
 			ids[v.ID] = struct{}{}
 		}
 	}
-	for _, v := range b {
+	for i, v := range b {
 		if v.Name == "" {
 			return nil, fmt.Errorf("channels must have names")
 		}
@@ -235,11 +307,50 @@ func mergeChannels(a []Channel, b []Channel, r Restrictions) ([]Channel, error)
 		if _, ok := ids[v.ID]; ok {
 			return nil, fmt.Errorf("cannot overwrite channel definitions (duplicate channel ID %s)", v.Name)
 		}
+		if !permitted(r.ArchiveChannels) || !permitted(r.RenameChannels) {
+			b[i].ArchiveForbidden = !permitted(r.ArchiveChannels)
+			b[i].RenameForbidden = !permitted(r.RenameChannels)
+			b[i].DefinedIn = r.Path
+		}
+		for _, old := range v.RedirectFrom {
+			if !matchesRegexList(old, r.Channels) {
+				return nil, fmt.Errorf("cannot redirect from channel %q in %q", old, r.Path)
+			}
+			if _, ok := names[old]; ok || old == v.Name {
+				return nil, fmt.Errorf("channel %s cannot redirect from %s, which is already in use", v.Name, old)
+			}
+			names[old] = struct{}{}
+		}
+		names[v.Name] = struct{}{}
+		if v.ID != "" {
+			ids[v.ID] = struct{}{}
+		}
+		if err := validateBookmarks(v.Bookmarks); err != nil {
+			return nil, fmt.Errorf("channel %s has invalid bookmarks: %v", v.Name, err)
+		}
 	}
 
 	return append(a, b...), nil
 }
 
+func validateBookmarks(bookmarks []Bookmark) error {
+	titles := map[string]struct{}{}
+	for _, b := range bookmarks {
+		if b.Title == "" {
+			return fmt.Errorf("bookmarks must have titles")
+		}
+		if _, ok := titles[b.Title]; ok {
+			return fmt.Errorf("duplicate bookmark title %q", b.Title)
+		}
+		titles[b.Title] = struct{}{}
+		u, err := url.Parse(b.Link)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("bookmark %q has invalid link %q", b.Title, b.Link)
+		}
+	}
+	return nil
+}
+
 func mergeUsergroups(a []Usergroup, b []Usergroup, r Restrictions) ([]Usergroup, error) {
 	names := map[string]struct{}{}
 	for _, v := range a {
@@ -249,6 +360,41 @@ func mergeUsergroups(a []Usergroup, b []Usergroup, r Restrictions) ([]Usergroup,
 		if v.Name == "" {
 			return nil, fmt.Errorf("usergroups must have names")
 		}
+		if v.KickRemovedMembers && !v.EnforceChannelMembership {
+			return nil, fmt.Errorf("usergroup %s can only kick removed members if it enforces channel membership", v.Name)
+		}
+		if len(v.Manage) > 0 {
+			if !v.External {
+				return nil, fmt.Errorf("usergroup %s is not external, so Tempelis already manages all of it", v.Name)
+			}
+			for _, f := range v.Manage {
+				switch f {
+				case ManageLongName, ManageDescription, ManageChannels:
+				default:
+					return nil, fmt.Errorf("usergroup %s: can't manage %q (only %s, %s, and %s can be managed)", v.Name, f, ManageLongName, ManageDescription, ManageChannels)
+				}
+			}
+			if v.Manages(ManageLongName) && v.LongName == "" {
+				return nil, fmt.Errorf("usergroup %s manages its long name, so must have one", v.Name)
+			}
+			if v.Manages(ManageDescription) && v.Description == "" {
+				return nil, fmt.Errorf("usergroup %s manages its description, so must have one", v.Name)
+			}
+		}
+		if v.EnforceChannelMembership && v.External {
+			return nil, fmt.Errorf("usergroup %s is external, so cannot enforce channel membership", v.Name)
+		}
+		if v.Rotation != nil {
+			if v.External {
+				return nil, fmt.Errorf("usergroup %s is external, so cannot have a rotation", v.Name)
+			}
+			if len(v.Members) > 0 {
+				return nil, fmt.Errorf("usergroup %s cannot have both members and a rotation", v.Name)
+			}
+			if err := ParseRotation(v.Rotation); err != nil {
+				return nil, fmt.Errorf("usergroup %s has an invalid rotation: %v", v.Name, err)
+			}
+		}
 		if !matchesRegexList(v.Name, r.Usergroups) {
 			return nil, fmt.Errorf("cannot define usergroup %q in %q", v.Name, r.Path)
 		}
@@ -259,18 +405,89 @@ func mergeUsergroups(a []Usergroup, b []Usergroup, r Restrictions) ([]Usergroup,
 			if v.Description == "" {
 				return nil, fmt.Errorf("usergroup %s must have a description", v.Name)
 			}
-			if len(v.Members) == 0 {
-				return nil, fmt.Errorf("usergroup %s must have at least one member", v.Name)
+			if len(v.Members) == 0 && v.Rotation == nil {
+				return nil, fmt.Errorf("usergroup %s must have at least one member or a rotation", v.Name)
 			}
 		}
 		if _, ok := names[v.Name]; ok {
 			return nil, fmt.Errorf("cannot usergroups (duplicate usergroup %s)", v.Name)
 		}
+		if size := maxUsergroupSize(v); r.MaxUsergroupSize > 0 && size > r.MaxUsergroupSize {
+			return nil, fmt.Errorf("usergroup %s can have %d members, but usergroups in %q can have at most %d", v.Name, size, r.Path, r.MaxUsergroupSize)
+		}
 	}
 
 	return append(a, b...), nil
 }
 
+// permitted interprets a restriction that permits everything unless it is explicitly false.
+func permitted(b *bool) bool {
+	return b == nil || *b
+}
+
+// maxUsergroupSize returns the largest number of members g can have at once.
+func maxUsergroupSize(g Usergroup) int {
+	if g.Rotation == nil {
+		return len(g.Members)
+	}
+	size := g.Rotation.OnCallCount
+	for _, o := range g.Rotation.Overrides {
+		if len(o.Members) > size {
+			size = len(o.Members)
+		}
+	}
+	return size
+}
+
+// usergroupMemberNames returns the names of everyone who could ever be a member of g.
+func usergroupMemberNames(g Usergroup) []string {
+	names := append([]string(nil), g.Members...)
+	if g.Rotation != nil {
+		names = append(names, g.Rotation.Members...)
+		for _, o := range g.Rotation.Overrides {
+			names = append(names, o.Members...)
+		}
+	}
+	return names
+}
+
+type memberCheck struct {
+	path            string
+	restrictionPath string
+	group           Usergroup
+	from            []string
+}
+
+// check returns an error if anyone who could be a member of c.group is not a member of any of the
+// usergroups in c.from.
+func (c memberCheck) check(groups map[string]Usergroup) error {
+	allowed := map[string]bool{}
+	for _, name := range c.from {
+		g, ok := groups[name]
+		if !ok {
+			return fmt.Errorf("usergroups in %q may only contain members of usergroup %s, which is not defined", c.restrictionPath, name)
+		}
+		if g.External {
+			return fmt.Errorf("usergroups in %q may only contain members of usergroup %s, which is external, so its members are unknown", c.restrictionPath, name)
+		}
+		for _, m := range usergroupMemberNames(g) {
+			allowed[m] = true
+		}
+	}
+	var forbidden []string
+	seen := map[string]bool{}
+	for _, m := range usergroupMemberNames(c.group) {
+		if !allowed[m] && !seen[m] {
+			forbidden = append(forbidden, m)
+			seen[m] = true
+		}
+	}
+	if len(forbidden) > 0 {
+		return fmt.Errorf("usergroup %s cannot contain %s, because usergroups in %q may only contain members of %s", c.group.Name, strings.Join(forbidden, ", "), c.restrictionPath, strings.Join(c.from, ", "))
+	}
+	return nil
+}
+
 func isTemplateEmpty(t ChannelTemplate) bool {
 	return len(t.Pins) == 0 && t.Purpose == "" && t.Topic == ""
 }
@@ -19,7 +19,10 @@ package config
 import (
 	"reflect"
 	"regexp"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestResolveRestrictions(t *testing.T) {
@@ -128,11 +131,13 @@ func TestMergeRestrictions(t *testing.T) {
 					Path:       "foo.yaml",
 					Channels:   []*regexp.Regexp{},
 					Usergroups: []*regexp.Regexp{},
+					Emoji:      []*regexp.Regexp{},
 				},
 				{
 					Path:       "bar.yaml",
 					Channels:   []*regexp.Regexp{},
 					Usergroups: []*regexp.Regexp{},
+					Emoji:      []*regexp.Regexp{},
 				},
 			},
 		},
@@ -180,6 +185,7 @@ func TestMergeRestrictions(t *testing.T) {
 					Path:             "foo.yaml",
 					ChannelsString:   []string{"foo.*"},
 					UsergroupsString: []string{"bar.*"},
+					EmojiString:      []string{"baz.*"},
 				},
 			},
 			expected: []Restrictions{
@@ -187,8 +193,10 @@ func TestMergeRestrictions(t *testing.T) {
 					Path:             "foo.yaml",
 					ChannelsString:   []string{"foo.*"},
 					UsergroupsString: []string{"bar.*"},
+					EmojiString:      []string{"baz.*"},
 					Channels:         []*regexp.Regexp{regexp.MustCompile("foo.*")},
 					Usergroups:       []*regexp.Regexp{regexp.MustCompile("bar.*")},
+					Emoji:            []*regexp.Regexp{regexp.MustCompile("baz.*")},
 				},
 			},
 		},
@@ -204,6 +212,17 @@ func TestMergeRestrictions(t *testing.T) {
 			},
 			expectErr: true,
 		},
+		{
+			name: "invalid emoji regexes are an error",
+			a:    nil,
+			b: []Restrictions{
+				{
+					Path:        "foo.yaml",
+					EmojiString: []string{"baz("},
+				},
+			},
+			expectErr: true,
+		},
 		{
 			name: "invalid usergroup regexes are an error",
 			a:    nil,
@@ -314,6 +333,7 @@ func TestMergeUsers(t *testing.T) {
 }
 
 func TestMergeChannels(t *testing.T) {
+	yes, no := true, false
 	tests := []struct {
 		name         string
 		a            []Channel
@@ -343,6 +363,64 @@ func TestMergeChannels(t *testing.T) {
 			restrictions: defaultRestriction,
 			expectErr:    true,
 		},
+		{
+			name:         "merging channels that duplicate each other fails",
+			a:            []Channel{{Name: "slack-admins"}},
+			b:            []Channel{{Name: "ponies"}, {Name: "ponies"}},
+			restrictions: defaultRestriction,
+			expectErr:    true,
+		},
+		{
+			name:         "archived channels are permitted by default",
+			b:            []Channel{{Name: "ponies", Archived: true}},
+			restrictions: Restrictions{Channels: []*regexp.Regexp{emptyRegexp}},
+			expected:     []Channel{{Name: "ponies", Archived: true}},
+		},
+		{
+			name:         "forbidding archiving is recorded for the reconciler to check",
+			b:            []Channel{{Name: "ponies", Archived: true}},
+			restrictions: Restrictions{Path: "ponies.yaml", Channels: []*regexp.Regexp{emptyRegexp}, ArchiveChannels: &no},
+			expected:     []Channel{{Name: "ponies", Archived: true, ArchiveForbidden: true, DefinedIn: "ponies.yaml"}},
+		},
+		{
+			name:         "forbidding renaming is recorded for the reconciler to check",
+			b:            []Channel{{Name: "ponies", ID: "C12345678"}},
+			restrictions: Restrictions{Path: "ponies.yaml", Channels: []*regexp.Regexp{emptyRegexp}, RenameChannels: &no},
+			expected:     []Channel{{Name: "ponies", ID: "C12345678", RenameForbidden: true, DefinedIn: "ponies.yaml"}},
+		},
+		{
+			name:         "channel IDs are fine when renaming is explicitly permitted",
+			b:            []Channel{{Name: "ponies", ID: "C12345678"}},
+			restrictions: Restrictions{Channels: []*regexp.Regexp{emptyRegexp}, RenameChannels: &yes},
+			expected:     []Channel{{Name: "ponies", ID: "C12345678"}},
+		},
+		{
+			name:         "channels can redirect from unused names",
+			a:            []Channel{{Name: "slack-admins"}},
+			b:            []Channel{{Name: "ponies", RedirectFrom: []string{"horses"}}},
+			restrictions: defaultRestriction,
+			expected:     []Channel{{Name: "slack-admins"}, {Name: "ponies", RedirectFrom: []string{"horses"}}},
+		},
+		{
+			name:         "channels can't redirect from other channels",
+			a:            []Channel{{Name: "slack-admins"}},
+			b:            []Channel{{Name: "ponies", RedirectFrom: []string{"slack-admins"}}},
+			restrictions: defaultRestriction,
+			expectErr:    true,
+		},
+		{
+			name:         "channels can't be named after other channels' redirects",
+			a:            []Channel{{Name: "ponies", RedirectFrom: []string{"horses"}}},
+			b:            []Channel{{Name: "horses"}},
+			restrictions: defaultRestriction,
+			expectErr:    true,
+		},
+		{
+			name:         "redirects are subject to channel restrictions",
+			b:            []Channel{{Name: "ponies", RedirectFrom: []string{"horses"}}},
+			restrictions: Restrictions{Channels: []*regexp.Regexp{regexp.MustCompile("^ponies$")}},
+			expectErr:    true,
+		},
 		{
 			name:         "merging fails when all channels are forbidden",
 			a:            []Channel{{Name: "slack-admins"}},
@@ -371,6 +449,34 @@ func TestMergeChannels(t *testing.T) {
 			restrictions: defaultRestriction,
 			expectErr:    true,
 		},
+		{
+			name:         "channels can have bookmarks",
+			a:            nil,
+			b:            []Channel{{Name: "ponies", Bookmarks: []Bookmark{{Title: "Ponies", Link: "https://example.com/ponies", Emoji: "horse"}}}},
+			restrictions: defaultRestriction,
+			expected:     []Channel{{Name: "ponies", Bookmarks: []Bookmark{{Title: "Ponies", Link: "https://example.com/ponies", Emoji: "horse"}}}},
+		},
+		{
+			name:         "a bookmark without a title is illegal",
+			a:            nil,
+			b:            []Channel{{Name: "ponies", Bookmarks: []Bookmark{{Link: "https://example.com/ponies"}}}},
+			restrictions: defaultRestriction,
+			expectErr:    true,
+		},
+		{
+			name:         "duplicate bookmark titles are illegal",
+			a:            nil,
+			b:            []Channel{{Name: "ponies", Bookmarks: []Bookmark{{Title: "Ponies", Link: "https://example.com/ponies"}, {Title: "Ponies", Link: "https://example.com/more-ponies"}}}},
+			restrictions: defaultRestriction,
+			expectErr:    true,
+		},
+		{
+			name:         "a bookmark without a valid link is illegal",
+			a:            nil,
+			b:            []Channel{{Name: "ponies", Bookmarks: []Bookmark{{Title: "Ponies", Link: "ponies"}}}},
+			restrictions: defaultRestriction,
+			expectErr:    true,
+		},
 	}
 
 	for _, tc := range tests {
@@ -509,6 +615,55 @@ func TestMergeUsergroups(t *testing.T) {
 			restrictions: defaultRestriction,
 			expected:     []Usergroup{{Name: "sig-testing", External: true}},
 		},
+		{
+			name: "a usergroup with a rotation doesn't need members",
+			a:    nil,
+			b: []Usergroup{{
+				Name:        "sig-testing-oncall",
+				LongName:    "SIG Testing On-call",
+				Description: "prow, mostly.",
+				Rotation:    &Rotation{Members: []string{"U11111111"}, ShiftLengthString: "1w", StartString: "2019-10-07 09:00"},
+			}},
+			restrictions: defaultRestriction,
+			expected: []Usergroup{{
+				Name:        "sig-testing-oncall",
+				LongName:    "SIG Testing On-call",
+				Description: "prow, mostly.",
+				Rotation: &Rotation{
+					Members:           []string{"U11111111"},
+					ShiftLengthString: "1w",
+					StartString:       "2019-10-07 09:00",
+					OnCallCount:       1,
+					ShiftLength:       7 * 24 * time.Hour,
+					Start:             time.Date(2019, 10, 7, 9, 0, 0, 0, time.UTC),
+				},
+			}},
+		},
+		{
+			name: "a usergroup with both members and a rotation is an error",
+			a:    nil,
+			b: []Usergroup{{
+				Name:        "sig-testing-oncall",
+				LongName:    "SIG Testing On-call",
+				Description: "prow, mostly.",
+				Members:     []string{"U11111111"},
+				Rotation:    &Rotation{Members: []string{"U11111111"}, ShiftLengthString: "1w", StartString: "2019-10-07 09:00"},
+			}},
+			restrictions: defaultRestriction,
+			expectErr:    true,
+		},
+		{
+			name: "a usergroup with an invalid rotation is an error",
+			a:    nil,
+			b: []Usergroup{{
+				Name:        "sig-testing-oncall",
+				LongName:    "SIG Testing On-call",
+				Description: "prow, mostly.",
+				Rotation:    &Rotation{Members: []string{"U11111111"}, ShiftLengthString: "forever", StartString: "2019-10-07 09:00"},
+			}},
+			restrictions: defaultRestriction,
+			expectErr:    true,
+		},
 		{
 			name:         "an externally-managed usergroup with no name is an error",
 			a:            nil,
@@ -516,6 +671,65 @@ func TestMergeUsergroups(t *testing.T) {
 			restrictions: defaultRestriction,
 			expectErr:    true,
 		},
+		{
+			name:         "an external usergroup can manage some of its fields",
+			b:            []Usergroup{{Name: "sig-testing", Description: "prow, mostly.", External: true, Manage: []string{"description", "channels"}}},
+			restrictions: defaultRestriction,
+			expected:     []Usergroup{{Name: "sig-testing", Description: "prow, mostly.", External: true, Manage: []string{"description", "channels"}}},
+		},
+		{
+			name:         "an external usergroup can't manage its members",
+			b:            []Usergroup{{Name: "sig-testing", External: true, Manage: []string{"members"}}},
+			restrictions: defaultRestriction,
+			expectErr:    true,
+		},
+		{
+			name:         "an external usergroup managing its description must have one",
+			b:            []Usergroup{{Name: "sig-testing", External: true, Manage: []string{"description"}}},
+			restrictions: defaultRestriction,
+			expectErr:    true,
+		},
+		{
+			name:         "a usergroup that isn't external can't list managed fields",
+			b:            []Usergroup{{Name: "sig-testing", LongName: "SIG Testing", Description: "prow, mostly.", Members: []string{"U11111111"}, Manage: []string{"description"}}},
+			restrictions: defaultRestriction,
+			expectErr:    true,
+		},
+		{
+			name:         "a usergroup within the size limit is fine",
+			b:            []Usergroup{group2},
+			restrictions: Restrictions{Usergroups: []*regexp.Regexp{emptyRegexp}, MaxUsergroupSize: 3},
+			expected:     []Usergroup{group2},
+		},
+		{
+			name:         "a usergroup over the size limit is an error",
+			b:            []Usergroup{group2},
+			restrictions: Restrictions{Usergroups: []*regexp.Regexp{emptyRegexp}, MaxUsergroupSize: 2},
+			expectErr:    true,
+		},
+		{
+			name: "a rotation's size is how many people can be on call at once",
+			b: []Usergroup{{
+				Name:        "sig-testing-oncall",
+				LongName:    "SIG Testing On-call",
+				Description: "prow, mostly.",
+				Rotation:    &Rotation{Members: []string{"U11111111", "U22222222", "U33333333"}, ShiftLengthString: "1w", StartString: "2019-10-07 09:00"},
+			}},
+			restrictions: Restrictions{Usergroups: []*regexp.Regexp{emptyRegexp}, MaxUsergroupSize: 1},
+			expected: []Usergroup{{
+				Name:        "sig-testing-oncall",
+				LongName:    "SIG Testing On-call",
+				Description: "prow, mostly.",
+				Rotation: &Rotation{
+					Members:           []string{"U11111111", "U22222222", "U33333333"},
+					ShiftLengthString: "1w",
+					StartString:       "2019-10-07 09:00",
+					OnCallCount:       1,
+					ShiftLength:       7 * 24 * time.Hour,
+					Start:             time.Date(2019, 10, 7, 9, 0, 0, 0, time.UTC),
+				},
+			}},
+		},
 	}
 
 	for _, tc := range tests {
@@ -536,3 +750,79 @@ func TestMergeUsergroups(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateUsergroupMembers(t *testing.T) {
+	const restrictions = `
+restrictions:
+- path: sig-testing.yaml
+  usergroups: ["^sig-testing-"]
+  usergroup_members_from: [sig-testing-leads, sig-testing-reviewers]
+- path: "*"
+  users: true
+  usergroups: [""]
+`
+	const users = `
+users:
+  Katharine: U12345678
+  bentheelder: U11111111
+  spiffxp: U22222222
+`
+	tests := []struct {
+		name      string
+		files     map[string]string
+		expectErr bool
+	}{
+		{
+			name: "members of the parent groups are allowed, whichever file defines them",
+			files: map[string]string{
+				"a.yaml":           "usergroups:\n- {name: sig-testing-leads, long_name: Leads, description: Leads, members: [Katharine]}\n",
+				"sig-testing.yaml": "usergroups:\n- {name: sig-testing-triage, long_name: Triage, description: Triage, members: [Katharine, bentheelder]}\n",
+				"z.yaml":           "usergroups:\n- {name: sig-testing-reviewers, long_name: Reviewers, description: Reviewers, members: [bentheelder]}\n",
+			},
+		},
+		{
+			name: "other users are not allowed",
+			files: map[string]string{
+				"a.yaml":           "usergroups:\n- {name: sig-testing-leads, long_name: Leads, description: Leads, members: [Katharine]}\n- {name: sig-testing-reviewers, long_name: Reviewers, description: Reviewers, members: [bentheelder]}\n",
+				"sig-testing.yaml": "usergroups:\n- {name: sig-testing-triage, long_name: Triage, description: Triage, members: [spiffxp]}\n",
+			},
+			expectErr: true,
+		},
+		{
+			name: "undefined parent groups are an error",
+			files: map[string]string{
+				"sig-testing.yaml": "usergroups:\n- {name: sig-testing-triage, long_name: Triage, description: Triage, members: [Katharine]}\n",
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p := NewParser()
+			if err := p.Parse(strings.NewReader(restrictions), "restrictions.yaml"); err != nil {
+				t.Fatalf("failed to parse restrictions: %v", err)
+			}
+			if err := p.Parse(strings.NewReader(users), "users.yaml"); err != nil {
+				t.Fatalf("failed to parse users: %v", err)
+			}
+			var paths []string
+			for path := range tc.files {
+				paths = append(paths, path)
+			}
+			sort.Strings(paths)
+			for _, path := range paths {
+				if err := p.Parse(strings.NewReader(tc.files[path]), path); err != nil {
+					t.Fatalf("failed to parse %s: %v", path, err)
+				}
+			}
+			err := p.Validate()
+			if err != nil && !tc.expectErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err == nil && tc.expectErr {
+				t.Fatalf("expected an error, but got none")
+			}
+		})
+	}
+}
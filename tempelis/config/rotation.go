@@ -0,0 +1,191 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rotationTimeFormat is the format of times in rotation specs, which are interpreted in the
+// rotation's time zone.
+const rotationTimeFormat = "2006-01-02 15:04"
+
+const day = 24 * time.Hour
+
+// Rotation describes an on-call schedule that determines the membership of a usergroup.
+type Rotation struct {
+	Members           []string           `json:"members" desc:"Names of everyone in the rotation, in order." required:"true"`
+	ShiftLengthString string             `json:"shift_length" desc:"How long each shift lasts, such as 1w, 3d or 12h." required:"true"`
+	StartString       string             `json:"start" desc:"When the first shift starts, as YYYY-MM-DD HH:MM in the rotation's time zone." pattern:"^[0-9]{4}-[0-9]{2}-[0-9]{2} [0-9]{2}:[0-9]{2}$" required:"true"`
+	TimeZone          string             `json:"time_zone,omitempty" desc:"An IANA time zone name. Defaults to UTC."`
+	OnCallCount       int                `json:"on_call_count,omitempty" desc:"How many people are on call at once. Defaults to 1."`
+	AnnounceChannel   string             `json:"announce_channel,omitempty" desc:"A channel in which to announce each handoff."`
+	Overrides         []RotationOverride `json:"overrides,omitempty" desc:"Replacements for whoever would otherwise be on call."`
+
+	ShiftLength time.Duration `json:"-"`
+	Start       time.Time     `json:"-"`
+}
+
+// RotationOverride replaces whoever would otherwise be on call between Start and End.
+type RotationOverride struct {
+	StartString string   `json:"start" desc:"When the override starts, as YYYY-MM-DD HH:MM." pattern:"^[0-9]{4}-[0-9]{2}-[0-9]{2} [0-9]{2}:[0-9]{2}$" required:"true"`
+	EndString   string   `json:"end" desc:"When the override ends, as YYYY-MM-DD HH:MM." pattern:"^[0-9]{4}-[0-9]{2}-[0-9]{2} [0-9]{2}:[0-9]{2}$" required:"true"`
+	Members     []string `json:"members" desc:"Names of whoever is on call during the override." required:"true"`
+
+	Start time.Time `json:"-"`
+	End   time.Time `json:"-"`
+}
+
+// ParseRotation validates a rotation and fills in its parsed fields.
+func ParseRotation(r *Rotation) error {
+	if len(r.Members) == 0 {
+		return fmt.Errorf("rotation must have at least one member")
+	}
+	if r.OnCallCount == 0 {
+		r.OnCallCount = 1
+	}
+	if r.OnCallCount < 0 || r.OnCallCount > len(r.Members) {
+		return fmt.Errorf("on_call_count must be between 1 and the number of members (%d), not %d", len(r.Members), r.OnCallCount)
+	}
+	shift, err := parseShiftLength(r.ShiftLengthString)
+	if err != nil {
+		return fmt.Errorf("bad shift_length %q: %v", r.ShiftLengthString, err)
+	}
+	if shift <= 0 {
+		return fmt.Errorf("shift_length must be positive, not %s", shift)
+	}
+	r.ShiftLength = shift
+
+	loc := time.UTC
+	if r.TimeZone != "" {
+		loc, err = time.LoadLocation(r.TimeZone)
+		if err != nil {
+			return fmt.Errorf("bad time_zone %q: %v", r.TimeZone, err)
+		}
+	}
+	r.Start, err = time.ParseInLocation(rotationTimeFormat, r.StartString, loc)
+	if err != nil {
+		return fmt.Errorf("bad start time %q (expected format %q): %v", r.StartString, rotationTimeFormat, err)
+	}
+
+	for i := range r.Overrides {
+		o := &r.Overrides[i]
+		if len(o.Members) == 0 {
+			return fmt.Errorf("override starting %q must have at least one member", o.StartString)
+		}
+		if o.Start, err = time.ParseInLocation(rotationTimeFormat, o.StartString, loc); err != nil {
+			return fmt.Errorf("bad override start time %q: %v", o.StartString, err)
+		}
+		if o.End, err = time.ParseInLocation(rotationTimeFormat, o.EndString, loc); err != nil {
+			return fmt.Errorf("bad override end time %q: %v", o.EndString, err)
+		}
+		if !o.End.After(o.Start) {
+			return fmt.Errorf("override starting %q must end after it starts", o.StartString)
+		}
+	}
+	return nil
+}
+
+// parseShiftLength is time.ParseDuration, but also accepts a whole number of days ("3d") or
+// weeks ("1w").
+func parseShiftLength(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": day, "w": 7 * day} {
+		if strings.HasSuffix(s, suffix) {
+			n, err := strconv.Atoi(strings.TrimSuffix(s, suffix))
+			if err != nil {
+				return 0, err
+			}
+			return time.Duration(n) * unit, nil
+		}
+	}
+	return time.ParseDuration(s)
+}
+
+// shiftStart returns the time at which the nth shift starts. Shifts that are a whole number of
+// days long start at the same wall-clock time in the rotation's time zone, even across DST changes.
+func (r *Rotation) shiftStart(n int) time.Time {
+	if r.ShiftLength%day == 0 {
+		return r.Start.AddDate(0, 0, n*int(r.ShiftLength/day))
+	}
+	return r.Start.Add(time.Duration(n) * r.ShiftLength)
+}
+
+// shiftIndex returns the number of the shift in progress at t, which is negative if t is before
+// the rotation starts.
+func (r *Rotation) shiftIndex(t time.Time) int {
+	n := int(t.Sub(r.Start) / r.ShiftLength)
+	for r.shiftStart(n).After(t) {
+		n--
+	}
+	for !r.shiftStart(n + 1).After(t) {
+		n++
+	}
+	return n
+}
+
+// OnCall returns the names of the members on call at t.
+func (r *Rotation) OnCall(t time.Time) []string {
+	for _, o := range r.Overrides {
+		if !t.Before(o.Start) && t.Before(o.End) {
+			return o.Members
+		}
+	}
+	n := r.shiftIndex(t)
+	result := make([]string, 0, r.OnCallCount)
+	for i := 0; i < r.OnCallCount; i++ {
+		idx := (n*r.OnCallCount + i) % len(r.Members)
+		if idx < 0 {
+			idx += len(r.Members)
+		}
+		result = append(result, r.Members[idx])
+	}
+	return result
+}
+
+// NextHandoff returns the first time after t at which the set of people on call may change.
+func (r *Rotation) NextHandoff(t time.Time) time.Time {
+	next := r.shiftStart(r.shiftIndex(t) + 1)
+	for _, o := range r.Overrides {
+		for _, b := range []time.Time{o.Start, o.End} {
+			if b.After(t) && b.Before(next) {
+				next = b
+			}
+		}
+	}
+	return next
+}
+
+// NextRotationHandoff returns the earliest time after t at which any usergroup rotation hands off,
+// and false if there are no rotations.
+func (c *Config) NextRotationHandoff(t time.Time) (time.Time, bool) {
+	var next time.Time
+	found := false
+	for _, g := range c.Usergroups {
+		if g.Rotation == nil {
+			continue
+		}
+		h := g.Rotation.NextHandoff(t)
+		if !found || h.Before(next) {
+			next = h
+			found = true
+		}
+	}
+	return next, found
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRotation(t *testing.T) {
+	tests := []struct {
+		name      string
+		rotation  Rotation
+		expectErr bool
+	}{
+		{
+			name:     "a simple weekly rotation is fine",
+			rotation: Rotation{Members: []string{"a", "b"}, ShiftLengthString: "1w", StartString: "2019-10-07 09:00", TimeZone: "America/Los_Angeles"},
+		},
+		{
+			name:      "a rotation with no members is an error",
+			rotation:  Rotation{ShiftLengthString: "1w", StartString: "2019-10-07 09:00"},
+			expectErr: true,
+		},
+		{
+			name:      "a rotation with no shift length is an error",
+			rotation:  Rotation{Members: []string{"a"}, StartString: "2019-10-07 09:00"},
+			expectErr: true,
+		},
+		{
+			name:      "a rotation with a bad start time is an error",
+			rotation:  Rotation{Members: []string{"a"}, ShiftLengthString: "24h", StartString: "next tuesday"},
+			expectErr: true,
+		},
+		{
+			name:      "a rotation with a bad time zone is an error",
+			rotation:  Rotation{Members: []string{"a"}, ShiftLengthString: "24h", StartString: "2019-10-07 09:00", TimeZone: "Mars/Olympus_Mons"},
+			expectErr: true,
+		},
+		{
+			name:      "more people on call than members is an error",
+			rotation:  Rotation{Members: []string{"a"}, ShiftLengthString: "24h", StartString: "2019-10-07 09:00", OnCallCount: 2},
+			expectErr: true,
+		},
+		{
+			name: "an override that ends before it starts is an error",
+			rotation: Rotation{Members: []string{"a"}, ShiftLengthString: "24h", StartString: "2019-10-07 09:00", Overrides: []RotationOverride{
+				{StartString: "2019-10-08 09:00", EndString: "2019-10-07 09:00", Members: []string{"b"}},
+			}},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ParseRotation(&tc.rotation)
+			if err != nil && !tc.expectErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err == nil && tc.expectErr {
+				t.Fatalf("expected an error, but got rotation %#v", tc.rotation)
+			}
+		})
+	}
+}
+
+func TestRotationOnCall(t *testing.T) {
+	rotation := Rotation{
+		Members:           []string{"alice", "bob", "carol"},
+		ShiftLengthString: "1w",
+		StartString:       "2019-10-07 09:00",
+		TimeZone:          "America/Los_Angeles",
+		Overrides: []RotationOverride{
+			{StartString: "2019-10-31 00:00", EndString: "2019-11-01 00:00", Members: []string{"dave"}},
+		},
+	}
+	if err := ParseRotation(&rotation); err != nil {
+		t.Fatalf("failed to parse rotation: %v", err)
+	}
+	loc, _ := time.LoadLocation("America/Los_Angeles")
+
+	tests := []struct {
+		name         string
+		when         time.Time
+		expected     []string
+		expectedNext time.Time
+	}{
+		{
+			name:         "the first shift",
+			when:         time.Date(2019, 10, 7, 9, 0, 0, 0, loc),
+			expected:     []string{"alice"},
+			expectedNext: time.Date(2019, 10, 14, 9, 0, 0, 0, loc),
+		},
+		{
+			name:         "just before a handoff",
+			when:         time.Date(2019, 10, 14, 8, 59, 0, 0, loc),
+			expected:     []string{"alice"},
+			expectedNext: time.Date(2019, 10, 14, 9, 0, 0, 0, loc),
+		},
+		{
+			name:         "the rotation wraps around",
+			when:         time.Date(2019, 10, 28, 12, 0, 0, 0, loc),
+			expected:     []string{"alice"},
+			expectedNext: time.Date(2019, 10, 31, 0, 0, 0, 0, loc),
+		},
+		{
+			name:         "overrides take precedence",
+			when:         time.Date(2019, 10, 31, 12, 0, 0, 0, loc),
+			expected:     []string{"dave"},
+			expectedNext: time.Date(2019, 11, 1, 0, 0, 0, 0, loc),
+		},
+		{
+			name:         "handoffs keep their local time across DST changes",
+			when:         time.Date(2019, 11, 5, 12, 0, 0, 0, loc),
+			expected:     []string{"bob"},
+			expectedNext: time.Date(2019, 11, 11, 9, 0, 0, 0, loc),
+		},
+		{
+			name:         "times before the rotation starts still have someone on call",
+			when:         time.Date(2019, 10, 1, 12, 0, 0, 0, loc),
+			expected:     []string{"carol"},
+			expectedNext: time.Date(2019, 10, 7, 9, 0, 0, 0, loc),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if onCall := rotation.OnCall(tc.when); !reflect.DeepEqual(onCall, tc.expected) {
+				t.Errorf("Expected %v to be on call, but got %v", tc.expected, onCall)
+			}
+			if next := rotation.NextHandoff(tc.when); !next.Equal(tc.expectedNext) {
+				t.Errorf("Expected next handoff at %s, but got %s", tc.expectedNext, next)
+			}
+		})
+	}
+}
+
+func TestRotationOnCallCount(t *testing.T) {
+	rotation := Rotation{
+		Members:           []string{"alice", "bob", "carol"},
+		ShiftLengthString: "12h",
+		StartString:       "2019-10-07 00:00",
+		OnCallCount:       2,
+	}
+	if err := ParseRotation(&rotation); err != nil {
+		t.Fatalf("failed to parse rotation: %v", err)
+	}
+	expected := [][]string{{"alice", "bob"}, {"carol", "alice"}, {"bob", "carol"}}
+	for i, e := range expected {
+		when := rotation.Start.Add(time.Duration(i) * 12 * time.Hour)
+		if onCall := rotation.OnCall(when); !reflect.DeepEqual(onCall, e) {
+			t.Errorf("Shift %d: expected %v to be on call, but got %v", i, e, onCall)
+		}
+	}
+}
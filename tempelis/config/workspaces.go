@@ -0,0 +1,156 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+)
+
+// Workspace is a Slack workspace that Tempelis manages.
+type Workspace struct {
+	Name    string `json:"name" desc:"The name used to refer to the workspace elsewhere in the config." required:"true"`
+	TeamID  string `json:"team_id" desc:"The workspace's Slack team ID." pattern:"^T[A-Z0-9]+$" required:"true"`
+	Auth    string `json:"auth,omitempty" desc:"Path to the Slack auth file for the workspace. Defaults to the one passed to --auth."`
+	Default bool   `json:"default,omitempty" desc:"Whether channels and usergroups that don't list their workspaces belong to this one."`
+	// Users maps user names to IDs in this workspace. Separate workspaces give the same person
+	// different IDs, so the top-level users only apply to default workspaces.
+	Users map[string]string `json:"users,omitempty" desc:"Maps user names to Slack user IDs in this workspace. Default workspaces also use the top-level users." pattern:"^[UW][A-Z0-9]{8,}$"`
+}
+
+func mergeWorkspaces(a []Workspace, b []Workspace, r Restrictions) ([]Workspace, error) {
+	if len(b) == 0 {
+		return a, nil
+	}
+	if !r.Workspaces {
+		return nil, fmt.Errorf("cannot define workspaces in %q", r.Path)
+	}
+	names := map[string]struct{}{}
+	teams := map[string]struct{}{}
+	for _, w := range a {
+		names[w.Name] = struct{}{}
+		teams[w.TeamID] = struct{}{}
+	}
+	for _, w := range b {
+		if w.Name == "" {
+			return nil, fmt.Errorf("workspaces must have names")
+		}
+		if w.TeamID == "" {
+			return nil, fmt.Errorf("workspace %s must have a team ID", w.Name)
+		}
+		if _, ok := names[w.Name]; ok {
+			return nil, fmt.Errorf("cannot overwrite workspaces (duplicate workspace %s)", w.Name)
+		}
+		if _, ok := teams[w.TeamID]; ok {
+			return nil, fmt.Errorf("cannot overwrite workspaces (duplicate team ID %s)", w.TeamID)
+		}
+		names[w.Name] = struct{}{}
+		teams[w.TeamID] = struct{}{}
+	}
+	return append(a, b...), nil
+}
+
+// validateWorkspaces checks that every workspace referred to is defined, and that there is a
+// default workspace for everything else to belong to.
+func (c *Config) validateWorkspaces() error {
+	if len(c.Workspaces) == 0 {
+		return nil
+	}
+	defined := map[string]bool{}
+	hasDefault := false
+	for _, w := range c.Workspaces {
+		defined[w.Name] = true
+		hasDefault = hasDefault || w.Default
+	}
+	if !hasDefault {
+		return fmt.Errorf("workspaces are defined, but none is the default")
+	}
+	check := func(kind, name string, workspaces []string) error {
+		for _, w := range workspaces {
+			if !defined[w] {
+				return fmt.Errorf("%s %s belongs to workspace %q, which is not defined", kind, name, w)
+			}
+		}
+		return nil
+	}
+	for _, ch := range c.Channels {
+		if err := check("channel", ch.Name, ch.Workspaces); err != nil {
+			return err
+		}
+	}
+	for _, g := range c.Usergroups {
+		if err := check("usergroup", g.Name, g.Workspaces); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ForWorkspace returns the config that applies to the named workspace: only the channels and
+// usergroups that belong to it, and only that workspace. Channels and usergroups that don't list
+// their workspaces belong to every default workspace. The top-level users, emoji, and channel
+// template only apply to default workspaces; other workspaces only have the users they list.
+func (c Config) ForWorkspace(name string) Config {
+	var defaults []string
+	var workspace Workspace
+	for _, w := range c.Workspaces {
+		if w.Default {
+			defaults = append(defaults, w.Name)
+		}
+		if w.Name == name {
+			workspace = w
+		}
+	}
+	belongs := func(workspaces []string) bool {
+		if len(workspaces) == 0 {
+			workspaces = defaults
+		}
+		for _, w := range workspaces {
+			if w == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	result := c
+	result.Workspaces = []Workspace{workspace}
+	result.Users = map[string]string{}
+	if workspace.Default {
+		for n, id := range c.Users {
+			result.Users[n] = id
+		}
+	} else {
+		result.Emoji = nil
+		result.ChannelTemplate = ChannelTemplate{}
+	}
+	for n, id := range workspace.Users {
+		result.Users[n] = id
+	}
+	result.Channels = nil
+	for _, ch := range c.Channels {
+		if belongs(ch.Workspaces) {
+			result.Channels = append(result.Channels, ch)
+		}
+	}
+	result.Usergroups = nil
+	for _, g := range c.Usergroups {
+		if belongs(g.Workspaces) {
+			result.Usergroups = append(result.Usergroups, g)
+		}
+	}
+	return result
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeWorkspaces(t *testing.T) {
+	main := Workspace{Name: "main", TeamID: "T12345678", Default: true}
+	staging := Workspace{Name: "staging", TeamID: "T87654321", Auth: "/etc/slack/staging.json"}
+
+	tests := []struct {
+		name         string
+		a            []Workspace
+		b            []Workspace
+		restrictions Restrictions
+		expected     []Workspace
+		expectErr    bool
+	}{
+		{
+			name:         "merging workspaces works",
+			a:            []Workspace{main},
+			b:            []Workspace{staging},
+			restrictions: defaultRestriction,
+			expected:     []Workspace{main, staging},
+		},
+		{
+			name:         "merging nothing is fine regardless of permissions",
+			a:            []Workspace{main},
+			restrictions: Restrictions{},
+			expected:     []Workspace{main},
+		},
+		{
+			name:         "merging when not permitted is an error",
+			b:            []Workspace{main},
+			restrictions: Restrictions{},
+			expectErr:    true,
+		},
+		{
+			name:         "duplicate names are an error",
+			a:            []Workspace{main},
+			b:            []Workspace{{Name: "main", TeamID: "T11111111"}},
+			restrictions: defaultRestriction,
+			expectErr:    true,
+		},
+		{
+			name:         "duplicate team IDs are an error",
+			b:            []Workspace{main, {Name: "other", TeamID: main.TeamID}},
+			restrictions: defaultRestriction,
+			expectErr:    true,
+		},
+		{
+			name:         "a workspace without a team ID is an error",
+			b:            []Workspace{{Name: "main"}},
+			restrictions: defaultRestriction,
+			expectErr:    true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := mergeWorkspaces(tc.a, tc.b, tc.restrictions)
+			if err != nil {
+				if !tc.expectErr {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if tc.expectErr {
+				t.Fatalf("expected an error, but got result %#v", r)
+			}
+			if !reflect.DeepEqual(r, tc.expected) {
+				t.Fatalf("Expected workspaces %#v, got %#v", tc.expected, r)
+			}
+		})
+	}
+}
+
+func TestForWorkspace(t *testing.T) {
+	main := Workspace{Name: "main", TeamID: "T12345678", Default: true}
+	staging := Workspace{Name: "staging", TeamID: "T87654321", Users: map[string]string{"Katharine": "U87654321"}}
+	c := Config{
+		Users:           map[string]string{"Katharine": "U12345678", "Bob": "U11111111"},
+		Workspaces:      []Workspace{main, staging},
+		Emoji:           map[string]string{"k8s": "alias:kubernetes"},
+		ChannelTemplate: ChannelTemplate{Topic: "Welcome!"},
+		Channels: []Channel{
+			{Name: "general"},
+			{Name: "staging-only", Workspaces: []string{"staging"}},
+			{Name: "everywhere", Workspaces: []string{"main", "staging"}},
+		},
+		Usergroups: []Usergroup{
+			{Name: "admins", Workspaces: []string{"main", "staging"}},
+			{Name: "sig-testing"},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		workspace string
+		expected  Config
+	}{
+		{
+			name:      "unassigned objects and top-level users belong to the default workspace",
+			workspace: "main",
+			expected: Config{
+				Users:           c.Users,
+				Workspaces:      []Workspace{main},
+				Emoji:           c.Emoji,
+				ChannelTemplate: c.ChannelTemplate,
+				Channels:        []Channel{c.Channels[0], c.Channels[2]},
+				Usergroups:      []Usergroup{c.Usergroups[0], c.Usergroups[1]},
+			},
+		},
+		{
+			name:      "other workspaces only get their own users",
+			workspace: "staging",
+			expected: Config{
+				Users:      staging.Users,
+				Workspaces: []Workspace{staging},
+				Channels:   []Channel{c.Channels[1], c.Channels[2]},
+				Usergroups: []Usergroup{c.Usergroups[0]},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := c.ForWorkspace(tc.workspace)
+			if !reflect.DeepEqual(actual, tc.expected) {
+				t.Errorf("Expected config %#v, got %#v", tc.expected, actual)
+			}
+		})
+	}
+
+	staged := c.ForWorkspace("staging")
+	if _, err := staged.NamesToIDs([]string{"Bob"}); err == nil {
+		t.Errorf("Expected names without an ID in the workspace not to resolve")
+	}
+}
+
+func TestValidateWorkspaces(t *testing.T) {
+	c := Config{
+		Workspaces: []Workspace{{Name: "main", TeamID: "T12345678", Default: true}},
+		Channels:   []Channel{{Name: "general", Workspaces: []string{"main"}}},
+	}
+	if err := c.validateWorkspaces(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	c.Usergroups = []Usergroup{{Name: "admins", Workspaces: []string{"staging"}}}
+	if err := c.validateWorkspaces(); err == nil {
+		t.Errorf("expected an error for an undefined workspace, but got none")
+	}
+	c.Usergroups = nil
+	c.Workspaces[0].Default = false
+	if err := c.validateWorkspaces(); err == nil {
+		t.Errorf("expected an error when no workspace is the default, but got none")
+	}
+}
@@ -18,9 +18,13 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"os"
+	"os/exec"
 	"path"
+	"strings"
+	"time"
 
 	"sigs.k8s.io/slack-infra/slack"
 	"sigs.k8s.io/slack-infra/tempelis/config"
@@ -32,6 +36,12 @@ type options struct {
 	config       string
 	restrictions string
 	authConfig   string
+	daemon       bool
+	resync       time.Duration
+	emojiURLBase string
+	journal      string
+	revision     string
+	stateFrom    string
 }
 
 func parseOptions() options {
@@ -40,41 +50,238 @@ func parseOptions() options {
 	flag.StringVar(&o.config, "config", "", "path to a configuration file, or directory of files")
 	flag.StringVar(&o.restrictions, "restrictions", "", "path to a configuration file containing restrictions")
 	flag.StringVar(&o.authConfig, "auth", "", "path to slack auth")
+	flag.StringVar(&o.emojiURLBase, "emoji-url-base", "", "URL at which the root of the config directory is served, used to add emoji images")
+	flag.StringVar(&o.journal, "journal", "", "optional path to a file in which to record every applied action")
+	flag.StringVar(&o.revision, "revision", "", "the revision of the config being applied, recorded in the journal (default: the config's git revision, if any)")
+	flag.StringVar(&o.stateFrom, "state-from", "", "plan against a snapshot written by 'tempelis snapshot' instead of the live Slack state, which implies --dry-run")
+	flag.BoolVar(&o.daemon, "daemon", false, "if true, keep running and reconcile again at every rotation handoff")
+	flag.DurationVar(&o.resync, "resync-period", time.Hour, "in daemon mode, the longest time to wait between reconciliations")
 	flag.Parse()
 	return o
 }
 
-func main() {
-	o := parseOptions()
-
-	sc, err := slack.LoadConfig(o.authConfig)
+func loadConfig(o options) (config.Config, error) {
+	p, err := loadParser(o.config, o.restrictions)
 	if err != nil {
-		log.Fatalf("Failed to load slack auth config: %v.\n", err)
+		return config.Config{}, err
 	}
+	return p.Config, nil
+}
 
-	stat, err := os.Stat(o.config)
+// loadParser parses the config at configPath, which may be a file or a directory, after first
+// parsing restrictions from restrictionsPath if given.
+func loadParser(configPath, restrictionsPath string) (*config.Parser, error) {
+	stat, err := os.Stat(configPath)
 	if err != nil {
-		log.Fatalf("Failed to stat %s: %v\n", o.config, err)
+		return nil, fmt.Errorf("failed to stat %s: %v", configPath, err)
 	}
 	p := config.NewParser()
 
-	if o.restrictions != "" {
-		if err := p.ParseFile(o.restrictions, path.Dir(o.restrictions)); err != nil {
-			log.Fatalf("Failed to parse restrictions file: %v.\n", err)
+	if restrictionsPath != "" {
+		if err := p.ParseFile(restrictionsPath, path.Dir(restrictionsPath)); err != nil {
+			return nil, fmt.Errorf("failed to parse restrictions file: %v", err)
 		}
 	}
 
 	if stat.IsDir() {
-		err = p.ParseDir(o.config)
+		err = p.ParseDir(configPath)
 	} else {
-		err = p.ParseFile(o.config, path.Dir(o.config))
+		err = p.ParseFile(configPath, path.Dir(configPath))
 	}
 	if err != nil {
-		log.Fatalf("Failed to load config: %v\n", err)
+		return nil, fmt.Errorf("failed to load config: %v", err)
 	}
+	if err := p.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %v", err)
+	}
+	return p, nil
+}
 
-	r := reconciler.New(slack.New(sc), p.Config)
-	if err := r.Reconcile(o.dryRun); err != nil {
+// newReconciler returns a Reconciler configured by o.
+func newReconciler(o options, client *slack.Client, c config.Config) (*reconciler.Reconciler, error) {
+	r := reconciler.New(client, c)
+	r.SetEmojiURLBase(o.emojiURLBase)
+	if o.journal != "" {
+		revision := o.revision
+		if revision == "" {
+			revision = gitRevision(o.config)
+		}
+		// Record which team the run is applied to, so that it can't be rolled back against
+		// another one.
+		team := ""
+		if client != nil {
+			id, err := client.AuthTest()
+			if err != nil {
+				return nil, fmt.Errorf("failed to check which team is being reconciled: %v", err)
+			}
+			team = id.TeamID
+		}
+		j, err := reconciler.NewJournal(o.journal, revision, team)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create journal: %v", err)
+		}
+		r.SetJournal(j)
+	}
+	return r, nil
+}
+
+// gitRevision returns the git commit checked out at path, or "" if that can't be determined.
+func gitRevision(p string) string {
+	dir := p
+	if stat, err := os.Stat(p); err == nil && !stat.IsDir() {
+		dir = path.Dir(p)
+	}
+	out, err := exec.Command("git", "-C", dir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "rollback":
+			runRollback(os.Args[2:])
+			return
+		case "stale":
+			runStale(os.Args[2:])
+			return
+		case "schema":
+			runSchema()
+			return
+		case "snapshot":
+			runSnapshot(os.Args[2:])
+			return
+		case "check-approvals":
+			runCheckApprovals(os.Args[2:])
+			return
+		}
+	}
+
+	o := parseOptions()
+
+	if o.stateFrom != "" {
+		o.dryRun = true
+	}
+
+	if o.daemon {
+		runDaemon(o)
+		return
+	}
+
+	c, err := loadConfig(o)
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
+
+	if err := reconcileAll(o, c); err != nil {
 		log.Fatalf("Reconciliation failed: %v\n", err)
 	}
 }
+
+// workspace is a Slack workspace to reconcile, along with the config that applies to it.
+type workspace struct {
+	name   string
+	client *slack.Client
+	config config.Config
+	// snapshot is the path to a snapshot of the workspace's state, if it should be used instead of
+	// client.
+	snapshot string
+}
+
+// workspaces returns every workspace that c applies to. If c doesn't define any, that's just the
+// one given by --auth.
+func workspaces(o options, c config.Config) ([]workspace, error) {
+	if o.stateFrom != "" {
+		return snapshotWorkspaces(o.stateFrom, c), nil
+	}
+	if len(c.Workspaces) == 0 {
+		sc, err := slack.LoadConfig(o.authConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load slack auth config: %v", err)
+		}
+		return []workspace{{client: slack.New(sc), config: c}}, nil
+	}
+
+	var result []workspace
+	for _, w := range c.Workspaces {
+		auth := w.Auth
+		if auth == "" {
+			auth = o.authConfig
+		}
+		sc, err := slack.LoadConfig(auth)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load slack auth config for workspace %s: %v", w.Name, err)
+		}
+		client := slack.New(sc)
+		// Applying one workspace's config to another would be disastrous, so make sure the
+		// credentials are for the right one.
+		id, err := client.AuthTest()
+		if err != nil {
+			return nil, fmt.Errorf("workspace %s: %v", w.Name, err)
+		}
+		if id.TeamID != w.TeamID {
+			return nil, fmt.Errorf("the credentials for workspace %s are for team %s (%s), not %s", w.Name, id.TeamID, id.Team, w.TeamID)
+		}
+		result = append(result, workspace{name: w.Name, client: client, config: c.ForWorkspace(w.Name)})
+	}
+	return result, nil
+}
+
+// reconcileAll reconciles every workspace c applies to, grouping the plan for each together.
+func reconcileAll(o options, c config.Config) error {
+	ws, err := workspaces(o, c)
+	if err != nil {
+		return err
+	}
+	var failed []string
+	for _, w := range ws {
+		if w.name != "" {
+			log.Printf("Reconciling workspace %s:\n", w.name)
+		}
+		r, err := newReconciler(o, w.client, w.config)
+		if err != nil {
+			return err
+		}
+		if w.snapshot != "" {
+			s, err := reconciler.ReadSnapshot(w.snapshot)
+			if err != nil {
+				return err
+			}
+			r.SetSnapshot(s)
+		}
+		if err := r.Reconcile(o.dryRun); err != nil {
+			if w.name == "" {
+				return err
+			}
+			log.Printf("Reconciling workspace %s failed: %v\n", w.name, err)
+			failed = append(failed, w.name)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("reconciling workspaces %s failed", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// runDaemon reconciles forever, waking up whenever a rotation hands off or the resync period
+// elapses, whichever comes first. The config is reloaded every time.
+func runDaemon(o options) {
+	for {
+		next := time.Now().Add(o.resync)
+		c, err := loadConfig(o)
+		if err != nil {
+			log.Printf("%v\n", err)
+		} else {
+			if err := reconcileAll(o, c); err != nil {
+				log.Printf("Reconciliation failed: %v\n", err)
+			}
+			if handoff, ok := c.NextRotationHandoff(time.Now()); ok && handoff.Before(next) {
+				next = handoff
+			}
+		}
+		log.Printf("Sleeping until %s.\n", next.Format(time.RFC3339))
+		time.Sleep(time.Until(next))
+	}
+}
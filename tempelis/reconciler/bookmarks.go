@@ -0,0 +1,169 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconciler
+
+import (
+	"fmt"
+	"strings"
+
+	"sigs.k8s.io/slack-infra/slack"
+	"sigs.k8s.io/slack-infra/tempelis/config"
+)
+
+// bookmarkedChannelIDs returns the IDs of every extant channel whose bookmarks are managed.
+func (r *Reconciler) bookmarkedChannelIDs() []string {
+	var ids []string
+	for _, c := range r.config.Channels {
+		if c.Bookmarks == nil || c.Archived {
+			continue
+		}
+		if id := r.priorChannelID(c.Name); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// normaliseEmoji returns the emoji name e surrounded by colons, which is how Slack returns it.
+func normaliseEmoji(e string) string {
+	if e == "" {
+		return ""
+	}
+	return ":" + strings.Trim(e, ":") + ":"
+}
+
+func (r *Reconciler) reconcileBookmarks() ([]Action, []error) {
+	var actions []Action
+	for _, c := range r.config.Channels {
+		if c.Bookmarks == nil || c.Archived {
+			continue
+		}
+		id := ""
+		var existing []slack.Bookmark
+		if o, ok := r.channels.byName[c.Name]; ok {
+			id = o.ID
+			existing = r.channels.bookmarks[o.ID]
+		}
+
+		byTitle := map[string]slack.Bookmark{}
+		for _, b := range existing {
+			byTitle[b.Title] = b
+		}
+
+		for _, b := range c.Bookmarks {
+			target := config.Bookmark{Title: b.Title, Link: b.Link, Emoji: normaliseEmoji(b.Emoji)}
+			o, ok := byTitle[b.Title]
+			if !ok {
+				actions = append(actions, addBookmarkAction{channelID: id, channelName: c.Name, bookmark: target})
+				continue
+			}
+			delete(byTitle, b.Title)
+			if o.Link != target.Link || o.Emoji != target.Emoji {
+				actions = append(actions, editBookmarkAction{id: o.ID, channelID: id, channelName: c.Name, bookmark: target})
+			}
+		}
+
+		// Iterate over existing rather than byTitle to keep the order stable.
+		for _, b := range existing {
+			if _, ok := byTitle[b.Title]; ok {
+				actions = append(actions, removeBookmarkAction{id: b.ID, channelID: id, channelName: c.Name, title: b.Title})
+			}
+		}
+	}
+	return actions, nil
+}
+
+type addBookmarkAction struct {
+	channelID   string
+	channelName string
+	bookmark    config.Bookmark
+}
+
+func (a addBookmarkAction) Describe() string {
+	return fmt.Sprintf("Add bookmark %q to channel %s: link = %s, emoji = %q", a.bookmark.Title, a.channelName, a.bookmark.Link, a.bookmark.Emoji)
+}
+
+func (a addBookmarkAction) Perform(reconciler *Reconciler) error {
+	id, err := reconciler.channelID(a.channelID, a.channelName)
+	if err != nil {
+		return fmt.Errorf("couldn't add bookmark %q to %s: %v", a.bookmark.Title, a.channelName, err)
+	}
+	req := map[string]string{
+		"channel_id": id,
+		"title":      a.bookmark.Title,
+		"type":       "link",
+		"link":       a.bookmark.Link,
+		"emoji":      a.bookmark.Emoji,
+	}
+	ret := struct {
+		Bookmark slack.Bookmark `json:"bookmark"`
+	}{}
+	if err := reconciler.slack.CallMethod("bookmarks.add", req, &ret); err != nil {
+		return fmt.Errorf("failed to add bookmark %q to %s (%s): %v", a.bookmark.Title, a.channelName, id, err)
+	}
+	if reconciler.channels.bookmarks != nil {
+		reconciler.channels.bookmarks[id] = append(reconciler.channels.bookmarks[id], ret.Bookmark)
+	}
+	return nil
+}
+
+type editBookmarkAction struct {
+	id          string
+	channelID   string
+	channelName string
+	bookmark    config.Bookmark
+}
+
+func (a editBookmarkAction) Describe() string {
+	return fmt.Sprintf("Update bookmark %q in channel %s: link = %s, emoji = %q", a.bookmark.Title, a.channelName, a.bookmark.Link, a.bookmark.Emoji)
+}
+
+func (a editBookmarkAction) Perform(reconciler *Reconciler) error {
+	req := map[string]string{
+		"bookmark_id": a.id,
+		"channel_id":  a.channelID,
+		"title":       a.bookmark.Title,
+		"link":        a.bookmark.Link,
+		"emoji":       a.bookmark.Emoji,
+	}
+	if err := reconciler.slack.CallMethod("bookmarks.edit", req, nil); err != nil {
+		return fmt.Errorf("failed to update bookmark %q in %s (%s): %v", a.bookmark.Title, a.channelName, a.channelID, err)
+	}
+	return nil
+}
+
+type removeBookmarkAction struct {
+	id          string
+	channelID   string
+	channelName string
+	title       string
+}
+
+func (a removeBookmarkAction) Describe() string {
+	return fmt.Sprintf("Remove bookmark %q from channel %s", a.title, a.channelName)
+}
+
+func (a removeBookmarkAction) Perform(reconciler *Reconciler) error {
+	req := map[string]string{
+		"bookmark_id": a.id,
+		"channel_id":  a.channelID,
+	}
+	if err := reconciler.slack.CallMethod("bookmarks.remove", req, nil); err != nil {
+		return fmt.Errorf("failed to remove bookmark %q from %s (%s): %v", a.title, a.channelName, a.channelID, err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconciler
+
+import (
+	"reflect"
+	"testing"
+
+	"sigs.k8s.io/slack-infra/slack"
+	"sigs.k8s.io/slack-infra/tempelis/config"
+)
+
+func TestReconcileBookmarks(t *testing.T) {
+	charter := config.Bookmark{Title: "Charter", Link: "https://git.k8s.io/community/sig-testing/charter.md", Emoji: ":scroll:"}
+	notes := config.Bookmark{Title: "Meeting notes", Link: "https://bit.ly/k8s-sig-testing-notes"}
+
+	tests := []struct {
+		name              string
+		priorChannels     []slack.Conversation
+		priorBookmarks    map[string][]slack.Bookmark
+		newChannels       []config.Channel
+		expectedActions   []Action
+		expectedErrCounts int
+	}{
+		{
+			name:           "channels without bookmarks configured are left alone",
+			priorChannels:  []slack.Conversation{{Name: "sig-testing", ID: "C12345678"}},
+			priorBookmarks: map[string][]slack.Bookmark{"C12345678": {{ID: "Bk1", Title: "Something"}}},
+			newChannels:    []config.Channel{{Name: "sig-testing"}},
+		},
+		{
+			name:            "adding bookmarks",
+			priorChannels:   []slack.Conversation{{Name: "sig-testing", ID: "C12345678"}},
+			priorBookmarks:  map[string][]slack.Bookmark{"C12345678": {}},
+			newChannels:     []config.Channel{{Name: "sig-testing", Bookmarks: []config.Bookmark{{Title: "Charter", Link: charter.Link, Emoji: "scroll"}, notes}}},
+			expectedActions: []Action{addBookmarkAction{channelID: "C12345678", channelName: "sig-testing", bookmark: charter}, addBookmarkAction{channelID: "C12345678", channelName: "sig-testing", bookmark: notes}},
+		},
+		{
+			name:            "adding bookmarks to a new channel",
+			newChannels:     []config.Channel{{Name: "sig-testing", Bookmarks: []config.Bookmark{notes}}},
+			expectedActions: []Action{addBookmarkAction{channelName: "sig-testing", bookmark: notes}},
+		},
+		{
+			name:          "editing and removing bookmarks",
+			priorChannels: []slack.Conversation{{Name: "sig-testing", ID: "C12345678"}},
+			priorBookmarks: map[string][]slack.Bookmark{"C12345678": {
+				{ID: "Bk1", Title: "Charter", Link: charter.Link, Emoji: ":page_facing_up:"},
+				{ID: "Bk2", Title: "Meeting notes", Link: notes.Link},
+				{ID: "Bk3", Title: "Zoom"},
+			}},
+			newChannels: []config.Channel{{Name: "sig-testing", Bookmarks: []config.Bookmark{charter, notes}}},
+			expectedActions: []Action{
+				editBookmarkAction{id: "Bk1", channelID: "C12345678", channelName: "sig-testing", bookmark: charter},
+				removeBookmarkAction{id: "Bk3", channelID: "C12345678", channelName: "sig-testing", title: "Zoom"},
+			},
+		},
+		{
+			name:            "an empty list removes all bookmarks",
+			priorChannels:   []slack.Conversation{{Name: "sig-testing", ID: "C12345678"}},
+			priorBookmarks:  map[string][]slack.Bookmark{"C12345678": {{ID: "Bk1", Title: "Zoom"}}},
+			newChannels:     []config.Channel{{Name: "sig-testing", Bookmarks: []config.Bookmark{}}},
+			expectedActions: []Action{removeBookmarkAction{id: "Bk1", channelID: "C12345678", channelName: "sig-testing", title: "Zoom"}},
+		},
+		{
+			name:           "archived channels are left alone",
+			priorChannels:  []slack.Conversation{{Name: "sig-testing", ID: "C12345678"}},
+			priorBookmarks: map[string][]slack.Bookmark{"C12345678": {{ID: "Bk1", Title: "Zoom"}}},
+			newChannels:    []config.Channel{{Name: "sig-testing", Archived: true, Bookmarks: []config.Bookmark{}}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := Reconciler{
+				config:   config.Config{Channels: tc.newChannels},
+				channels: channelState{byID: map[string]*slack.Conversation{}, byName: map[string]*slack.Conversation{}, bookmarks: tc.priorBookmarks},
+			}
+			for _, c := range tc.priorChannels {
+				c2 := c
+				r.channels.byID[c.ID] = &c2
+				r.channels.byName[c.Name] = &c2
+			}
+			actions, errs := r.reconcileBookmarks()
+			if !reflect.DeepEqual(actions, tc.expectedActions) {
+				t.Errorf("Expected actions: %#v\nActual actions: %#v", tc.expectedActions, actions)
+			}
+			if len(errs) != tc.expectedErrCounts {
+				t.Errorf("Expected %d errors, but got %d: %v", tc.expectedErrCounts, len(errs), errs)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconciler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// enforcedChannelIDs returns the IDs of every extant channel whose membership is enforced by some
+// usergroup.
+func (r *Reconciler) enforcedChannelIDs() []string {
+	seen := map[string]struct{}{}
+	var ids []string
+	for _, g := range r.config.Usergroups {
+		if !g.EnforceChannelMembership {
+			continue
+		}
+		for _, name := range g.Channels {
+			id := r.priorChannelID(name)
+			if id == "" {
+				continue
+			}
+			if _, ok := seen[id]; ok {
+				continue
+			}
+			seen[id] = struct{}{}
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func (r *Reconciler) reconcileChannelMembership() ([]Action, []error) {
+	var actions []Action
+	var errors []error
+
+	archived := map[string]bool{}
+	for _, c := range r.config.Channels {
+		archived[c.Name] = c.Archived
+	}
+
+	// Anyone who should be in a channel because of any usergroup must not be kicked from it
+	// because of another, so work out everyone who should be in each channel first.
+	var channelOrder []string
+	wanted := map[string]map[string]bool{}
+	removed := map[string]map[string]bool{}
+	for _, g := range r.config.Usergroups {
+		if !g.EnforceChannelMembership {
+			continue
+		}
+		targetIDs, err := r.usergroupMembers(g)
+		if err != nil {
+			errors = append(errors, fmt.Errorf("%s: %v", g.Name, err))
+			continue
+		}
+		var formerIDs []string
+		if o, ok := r.groups.byHandle[g.Name]; ok && g.KickRemovedMembers {
+			formerIDs = o.Users
+		}
+		for _, name := range g.Channels {
+			if archived[name] {
+				continue
+			}
+			if _, ok := wanted[name]; !ok {
+				channelOrder = append(channelOrder, name)
+				wanted[name] = map[string]bool{}
+				removed[name] = map[string]bool{}
+			}
+			for _, u := range targetIDs {
+				wanted[name][u] = true
+			}
+			for _, u := range formerIDs {
+				removed[name][u] = true
+			}
+		}
+	}
+
+	for _, name := range channelOrder {
+		present := map[string]bool{}
+		id := ""
+		if c, ok := r.channels.byName[name]; ok {
+			id = c.ID
+			for _, u := range r.channels.members[c.ID] {
+				present[u] = true
+			}
+		}
+
+		var invites []string
+		for u := range wanted[name] {
+			if !present[u] {
+				invites = append(invites, u)
+			}
+		}
+		if len(invites) > 0 {
+			actions = append(actions, inviteToChannelAction{id: id, name: name, users: sortedStrings(invites)})
+		}
+
+		var kicks []string
+		for u := range removed[name] {
+			if present[u] && !wanted[name][u] {
+				kicks = append(kicks, u)
+			}
+		}
+		for _, u := range sortedStrings(kicks) {
+			actions = append(actions, kickFromChannelAction{id: id, name: name, user: u})
+		}
+	}
+
+	return actions, errors
+}
+
+// channelID returns id if set, or otherwise looks up the ID of the named channel, which may
+// have been created since planning.
+func (r *Reconciler) channelID(id, name string) (string, error) {
+	if id != "" {
+		return id, nil
+	}
+	ids, err := r.channels.namesToIDs([]string{name})
+	if err != nil {
+		return "", err
+	}
+	if ids[0] == "" {
+		return "", fmt.Errorf("channel %s has no ID", name)
+	}
+	return ids[0], nil
+}
+
+// maxInvitesPerCall is the most users conversations.invite accepts at once.
+const maxInvitesPerCall = 1000
+
+type inviteToChannelAction struct {
+	id    string
+	name  string
+	users []string
+}
+
+func (a inviteToChannelAction) Describe() string {
+	return fmt.Sprintf("Invite %v to channel %s", a.users, a.name)
+}
+
+func (a inviteToChannelAction) Perform(reconciler *Reconciler) error {
+	id, err := reconciler.channelID(a.id, a.name)
+	if err != nil {
+		return fmt.Errorf("couldn't invite users to %s: %v", a.name, err)
+	}
+	for start := 0; start < len(a.users); start += maxInvitesPerCall {
+		end := start + maxInvitesPerCall
+		if end > len(a.users) {
+			end = len(a.users)
+		}
+		users := a.users[start:end]
+		if err := reconciler.slack.CallMethod("conversations.invite", map[string]string{"channel": id, "users": strings.Join(users, ",")}, nil); err != nil {
+			return fmt.Errorf("failed to invite %v to %s (%s): %v", users, a.name, id, err)
+		}
+	}
+	return nil
+}
+
+type kickFromChannelAction struct {
+	id   string
+	name string
+	user string
+}
+
+func (a kickFromChannelAction) Describe() string {
+	return fmt.Sprintf("Remove %s from channel %s", a.user, a.name)
+}
+
+func (a kickFromChannelAction) Perform(reconciler *Reconciler) error {
+	if err := reconciler.slack.CallMethod("conversations.kick", map[string]string{"channel": a.id, "user": a.user}, nil); err != nil {
+		return fmt.Errorf("failed to remove %s from %s (%s): %v", a.user, a.name, a.id, err)
+	}
+	return nil
+}
+
+func sortedStrings(s []string) []string {
+	sort.Strings(s)
+	return s
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconciler
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"sigs.k8s.io/slack-infra/slack"
+	"sigs.k8s.io/slack-infra/slack/slacktest"
+	"sigs.k8s.io/slack-infra/tempelis/config"
+)
+
+func TestReconcileChannelMembership(t *testing.T) {
+	userMapping := map[string]string{
+		"Katharine":   "U12345678",
+		"bentheelder": "U11111111",
+		"spiffxp":     "U22222222",
+	}
+
+	tests := []struct {
+		name             string
+		priorChannels    []slack.Conversation
+		priorMembers     map[string][]string
+		priorGroups      []slack.Subteam
+		channels         []config.Channel
+		newGroups        []config.Usergroup
+		expectedActions  []Action
+		expectedErrCount int
+	}{
+		{
+			name:          "groups that don't enforce membership are ignored",
+			priorChannels: []slack.Conversation{{Name: "sig-testing", ID: "C12345678"}},
+			priorMembers:  map[string][]string{"C12345678": {}},
+			newGroups:     []config.Usergroup{{Name: "sig-testing-leads", Members: []string{"Katharine"}, Channels: []string{"sig-testing"}}},
+		},
+		{
+			name:            "missing members are invited",
+			priorChannels:   []slack.Conversation{{Name: "sig-testing", ID: "C12345678"}},
+			priorMembers:    map[string][]string{"C12345678": {"U12345678"}},
+			newGroups:       []config.Usergroup{{Name: "sig-testing-leads", Members: []string{"Katharine", "bentheelder", "spiffxp"}, Channels: []string{"sig-testing"}, EnforceChannelMembership: true}},
+			expectedActions: []Action{inviteToChannelAction{id: "C12345678", name: "sig-testing", users: []string{"U11111111", "U22222222"}}},
+		},
+		{
+			name:            "everyone is invited to new channels",
+			newGroups:       []config.Usergroup{{Name: "sig-testing-leads", Members: []string{"Katharine"}, Channels: []string{"sig-testing"}, EnforceChannelMembership: true}},
+			expectedActions: []Action{inviteToChannelAction{name: "sig-testing", users: []string{"U12345678"}}},
+		},
+		{
+			name:          "archived channels are ignored",
+			priorChannels: []slack.Conversation{{Name: "sig-testing", ID: "C12345678"}},
+			priorMembers:  map[string][]string{"C12345678": {}},
+			channels:      []config.Channel{{Name: "sig-testing", Archived: true}},
+			newGroups:     []config.Usergroup{{Name: "sig-testing-leads", Members: []string{"Katharine"}, Channels: []string{"sig-testing"}, EnforceChannelMembership: true}},
+		},
+		{
+			name:          "removed members are not kicked by default",
+			priorChannels: []slack.Conversation{{Name: "sig-testing", ID: "C12345678"}},
+			priorMembers:  map[string][]string{"C12345678": {"U12345678", "U11111111"}},
+			priorGroups:   []slack.Subteam{{Handle: "sig-testing-leads", Users: []string{"U12345678", "U11111111"}}},
+			newGroups:     []config.Usergroup{{Name: "sig-testing-leads", Members: []string{"Katharine"}, Channels: []string{"sig-testing"}, EnforceChannelMembership: true}},
+		},
+		{
+			name:            "removed members are kicked if requested",
+			priorChannels:   []slack.Conversation{{Name: "sig-testing", ID: "C12345678"}},
+			priorMembers:    map[string][]string{"C12345678": {"U12345678", "U11111111", "U22222222"}},
+			priorGroups:     []slack.Subteam{{Handle: "sig-testing-leads", Users: []string{"U12345678", "U11111111"}}},
+			newGroups:       []config.Usergroup{{Name: "sig-testing-leads", Members: []string{"Katharine"}, Channels: []string{"sig-testing"}, EnforceChannelMembership: true, KickRemovedMembers: true}},
+			expectedActions: []Action{kickFromChannelAction{id: "C12345678", name: "sig-testing", user: "U11111111"}},
+		},
+		{
+			name:          "removed members are not kicked if another group keeps them in the channel",
+			priorChannels: []slack.Conversation{{Name: "sig-testing", ID: "C12345678"}},
+			priorMembers:  map[string][]string{"C12345678": {"U12345678", "U11111111"}},
+			priorGroups:   []slack.Subteam{{Handle: "sig-testing-leads", Users: []string{"U12345678", "U11111111"}}},
+			newGroups: []config.Usergroup{
+				{Name: "sig-testing-leads", Members: []string{"Katharine"}, Channels: []string{"sig-testing"}, EnforceChannelMembership: true, KickRemovedMembers: true},
+				{Name: "sig-testing-reviewers", Members: []string{"bentheelder"}, Channels: []string{"sig-testing"}, EnforceChannelMembership: true},
+			},
+		},
+		{
+			name:             "unknown members are an error",
+			priorChannels:    []slack.Conversation{{Name: "sig-testing", ID: "C12345678"}},
+			newGroups:        []config.Usergroup{{Name: "sig-testing-leads", Members: []string{"nobody"}, Channels: []string{"sig-testing"}, EnforceChannelMembership: true}},
+			expectedErrCount: 1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := Reconciler{
+				config:   config.Config{Channels: tc.channels, Usergroups: tc.newGroups, Users: userMapping},
+				channels: channelState{byID: map[string]*slack.Conversation{}, byName: map[string]*slack.Conversation{}, members: tc.priorMembers},
+				groups:   usergroupState{byID: map[string]*slack.Subteam{}, byHandle: map[string]*slack.Subteam{}},
+			}
+			for _, c := range tc.priorChannels {
+				c2 := c
+				r.channels.byID[c.ID] = &c2
+				r.channels.byName[c.Name] = &c2
+			}
+			for _, g := range tc.priorGroups {
+				g2 := g
+				r.groups.byHandle[g2.Handle] = &g2
+				r.groups.byID[g2.ID] = &g2
+			}
+			actions, errs := r.reconcileChannelMembership()
+			if !reflect.DeepEqual(actions, tc.expectedActions) {
+				t.Errorf("Expected actions: %#v\nActual actions: %#v", tc.expectedActions, actions)
+			}
+			if len(errs) != tc.expectedErrCount {
+				t.Errorf("Expected %d errors, but got %d: %v", tc.expectedErrCount, len(errs), errs)
+			}
+		})
+	}
+}
+
+func TestInviteToChannelActionPerform(t *testing.T) {
+	tests := []struct {
+		name          string
+		userCount     int
+		expectedCalls int
+	}{
+		{name: "a few users are invited at once", userCount: 3, expectedCalls: 1},
+		{name: "exactly as many users as fit in one call", userCount: maxInvitesPerCall, expectedCalls: 1},
+		{name: "more users are invited in batches", userCount: 2*maxInvitesPerCall + 1, expectedCalls: 3},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := slacktest.NewServer()
+			defer s.Close()
+			id := s.AddChannel(slack.Conversation{Name: "sig-testing"})
+			var users []string
+			for i := 0; i < tc.userCount; i++ {
+				users = append(users, fmt.Sprintf("U%08d", i))
+			}
+			r := New(s.Client(), config.Config{})
+
+			if err := (inviteToChannelAction{id: id, name: "sig-testing", users: users}).Perform(r); err != nil {
+				t.Fatalf("Failed to invite users: %v", err)
+			}
+			if calls := len(s.RequestsFor("conversations.invite")); calls != tc.expectedCalls {
+				t.Errorf("Expected %d calls to conversations.invite, but got %d", tc.expectedCalls, calls)
+			}
+			if members := s.Members(id); !reflect.DeepEqual(members, users) {
+				t.Errorf("Expected all %d users to be invited, but %d were", len(users), len(members))
+			}
+		})
+	}
+}
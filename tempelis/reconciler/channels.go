@@ -18,6 +18,7 @@ package reconciler
 
 import (
 	"fmt"
+	"strings"
 
 	"sigs.k8s.io/slack-infra/slack"
 )
@@ -37,10 +38,15 @@ func (r *Reconciler) reconcileChannels() ([]Action, []error) {
 			if o, ok := r.channels.byID[c.ID]; ok {
 				if o.Name != c.Name {
 					oldName := o.Name
+					if c.RenameForbidden {
+						errors = append(errors, fmt.Errorf("cannot rename channel %s to %s in %q", oldName, c.Name, c.DefinedIn))
+						delete(missingChannels, oldName)
+						continue
+					}
 					if err := r.channels.rename(oldName, c.Name); err != nil {
 						errors = append(errors, err)
 					} else {
-						actions = append(actions, renameChannelAction{id: o.ID, oldName: oldName, newName: c.Name})
+						actions = append(actions, renameChannelAction{id: o.ID, oldName: oldName, newName: c.Name, previousNames: o.PreviousNames})
 					}
 					delete(missingChannels, oldName)
 				}
@@ -49,7 +55,9 @@ func (r *Reconciler) reconcileChannels() ([]Action, []error) {
 			}
 		}
 		if o, ok := r.channels.byName[c.Name]; ok {
-			if c.Archived && !o.IsArchived {
+			if c.Archived != o.IsArchived && c.ArchiveForbidden {
+				errors = append(errors, fmt.Errorf("cannot archive or unarchive channel %s in %q", c.Name, c.DefinedIn))
+			} else if c.Archived && !o.IsArchived {
 				actions = append(actions, archiveChannelAction{id: o.ID, name: o.Name})
 			} else if !c.Archived && o.IsArchived {
 				actions = append(actions, unarchiveChannelAction{id: o.ID, name: o.Name})
@@ -59,11 +67,37 @@ func (r *Reconciler) reconcileChannels() ([]Action, []error) {
 			if c.Archived {
 				errors = append(errors, fmt.Errorf("channel %s is new but already marked as archived, which is not permitted", c.Name))
 			} else {
-				actions = append(actions, createChannelAction{name: c.Name})
+				actions = append(actions, createChannelAction{name: c.Name, topic: c.Topic, purpose: c.Purpose})
 			}
 		}
 	}
 
+	// Redirects are handled last, so that renames have already freed up the old names.
+	for _, c := range r.config.Channels {
+		for _, old := range c.RedirectFrom {
+			if o, ok := r.channels.byName[old]; ok {
+				delete(missingChannels, old)
+				if !o.IsArchived {
+					errors = append(errors, fmt.Errorf("channel %s (%s) should be an archived redirect to %s, but is not archived", old, o.ID, c.Name))
+				}
+				continue
+			}
+			if c.ArchiveForbidden {
+				errors = append(errors, fmt.Errorf("cannot create archived redirect %s to channel %s in %q", old, c.Name, c.DefinedIn))
+				continue
+			}
+			if err := r.channels.create(old); err != nil {
+				errors = append(errors, err)
+				continue
+			}
+			targetID := ""
+			if t, ok := r.channels.byName[c.Name]; ok {
+				targetID = t.ID
+			}
+			actions = append(actions, createRedirectChannelAction{name: old, targetID: targetID, targetName: c.Name})
+		}
+	}
+
 	for _, o := range missingChannels {
 		errors = append(errors, fmt.Errorf("channel %s (%s) not referenced in config", o.Name, o.ID))
 	}
@@ -72,7 +106,9 @@ func (r *Reconciler) reconcileChannels() ([]Action, []error) {
 }
 
 type createChannelAction struct {
-	name string
+	name    string
+	topic   string
+	purpose string
 }
 
 func (a createChannelAction) Describe() string {
@@ -88,43 +124,59 @@ func (a createChannelAction) Perform(reconciler *Reconciler) error {
 	}
 	c := ret.Channel
 	reconciler.channels.byName[c.Name] = &c
-	reconciler.channels.byID[c.Name] = &c
+	reconciler.channels.byID[c.ID] = &c
 	t := &reconciler.config.ChannelTemplate
-	if t.Topic != "" {
-		if err := reconciler.slack.CallMethod("conversations.setTopic", map[string]string{"channel": c.ID, "topic": t.Topic}, nil); err != nil {
-			return fmt.Errorf("failed to set topic of channel %s to %q: %v", c.Name, t.Topic, err)
+	topic := a.topic
+	if topic == "" {
+		topic = t.Topic
+	}
+	if topic != "" {
+		if err := reconciler.slack.CallMethod("conversations.setTopic", map[string]string{"channel": c.ID, "topic": topic}, nil); err != nil {
+			return fmt.Errorf("failed to set topic of channel %s to %q: %v", c.Name, topic, err)
 		}
 	}
-	if t.Purpose != "" {
-		if err := reconciler.slack.CallMethod("conversations.setPurpose", map[string]interface{}{"channel": c.ID, "purpose": t.Purpose}, nil); err != nil {
-			return fmt.Errorf("failed to set purpose of channel %s to %q: %v", c.Name, c.Topic, err)
+	purpose := a.purpose
+	if purpose == "" {
+		purpose = t.Purpose
+	}
+	if purpose != "" {
+		if err := reconciler.slack.CallMethod("conversations.setPurpose", map[string]interface{}{"channel": c.ID, "purpose": purpose}, nil); err != nil {
+			return fmt.Errorf("failed to set purpose of channel %s to %q: %v", c.Name, purpose, err)
 		}
 	}
 	for _, p := range t.Pins {
-		message := struct {
-			Channel   string `json:"channel"`
-			Text      string `json:"text"`
-			AsUser    bool   `json:"as_user"`
-			LinkNames bool   `json:"link_names"`
-		}{
-			Channel:   c.ID,
-			Text:      p,
-			AsUser:    false,
-			LinkNames: true,
-		}
-		r := struct {
-			TS string `json:"ts"`
-		}{}
-		if err := reconciler.slack.CallMethod("chat.postMessage", message, &r); err != nil {
-			return fmt.Errorf("failed to send message: %v", err)
-		}
-		if err := reconciler.slack.CallMethod("pins.add", map[string]string{"channel": c.ID, "timestamp": r.TS}, nil); err != nil {
-			return fmt.Errorf("failed to pin message %s in %s: %v", r.TS, c.Name, err)
+		if err := reconciler.postAndPin(c, p); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
+// postAndPin posts text in c and pins it.
+func (r *Reconciler) postAndPin(c slack.Conversation, text string) error {
+	message := struct {
+		Channel   string `json:"channel"`
+		Text      string `json:"text"`
+		AsUser    bool   `json:"as_user"`
+		LinkNames bool   `json:"link_names"`
+	}{
+		Channel:   c.ID,
+		Text:      text,
+		AsUser:    false,
+		LinkNames: true,
+	}
+	ret := struct {
+		TS string `json:"ts"`
+	}{}
+	if err := r.slack.CallMethod("chat.postMessage", message, &ret); err != nil {
+		return fmt.Errorf("failed to send message: %v", err)
+	}
+	if err := r.slack.CallMethod("pins.add", map[string]string{"channel": c.ID, "timestamp": ret.TS}, nil); err != nil {
+		return fmt.Errorf("failed to pin message %s in %s: %v", ret.TS, c.Name, err)
+	}
+	return nil
+}
+
 type unarchiveChannelAction struct {
 	id   string
 	name string
@@ -161,10 +213,16 @@ type renameChannelAction struct {
 	id      string
 	oldName string
 	newName string
+	// previousNames are the names the channel had before oldName, according to Slack.
+	previousNames []string
 }
 
 func (a renameChannelAction) Describe() string {
-	return fmt.Sprintf("Rename channel %s from %s to %s", a.id, a.oldName, a.newName)
+	d := fmt.Sprintf("Rename channel %s from %s to %s", a.id, a.oldName, a.newName)
+	if len(a.previousNames) > 0 {
+		d += fmt.Sprintf(" (it was previously called %s)", strings.Join(a.previousNames, ", "))
+	}
+	return d
 }
 
 func (a renameChannelAction) Perform(reconciler *Reconciler) error {
@@ -173,3 +231,37 @@ func (a renameChannelAction) Perform(reconciler *Reconciler) error {
 	}
 	return nil
 }
+
+type createRedirectChannelAction struct {
+	name       string
+	targetID   string
+	targetName string
+}
+
+func (a createRedirectChannelAction) Describe() string {
+	return fmt.Sprintf("Create archived channel %s redirecting to %s", a.name, a.targetName)
+}
+
+func (a createRedirectChannelAction) Perform(reconciler *Reconciler) error {
+	targetID, err := reconciler.channelID(a.targetID, a.targetName)
+	if err != nil {
+		return fmt.Errorf("couldn't create redirect to %s: %v", a.targetName, err)
+	}
+	ret := struct {
+		Channel slack.Conversation `json:"channel"`
+	}{}
+	if err := reconciler.slack.CallMethod("conversations.create", map[string]string{"name": a.name}, &ret); err != nil {
+		return fmt.Errorf("failed to create channel %s: %v", a.name, err)
+	}
+	c := ret.Channel
+	reconciler.channels.byName[c.Name] = &c
+	reconciler.channels.byID[c.ID] = &c
+	if err := reconciler.postAndPin(c, fmt.Sprintf("This channel is now <#%s|%s>. Please head over there!", targetID, a.targetName)); err != nil {
+		return err
+	}
+	if err := reconciler.slack.CallMethod("conversations.archive", map[string]string{"channel": c.ID}, nil); err != nil {
+		return fmt.Errorf("failed to archive channel %s (%s): %v", c.Name, c.ID, err)
+	}
+	c.IsArchived = true
+	return nil
+}
@@ -21,6 +21,7 @@ import (
 	"testing"
 
 	"sigs.k8s.io/slack-infra/slack"
+	"sigs.k8s.io/slack-infra/slack/slacktest"
 	"sigs.k8s.io/slack-infra/tempelis/config"
 )
 
@@ -38,6 +39,11 @@ func TestReconcileChannels(t *testing.T) {
 			newChannels:     []config.Channel{{Name: "sig-testing"}, {Name: "sig-contribex"}},
 			expectedActions: []Action{createChannelAction{name: "sig-contribex"}},
 		},
+		{
+			name:            "new channels get their own topic and purpose",
+			newChannels:     []config.Channel{{Name: "sig-contribex", Topic: "Contributor experience", Purpose: "SIG ContribEx"}},
+			expectedActions: []Action{createChannelAction{name: "sig-contribex", topic: "Contributor experience", purpose: "SIG ContribEx"}},
+		},
 		{
 			name:            "archive a channel",
 			priorChannels:   []slack.Conversation{{Name: "sig-testing", ID: "C12345678"}},
@@ -67,12 +73,82 @@ func TestReconcileChannels(t *testing.T) {
 			newChannels:     []config.Channel{{Name: "sig-ponies", ID: "C12345678"}},
 			expectedActions: []Action{renameChannelAction{id: "C12345678", oldName: "sig-testing", newName: "sig-ponies"}},
 		},
+		{
+			name:            "renaming a channel mentions its previous names",
+			priorChannels:   []slack.Conversation{{Name: "sig-testing", ID: "C12345678", PreviousNames: []string{"testing"}}},
+			newChannels:     []config.Channel{{Name: "sig-ponies", ID: "C12345678"}},
+			expectedActions: []Action{renameChannelAction{id: "C12345678", oldName: "sig-testing", newName: "sig-ponies", previousNames: []string{"testing"}}},
+		},
+		{
+			name:          "renaming a channel with a redirect creates the redirect",
+			priorChannels: []slack.Conversation{{Name: "sig-testing", ID: "C12345678"}},
+			newChannels:   []config.Channel{{Name: "sig-ponies", ID: "C12345678", RedirectFrom: []string{"sig-testing"}}},
+			expectedActions: []Action{
+				renameChannelAction{id: "C12345678", oldName: "sig-testing", newName: "sig-ponies"},
+				createRedirectChannelAction{name: "sig-testing", targetID: "C12345678", targetName: "sig-ponies"},
+			},
+		},
+		{
+			name:            "redirects to new channels are created",
+			newChannels:     []config.Channel{{Name: "sig-ponies", RedirectFrom: []string{"sig-horses"}}},
+			expectedActions: []Action{createChannelAction{name: "sig-ponies"}, createRedirectChannelAction{name: "sig-horses", targetName: "sig-ponies"}},
+		},
+		{
+			name:          "existing archived redirects are left alone",
+			priorChannels: []slack.Conversation{{Name: "sig-ponies", ID: "C12345678"}, {Name: "sig-testing", ID: "C11111111", IsArchived: true}},
+			newChannels:   []config.Channel{{Name: "sig-ponies", RedirectFrom: []string{"sig-testing"}}},
+		},
+		{
+			name:             "creating a redirect is an error where archiving is forbidden",
+			priorChannels:    []slack.Conversation{{Name: "sig-ponies", ID: "C12345678"}},
+			newChannels:      []config.Channel{{Name: "sig-ponies", RedirectFrom: []string{"sig-testing"}, ArchiveForbidden: true}},
+			expectedErrCount: 1,
+		},
+		{
+			name:          "existing redirects can be listed where archiving is forbidden",
+			priorChannels: []slack.Conversation{{Name: "sig-ponies", ID: "C12345678"}, {Name: "sig-testing", ID: "C11111111", IsArchived: true}},
+			newChannels:   []config.Channel{{Name: "sig-ponies", RedirectFrom: []string{"sig-testing"}, ArchiveForbidden: true}},
+		},
+		{
+			name:             "a redirect that isn't archived is an error",
+			priorChannels:    []slack.Conversation{{Name: "sig-ponies", ID: "C12345678"}, {Name: "sig-testing", ID: "C11111111"}},
+			newChannels:      []config.Channel{{Name: "sig-ponies", RedirectFrom: []string{"sig-testing"}}},
+			expectedErrCount: 1,
+		},
 		{
 			name:             "creating an archived channel is an error",
 			priorChannels:    []slack.Conversation{},
 			newChannels:      []config.Channel{{Name: "sig-ponies", Archived: true}},
 			expectedErrCount: 1,
 		},
+		{
+			name:             "archiving is an error where it's forbidden",
+			priorChannels:    []slack.Conversation{{Name: "sig-testing", ID: "C12345678"}},
+			newChannels:      []config.Channel{{Name: "sig-testing", Archived: true, ArchiveForbidden: true}},
+			expectedErrCount: 1,
+		},
+		{
+			name:             "unarchiving is an error where archiving is forbidden",
+			priorChannels:    []slack.Conversation{{Name: "sig-testing", ID: "C12345678", IsArchived: true}},
+			newChannels:      []config.Channel{{Name: "sig-testing", ArchiveForbidden: true}},
+			expectedErrCount: 1,
+		},
+		{
+			name:          "already archived channels can be listed where archiving is forbidden",
+			priorChannels: []slack.Conversation{{Name: "sig-testing", ID: "C12345678", IsArchived: true}},
+			newChannels:   []config.Channel{{Name: "sig-testing", Archived: true, ArchiveForbidden: true}},
+		},
+		{
+			name:             "renaming is an error where it's forbidden",
+			priorChannels:    []slack.Conversation{{Name: "sig-testing", ID: "C12345678"}},
+			newChannels:      []config.Channel{{Name: "sig-ponies", ID: "C12345678", RenameForbidden: true}},
+			expectedErrCount: 1,
+		},
+		{
+			name:          "channels can have their current IDs where renaming is forbidden",
+			priorChannels: []slack.Conversation{{Name: "sig-testing", ID: "C12345678"}},
+			newChannels:   []config.Channel{{Name: "sig-testing", ID: "C12345678", RenameForbidden: true}},
+		},
 		{
 			name:             "simultaneously create, rename, archive, and unarchive channels, while reporting an error",
 			priorChannels:    []slack.Conversation{{Name: "sig-testing", ID: "C12345678"}, {Name: "sig-ponies", ID: "C11111111", IsArchived: true}, {Name: "sig-what", ID: "C22222222"}},
@@ -103,3 +179,36 @@ func TestReconcileChannels(t *testing.T) {
 		})
 	}
 }
+
+func TestCreateChannelActionPerform(t *testing.T) {
+	s := slacktest.NewServer()
+	defer s.Close()
+	r := New(s.Client(), config.Config{ChannelTemplate: config.ChannelTemplate{Purpose: "Discussion", Pins: []string{"Welcome!"}}})
+	if err := r.init(); err != nil {
+		t.Fatalf("Failed to init reconciler: %v", err)
+	}
+
+	if err := (createChannelAction{name: "sig-testing", topic: "Testing things"}).Perform(r); err != nil {
+		t.Fatalf("Failed to create channel: %v", err)
+	}
+	c, ok := s.ChannelByName("sig-testing")
+	if !ok {
+		t.Fatalf("Expected channel sig-testing to have been created")
+	}
+	if c.Topic.Topic != "Testing things" || c.Purpose.Purpose != "Discussion" {
+		t.Errorf("Expected the given topic and the template's purpose, but got %q and %q", c.Topic.Topic, c.Purpose.Purpose)
+	}
+	messages := s.Messages(c.ID)
+	if len(messages) != 1 || messages[0].Text != "Welcome!" {
+		t.Fatalf("Expected the template's pin to be posted, but got %#v", messages)
+	}
+	if pins := s.Pins(c.ID); !reflect.DeepEqual(pins, []string{messages[0].TS}) {
+		t.Errorf("Expected the posted message to be pinned, but got pins %v", pins)
+	}
+	if _, ok := r.channels.byName["sig-testing"]; !ok {
+		t.Errorf("Expected the reconciler to know about the new channel")
+	}
+	if got, ok := r.channels.byID[c.ID]; !ok || got.Name != "sig-testing" {
+		t.Errorf("Expected the reconciler to know the new channel by its ID %s", c.ID)
+	}
+}
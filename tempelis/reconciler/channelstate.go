@@ -25,6 +25,10 @@ import (
 type channelState struct {
 	byName map[string]*slack.Conversation
 	byID   map[string]*slack.Conversation
+	// members maps channel IDs to member IDs, but only for channels passed to initMembers.
+	members map[string][]string
+	// bookmarks maps channel IDs to bookmarks, but only for channels passed to initBookmarks.
+	bookmarks map[string][]slack.Bookmark
 }
 
 func (c *channelState) init(s *slack.Client) error {
@@ -42,6 +46,32 @@ func (c *channelState) init(s *slack.Client) error {
 	return nil
 }
 
+// initMembers fetches the members of each of the given channel IDs.
+func (c *channelState) initMembers(s *slack.Client, ids []string) error {
+	c.members = map[string][]string{}
+	for _, id := range ids {
+		members, err := s.GetConversationMembers(id)
+		if err != nil {
+			return err
+		}
+		c.members[id] = members
+	}
+	return nil
+}
+
+// initBookmarks fetches the bookmarks in each of the given channel IDs.
+func (c *channelState) initBookmarks(s *slack.Client, ids []string) error {
+	c.bookmarks = map[string][]slack.Bookmark{}
+	for _, id := range ids {
+		bookmarks, err := s.GetBookmarks(id)
+		if err != nil {
+			return err
+		}
+		c.bookmarks[id] = bookmarks
+	}
+	return nil
+}
+
 func (c *channelState) rename(old, new string) error {
 	if _, ok := c.byName[new]; ok {
 		return fmt.Errorf("can't rename %s to %s: name already used", old, new)
@@ -80,3 +110,21 @@ func (c *channelState) namesToIDs(names []string) ([]string, error) {
 	}
 	return result, nil
 }
+
+// idsToNames returns the names of the channels with the given IDs.
+func (c *channelState) idsToNames(ids []string) ([]string, error) {
+	var result []string
+	var missing []string
+	for _, id := range ids {
+		if r, ok := c.byID[id]; ok {
+			result = append(result, r.Name)
+		} else {
+			missing = append(missing, id)
+		}
+	}
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("couldn't find channel names: %s", strings.Join(missing, ", "))
+	}
+	return result, nil
+}
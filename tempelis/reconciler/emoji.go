@@ -0,0 +1,144 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconciler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/slack-infra/tempelis/config"
+)
+
+func (r *Reconciler) reconcileEmoji() ([]Action, []error) {
+	// Emoji management is opt-in: if nothing is configured, leave everything alone.
+	if len(r.config.Emoji) == 0 {
+		return nil, nil
+	}
+
+	var removals, images, aliases []Action
+	var errors []error
+
+	names := make([]string, 0, len(r.config.Emoji))
+	for name := range r.config.Emoji {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		v := r.config.Emoji[name]
+		current, exists := r.emoji.byName[name]
+		_, currentIsAlias := config.IsEmojiAlias(current)
+		if v == config.EmojiRemoved {
+			if exists {
+				removals = append(removals, removeEmojiAction{name: name})
+			}
+			continue
+		}
+		if target, ok := config.IsEmojiAlias(v); ok {
+			if r.config.Emoji[target] == config.EmojiRemoved {
+				errors = append(errors, fmt.Errorf("emoji %s is an alias for %s, which is being removed", name, target))
+				continue
+			}
+			if exists && current == v {
+				continue
+			}
+			if exists {
+				removals = append(removals, removeEmojiAction{name: name})
+			}
+			aliases = append(aliases, addEmojiAliasAction{name: name, aliasFor: target})
+			continue
+		}
+		// We can't cheaply tell whether an existing image matches the configured one, so existing
+		// images are left alone. To change an image, rename the emoji.
+		if exists && !currentIsAlias {
+			continue
+		}
+		if r.emojiURLBase == "" {
+			errors = append(errors, fmt.Errorf("can't add emoji %s: no emoji URL base was provided", name))
+			continue
+		}
+		if exists {
+			removals = append(removals, removeEmojiAction{name: name})
+		}
+		images = append(images, addEmojiAction{name: name, url: strings.TrimSuffix(r.emojiURLBase, "/") + "/" + v})
+	}
+
+	// Like channels, emoji that aren't in the config are reported rather than removed, since they
+	// were probably uploaded by hand. Emoji are only removed if the config says so.
+	var extra []string
+	for name := range r.emoji.byName {
+		if _, ok := r.config.Emoji[name]; !ok {
+			extra = append(extra, name)
+		}
+	}
+	sort.Strings(extra)
+	for _, name := range extra {
+		errors = append(errors, fmt.Errorf("emoji %s not referenced in config", name))
+	}
+
+	// Remove first so names can be reused, and add images before aliases so aliases have targets.
+	actions := append(removals, images...)
+	return append(actions, aliases...), errors
+}
+
+type addEmojiAction struct {
+	name string
+	url  string
+}
+
+func (a addEmojiAction) Describe() string {
+	return fmt.Sprintf("Add emoji :%s: from %s", a.name, a.url)
+}
+
+func (a addEmojiAction) Perform(reconciler *Reconciler) error {
+	if err := reconciler.slack.CallOldMethod("admin.emoji.add", map[string]string{"name": a.name, "url": a.url}, nil); err != nil {
+		return fmt.Errorf("failed to add emoji %s from %s: %v", a.name, a.url, err)
+	}
+	return nil
+}
+
+type addEmojiAliasAction struct {
+	name     string
+	aliasFor string
+}
+
+func (a addEmojiAliasAction) Describe() string {
+	return fmt.Sprintf("Add emoji :%s: as an alias for :%s:", a.name, a.aliasFor)
+}
+
+func (a addEmojiAliasAction) Perform(reconciler *Reconciler) error {
+	if err := reconciler.slack.CallOldMethod("admin.emoji.addAlias", map[string]string{"name": a.name, "alias_for": a.aliasFor}, nil); err != nil {
+		return fmt.Errorf("failed to add emoji alias %s for %s: %v", a.name, a.aliasFor, err)
+	}
+	return nil
+}
+
+type removeEmojiAction struct {
+	name string
+}
+
+func (a removeEmojiAction) Describe() string {
+	return fmt.Sprintf("Remove emoji :%s:", a.name)
+}
+
+func (a removeEmojiAction) Perform(reconciler *Reconciler) error {
+	if err := reconciler.slack.CallOldMethod("admin.emoji.remove", map[string]string{"name": a.name}, nil); err != nil {
+		return fmt.Errorf("failed to remove emoji %s: %v", a.name, err)
+	}
+	return nil
+}
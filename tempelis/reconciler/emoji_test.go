@@ -0,0 +1,105 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconciler
+
+import (
+	"reflect"
+	"testing"
+
+	"sigs.k8s.io/slack-infra/tempelis/config"
+)
+
+func TestReconcileEmoji(t *testing.T) {
+	tests := []struct {
+		name             string
+		priorEmoji       map[string]string
+		newEmoji         map[string]string
+		urlBase          string
+		expectedActions  []Action
+		expectedErrCount int
+	}{
+		{
+			name:       "nothing configured leaves everything alone",
+			priorEmoji: map[string]string{"prow": "https://emoji.slack-edge.com/prow.png"},
+		},
+		{
+			name:            "adding an image",
+			priorEmoji:      map[string]string{"prow": "https://emoji.slack-edge.com/prow.png"},
+			newEmoji:        map[string]string{"prow": "emoji/prow.png", "kubernetes": "emoji/kubernetes.png"},
+			urlBase:         "https://example.com/config/",
+			expectedActions: []Action{addEmojiAction{name: "kubernetes", url: "https://example.com/config/emoji/kubernetes.png"}},
+		},
+		{
+			name:             "adding an image without a URL base is an error",
+			newEmoji:         map[string]string{"kubernetes": "emoji/kubernetes.png"},
+			expectedErrCount: 1,
+		},
+		{
+			name:            "adding an alias after its target",
+			newEmoji:        map[string]string{"k8s": "alias:kubernetes", "kubernetes": "emoji/kubernetes.png"},
+			urlBase:         "https://example.com/config",
+			expectedActions: []Action{addEmojiAction{name: "kubernetes", url: "https://example.com/config/emoji/kubernetes.png"}, addEmojiAliasAction{name: "k8s", aliasFor: "kubernetes"}},
+		},
+		{
+			name:            "changing an alias target",
+			priorEmoji:      map[string]string{"k8s": "alias:kubernetes"},
+			newEmoji:        map[string]string{"k8s": "alias:k8s-logo"},
+			expectedActions: []Action{removeEmojiAction{name: "k8s"}, addEmojiAliasAction{name: "k8s", aliasFor: "k8s-logo"}},
+		},
+		{
+			name:             "unlisted emoji are an error, not removed",
+			priorEmoji:       map[string]string{"k8s": "alias:kubernetes", "kubernetes": "https://emoji.slack-edge.com/kubernetes.png"},
+			newEmoji:         map[string]string{"kubernetes": "emoji/kubernetes.png"},
+			expectedErrCount: 1,
+		},
+		{
+			name:            "removing an emoji",
+			priorEmoji:      map[string]string{"k8s": "alias:kubernetes", "kubernetes": "https://emoji.slack-edge.com/kubernetes.png"},
+			newEmoji:        map[string]string{"k8s": "removed", "kubernetes": "emoji/kubernetes.png"},
+			expectedActions: []Action{removeEmojiAction{name: "k8s"}},
+		},
+		{
+			name:       "removing an emoji that is already gone does nothing",
+			priorEmoji: map[string]string{"kubernetes": "https://emoji.slack-edge.com/kubernetes.png"},
+			newEmoji:   map[string]string{"k8s": "removed", "kubernetes": "emoji/kubernetes.png"},
+		},
+		{
+			name:             "aliases for removed emoji are an error",
+			priorEmoji:       map[string]string{"k8s": "alias:kubernetes", "kubernetes": "https://emoji.slack-edge.com/kubernetes.png"},
+			newEmoji:         map[string]string{"k8s": "alias:kubernetes", "kubernetes": "removed"},
+			expectedActions:  []Action{removeEmojiAction{name: "kubernetes"}},
+			expectedErrCount: 1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := Reconciler{
+				config:       config.Config{Emoji: tc.newEmoji},
+				emoji:        emojiState{byName: tc.priorEmoji},
+				emojiURLBase: tc.urlBase,
+			}
+			actions, errs := r.reconcileEmoji()
+			if !reflect.DeepEqual(actions, tc.expectedActions) {
+				t.Errorf("Expected actions: %#v\nActual actions: %#v", tc.expectedActions, actions)
+			}
+			if len(errs) != tc.expectedErrCount {
+				t.Errorf("Expected %d errors, but got %d: %v", tc.expectedErrCount, len(errs), errs)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconciler
+
+import (
+	"fmt"
+
+	"sigs.k8s.io/slack-infra/slack"
+)
+
+type emojiState struct {
+	// byName maps emoji names to either their image URL or "alias:" and the emoji they alias.
+	byName map[string]string
+}
+
+func (e *emojiState) init(s *slack.Client) error {
+	result := struct {
+		Emoji map[string]string `json:"emoji"`
+	}{}
+	if err := s.CallOldMethod("emoji.list", nil, &result); err != nil {
+		return fmt.Errorf("couldn't get emoji list: %v", err)
+	}
+	e.byName = result.Emoji
+	if e.byName == nil {
+		e.byName = map[string]string{}
+	}
+	return nil
+}
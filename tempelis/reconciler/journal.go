@@ -0,0 +1,269 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconciler
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Journal appends a record of every action applied to Slack to a JSON Lines file.
+type Journal struct {
+	path     string
+	runID    string
+	revision string
+	team     string
+}
+
+// JournalEntry records a single applied action.
+type JournalEntry struct {
+	RunID    string    `json:"run_id"`
+	Time     time.Time `json:"time"`
+	Revision string    `json:"revision,omitempty"`
+	// Team is the ID of the Slack team the action was applied to.
+	Team    string `json:"team,omitempty"`
+	Type    string `json:"type"`
+	Target  string `json:"target,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Channel string `json:"channel,omitempty"`
+	// ChannelName is the name of Channel, which is all that is known of it when it is created in
+	// the same run.
+	ChannelName string   `json:"channel_name,omitempty"`
+	Users       []string `json:"users,omitempty"`
+	Description string   `json:"description"`
+	// Prior is the state of the target before the action was applied.
+	Prior *PriorState `json:"prior,omitempty"`
+	Error string      `json:"error,omitempty"`
+}
+
+// PriorState is the subset of an object's state that an action might change.
+type PriorState struct {
+	Name        string   `json:"name,omitempty"`
+	Archived    bool     `json:"archived,omitempty"`
+	Disabled    bool     `json:"disabled,omitempty"`
+	Members     []string `json:"members,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Channels    []string `json:"channels,omitempty"`
+	Link        string   `json:"link,omitempty"`
+	Emoji       string   `json:"emoji,omitempty"`
+	Value       string   `json:"value,omitempty"`
+	// PreviousNames are the names a channel had before Name.
+	PreviousNames []string `json:"previous_names,omitempty"`
+}
+
+// NewJournal returns a Journal that appends to the file at path, recording actions under a fresh
+// run ID. revision should identify the version of the config being applied, and team is the ID of
+// the Slack team it is being applied to.
+func NewJournal(path, revision, team string) (*Journal, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("couldn't generate run ID: %v", err)
+	}
+	return &Journal{
+		path:     path,
+		runID:    time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(b),
+		revision: revision,
+		team:     team,
+	}, nil
+}
+
+// RunID returns the ID under which this journal records actions.
+func (j *Journal) RunID() string {
+	return j.runID
+}
+
+func (j *Journal) record(e JournalEntry) error {
+	e.RunID = j.runID
+	e.Revision = j.revision
+	e.Team = j.team
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("couldn't marshal journal entry: %v", err)
+	}
+	f, err := os.OpenFile(j.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("couldn't open journal: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("couldn't write journal entry: %v", err)
+	}
+	return f.Sync()
+}
+
+// ReadJournal returns every entry in the journal at path recorded under runID, in the order they
+// were recorded.
+func ReadJournal(path, runID string) ([]JournalEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open journal: %v", err)
+	}
+	defer f.Close()
+
+	var entries []JournalEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var e JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("couldn't parse journal line %d: %v", line, err)
+		}
+		if e.RunID == runID {
+			entries = append(entries, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("couldn't read journal: %v", err)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no entries for run %s", runID)
+	}
+	return entries, nil
+}
+
+// journalEntry describes a, capturing the state it is about to change. It must be called before a
+// is performed.
+func (r *Reconciler) journalEntry(a Action) JournalEntry {
+	e := JournalEntry{Time: time.Now().UTC(), Description: a.Describe()}
+	switch a := a.(type) {
+	case createChannelAction:
+		e.Type, e.Name = "create_channel", a.name
+	case createRedirectChannelAction:
+		e.Type, e.Name, e.Channel = "create_redirect_channel", a.name, a.targetID
+	case archiveChannelAction:
+		e.Type, e.Target, e.Name = "archive_channel", a.id, a.name
+		e.Prior = r.priorChannelState(a.id)
+	case unarchiveChannelAction:
+		e.Type, e.Target, e.Name = "unarchive_channel", a.id, a.name
+		e.Prior = r.priorChannelState(a.id)
+	case renameChannelAction:
+		e.Type, e.Target, e.Name = "rename_channel", a.id, a.newName
+		e.Prior = &PriorState{Name: a.oldName, PreviousNames: a.previousNames}
+	case updateUsergroupAction:
+		e.Type, e.Target, e.Name = "update_usergroup", a.id, a.handle
+		if a.create {
+			e.Type = "create_usergroup"
+		}
+		e.Prior = r.priorUsergroupState(a.id)
+	case updateUsergroupMembersAction:
+		e.Type, e.Target, e.Name = "update_usergroup_members", a.id, a.name
+		if a.id == "" {
+			if g, ok := r.groups.byHandle[a.name]; ok {
+				e.Target = g.ID
+			}
+		}
+		e.Prior = r.priorUsergroupState(e.Target)
+	case deactivateUsergroupAction:
+		e.Type, e.Target, e.Name = "deactivate_usergroup", a.id, a.handle
+		e.Prior = r.priorUsergroupState(a.id)
+	case reactivateUsergroupAction:
+		e.Type, e.Target, e.Name = "reactivate_usergroup", a.id, a.handle
+		e.Prior = r.priorUsergroupState(a.id)
+	case announceRotationAction:
+		e.Type, e.Name, e.Channel, e.Users = "announce_rotation", a.handle, a.channel, a.users
+	case inviteToChannelAction:
+		e.Type, e.Target, e.Name, e.Users = "invite_to_channel", a.id, a.name, a.users
+	case kickFromChannelAction:
+		e.Type, e.Target, e.Name, e.Users = "kick_from_channel", a.id, a.name, []string{a.user}
+	case addBookmarkAction:
+		e.Type, e.Name, e.Channel, e.ChannelName = "add_bookmark", a.bookmark.Title, a.channelID, a.channelName
+	case editBookmarkAction:
+		e.Type, e.Target, e.Name, e.Channel, e.ChannelName = "edit_bookmark", a.id, a.bookmark.Title, a.channelID, a.channelName
+		e.Prior = r.priorBookmarkState(a.channelID, a.id)
+	case removeBookmarkAction:
+		e.Type, e.Target, e.Name, e.Channel, e.ChannelName = "remove_bookmark", a.id, a.title, a.channelID, a.channelName
+		e.Prior = r.priorBookmarkState(a.channelID, a.id)
+	case addEmojiAction:
+		e.Type, e.Name = "add_emoji", a.name
+	case addEmojiAliasAction:
+		e.Type, e.Name = "add_emoji_alias", a.name
+	case removeEmojiAction:
+		e.Type, e.Name = "remove_emoji", a.name
+		e.Prior = &PriorState{Value: r.emoji.byName[a.name]}
+	default:
+		e.Type = fmt.Sprintf("%T", a)
+	}
+	return e
+}
+
+// completeJournalEntry fills in the IDs of objects created by the action e describes. It must be
+// called after the action is performed.
+func (r *Reconciler) completeJournalEntry(e *JournalEntry) {
+	switch e.Type {
+	case "create_channel", "create_redirect_channel":
+		if c, ok := r.channels.byName[e.Name]; ok {
+			e.Target = c.ID
+		}
+	case "create_usergroup":
+		if g, ok := r.groups.byHandle[e.Name]; ok {
+			e.Target = g.ID
+		}
+	case "invite_to_channel":
+		if e.Target == "" {
+			if c, ok := r.channels.byName[e.Name]; ok {
+				e.Target = c.ID
+			}
+		}
+	case "add_bookmark":
+		if e.Channel == "" {
+			if c, ok := r.channels.byName[e.ChannelName]; ok {
+				e.Channel = c.ID
+			}
+		}
+		for _, b := range r.channels.bookmarks[e.Channel] {
+			if b.Title == e.Name {
+				e.Target = b.ID
+			}
+		}
+	}
+}
+
+func (r *Reconciler) priorChannelState(id string) *PriorState {
+	c, ok := r.channels.byID[id]
+	if !ok {
+		return nil
+	}
+	return &PriorState{Name: c.Name, Archived: c.IsArchived}
+}
+
+func (r *Reconciler) priorUsergroupState(id string) *PriorState {
+	g, ok := r.groups.byID[id]
+	if !ok {
+		return nil
+	}
+	return &PriorState{
+		Name:        g.Name,
+		Description: g.Description,
+		Disabled:    g.DeleteTime > 0,
+		Members:     append([]string(nil), g.Users...),
+		Channels:    append([]string(nil), g.Prefs.Channels...),
+	}
+}
+
+func (r *Reconciler) priorBookmarkState(channelID, id string) *PriorState {
+	for _, b := range r.channels.bookmarks[channelID] {
+		if b.ID == id {
+			return &PriorState{Name: b.Title, Link: b.Link, Emoji: b.Emoji}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconciler
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"sigs.k8s.io/slack-infra/slack"
+)
+
+func TestJournalRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal.jsonl")
+
+	first, err := NewJournal(path, "abc123", "T12345678")
+	if err != nil {
+		t.Fatalf("Failed to create journal: %v", err)
+	}
+	second, err := NewJournal(path, "def456", "T12345678")
+	if err != nil {
+		t.Fatalf("Failed to create journal: %v", err)
+	}
+	if first.RunID() == second.RunID() {
+		t.Fatalf("Expected distinct run IDs, but both were %s", first.RunID())
+	}
+
+	entries := []JournalEntry{
+		{Type: "archive_channel", Target: "C12345678", Name: "sig-testing", Prior: &PriorState{Name: "sig-testing"}},
+		{Type: "update_usergroup_members", Target: "S12345678", Name: "sig-testing-leads", Error: "something broke"},
+	}
+	for _, e := range entries {
+		if err := first.record(e); err != nil {
+			t.Fatalf("Failed to record entry: %v", err)
+		}
+		if err := second.record(JournalEntry{Type: "create_channel", Name: "other"}); err != nil {
+			t.Fatalf("Failed to record entry: %v", err)
+		}
+	}
+
+	actual, err := ReadJournal(path, first.RunID())
+	if err != nil {
+		t.Fatalf("Failed to read journal: %v", err)
+	}
+	for i := range entries {
+		entries[i].RunID = first.RunID()
+		entries[i].Revision = "abc123"
+		entries[i].Team = "T12345678"
+	}
+	if !reflect.DeepEqual(actual, entries) {
+		t.Errorf("Expected entries: %#v\nActual entries: %#v", entries, actual)
+	}
+
+	if _, err := ReadJournal(path, "nonexistent"); err == nil {
+		t.Errorf("Expected an error reading an unknown run, but got none")
+	}
+}
+
+func TestJournalEntry(t *testing.T) {
+	r := Reconciler{
+		channels: channelState{
+			byID:   map[string]*slack.Conversation{"C12345678": {ID: "C12345678", Name: "sig-testing"}},
+			byName: map[string]*slack.Conversation{},
+			bookmarks: map[string][]slack.Bookmark{
+				"C12345678": {{ID: "Bk12345678", ChannelID: "C12345678", Title: "Docs", Link: "https://example.com"}},
+			},
+		},
+		groups: usergroupState{
+			byID: map[string]*slack.Subteam{"S12345678": {
+				ID:          "S12345678",
+				Handle:      "sig-testing-leads",
+				Name:        "SIG Testing Leads",
+				Description: "Leads",
+				Users:       []string{"U12345678"},
+				Prefs:       slack.SubteamPrefs{Channels: []string{"C12345678"}},
+			}},
+			byHandle: map[string]*slack.Subteam{},
+		},
+		emoji: emojiState{byName: map[string]string{"party": "alias:tada"}},
+	}
+
+	tests := []struct {
+		name     string
+		action   Action
+		expected JournalEntry
+	}{
+		{
+			name:     "archiving a channel records its prior state",
+			action:   archiveChannelAction{id: "C12345678", name: "sig-testing"},
+			expected: JournalEntry{Type: "archive_channel", Target: "C12345678", Name: "sig-testing", Prior: &PriorState{Name: "sig-testing"}},
+		},
+		{
+			name:     "renaming a channel records its old name",
+			action:   renameChannelAction{id: "C12345678", oldName: "sig-testing", newName: "sig-testing-2"},
+			expected: JournalEntry{Type: "rename_channel", Target: "C12345678", Name: "sig-testing-2", Prior: &PriorState{Name: "sig-testing"}},
+		},
+		{
+			name:   "updating a usergroup's members records its prior state",
+			action: updateUsergroupMembersAction{id: "S12345678", name: "sig-testing-leads", users: []string{"U11111111"}},
+			expected: JournalEntry{Type: "update_usergroup_members", Target: "S12345678", Name: "sig-testing-leads", Prior: &PriorState{
+				Name:        "SIG Testing Leads",
+				Description: "Leads",
+				Members:     []string{"U12345678"},
+				Channels:    []string{"C12345678"},
+			}},
+		},
+		{
+			name:     "removing a bookmark records the bookmark",
+			action:   removeBookmarkAction{id: "Bk12345678", channelID: "C12345678", channelName: "sig-testing", title: "Docs"},
+			expected: JournalEntry{Type: "remove_bookmark", Target: "Bk12345678", Name: "Docs", Channel: "C12345678", ChannelName: "sig-testing", Prior: &PriorState{Name: "Docs", Link: "https://example.com"}},
+		},
+		{
+			name:     "removing an emoji records its definition",
+			action:   removeEmojiAction{name: "party"},
+			expected: JournalEntry{Type: "remove_emoji", Name: "party", Prior: &PriorState{Value: "alias:tada"}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := r.journalEntry(tc.action)
+			tc.expected.Description = tc.action.Describe()
+			actual.Time = tc.expected.Time
+			if !reflect.DeepEqual(actual, tc.expected) {
+				t.Errorf("Expected entry: %#v\nActual entry: %#v", tc.expected, actual)
+			}
+		})
+	}
+}
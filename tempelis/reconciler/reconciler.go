@@ -19,6 +19,7 @@ package reconciler
 import (
 	"fmt"
 	"log"
+	"time"
 
 	"sigs.k8s.io/slack-infra/slack"
 	"sigs.k8s.io/slack-infra/tempelis/config"
@@ -29,6 +30,12 @@ type Reconciler struct {
 	config   config.Config
 	channels channelState
 	groups   usergroupState
+	emoji    emojiState
+	now      func() time.Time
+	journal  *Journal
+	snapshot *Snapshot
+
+	emojiURLBase string
 }
 
 func New(slack *slack.Client, config config.Config) *Reconciler {
@@ -37,16 +44,76 @@ func New(slack *slack.Client, config config.Config) *Reconciler {
 		config:   config,
 		channels: channelState{},
 		groups:   usergroupState{},
+		now:      time.Now,
 	}
 }
 
-func (r *Reconciler) Reconcile(dryRun bool) error {
+// priorChannelID returns the ID of the channel that the configured channel called name currently
+// refers to, or "" if it doesn't exist yet. It must be called before reconcileChannels, so channels
+// about to be renamed are found by their configured ID.
+func (r *Reconciler) priorChannelID(name string) string {
+	for _, c := range r.config.Channels {
+		if c.Name == name && c.ID != "" {
+			return c.ID
+		}
+	}
+	if c, ok := r.channels.byName[name]; ok {
+		return c.ID
+	}
+	return ""
+}
+
+// SetEmojiURLBase sets the URL under which the config tree is served, which is where Slack will
+// fetch new emoji images from.
+func (r *Reconciler) SetEmojiURLBase(url string) {
+	r.emojiURLBase = url
+}
+
+// SetJournal makes the reconciler record every action it applies in j.
+func (r *Reconciler) SetJournal(j *Journal) {
+	r.journal = j
+}
+
+// currentTime returns the time that should be used to decide who is on call.
+func (r *Reconciler) currentTime() time.Time {
+	if r.now == nil {
+		return time.Now()
+	}
+	return r.now()
+}
+
+// init fetches the current state of Slack.
+func (r *Reconciler) init() error {
+	if r.snapshot != nil {
+		return r.initFromSnapshot()
+	}
 	if err := r.channels.init(r.slack); err != nil {
 		return fmt.Errorf("failed to get initial channel state: %v", err)
 	}
 	if err := r.groups.init(r.slack); err != nil {
 		return fmt.Errorf("failed to get initial usergroup state: %v", err)
 	}
+	if err := r.channels.initBookmarks(r.slack, r.bookmarkedChannelIDs()); err != nil {
+		return fmt.Errorf("failed to get initial channel bookmarks: %v", err)
+	}
+	if len(r.config.Emoji) > 0 {
+		if err := r.emoji.init(r.slack); err != nil {
+			return fmt.Errorf("failed to get initial emoji state: %v", err)
+		}
+	}
+	if err := r.channels.initMembers(r.slack, r.enforcedChannelIDs()); err != nil {
+		return fmt.Errorf("failed to get initial channel membership: %v", err)
+	}
+	return nil
+}
+
+func (r *Reconciler) Reconcile(dryRun bool) error {
+	if r.snapshot != nil && !dryRun {
+		return fmt.Errorf("can't apply a plan computed against a snapshot")
+	}
+	if err := r.init(); err != nil {
+		return err
+	}
 	var actions []Action
 	var errors []error
 	a, e := r.reconcileChannels()
@@ -55,7 +122,22 @@ func (r *Reconciler) Reconcile(dryRun bool) error {
 	a, e = r.reconcileUsergroups()
 	actions = append(actions, a...)
 	errors = append(errors, e...)
+	a, e = r.reconcileChannelMembership()
+	actions = append(actions, a...)
+	errors = append(errors, e...)
+	a, e = r.reconcileBookmarks()
+	actions = append(actions, a...)
+	errors = append(errors, e...)
+	a, e = r.reconcileEmoji()
+	actions = append(actions, a...)
+	errors = append(errors, e...)
 
+	return r.apply(actions, errors, dryRun)
+}
+
+// apply reports errors and describes actions, then performs the actions unless this is a dry run
+// or there were any errors.
+func (r *Reconciler) apply(actions []Action, errors []error, dryRun bool) error {
 	failed := false
 	if len(errors) > 0 {
 		log.Printf("This configuration cannot be applied against the current reality:")
@@ -74,12 +156,13 @@ func (r *Reconciler) Reconcile(dryRun bool) error {
 	}
 
 	if len(actions) > 0 {
+		if !dryRun && r.journal != nil {
+			log.Printf("Recording actions in the journal as run %s.\n", r.journal.RunID())
+		}
 		for i, a := range actions {
 			log.Printf("Step %d: %s.\n", i+1, a.Describe())
 			if !dryRun {
-				if err := a.Perform(r); err != nil {
-					log.Printf("Failed: %v.\n", err)
-				}
+				r.perform(a)
 			}
 		}
 	} else {
@@ -92,6 +175,29 @@ func (r *Reconciler) Reconcile(dryRun bool) error {
 	return nil
 }
 
+// perform performs a, recording it in the journal if there is one.
+func (r *Reconciler) perform(a Action) {
+	var entry JournalEntry
+	if r.journal != nil {
+		entry = r.journalEntry(a)
+	}
+	err := a.Perform(r)
+	if err != nil {
+		log.Printf("Failed: %v.\n", err)
+	}
+	if r.journal == nil {
+		return
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	} else {
+		r.completeJournalEntry(&entry)
+	}
+	if err := r.journal.record(entry); err != nil {
+		log.Printf("Failed to record step in journal: %v.\n", err)
+	}
+}
+
 type Action interface {
 	Describe() string
 	Perform(reconciler *Reconciler) error
@@ -0,0 +1,205 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconciler
+
+import (
+	"fmt"
+
+	"sigs.k8s.io/slack-infra/tempelis/config"
+)
+
+// Rollback undoes, as far as possible, the actions recorded in entries, which should all be from
+// the same run. The inverse actions go through the same plan and apply path as Reconcile.
+func (r *Reconciler) Rollback(entries []JournalEntry, dryRun bool) error {
+	if err := r.init(); err != nil {
+		return err
+	}
+	if err := r.initRollbackState(entries); err != nil {
+		return err
+	}
+	actions, errors := r.inverseActions(entries)
+	return r.apply(actions, errors, dryRun)
+}
+
+// rollbackState returns the IDs of the channels whose bookmarks undoing entries will change, and
+// whether it will change emoji.
+func rollbackState(entries []JournalEntry) ([]string, bool) {
+	seen := map[string]bool{}
+	var bookmarked []string
+	emoji := false
+	for _, e := range entries {
+		switch e.Type {
+		case "add_bookmark", "edit_bookmark", "remove_bookmark":
+			if e.Channel != "" && !seen[e.Channel] {
+				seen[e.Channel] = true
+				bookmarked = append(bookmarked, e.Channel)
+			}
+		case "add_emoji", "add_emoji_alias", "remove_emoji":
+			emoji = true
+		}
+	}
+	return bookmarked, emoji
+}
+
+// initRollbackState fetches the bookmarks and emoji that undoing entries will change, which init
+// only fetches when the config mentions them. Without them, the rollback's own journal entries
+// wouldn't record the prior state, and the rollback couldn't itself be rolled back.
+func (r *Reconciler) initRollbackState(entries []JournalEntry) error {
+	bookmarked, emoji := rollbackState(entries)
+	for _, id := range bookmarked {
+		if _, ok := r.channels.bookmarks[id]; ok {
+			continue
+		}
+		bookmarks, err := r.slack.GetBookmarks(id)
+		if err != nil {
+			return fmt.Errorf("failed to get bookmarks for channel %s: %v", id, err)
+		}
+		r.channels.bookmarks[id] = bookmarks
+	}
+	if emoji && r.emoji.byName == nil {
+		if err := r.emoji.init(r.slack); err != nil {
+			return fmt.Errorf("failed to get initial emoji state: %v", err)
+		}
+	}
+	return nil
+}
+
+// JournalTeam returns the ID of the Slack team that entries were applied to. It fails unless they
+// all agree, since rolling back against the wrong team would be disastrous.
+func JournalTeam(entries []JournalEntry) (string, error) {
+	team := ""
+	for _, e := range entries {
+		if e.Team == "" {
+			return "", fmt.Errorf("the journal doesn't record which team %q was applied to", e.Description)
+		}
+		if team != "" && e.Team != team {
+			return "", fmt.Errorf("the run was applied to both team %s and team %s", team, e.Team)
+		}
+		team = e.Team
+	}
+	return team, nil
+}
+
+// inverseActions returns actions undoing entries, in reverse order. Entries that can't be undone
+// produce errors.
+func (r *Reconciler) inverseActions(entries []JournalEntry) ([]Action, []error) {
+	var actions []Action
+	var errors []error
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if e.Error != "" {
+			// Failed actions most likely did nothing, so there's nothing to undo.
+			continue
+		}
+		a, err := r.inverseAction(e)
+		if err != nil {
+			errors = append(errors, fmt.Errorf("can't undo %q: %v", e.Description, err))
+			continue
+		}
+		actions = append(actions, a...)
+	}
+	return actions, errors
+}
+
+func (r *Reconciler) inverseAction(e JournalEntry) ([]Action, error) {
+	needsTarget := map[string]bool{
+		"create_channel": true, "archive_channel": true, "unarchive_channel": true, "rename_channel": true,
+		"create_usergroup": true, "update_usergroup": true, "update_usergroup_members": true,
+		"deactivate_usergroup": true, "reactivate_usergroup": true, "invite_to_channel": true,
+		"kick_from_channel": true, "add_bookmark": true, "edit_bookmark": true, "remove_bookmark": true,
+	}
+	if needsTarget[e.Type] && e.Target == "" {
+		return nil, fmt.Errorf("the journal doesn't record which object was affected")
+	}
+	needsPrior := map[string]bool{
+		"rename_channel": true, "update_usergroup": true, "update_usergroup_members": true,
+		"edit_bookmark": true, "remove_bookmark": true, "remove_emoji": true,
+	}
+	if needsPrior[e.Type] && e.Prior == nil {
+		return nil, fmt.Errorf("the journal doesn't record the prior state")
+	}
+
+	channelName := e.ChannelName
+	if channelName == "" {
+		channelName = e.Channel
+	}
+	if c, ok := r.channels.byID[e.Channel]; ok {
+		channelName = c.Name
+	}
+
+	switch e.Type {
+	case "create_channel":
+		// Channels can't be deleted, so the best we can do is archive it.
+		return []Action{archiveChannelAction{id: e.Target, name: e.Name}}, nil
+	case "archive_channel":
+		return []Action{unarchiveChannelAction{id: e.Target, name: e.Name}}, nil
+	case "unarchive_channel":
+		return []Action{archiveChannelAction{id: e.Target, name: e.Name}}, nil
+	case "rename_channel":
+		return []Action{renameChannelAction{id: e.Target, oldName: e.Name, newName: e.Prior.Name}}, nil
+	case "create_usergroup":
+		return []Action{deactivateUsergroupAction{id: e.Target, handle: e.Name}}, nil
+	case "update_usergroup":
+		var names []string
+		for _, id := range e.Prior.Channels {
+			c, ok := r.channels.byID[id]
+			if !ok {
+				return nil, fmt.Errorf("channel %s no longer exists", id)
+			}
+			names = append(names, c.Name)
+		}
+		return []Action{updateUsergroupAction{id: e.Target, handle: e.Name, name: e.Prior.Name, description: e.Prior.Description, channelNames: names}}, nil
+	case "update_usergroup_members":
+		if len(e.Prior.Members) == 0 {
+			return nil, fmt.Errorf("usergroups can't be set to have no members")
+		}
+		return []Action{updateUsergroupMembersAction{id: e.Target, name: e.Name, users: e.Prior.Members}}, nil
+	case "deactivate_usergroup":
+		return []Action{reactivateUsergroupAction{id: e.Target, handle: e.Name}}, nil
+	case "reactivate_usergroup":
+		return []Action{deactivateUsergroupAction{id: e.Target, handle: e.Name}}, nil
+	case "invite_to_channel":
+		var actions []Action
+		for _, u := range e.Users {
+			actions = append(actions, kickFromChannelAction{id: e.Target, name: e.Name, user: u})
+		}
+		return actions, nil
+	case "kick_from_channel":
+		return []Action{inviteToChannelAction{id: e.Target, name: e.Name, users: e.Users}}, nil
+	case "add_bookmark":
+		return []Action{removeBookmarkAction{id: e.Target, channelID: e.Channel, channelName: channelName, title: e.Name}}, nil
+	case "edit_bookmark":
+		return []Action{editBookmarkAction{id: e.Target, channelID: e.Channel, channelName: channelName, bookmark: config.Bookmark{Title: e.Prior.Name, Link: e.Prior.Link, Emoji: e.Prior.Emoji}}}, nil
+	case "remove_bookmark":
+		return []Action{addBookmarkAction{channelID: e.Channel, channelName: channelName, bookmark: config.Bookmark{Title: e.Prior.Name, Link: e.Prior.Link, Emoji: e.Prior.Emoji}}}, nil
+	case "add_emoji", "add_emoji_alias":
+		return []Action{removeEmojiAction{name: e.Name}}, nil
+	case "remove_emoji":
+		if target, ok := config.IsEmojiAlias(e.Prior.Value); ok {
+			return []Action{addEmojiAliasAction{name: e.Name, aliasFor: target}}, nil
+		}
+		return []Action{addEmojiAction{name: e.Name, url: e.Prior.Value}}, nil
+	case "create_redirect_channel":
+		// The redirect is already archived, and channels can't be deleted.
+		return nil, nil
+	case "announce_rotation":
+		// Announcements can't be unsent, but they're harmless, so don't block rolling back anyway.
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown action type %q", e.Type)
+	}
+}
@@ -0,0 +1,231 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconciler
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"sigs.k8s.io/slack-infra/slack"
+	"sigs.k8s.io/slack-infra/slack/slacktest"
+	"sigs.k8s.io/slack-infra/tempelis/config"
+)
+
+func TestInverseActions(t *testing.T) {
+	tests := []struct {
+		name             string
+		entries          []JournalEntry
+		expectedActions  []Action
+		expectedErrCount int
+	}{
+		{
+			name: "actions are undone in reverse order",
+			entries: []JournalEntry{
+				{Type: "create_channel", Target: "C11111111", Name: "new-channel"},
+				{Type: "archive_channel", Target: "C12345678", Name: "sig-testing"},
+			},
+			expectedActions: []Action{
+				unarchiveChannelAction{id: "C12345678", name: "sig-testing"},
+				archiveChannelAction{id: "C11111111", name: "new-channel"},
+			},
+		},
+		{
+			name:            "renames are reverted",
+			entries:         []JournalEntry{{Type: "rename_channel", Target: "C12345678", Name: "sig-testing-2", Prior: &PriorState{Name: "sig-testing"}}},
+			expectedActions: []Action{renameChannelAction{id: "C12345678", oldName: "sig-testing-2", newName: "sig-testing"}},
+		},
+		{
+			name: "usergroups get their prior settings back",
+			entries: []JournalEntry{
+				{Type: "update_usergroup", Target: "S12345678", Name: "sig-testing-leads", Prior: &PriorState{Name: "Leads", Description: "Old", Channels: []string{"C12345678"}}},
+				{Type: "update_usergroup_members", Target: "S12345678", Name: "sig-testing-leads", Prior: &PriorState{Members: []string{"U12345678"}}},
+			},
+			expectedActions: []Action{
+				updateUsergroupMembersAction{id: "S12345678", name: "sig-testing-leads", users: []string{"U12345678"}},
+				updateUsergroupAction{id: "S12345678", handle: "sig-testing-leads", name: "Leads", description: "Old", channelNames: []string{"sig-testing"}},
+			},
+		},
+		{
+			name: "channel invitations are undone one user at a time",
+			entries: []JournalEntry{
+				{Type: "invite_to_channel", Target: "C12345678", Name: "sig-testing", Users: []string{"U12345678", "U11111111"}},
+			},
+			expectedActions: []Action{
+				kickFromChannelAction{id: "C12345678", name: "sig-testing", user: "U12345678"},
+				kickFromChannelAction{id: "C12345678", name: "sig-testing", user: "U11111111"},
+			},
+		},
+		{
+			name: "bookmarks and emoji are restored",
+			entries: []JournalEntry{
+				{Type: "remove_bookmark", Target: "Bk12345678", Name: "Docs", Channel: "C12345678", Prior: &PriorState{Name: "Docs", Link: "https://example.com"}},
+				{Type: "remove_emoji", Name: "party", Prior: &PriorState{Value: "alias:tada"}},
+				{Type: "add_emoji", Name: "new"},
+			},
+			expectedActions: []Action{
+				removeEmojiAction{name: "new"},
+				addEmojiAliasAction{name: "party", aliasFor: "tada"},
+				addBookmarkAction{channelID: "C12345678", channelName: "sig-testing", bookmark: config.Bookmark{Title: "Docs", Link: "https://example.com"}},
+			},
+		},
+		{
+			name: "failed actions and announcements are skipped",
+			entries: []JournalEntry{
+				{Type: "archive_channel", Target: "C12345678", Name: "sig-testing", Error: "not_in_channel"},
+				{Type: "announce_rotation", Name: "oncall", Channel: "sig-testing", Users: []string{"U12345678"}},
+			},
+		},
+		{
+			name: "entries that can't be undone are errors",
+			entries: []JournalEntry{
+				{Type: "create_channel", Name: "new-channel"},
+				{Type: "rename_channel", Target: "C12345678", Name: "sig-testing-2"},
+				{Type: "update_usergroup_members", Target: "S12345678", Name: "sig-testing-leads", Prior: &PriorState{}},
+				{Type: "something_else"},
+			},
+			expectedErrCount: 4,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := slack.Conversation{ID: "C12345678", Name: "sig-testing"}
+			r := Reconciler{
+				channels: channelState{byID: map[string]*slack.Conversation{c.ID: &c}, byName: map[string]*slack.Conversation{c.Name: &c}},
+			}
+			actions, errs := r.inverseActions(tc.entries)
+			if !reflect.DeepEqual(actions, tc.expectedActions) {
+				t.Errorf("Expected actions: %#v\nActual actions: %#v", tc.expectedActions, actions)
+			}
+			if len(errs) != tc.expectedErrCount {
+				t.Errorf("Expected %d errors, but got %d: %v", tc.expectedErrCount, len(errs), errs)
+			}
+		})
+	}
+}
+
+func TestJournalTeam(t *testing.T) {
+	tests := []struct {
+		name      string
+		entries   []JournalEntry
+		expected  string
+		expectErr bool
+	}{
+		{
+			name:     "the run's team is returned",
+			entries:  []JournalEntry{{Team: "T12345678"}, {Team: "T12345678"}},
+			expected: "T12345678",
+		},
+		{
+			name:      "entries without a team are an error",
+			entries:   []JournalEntry{{Team: "T12345678"}, {}},
+			expectErr: true,
+		},
+		{
+			name:      "entries for different teams are an error",
+			entries:   []JournalEntry{{Team: "T12345678"}, {Team: "T87654321"}},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			team, err := JournalTeam(tc.entries)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("Expected error: %t, but got %v", tc.expectErr, err)
+			}
+			if team != tc.expected {
+				t.Errorf("Expected team %q, but got %q", tc.expected, team)
+			}
+		})
+	}
+}
+
+func TestRollbackState(t *testing.T) {
+	entries := []JournalEntry{
+		{Type: "archive_channel", Target: "C12345678"},
+		{Type: "add_bookmark", Target: "Bk1", Channel: "C11111111"},
+		{Type: "edit_bookmark", Target: "Bk2", Channel: "C22222222"},
+		{Type: "remove_bookmark", Target: "Bk3", Channel: "C11111111"},
+	}
+	bookmarked, emoji := rollbackState(entries)
+	if expected := []string{"C11111111", "C22222222"}; !reflect.DeepEqual(bookmarked, expected) {
+		t.Errorf("Expected bookmarks to be fetched for %v, but got %v", expected, bookmarked)
+	}
+	if emoji {
+		t.Errorf("Expected emoji not to be fetched without emoji entries")
+	}
+	if _, emoji := rollbackState(append(entries, JournalEntry{Type: "remove_emoji", Name: "k8s"})); !emoji {
+		t.Errorf("Expected emoji to be fetched for emoji entries")
+	}
+}
+
+func TestRollbackBookmarkInNewChannel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rollback")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal.jsonl")
+
+	s := slacktest.NewServer()
+	defer s.Close()
+	// The bookmark's title is also the name of a channel, which the journal must not mix up with
+	// the channel the bookmark is in.
+	general := s.AddChannel(slack.Conversation{Name: "general"})
+	c := config.Config{Channels: []config.Channel{
+		{Name: "general"},
+		{Name: "sig-testing", Bookmarks: []config.Bookmark{{Title: "general", Link: "https://example.com"}}},
+	}}
+
+	j, err := NewJournal(path, "abc123", s.TeamID)
+	if err != nil {
+		t.Fatalf("Failed to create journal: %v", err)
+	}
+	r := New(s.Client(), c)
+	r.SetJournal(j)
+	if err := r.Reconcile(false); err != nil {
+		t.Fatalf("Failed to reconcile: %v", err)
+	}
+	created, ok := s.ChannelByName("sig-testing")
+	if !ok {
+		t.Fatalf("Expected sig-testing to have been created")
+	}
+	if bookmarks := s.Bookmarks(created.ID); len(bookmarks) != 1 {
+		t.Fatalf("Expected a bookmark to have been added to sig-testing, but got %#v", bookmarks)
+	}
+
+	entries, err := ReadJournal(path, j.RunID())
+	if err != nil {
+		t.Fatalf("Failed to read journal: %v", err)
+	}
+	if err := New(s.Client(), c).Rollback(entries, false); err != nil {
+		t.Fatalf("Failed to roll back: %v", err)
+	}
+	if bookmarks := s.Bookmarks(created.ID); len(bookmarks) != 0 {
+		t.Errorf("Expected the bookmark to have been removed, but got %#v", bookmarks)
+	}
+	if c, _ := s.Channel(created.ID); !c.IsArchived {
+		t.Errorf("Expected the created channel to have been archived")
+	}
+	if c, _ := s.Channel(general); c.IsArchived {
+		t.Errorf("Expected #general to be left alone")
+	}
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconciler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/slack-infra/slack"
+)
+
+// Snapshot is the state of a Slack workspace as observed by the reconciler, which is everything it
+// needs to compute a plan without talking to Slack. Users aren't included, because the reconciler
+// takes them from the config.
+type Snapshot struct {
+	Channels []slack.Conversation `json:"channels"`
+	// ChannelMembers maps channel IDs to the IDs of their members, but only for channels whose
+	// membership the config enforces.
+	ChannelMembers map[string][]string `json:"channel_members,omitempty"`
+	// Bookmarks maps channel IDs to their bookmarks, but only for channels the config bookmarks.
+	Bookmarks  map[string][]slack.Bookmark `json:"bookmarks,omitempty"`
+	Usergroups []slack.Subteam             `json:"usergroups"`
+	// Emoji is only present if the config has any emoji.
+	Emoji map[string]string `json:"emoji"`
+}
+
+// ReadSnapshot reads a snapshot written by WriteSnapshot.
+func ReadSnapshot(path string) (*Snapshot, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read snapshot: %v", err)
+	}
+	var s Snapshot
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("couldn't parse snapshot %s: %v", path, err)
+	}
+	return &s, nil
+}
+
+// WriteSnapshot writes s to the file at path.
+func WriteSnapshot(path string, s *Snapshot) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("couldn't serialise snapshot: %v", err)
+	}
+	if err := ioutil.WriteFile(path, append(b, '\n'), 0644); err != nil {
+		return fmt.Errorf("couldn't write snapshot: %v", err)
+	}
+	return nil
+}
+
+// Snapshot fetches the current state of Slack, or at least the parts of it that matter to the
+// reconciler's config.
+func (r *Reconciler) Snapshot() (*Snapshot, error) {
+	if err := r.init(); err != nil {
+		return nil, err
+	}
+	s := &Snapshot{
+		ChannelMembers: r.channels.members,
+		Bookmarks:      r.channels.bookmarks,
+		Emoji:          r.emoji.byName,
+	}
+	for _, c := range r.channels.byID {
+		s.Channels = append(s.Channels, *c)
+	}
+	sort.Slice(s.Channels, func(i, j int) bool { return s.Channels[i].ID < s.Channels[j].ID })
+	for _, g := range r.groups.byID {
+		s.Usergroups = append(s.Usergroups, *g)
+	}
+	sort.Slice(s.Usergroups, func(i, j int) bool { return s.Usergroups[i].ID < s.Usergroups[j].ID })
+	return s, nil
+}
+
+// SetSnapshot makes the reconciler treat s as the current state of Slack instead of fetching it.
+// Plans computed against a snapshot can't be applied.
+func (r *Reconciler) SetSnapshot(s *Snapshot) {
+	r.snapshot = s
+}
+
+// initFromSnapshot is init, but reading from r.snapshot instead of Slack. It fails if the snapshot
+// is missing anything the config needs, which happens if it was taken with a different config.
+func (r *Reconciler) initFromSnapshot() error {
+	s := r.snapshot
+	r.channels = channelState{
+		byName:    map[string]*slack.Conversation{},
+		byID:      map[string]*slack.Conversation{},
+		members:   map[string][]string{},
+		bookmarks: map[string][]slack.Bookmark{},
+	}
+	for _, c := range s.Channels {
+		c2 := c
+		r.channels.byName[c.Name] = &c2
+		r.channels.byID[c.ID] = &c2
+	}
+	for id, members := range s.ChannelMembers {
+		r.channels.members[id] = append([]string(nil), members...)
+	}
+	for id, bookmarks := range s.Bookmarks {
+		r.channels.bookmarks[id] = append([]slack.Bookmark(nil), bookmarks...)
+	}
+
+	r.groups = usergroupState{byHandle: map[string]*slack.Subteam{}, byID: map[string]*slack.Subteam{}}
+	for _, g := range s.Usergroups {
+		g2 := g
+		r.groups.byHandle[g.Handle] = &g2
+		r.groups.byID[g.ID] = &g2
+	}
+
+	r.emoji.byName = map[string]string{}
+	for k, v := range s.Emoji {
+		r.emoji.byName[k] = v
+	}
+
+	channelName := func(id string) string {
+		if c, ok := r.channels.byID[id]; ok {
+			return "#" + c.Name
+		}
+		return id
+	}
+	var missing []string
+	for _, id := range r.enforcedChannelIDs() {
+		if _, ok := s.ChannelMembers[id]; !ok {
+			missing = append(missing, "the members of "+channelName(id))
+		}
+	}
+	for _, id := range r.bookmarkedChannelIDs() {
+		if _, ok := s.Bookmarks[id]; !ok {
+			missing = append(missing, "the bookmarks of "+channelName(id))
+		}
+	}
+	if len(r.config.Emoji) > 0 && s.Emoji == nil {
+		missing = append(missing, "the emoji")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("the snapshot doesn't have %s, which the config needs; take a new snapshot with this config", strings.Join(missing, ", "))
+	}
+	return nil
+}
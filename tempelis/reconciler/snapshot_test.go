@@ -0,0 +1,143 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconciler
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"sigs.k8s.io/slack-infra/slack"
+	"sigs.k8s.io/slack-infra/tempelis/config"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "snapshot.json")
+
+	s := &Snapshot{
+		Channels:       []slack.Conversation{{ID: "C12345678", Name: "sig-testing", IsChannel: true}},
+		ChannelMembers: map[string][]string{"C12345678": {"U12345678"}},
+		Bookmarks:      map[string][]slack.Bookmark{"C12345678": {{ID: "Bk12345678", Title: "Docs", Link: "https://example.com"}}},
+		Usergroups:     []slack.Subteam{{ID: "S12345678", Handle: "pony-fans", Name: "Pony Fans", Users: []string{"U12345678"}}},
+		Emoji:          map[string]string{"pony": "https://example.com/pony.png"},
+	}
+	if err := WriteSnapshot(path, s); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+	got, err := ReadSnapshot(path)
+	if err != nil {
+		t.Fatalf("Failed to read snapshot: %v", err)
+	}
+	if !reflect.DeepEqual(got, s) {
+		t.Errorf("Expected snapshot %#v\nActual snapshot: %#v", s, got)
+	}
+}
+
+func TestReconcileAgainstSnapshot(t *testing.T) {
+	r := New(nil, config.Config{
+		Users:      map[string]string{"Katharine": "U12345678", "bentheelder": "U11111111"},
+		Usergroups: []config.Usergroup{{Name: "pony-fans", LongName: "Pony Fans", Description: "Fans of ponies", Members: []string{"Katharine", "bentheelder"}}},
+	})
+	r.SetSnapshot(&Snapshot{
+		Usergroups: []slack.Subteam{{ID: "S12345678", Handle: "pony-fans", Name: "Pony Fans", Description: "Fans of ponies", Users: []string{"U12345678"}}},
+	})
+	if err := r.init(); err != nil {
+		t.Fatalf("Failed to load snapshot: %v", err)
+	}
+	actions, errs := r.reconcileUsergroups()
+	if len(errs) > 0 {
+		t.Fatalf("Unexpected errors: %v", errs)
+	}
+	expected := []Action{updateUsergroupMembersAction{id: "S12345678", name: "pony-fans", users: []string{"U11111111", "U12345678"}}}
+	if !reflect.DeepEqual(actions, expected) {
+		t.Errorf("Expected actions: %#v\nActual actions: %#v", expected, actions)
+	}
+
+	if err := r.Reconcile(false); err == nil {
+		t.Errorf("Expected applying a plan computed against a snapshot to fail")
+	}
+}
+
+func TestSnapshotMissingState(t *testing.T) {
+	channels := []slack.Conversation{{ID: "C12345678", Name: "sig-testing", IsChannel: true}}
+	tests := []struct {
+		name        string
+		config      config.Config
+		snapshot    Snapshot
+		expectedErr bool
+	}{
+		{
+			name: "members of enforced channels are needed",
+			config: config.Config{
+				Channels:   []config.Channel{{Name: "sig-testing"}},
+				Usergroups: []config.Usergroup{{Name: "pony-fans", Channels: []string{"sig-testing"}, EnforceChannelMembership: true}},
+			},
+			snapshot:    Snapshot{Channels: channels},
+			expectedErr: true,
+		},
+		{
+			name: "an enforced channel with no members is fine",
+			config: config.Config{
+				Channels:   []config.Channel{{Name: "sig-testing"}},
+				Usergroups: []config.Usergroup{{Name: "pony-fans", Channels: []string{"sig-testing"}, EnforceChannelMembership: true}},
+			},
+			snapshot: Snapshot{Channels: channels, ChannelMembers: map[string][]string{"C12345678": nil}},
+		},
+		{
+			name:        "bookmarks of bookmarked channels are needed",
+			config:      config.Config{Channels: []config.Channel{{Name: "sig-testing", Bookmarks: []config.Bookmark{}}}},
+			snapshot:    Snapshot{Channels: channels},
+			expectedErr: true,
+		},
+		{
+			name:        "emoji are needed if the config has any",
+			config:      config.Config{Emoji: map[string]string{"pony": "pony.png"}},
+			snapshot:    Snapshot{Channels: channels},
+			expectedErr: true,
+		},
+		{
+			name:     "a workspace with no emoji is fine",
+			config:   config.Config{Emoji: map[string]string{"pony": "pony.png"}},
+			snapshot: Snapshot{Channels: channels, Emoji: map[string]string{}},
+		},
+		{
+			name:     "nothing else is needed otherwise",
+			config:   config.Config{Channels: []config.Channel{{Name: "sig-testing"}}},
+			snapshot: Snapshot{Channels: channels},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := New(nil, tc.config)
+			r.SetSnapshot(&tc.snapshot)
+			err := r.init()
+			if tc.expectedErr && err == nil {
+				t.Errorf("Expected an error")
+			} else if !tc.expectedErr && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
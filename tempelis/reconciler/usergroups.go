@@ -22,6 +22,7 @@ import (
 	"strings"
 
 	"sigs.k8s.io/slack-infra/slack"
+	"sigs.k8s.io/slack-infra/tempelis/config"
 )
 
 func (r *Reconciler) reconcileUsergroups() ([]Action, []error) {
@@ -37,10 +38,16 @@ func (r *Reconciler) reconcileUsergroups() ([]Action, []error) {
 		delete(missingGroups, g.Name)
 		if o, ok := r.groups.byHandle[g.Name]; ok {
 			if g.External {
+				a, err := r.reconcileExternalUsergroup(g, o)
+				if err != nil {
+					errors = append(errors, fmt.Errorf("%s: %v", o.Name, err))
+				} else if a != nil {
+					actions = append(actions, a)
+				}
 				continue
 			}
-			if g.LongName == "" || g.Name == "" || g.Description == "" || len(g.Members) == 0 {
-				errors = append(errors, fmt.Errorf("usergroup configuration for %q is bad: all usergroups must have a name, long name, description, and at least one member", g.Name))
+			if g.LongName == "" || g.Name == "" || g.Description == "" || (len(g.Members) == 0 && g.Rotation == nil) {
+				errors = append(errors, fmt.Errorf("usergroup configuration for %q is bad: all usergroups must have a name, long name, description, and at least one member or a rotation", g.Name))
 				continue
 			}
 			// If we have a "deleted" group, but we found it here, it needs undeleting.
@@ -49,12 +56,11 @@ func (r *Reconciler) reconcileUsergroups() ([]Action, []error) {
 			}
 
 			needsUpdate := false
-			targetIDs, err := r.config.NamesToIDs(g.Members)
+			targetIDs, err := r.usergroupMembers(g)
 			if err != nil {
 				errors = append(errors, fmt.Errorf("%s: %v", o.Name, err))
 				continue
 			}
-			sort.Strings(targetIDs)
 			sort.Strings(o.Users)
 
 			targetChannels, err := r.channels.namesToIDs(g.Channels)
@@ -75,14 +81,20 @@ func (r *Reconciler) reconcileUsergroups() ([]Action, []error) {
 
 			if !stringSlicesEqual(o.Users, targetIDs) {
 				actions = append(actions, updateUsergroupMembersAction{id: o.ID, name: o.Handle, users: targetIDs})
+				if g.Rotation != nil && g.Rotation.AnnounceChannel != "" {
+					actions = append(actions, announceRotationAction{channel: g.Rotation.AnnounceChannel, handle: g.Name, users: targetIDs})
+				}
 			}
 		} else {
-			targetIDs, err := r.config.NamesToIDs(g.Members)
+			targetIDs, err := r.usergroupMembers(g)
 			if err != nil {
 				errors = append(errors, fmt.Errorf("%s: %v", g.Name, err))
 				continue
 			}
 			actions = append(actions, updateUsergroupAction{handle: g.Name, description: g.Description, name: g.LongName, channelNames: g.Channels, create: true}, updateUsergroupMembersAction{name: g.Name, users: targetIDs})
+			if g.Rotation != nil && g.Rotation.AnnounceChannel != "" {
+				actions = append(actions, announceRotationAction{channel: g.Rotation.AnnounceChannel, handle: g.Name, users: targetIDs})
+			}
 		}
 	}
 
@@ -95,6 +107,56 @@ func (r *Reconciler) reconcileUsergroups() ([]Action, []error) {
 	return actions, errors
 }
 
+// reconcileExternalUsergroup returns an action updating just the fields of the external usergroup
+// g that Tempelis manages, or nil if they are already correct. Its members are never touched, and
+// neither are the fields Tempelis doesn't manage.
+func (r *Reconciler) reconcileExternalUsergroup(g config.Usergroup, o *slack.Subteam) (Action, error) {
+	if len(g.Manage) == 0 {
+		return nil, nil
+	}
+	a := updateUsergroupAction{id: o.ID, handle: g.Name}
+	if g.Manages(config.ManageLongName) && o.Name != g.LongName {
+		a.name = g.LongName
+		a.fields = append(a.fields, config.ManageLongName)
+	}
+	if g.Manages(config.ManageDescription) && o.Description != g.Description {
+		a.description = g.Description
+		a.fields = append(a.fields, config.ManageDescription)
+	}
+	if g.Manages(config.ManageChannels) {
+		targetChannels, err := r.channels.namesToIDs(g.Channels)
+		if err != nil {
+			return nil, err
+		}
+		current := append([]string(nil), o.Prefs.Channels...)
+		sort.Strings(targetChannels)
+		sort.Strings(current)
+		if !stringSlicesEqual(targetChannels, current) {
+			a.channelNames = g.Channels
+			a.fields = append(a.fields, config.ManageChannels)
+		}
+	}
+	if len(a.fields) == 0 {
+		return nil, nil
+	}
+	return a, nil
+}
+
+// usergroupMembers returns the sorted user IDs that should currently be members of g, taking its
+// rotation into account if it has one.
+func (r *Reconciler) usergroupMembers(g config.Usergroup) ([]string, error) {
+	names := g.Members
+	if g.Rotation != nil {
+		names = g.Rotation.OnCall(r.currentTime())
+	}
+	ids, err := r.config.NamesToIDs(names)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
 func stringSlicesEqual(a []string, b []string) bool {
 	if len(a) != len(b) {
 		return false
@@ -146,6 +208,22 @@ type updateUsergroupAction struct {
 	name         string
 	channelNames []string
 	create       bool
+	// fields lists which of the name, description, and channels to set, using the names in
+	// config.Manage*. If it is empty, all of them are set.
+	fields []string
+}
+
+// sets returns whether a sets the given field.
+func (a updateUsergroupAction) sets(field string) bool {
+	if len(a.fields) == 0 {
+		return true
+	}
+	for _, f := range a.fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
 }
 
 func (a updateUsergroupAction) Describe() string {
@@ -153,26 +231,41 @@ func (a updateUsergroupAction) Describe() string {
 	if a.create {
 		verb = "Create"
 	}
-	return fmt.Sprintf("%s usergroup %s (%s): name = %q, description = %q, channels = %v", verb, a.handle, a.id, a.name, a.description, a.channelNames)
+	var changes []string
+	if a.sets(config.ManageLongName) {
+		changes = append(changes, fmt.Sprintf("name = %q", a.name))
+	}
+	if a.sets(config.ManageDescription) {
+		changes = append(changes, fmt.Sprintf("description = %q", a.description))
+	}
+	if a.sets(config.ManageChannels) {
+		changes = append(changes, fmt.Sprintf("channels = %v", a.channelNames))
+	}
+	return fmt.Sprintf("%s usergroup %s (%s): %s", verb, a.handle, a.id, strings.Join(changes, ", "))
 }
 
 func (a updateUsergroupAction) Perform(reconciler *Reconciler) error {
-	channelIDs, err := reconciler.channels.namesToIDs(a.channelNames)
-	if err != nil {
-		return fmt.Errorf("couldn't find channel IDs for usergroup %s: %v", a.name, err)
+	req := map[string]string{
+		"usergroup": a.id,
+		"handle":    a.handle,
 	}
-	for _, c := range channelIDs {
-		if c == "" {
-			return fmt.Errorf("unexpected empty channel ID when updating usergroup %s", a.name)
-		}
+	if a.sets(config.ManageLongName) {
+		req["name"] = a.name
 	}
-
-	req := map[string]string{
-		"usergroup":   a.id,
-		"channels":    strings.Join(channelIDs, ","),
-		"description": a.description,
-		"name":        a.name,
-		"handle":      a.handle,
+	if a.sets(config.ManageDescription) {
+		req["description"] = a.description
+	}
+	if a.sets(config.ManageChannels) {
+		channelIDs, err := reconciler.channels.namesToIDs(a.channelNames)
+		if err != nil {
+			return fmt.Errorf("couldn't find channel IDs for usergroup %s: %v", a.handle, err)
+		}
+		for _, c := range channelIDs {
+			if c == "" {
+				return fmt.Errorf("unexpected empty channel ID when updating usergroup %s", a.handle)
+			}
+		}
+		req["channels"] = strings.Join(channelIDs, ",")
 	}
 
 	action := "usergroups.update"
@@ -220,3 +313,35 @@ func (a updateUsergroupMembersAction) Perform(reconciler *Reconciler) error {
 	}
 	return nil
 }
+
+type announceRotationAction struct {
+	channel string
+	handle  string
+	users   []string
+}
+
+func (a announceRotationAction) Describe() string {
+	return fmt.Sprintf("Announce in %s that %v are now on call for %s", a.channel, a.users, a.handle)
+}
+
+func (a announceRotationAction) Perform(reconciler *Reconciler) error {
+	channelIDs, err := reconciler.channels.namesToIDs([]string{a.channel})
+	if err != nil {
+		return fmt.Errorf("couldn't find channel to announce rotation of %s: %v", a.handle, err)
+	}
+	mentions := make([]string, 0, len(a.users))
+	for _, u := range a.users {
+		mentions = append(mentions, fmt.Sprintf("<@%s>", u))
+	}
+	message := struct {
+		Channel string `json:"channel"`
+		Text    string `json:"text"`
+	}{
+		Channel: channelIDs[0],
+		Text:    fmt.Sprintf("The on-call rotation for @%s has handed off. Now on call: %s", a.handle, strings.Join(mentions, ", ")),
+	}
+	if err := reconciler.slack.CallMethod("chat.postMessage", message, nil); err != nil {
+		return fmt.Errorf("failed to announce rotation of %s in %s: %v", a.handle, a.channel, err)
+	}
+	return nil
+}
@@ -19,8 +19,10 @@ package reconciler
 import (
 	"reflect"
 	"testing"
+	"time"
 
 	"sigs.k8s.io/slack-infra/slack"
+	"sigs.k8s.io/slack-infra/slack/slacktest"
 	"sigs.k8s.io/slack-infra/tempelis/config"
 )
 
@@ -83,6 +85,37 @@ func TestReconcileUsergroups(t *testing.T) {
 			newGroups:       []config.Usergroup{{Name: "pony-fans", LongName: "Pony Fans", Description: "Fans of ponies", Members: []string{"Katharine", "bentheelder"}}},
 			expectedActions: []Action{updateUsergroupMembersAction{id: "S12345678", name: "pony-fans", users: []string{"U11111111", "U12345678"}}},
 		},
+		{
+			name:        "ignoring an external group",
+			priorGroups: []slack.Subteam{{Handle: "pony-fans", ID: "S12345678", Name: "Pon", Description: "old", Users: []string{"U12345678"}}},
+			newGroups:   []config.Usergroup{{Name: "pony-fans", LongName: "Pony Fans", Description: "Fans of ponies", External: true}},
+		},
+		{
+			name:            "updating only the managed fields of an external group",
+			priorChannels:   []slack.Conversation{{Name: "pony-channel", ID: "C22222222"}, {Name: "a-channel", ID: "C11111111"}},
+			priorGroups:     []slack.Subteam{{Handle: "pony-fans", ID: "S12345678", Name: "Pon", Description: "old", Users: []string{"U12345678"}, Prefs: slack.SubteamPrefs{Channels: []string{"C11111111"}}}},
+			newGroups:       []config.Usergroup{{Name: "pony-fans", LongName: "Pony Fans", Description: "Fans of ponies", Channels: []string{"pony-channel"}, External: true, Manage: []string{"description"}}},
+			expectedActions: []Action{updateUsergroupAction{id: "S12345678", handle: "pony-fans", description: "Fans of ponies", fields: []string{"description"}}},
+		},
+		{
+			name:            "updating the channels of an external group",
+			priorChannels:   []slack.Conversation{{Name: "pony-channel", ID: "C22222222"}, {Name: "a-channel", ID: "C11111111"}},
+			priorGroups:     []slack.Subteam{{Handle: "pony-fans", ID: "S12345678", Name: "Pon", Description: "old", Users: []string{"U12345678"}, Prefs: slack.SubteamPrefs{Channels: []string{"C11111111"}}}},
+			newGroups:       []config.Usergroup{{Name: "pony-fans", Channels: []string{"pony-channel", "a-channel"}, External: true, Manage: []string{"channels"}}},
+			expectedActions: []Action{updateUsergroupAction{id: "S12345678", handle: "pony-fans", channelNames: []string{"pony-channel", "a-channel"}, fields: []string{"channels"}}},
+		},
+		{
+			name:            "unmanaged channels of an external group don't need to be known",
+			priorGroups:     []slack.Subteam{{Handle: "pony-fans", ID: "S12345678", Name: "Pon", Description: "old", Users: []string{"U12345678"}, Prefs: slack.SubteamPrefs{Channels: []string{"G12345678"}}}},
+			newGroups:       []config.Usergroup{{Name: "pony-fans", Description: "Fans of ponies", External: true, Manage: []string{"description"}}},
+			expectedActions: []Action{updateUsergroupAction{id: "S12345678", handle: "pony-fans", description: "Fans of ponies", fields: []string{"description"}}},
+		},
+		{
+			name:          "leaving a correct external group alone",
+			priorChannels: []slack.Conversation{{Name: "a-channel", ID: "C11111111"}},
+			priorGroups:   []slack.Subteam{{Handle: "pony-fans", ID: "S12345678", Name: "Pon", Description: "Fans of ponies", Users: []string{"U12345678"}, Prefs: slack.SubteamPrefs{Channels: []string{"C11111111"}}}},
+			newGroups:     []config.Usergroup{{Name: "pony-fans", Description: "Fans of ponies", Channels: []string{"a-channel"}, External: true, Manage: []string{"description", "channels"}}},
+		},
 		{
 			name:        "don't try deleting and already-deleted group",
 			priorGroups: []slack.Subteam{{Handle: "pony-fans", ID: "S12345678", Name: "Pony Fans", Description: "Fans of ponies", Users: []string{"U12345678"}, DeleteTime: 10000}},
@@ -116,3 +149,110 @@ func TestReconcileUsergroups(t *testing.T) {
 		})
 	}
 }
+
+func TestReconcileRotatingUsergroups(t *testing.T) {
+	rotation := &config.Rotation{Members: []string{"Katharine", "bentheelder"}, ShiftLengthString: "1w", StartString: "2019-10-07 09:00", AnnounceChannel: "testing-ops"}
+	quietRotation := &config.Rotation{Members: []string{"Katharine", "bentheelder"}, ShiftLengthString: "1w", StartString: "2019-10-07 09:00"}
+	for _, r := range []*config.Rotation{rotation, quietRotation} {
+		if err := config.ParseRotation(r); err != nil {
+			t.Fatalf("Failed to parse rotation: %v", err)
+		}
+	}
+	now := time.Date(2019, 10, 15, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name            string
+		priorGroups     []slack.Subteam
+		newGroups       []config.Usergroup
+		expectedActions []Action
+	}{
+		{
+			name:        "handing off updates members and announces it",
+			priorGroups: []slack.Subteam{{Handle: "oncall", ID: "S12345678", Name: "On Call", Description: "On call", Users: []string{"U12345678"}}},
+			newGroups:   []config.Usergroup{{Name: "oncall", LongName: "On Call", Description: "On call", Rotation: rotation}},
+			expectedActions: []Action{
+				updateUsergroupMembersAction{id: "S12345678", name: "oncall", users: []string{"U11111111"}},
+				announceRotationAction{channel: "testing-ops", handle: "oncall", users: []string{"U11111111"}},
+			},
+		},
+		{
+			name:        "handing off without an announcement channel only updates members",
+			priorGroups: []slack.Subteam{{Handle: "oncall", ID: "S12345678", Name: "On Call", Description: "On call", Users: []string{"U12345678"}}},
+			newGroups:   []config.Usergroup{{Name: "oncall", LongName: "On Call", Description: "On call", Rotation: quietRotation}},
+			expectedActions: []Action{
+				updateUsergroupMembersAction{id: "S12345678", name: "oncall", users: []string{"U11111111"}},
+			},
+		},
+		{
+			name:        "no handoff does nothing",
+			priorGroups: []slack.Subteam{{Handle: "oncall", ID: "S12345678", Name: "On Call", Description: "On call", Users: []string{"U11111111"}}},
+			newGroups:   []config.Usergroup{{Name: "oncall", LongName: "On Call", Description: "On call", Rotation: rotation}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := Reconciler{
+				config:   config.Config{Usergroups: tc.newGroups, Users: map[string]string{"Katharine": "U12345678", "bentheelder": "U11111111"}},
+				channels: channelState{byID: map[string]*slack.Conversation{}, byName: map[string]*slack.Conversation{}},
+				groups:   usergroupState{byID: map[string]*slack.Subteam{}, byHandle: map[string]*slack.Subteam{}},
+				now:      func() time.Time { return now },
+			}
+			for _, g := range tc.priorGroups {
+				g2 := g
+				r.groups.byHandle[g2.Handle] = &g2
+				r.groups.byID[g2.ID] = &g2
+			}
+			actions, errs := r.reconcileUsergroups()
+			if !reflect.DeepEqual(actions, tc.expectedActions) {
+				t.Errorf("Expected actions: %#v\nActual actions: %#v", tc.expectedActions, actions)
+			}
+			if len(errs) != 0 {
+				t.Errorf("Expected no errors, but got %v", errs)
+			}
+		})
+	}
+}
+
+func TestUsergroupActionsPerform(t *testing.T) {
+	s := slacktest.NewServer()
+	defer s.Close()
+	channelID := s.AddChannel(slack.Conversation{Name: "pony-channel"})
+	r := New(s.Client(), config.Config{})
+	if err := r.init(); err != nil {
+		t.Fatalf("Failed to init reconciler: %v", err)
+	}
+
+	actions := []Action{
+		updateUsergroupAction{handle: "pony-fans", name: "Pony Fans", description: "Fans of ponies", channelNames: []string{"pony-channel"}, create: true},
+		updateUsergroupMembersAction{name: "pony-fans", users: []string{"U11111111", "U12345678"}},
+	}
+	for _, a := range actions {
+		if err := a.Perform(r); err != nil {
+			t.Fatalf("Failed to perform %q: %v", a.Describe(), err)
+		}
+	}
+	g, ok := s.Usergroup("pony-fans")
+	if !ok {
+		t.Fatalf("Expected usergroup pony-fans to have been created")
+	}
+	if g.Name != "Pony Fans" || g.Description != "Fans of ponies" || !reflect.DeepEqual(g.Prefs.Channels, []string{channelID}) {
+		t.Errorf("Usergroup was created with the wrong fields: %#v", g)
+	}
+	if expected := []string{"U11111111", "U12345678"}; !reflect.DeepEqual(g.Users, expected) {
+		t.Errorf("Expected members %v, but got %v", expected, g.Users)
+	}
+
+	if err := (updateUsergroupAction{id: g.ID, handle: g.Handle, description: "Ponies!", fields: []string{config.ManageDescription}}).Perform(r); err != nil {
+		t.Fatalf("Failed to update the usergroup's description: %v", err)
+	}
+	g, _ = s.Usergroup("pony-fans")
+	if g.Name != "Pony Fans" || g.Description != "Ponies!" || !reflect.DeepEqual(g.Prefs.Channels, []string{channelID}) {
+		t.Errorf("Expected only the description to change, but got %#v", g)
+	}
+
+	s.FailNext("usergroups.disable", "permission_denied")
+	if err := (deactivateUsergroupAction{id: g.ID, handle: g.Handle}).Perform(r); err == nil {
+		t.Errorf("Expected deactivating the usergroup to fail")
+	}
+}
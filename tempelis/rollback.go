@@ -0,0 +1,79 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"sigs.k8s.io/slack-infra/slack"
+	"sigs.k8s.io/slack-infra/tempelis/config"
+	"sigs.k8s.io/slack-infra/tempelis/reconciler"
+)
+
+// runRollback implements `tempelis rollback <run-id>`, which undoes the actions recorded in the
+// journal for a previous run.
+func runRollback(args []string) {
+	fs := flag.NewFlagSet("rollback", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", true, "does nothing if true (which is the default)")
+	authConfig := fs.String("auth", "", "path to slack auth")
+	journal := fs.String("journal", "", "path to the journal recording the run to roll back")
+	fs.Usage = func() {
+		log.Printf("Usage: %s rollback [flags] <run-id>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 || *journal == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	runID := fs.Arg(0)
+
+	entries, err := reconciler.ReadJournal(*journal, runID)
+	if err != nil {
+		log.Fatalf("Failed to read journal: %v.\n", err)
+	}
+
+	sc, err := slack.LoadConfig(*authConfig)
+	if err != nil {
+		log.Fatalf("Failed to load slack auth config: %v.\n", err)
+	}
+
+	client := slack.New(sc)
+	team, err := reconciler.JournalTeam(entries)
+	if err != nil {
+		log.Fatalf("Refusing to roll back run %s: %v.\n", runID, err)
+	}
+	id, err := client.AuthTest()
+	if err != nil {
+		log.Fatalf("Failed to check which team the credentials are for: %v.\n", err)
+	}
+	if id.TeamID != team {
+		log.Fatalf("Refusing to roll back run %s: it was applied to team %s, but the credentials are for team %s (%s).\n", runID, team, id.TeamID, id.Team)
+	}
+
+	r := reconciler.New(client, config.Config{})
+	j, err := reconciler.NewJournal(*journal, "rollback of "+runID, team)
+	if err != nil {
+		log.Fatalf("Failed to create journal: %v.\n", err)
+	}
+	r.SetJournal(j)
+	if err := r.Rollback(entries, *dryRun); err != nil {
+		log.Fatalf("Rollback failed: %v\n", err)
+	}
+}
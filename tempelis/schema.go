@@ -0,0 +1,35 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"log"
+	"os"
+
+	"sigs.k8s.io/slack-infra/tempelis/schema"
+)
+
+// runSchema implements `tempelis schema`, which prints the JSON Schema for config files.
+func runSchema() {
+	s, err := schema.Generate()
+	if err != nil {
+		log.Fatalf("Failed to generate schema: %v\n", err)
+	}
+	if _, err := os.Stdout.Write(s); err != nil {
+		log.Fatalf("Failed to write schema: %v\n", err)
+	}
+}
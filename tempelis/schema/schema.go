@@ -0,0 +1,132 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package schema generates a JSON Schema describing Tempelis config files from the config types.
+//
+// Every config field with a json tag is included. Fields may also have these tags:
+//   - desc: a description of the field.
+//   - pattern: a regex that strings in the field (or the values of a map field) must match.
+//   - keypattern: a regex that the keys of a map field must match.
+//   - required: "true" if the field must be present.
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"sigs.k8s.io/slack-infra/tempelis/config"
+)
+
+// Generate returns a JSON Schema describing Tempelis config files.
+func Generate() ([]byte, error) {
+	g := generator{definitions: map[string]interface{}{}}
+	root := g.structSchema(reflect.TypeOf(config.Config{}))
+	root["$schema"] = "http://json-schema.org/draft-07/schema#"
+	root["title"] = "Tempelis config"
+	root["definitions"] = g.definitions
+	b, err := json.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal schema: %v", err)
+	}
+	return append(b, '\n'), nil
+}
+
+type generator struct {
+	definitions map[string]interface{}
+}
+
+func (g *generator) schemaFor(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return g.schemaFor(t.Elem())
+	case reflect.Struct:
+		if _, ok := g.definitions[t.Name()]; !ok {
+			// Claim the name before recursing, in case the type refers to itself.
+			g.definitions[t.Name()] = nil
+			g.definitions[t.Name()] = g.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/definitions/" + t.Name()}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": g.schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schemaFor(t.Elem())}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+func (g *generator) structSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			// Untagged fields are filled in by the parser, not read from the config.
+			continue
+		}
+		s := g.schemaFor(f.Type)
+		if desc := f.Tag.Get("desc"); desc != "" {
+			if _, ok := s["$ref"]; ok {
+				// Keywords alongside $ref are ignored, so wrap it.
+				s = map[string]interface{}{"allOf": []interface{}{s}}
+			}
+			s["description"] = desc
+		}
+		if pattern := f.Tag.Get("pattern"); pattern != "" {
+			stringSchema(s)["pattern"] = pattern
+		}
+		if pattern := f.Tag.Get("keypattern"); pattern != "" {
+			s["propertyNames"] = map[string]interface{}{"pattern": pattern}
+		}
+		if f.Tag.Get("required") == "true" {
+			required = append(required, name)
+		}
+		properties[name] = s
+	}
+	s := map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+// stringSchema returns the schema for the strings in s, which may be the schema of a string, or of
+// an array or map of strings.
+func stringSchema(s map[string]interface{}) map[string]interface{} {
+	if items, ok := s["items"].(map[string]interface{}); ok {
+		return stringSchema(items)
+	}
+	if values, ok := s["additionalProperties"].(map[string]interface{}); ok {
+		return stringSchema(values)
+	}
+	return s
+}
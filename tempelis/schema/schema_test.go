@@ -0,0 +1,133 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schema
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"regexp"
+	"testing"
+)
+
+func TestSchemaIsUpToDate(t *testing.T) {
+	expected, err := Generate()
+	if err != nil {
+		t.Fatalf("Failed to generate schema: %v", err)
+	}
+	actual, err := ioutil.ReadFile("../config.schema.json")
+	if err != nil {
+		t.Fatalf("Failed to read checked-in schema: %v", err)
+	}
+	if string(actual) != string(expected) {
+		t.Errorf("tempelis/config.schema.json is out of date. Run hack/update-schema.sh to regenerate it.")
+	}
+}
+
+func TestSchema(t *testing.T) {
+	b, err := Generate()
+	if err != nil {
+		t.Fatalf("Failed to generate schema: %v", err)
+	}
+	var s struct {
+		Properties  map[string]map[string]interface{} `json:"properties"`
+		Definitions map[string]struct {
+			Properties map[string]map[string]interface{} `json:"properties"`
+			Required   []string                          `json:"required"`
+		} `json:"definitions"`
+	}
+	if err := json.Unmarshal(b, &s); err != nil {
+		t.Fatalf("Generated schema is not valid JSON: %v", err)
+	}
+
+	for _, p := range []string{"users", "channels", "usergroups", "channel_template", "restrictions"} {
+		if _, ok := s.Properties[p]; !ok {
+			t.Errorf("Expected top-level property %q", p)
+		}
+	}
+	if _, ok := s.Definitions["Restrictions"].Properties["Channels"]; ok {
+		t.Errorf("Untagged fields should not be in the schema")
+	}
+	if s.Definitions["Channel"].Properties["name"]["pattern"] == nil {
+		t.Errorf("Expected channel names to have a pattern")
+	}
+	if values, ok := s.Properties["users"]["additionalProperties"].(map[string]interface{}); !ok || values["pattern"] == nil {
+		t.Errorf("Expected user IDs to have a pattern, but got %#v", s.Properties["users"])
+	}
+	if len(s.Definitions["Channel"].Required) != 1 || s.Definitions["Channel"].Required[0] != "name" {
+		t.Errorf("Expected channels to require only a name, but got %v", s.Definitions["Channel"].Required)
+	}
+	for name, d := range s.Definitions {
+		for p, v := range d.Properties {
+			if _, ok := v["description"]; !ok {
+				t.Errorf("%s.%s has no description", name, p)
+			}
+		}
+	}
+}
+
+func TestIDPatterns(t *testing.T) {
+	b, err := Generate()
+	if err != nil {
+		t.Fatalf("Failed to generate schema: %v", err)
+	}
+	var s struct {
+		Properties struct {
+			Users struct {
+				AdditionalProperties struct {
+					Pattern string `json:"pattern"`
+				} `json:"additionalProperties"`
+			} `json:"users"`
+		} `json:"properties"`
+		Definitions struct {
+			Channel struct {
+				Properties struct {
+					ID struct {
+						Pattern string `json:"pattern"`
+					} `json:"id"`
+				} `json:"properties"`
+			} `json:"Channel"`
+		} `json:"definitions"`
+	}
+	if err := json.Unmarshal(b, &s); err != nil {
+		t.Fatalf("Generated schema is not valid JSON: %v", err)
+	}
+	userPattern := regexp.MustCompile(s.Properties.Users.AdditionalProperties.Pattern)
+	channelPattern := regexp.MustCompile(s.Definitions.Channel.Properties.ID.Pattern)
+
+	tests := []struct {
+		name    string
+		pattern *regexp.Regexp
+		id      string
+		valid   bool
+	}{
+		{name: "nine character user ID", pattern: userPattern, id: "U12345678", valid: true},
+		{name: "eleven character user ID", pattern: userPattern, id: "U0123456789", valid: true},
+		{name: "enterprise user ID", pattern: userPattern, id: "W12345678", valid: true},
+		{name: "channel ID as a user", pattern: userPattern, id: "C12345678"},
+		{name: "short user ID", pattern: userPattern, id: "U1234567"},
+		{name: "public channel ID", pattern: channelPattern, id: "C12345678", valid: true},
+		{name: "private channel ID", pattern: channelPattern, id: "G12345678", valid: true},
+		{name: "direct message ID", pattern: channelPattern, id: "D12345678"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if valid := tc.pattern.MatchString(tc.id); valid != tc.valid {
+				t.Errorf("Expected %s to be valid: %t, but got %t", tc.id, tc.valid, valid)
+			}
+		})
+	}
+}
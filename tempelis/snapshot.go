@@ -0,0 +1,90 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+
+	"sigs.k8s.io/slack-infra/tempelis/config"
+	"sigs.k8s.io/slack-infra/tempelis/reconciler"
+)
+
+// runSnapshot implements `tempelis snapshot`, which saves the state of Slack that a run would
+// observe, so that it can later be planned against with --state-from.
+func runSnapshot(args []string) {
+	fs := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	var o options
+	fs.StringVar(&o.config, "config", "", "path to a configuration file, or directory of files")
+	fs.StringVar(&o.restrictions, "restrictions", "", "path to a configuration file containing restrictions")
+	fs.StringVar(&o.authConfig, "auth", "", "path to slack auth")
+	output := fs.String("output", "", "path to write the snapshot to, or, if the config defines workspaces, a directory to write one per workspace to")
+	_ = fs.Parse(args)
+	if *output == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	c, err := loadConfig(o)
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
+	ws, err := workspaces(o, c)
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
+	if len(c.Workspaces) > 0 {
+		if err := os.MkdirAll(*output, 0755); err != nil {
+			log.Fatalf("Failed to create snapshot directory: %v\n", err)
+		}
+	}
+	for _, w := range ws {
+		s, err := reconciler.New(w.client, w.config).Snapshot()
+		if err != nil {
+			log.Fatalf("Failed to take snapshot: %v\n", err)
+		}
+		p := snapshotPath(*output, w.name)
+		if err := reconciler.WriteSnapshot(p, s); err != nil {
+			log.Fatalf("%v\n", err)
+		}
+		log.Printf("Wrote snapshot to %s.\n", p)
+	}
+}
+
+// snapshotWorkspaces returns every workspace that c applies to, with their state read from the
+// snapshots at path instead of Slack.
+func snapshotWorkspaces(path string, c config.Config) []workspace {
+	if len(c.Workspaces) == 0 {
+		return []workspace{{config: c, snapshot: path}}
+	}
+	var result []workspace
+	for _, w := range c.Workspaces {
+		result = append(result, workspace{name: w.Name, config: c.ForWorkspace(w.Name), snapshot: snapshotPath(path, w.Name)})
+	}
+	return result
+}
+
+// snapshotPath returns where the snapshot of the named workspace lives under path. The default
+// workspace has no name, and its snapshot is path itself.
+func snapshotPath(path, name string) string {
+	if name == "" {
+		return path
+	}
+	return filepath.Join(path, name+".json")
+}
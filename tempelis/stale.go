@@ -0,0 +1,88 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"time"
+
+	"sigs.k8s.io/slack-infra/slack"
+	"sigs.k8s.io/slack-infra/tempelis/stale"
+)
+
+// runStale implements `tempelis stale`, which lists channels that nobody has used for a while and
+// optionally writes a patch archiving them.
+func runStale(args []string) {
+	fs := flag.NewFlagSet("stale", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to a configuration file, or directory of files")
+	restrictions := fs.String("restrictions", "", "path to a configuration file containing restrictions")
+	authConfig := fs.String("auth", "", "path to slack auth")
+	days := fs.Int("days", 90, "how many days a channel must go without a message from a human or a thread reply from anyone to be stale")
+	patchPath := fs.String("patch", "", "if set, write a patch archiving the stale channels to this path")
+	_ = fs.Parse(args)
+
+	p, err := loadParser(*configPath, *restrictions)
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
+
+	sc, err := slack.LoadConfig(*authConfig)
+	if err != nil {
+		log.Fatalf("Failed to load slack auth config: %v.\n", err)
+	}
+
+	now := time.Now()
+	candidates, err := stale.Find(slack.New(sc), p.Config.Channels, now, time.Duration(*days)*24*time.Hour)
+	if err != nil {
+		log.Fatalf("Failed to find stale channels: %v\n", err)
+	}
+
+	if len(candidates) == 0 {
+		fmt.Printf("No channels have been quiet for %d days.\n", *days)
+		return
+	}
+	var names []string
+	for i, c := range candidates {
+		quiet := int(now.Sub(c.QuietSince).Hours() / 24)
+		what := "last activity"
+		if c.NeverUsed {
+			what = "never used; created"
+		}
+		fmt.Printf("%d. #%s: %s %s (%d days ago), %d members\n", i+1, c.Name, what, c.QuietSince.Format("2006-01-02"), quiet, c.Members)
+		names = append(names, c.Name)
+	}
+
+	if *patchPath == "" {
+		return
+	}
+	base := *configPath
+	if stat, err := os.Stat(base); err == nil && !stat.IsDir() {
+		base = path.Dir(base)
+	}
+	patch, errs := stale.Patch(names, p.ChannelFiles, base)
+	for _, err := range errs {
+		log.Printf("Warning: %v.\n", err)
+	}
+	if err := ioutil.WriteFile(*patchPath, []byte(patch), 0644); err != nil {
+		log.Fatalf("Failed to write patch: %v\n", err)
+	}
+}
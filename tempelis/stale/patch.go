@@ -0,0 +1,232 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stale
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const diffContext = 3
+
+var (
+	topLevelKeyRegexp = regexp.MustCompile(`^([A-Za-z0-9_]+):`)
+	nameRegexp        = regexp.MustCompile(`^(\s*)(-\s+)?name:\s*(.+?)\s*$`)
+	archivedRegexp    = regexp.MustCompile(`^(\s*)(-\s+)?archived:`)
+)
+
+// Patch returns a unified diff that marks each of the named channels as archived in the config
+// file that lists it. files maps channel names to the files that list them, as recorded by
+// config.Parser. Paths in the diff are relative to base. Channels that can't be patched, such as
+// those generated by channel sets, produce errors instead.
+func Patch(names []string, files map[string]string, base string) (string, []error) {
+	var errors []error
+	byFile := map[string][]string{}
+	for _, n := range names {
+		f, ok := files[n]
+		if !ok {
+			errors = append(errors, fmt.Errorf("channel %s isn't listed in any file (is it generated by a channel set?), so must be archived by hand", n))
+			continue
+		}
+		byFile[f] = append(byFile[f], n)
+	}
+
+	var paths []string
+	for f := range byFile {
+		paths = append(paths, f)
+	}
+	sort.Strings(paths)
+
+	var patch strings.Builder
+	for _, f := range paths {
+		content, err := ioutil.ReadFile(f)
+		if err != nil {
+			errors = append(errors, fmt.Errorf("couldn't read %s: %v", f, err))
+			continue
+		}
+		display := f
+		if rel, err := filepath.Rel(base, f); err == nil && !strings.HasPrefix(rel, "..") {
+			display = rel
+		}
+		diff, errs := archiveDiff(filepath.ToSlash(display), string(content), byFile[f])
+		errors = append(errors, errs...)
+		patch.WriteString(diff)
+	}
+	return patch.String(), errors
+}
+
+// lineEdit replaces the line at index line with text, or inserts text before it if insert is set.
+type lineEdit struct {
+	line   int
+	insert bool
+	text   string
+}
+
+// archiveDiff returns a unified diff of the YAML file content that sets archived to true for each
+// of the named channels.
+func archiveDiff(path, content string, names []string) (string, []error) {
+	wanted := map[string]bool{}
+	for _, n := range names {
+		wanted[n] = true
+	}
+	lines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+
+	var edits []lineEdit
+	found := map[string]bool{}
+	inChannels := false
+	for i, l := range lines {
+		if m := topLevelKeyRegexp.FindStringSubmatch(l); m != nil {
+			inChannels = m[1] == "channels"
+			continue
+		}
+		if !inChannels {
+			continue
+		}
+		m := nameRegexp.FindStringSubmatch(l)
+		if m == nil {
+			continue
+		}
+		name := strings.Trim(m[3], `"'`)
+		if !wanted[name] || found[name] {
+			continue
+		}
+		found[name] = true
+		keyColumn := len(m[1]) + len(m[2])
+		indent := strings.Repeat(" ", keyColumn)
+		if a, ok := findArchivedLine(lines, i, keyColumn); ok {
+			prefix := archivedRegexp.FindStringSubmatch(lines[a])
+			edits = append(edits, lineEdit{line: a, text: prefix[1] + prefix[2] + "archived: true"})
+		} else {
+			edits = append(edits, lineEdit{line: i + 1, insert: true, text: indent + "archived: true"})
+		}
+	}
+
+	var errors []error
+	for _, n := range names {
+		if !found[n] {
+			errors = append(errors, fmt.Errorf("couldn't find channel %s in %s", n, path))
+		}
+	}
+	if len(edits) == 0 {
+		return "", errors
+	}
+	sort.Slice(edits, func(i, j int) bool { return edits[i].line < edits[j].line })
+	return unifiedDiff(path, lines, edits), errors
+}
+
+// findArchivedLine finds an existing archived key in the list item containing the name key on
+// line nameLine, whose keys start at keyColumn.
+func findArchivedLine(lines []string, nameLine, keyColumn int) (int, bool) {
+	isItemKey := func(l string) bool {
+		m := archivedRegexp.FindStringSubmatch(l)
+		return m != nil && len(m[1])+len(m[2]) == keyColumn
+	}
+	// Look back to the start of the item, then forward to its end.
+	if !strings.HasPrefix(strings.TrimSpace(lines[nameLine]), "-") {
+		for i := nameLine - 1; i >= 0; i-- {
+			if isItemKey(lines[i]) {
+				return i, true
+			}
+			if indentation(lines[i]) < keyColumn || strings.HasPrefix(strings.TrimSpace(lines[i]), "-") {
+				break
+			}
+		}
+	}
+	for i := nameLine + 1; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == "" {
+			continue
+		}
+		if indentation(lines[i]) < keyColumn {
+			break
+		}
+		if isItemKey(lines[i]) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func indentation(l string) int {
+	return len(l) - len(strings.TrimLeft(l, " "))
+}
+
+// unifiedDiff renders edits to lines as a unified diff of the file at path.
+func unifiedDiff(path string, lines []string, edits []lineEdit) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- a/%s\n+++ b/%s\n", path, path)
+
+	// offset is how many more lines the new file has than the old one before the current hunk.
+	offset := 0
+	for start := 0; start < len(edits); {
+		end := start + 1
+		for end < len(edits) && edits[end].line-editEnd(edits[end-1]) <= 2*diffContext {
+			end++
+		}
+		hunk := edits[start:end]
+
+		from := hunk[0].line - diffContext
+		if from < 0 {
+			from = 0
+		}
+		to := editEnd(hunk[len(hunk)-1]) + diffContext
+		if to > len(lines) {
+			to = len(lines)
+		}
+		added := 0
+		for _, e := range hunk {
+			if e.insert {
+				added++
+			}
+		}
+		fmt.Fprintf(&b, "@@ -%d,%d +%d,%d @@\n", from+1, to-from, from+offset+1, to-from+added)
+
+		next := 0
+		for i := from; i < to; i++ {
+			if next < len(hunk) && hunk[next].line == i {
+				e := hunk[next]
+				next++
+				if e.insert {
+					fmt.Fprintf(&b, "+%s\n", e.text)
+				} else {
+					fmt.Fprintf(&b, "-%s\n+%s\n", lines[i], e.text)
+					continue
+				}
+			}
+			fmt.Fprintf(&b, " %s\n", lines[i])
+		}
+		// Insertions at the very end of the file come after every line.
+		for ; next < len(hunk); next++ {
+			fmt.Fprintf(&b, "+%s\n", hunk[next].text)
+		}
+
+		offset += added
+		start = end
+	}
+	return b.String()
+}
+
+// editEnd returns the index of the first line after those e touches.
+func editEnd(e lineEdit) int {
+	if e.insert {
+		return e.line
+	}
+	return e.line + 1
+}
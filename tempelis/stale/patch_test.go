@@ -0,0 +1,203 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stale
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/slack-infra/slack"
+)
+
+func TestArchiveDiff(t *testing.T) {
+	tests := []struct {
+		name             string
+		content          string
+		channels         []string
+		expected         string
+		expectedErrCount int
+	}{
+		{
+			name: "archived is added after the name",
+			content: `channels:
+- name: sig-testing
+- name: sig-release
+`,
+			channels: []string{"sig-testing"},
+			expected: `--- a/sigs.yaml
++++ b/sigs.yaml
+@@ -1,3 +1,4 @@
+ channels:
+ - name: sig-testing
++  archived: true
+ - name: sig-release
+`,
+		},
+		{
+			name: "an existing archived key is replaced",
+			content: `channels:
+  - id: C12345678
+    name: "sig-testing"
+    archived: false
+`,
+			channels: []string{"sig-testing"},
+			expected: `--- a/sigs.yaml
++++ b/sigs.yaml
+@@ -1,4 +1,4 @@
+ channels:
+   - id: C12345678
+     name: "sig-testing"
+-    archived: false
++    archived: true
+`,
+		},
+		{
+			name: "distant changes get separate hunks",
+			content: `channels:
+- name: a
+- name: b
+- name: c
+- name: d
+- name: e
+- name: f
+- name: g
+- name: h
+- name: i
+`,
+			channels: []string{"a", "i"},
+			expected: `--- a/sigs.yaml
++++ b/sigs.yaml
+@@ -1,5 +1,6 @@
+ channels:
+ - name: a
++  archived: true
+ - name: b
+ - name: c
+ - name: d
+@@ -8,3 +9,4 @@
+ - name: g
+ - name: h
+ - name: i
++  archived: true
+`,
+		},
+		{
+			name: "usergroups with the same name are ignored",
+			content: `usergroups:
+- name: sig-testing
+channels:
+- name: sig-testing
+`,
+			channels: []string{"sig-testing"},
+			expected: `--- a/sigs.yaml
++++ b/sigs.yaml
+@@ -2,3 +2,4 @@
+ - name: sig-testing
+ channels:
+ - name: sig-testing
++  archived: true
+`,
+		},
+		{
+			name:             "missing channels are errors",
+			content:          "channels:\n- name: sig-testing\n",
+			channels:         []string{"sig-release"},
+			expectedErrCount: 1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			diff, errs := archiveDiff("sigs.yaml", tc.content, tc.channels)
+			if diff != tc.expected {
+				t.Errorf("Expected diff:\n%s\nActual diff:\n%s", tc.expected, diff)
+			}
+			if len(errs) != tc.expectedErrCount {
+				t.Errorf("Expected %d errors, but got %d: %v", tc.expectedErrCount, len(errs), errs)
+			}
+		})
+	}
+}
+
+func TestPatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stale")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sig-testing.yaml")
+	if err := ioutil.WriteFile(path, []byte("channels:\n- name: sig-testing\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	patch, errs := Patch([]string{"sig-testing", "sig-release"}, map[string]string{"sig-testing": path}, dir)
+	expected := `--- a/sig-testing.yaml
++++ b/sig-testing.yaml
+@@ -1,2 +1,3 @@
+ channels:
+ - name: sig-testing
++  archived: true
+`
+	if patch != expected {
+		t.Errorf("Expected patch:\n%s\nActual patch:\n%s", expected, patch)
+	}
+	if len(errs) != 1 {
+		t.Errorf("Expected an error for the unlisted channel, but got %v", errs)
+	}
+}
+
+func TestIsHumanMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		message  slack.Message
+		expected bool
+	}{
+		{name: "plain messages are human", message: slack.Message{User: "U12345678"}, expected: true},
+		{name: "file shares are human", message: slack.Message{User: "U12345678", Subtype: "file_share"}, expected: true},
+		{name: "joins are not human", message: slack.Message{User: "U12345678", Subtype: "channel_join"}},
+		{name: "bot messages are not human", message: slack.Message{User: "U12345678", BotID: "B12345678"}},
+		{name: "messages without users are not human", message: slack.Message{}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := isHumanMessage(tc.message); actual != tc.expected {
+				t.Errorf("Expected %v, got %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestRank(t *testing.T) {
+	now := time.Now()
+	candidates := []Candidate{
+		{Name: "recent", QuietSince: now.Add(-time.Hour)},
+		{Name: "b-old", QuietSince: now.Add(-48 * time.Hour)},
+		{Name: "a-old", QuietSince: now.Add(-48 * time.Hour)},
+	}
+	rank(candidates)
+	var names []string
+	for _, c := range candidates {
+		names = append(names, c.Name)
+	}
+	if names[0] != "a-old" || names[1] != "b-old" || names[2] != "recent" {
+		t.Errorf("Expected quietest channels first, but got %v", names)
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package stale finds channels that nobody has used for a while.
+package stale
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/slack-infra/slack"
+	"sigs.k8s.io/slack-infra/tempelis/config"
+)
+
+// Candidate is a channel that might be worth archiving.
+type Candidate struct {
+	Name string
+	ID   string
+	// QuietSince is the time of the last message sent by a human or the last thread reply,
+	// or when the channel was created if there have been neither.
+	QuietSince time.Time
+	// NeverUsed is true if no human has ever sent a message in the channel, and nobody has ever
+	// replied in a thread there.
+	NeverUsed bool
+	Members   int
+}
+
+// Find returns the configured channels that have had no messages from humans and no thread replies
+// for at least maxAge, ranked from quietest to least quiet. Archived channels and channels exempt
+// from staleness are never returned.
+func Find(client *slack.Client, channels []config.Channel, now time.Time, maxAge time.Duration) ([]Candidate, error) {
+	current, err := client.GetPublicChannels()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list channels: %v", err)
+	}
+	byName := map[string]slack.Conversation{}
+	for _, c := range current {
+		byName[c.Name] = c
+	}
+
+	cutoff := now.Add(-maxAge)
+	var candidates []Candidate
+	for _, c := range channels {
+		if c.Archived || c.ExemptFromStaleness {
+			continue
+		}
+		o, ok := byName[c.Name]
+		if !ok || o.IsArchived {
+			continue
+		}
+		info, err := client.GetConversationInfo(o.ID)
+		if err != nil {
+			return nil, err
+		}
+		created := time.Unix(info.Created, 0)
+		if created.After(cutoff) {
+			continue
+		}
+		var last time.Time
+		var tsErr error
+		err = client.GetConversationHistory(o.ID, func(m slack.Message) bool {
+			last, tsErr = lastActivity(m, last)
+			// Threads started long ago can still be in use, so we can only stop early once we know
+			// the channel isn't quiet.
+			return tsErr == nil && !last.After(cutoff)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if tsErr != nil {
+			return nil, fmt.Errorf("channel %s: %v", c.Name, tsErr)
+		}
+		if last.After(cutoff) {
+			continue
+		}
+		candidate := Candidate{Name: c.Name, ID: o.ID, QuietSince: last, Members: info.NumMembers}
+		if last.IsZero() {
+			candidate.QuietSince = created
+			candidate.NeverUsed = true
+		}
+		candidates = append(candidates, candidate)
+	}
+	rank(candidates)
+	return candidates, nil
+}
+
+// rank sorts candidates from the longest quiet to the shortest.
+func rank(candidates []Candidate) {
+	sort.Slice(candidates, func(i, j int) bool {
+		if !candidates[i].QuietSince.Equal(candidates[j].QuietSince) {
+			return candidates[i].QuietSince.Before(candidates[j].QuietSince)
+		}
+		return candidates[i].Name < candidates[j].Name
+	})
+}
+
+// lastActivity returns the later of last and the latest time m shows the channel was used: when
+// it was sent, if a human sent it, or when the last reply in its thread was sent. Slack doesn't say
+// who sent the last reply, so replies from bots count too.
+func lastActivity(m slack.Message, last time.Time) (time.Time, error) {
+	if isHumanMessage(m) {
+		t, err := parseTimestamp(m.TS)
+		if err != nil {
+			return last, err
+		}
+		if t.After(last) {
+			last = t
+		}
+	}
+	if m.LatestReply != "" {
+		t, err := parseTimestamp(m.LatestReply)
+		if err != nil {
+			return last, err
+		}
+		if t.After(last) {
+			last = t
+		}
+	}
+	return last, nil
+}
+
+// isHumanMessage returns whether m was written by a person, as opposed to being sent by a bot or
+// being a notification of something like someone joining the channel.
+func isHumanMessage(m slack.Message) bool {
+	if m.BotID != "" || m.User == "" {
+		return false
+	}
+	switch m.Subtype {
+	case "", "thread_broadcast", "file_share", "me_message":
+		return true
+	default:
+		return false
+	}
+}
+
+// parseTimestamp converts a slack message timestamp, such as "1355517523.000005", to a time.
+func parseTimestamp(ts string) (time.Time, error) {
+	parts := strings.SplitN(ts, ".", 2)
+	sec, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid message timestamp %q: %v", ts, err)
+	}
+	return time.Unix(sec, 0), nil
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stale
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/slack-infra/slack"
+	"sigs.k8s.io/slack-infra/slack/slacktest"
+	"sigs.k8s.io/slack-infra/tempelis/config"
+)
+
+func TestFind(t *testing.T) {
+	now := time.Date(2019, 10, 7, 9, 0, 0, 0, time.UTC)
+	daysAgo := func(days int) time.Time {
+		return time.Unix(now.Add(-time.Duration(days)*24*time.Hour).Unix(), 0)
+	}
+	ts := func(days int) string {
+		return fmt.Sprintf("%d.000100", daysAgo(days).Unix())
+	}
+	created := daysAgo(365)
+
+	tests := []struct {
+		name     string
+		messages []slack.Message
+		expected []Candidate
+	}{
+		{
+			name: "a channel nobody has used is stale",
+			expected: []Candidate{
+				{Name: "sig-testing", QuietSince: created, NeverUsed: true},
+			},
+		},
+		{
+			name: "a recent message from a human keeps a channel in use",
+			messages: []slack.Message{
+				{User: "U12345678", Text: "hi", TS: ts(100)},
+				{User: "U12345678", Text: "hello", TS: ts(10)},
+			},
+		},
+		{
+			name: "recent messages from bots don't",
+			messages: []slack.Message{
+				{User: "U12345678", Text: "hi", TS: ts(100)},
+				{BotID: "B12345678", Text: "beep", TS: ts(10)},
+			},
+			expected: []Candidate{
+				{Name: "sig-testing", QuietSince: daysAgo(100)},
+			},
+		},
+		{
+			name: "a recent reply to an old thread keeps a channel in use",
+			messages: []slack.Message{
+				{User: "U12345678", Text: "hi", TS: ts(200), ThreadTS: ts(200)},
+				{User: "U12345678", Text: "hello", TS: ts(100)},
+				{User: "U12345678", Text: "still here", TS: ts(10), ThreadTS: ts(200)},
+			},
+		},
+		{
+			name: "old replies don't",
+			messages: []slack.Message{
+				{User: "U12345678", Text: "hi", TS: ts(200), ThreadTS: ts(200)},
+				{User: "U12345678", Text: "still here", TS: ts(150), ThreadTS: ts(200)},
+				{User: "U12345678", Text: "hello", TS: ts(100)},
+			},
+			expected: []Candidate{
+				{Name: "sig-testing", QuietSince: daysAgo(100)},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := slacktest.NewServer()
+			defer s.Close()
+			id := s.AddChannel(slack.Conversation{Name: "sig-testing", Created: created.Unix()})
+			for _, m := range tc.messages {
+				s.AddMessage(id, m)
+			}
+			for i := range tc.expected {
+				tc.expected[i].ID = id
+			}
+
+			candidates, err := Find(s.Client(), []config.Channel{{Name: "sig-testing"}}, now, 90*24*time.Hour)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(candidates, tc.expected) {
+				t.Errorf("Expected candidates %+v, but got %+v", tc.expected, candidates)
+			}
+		})
+	}
+}